@@ -0,0 +1,360 @@
+// package bulk provides methods for indexing documents in batches using the Elasticsearch bulk API. It
+// implements esutil.BulkIndexer on top of any esapi.Transport, such as a cluster.Client, rather than an
+// elasticsearch.Client, so that it can be used with every distribution supported by the cluster package.
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	json "github.com/goccy/go-json"
+)
+
+// Config configures a BulkIndexer.
+type Config struct {
+	// Transport is used to send bulk requests.
+	Transport esapi.Transport
+	// Index is the default index for items which do not specify one.
+	Index string
+	// NumWorkers is the number of concurrent bulk requests. If zero runtime.NumCPU() is used.
+	NumWorkers int
+	// FlushBytes is the size of the items buffered by each worker at which they are sent. If zero 5MB is used.
+	FlushBytes int
+	// FlushInterval is how often each worker sends the items it has buffered, regardless of their size. If
+	// zero 30 seconds is used.
+	FlushInterval time.Duration
+	// OnError, if not nil, is called when a bulk request fails as a whole. Each of its items' OnFailure
+	// callbacks is called as well.
+	OnError func(context.Context, error)
+}
+
+// BulkIndexer is an esutil.BulkIndexer which sends its requests using an esapi.Transport.
+type BulkIndexer struct {
+	cfg     Config
+	queue   chan esutil.BulkIndexerItem
+	wg      *sync.WaitGroup
+	closed  int32
+	added   uint64
+	flushed uint64
+	failed  uint64
+	indexed uint64
+	created uint64
+	updated uint64
+	deleted uint64
+	reqs    uint64
+}
+
+// meta is the action and metadata line written for each item.
+type meta struct {
+	ID              string `json:"_id,omitempty"`
+	Index           string `json:"_index,omitempty"`
+	Routing         string `json:"routing,omitempty"`
+	Version         *int64 `json:"version,omitempty"`
+	VersionType     string `json:"version_type,omitempty"`
+	RetryOnConflict *int   `json:"retry_on_conflict,omitempty"`
+}
+
+// NewBulkIndexer returns a new BulkIndexer configured by cfg and starts its workers.
+func NewBulkIndexer(cfg Config) (*BulkIndexer, error) {
+
+	if cfg.Transport == nil {
+		return nil, fmt.Errorf("Missing transport")
+	}
+
+	if cfg.NumWorkers < 1 {
+		cfg.NumWorkers = runtime.NumCPU()
+	}
+
+	if cfg.FlushBytes < 1 {
+		cfg.FlushBytes = 5e+6
+	}
+
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 30 * time.Second
+	}
+
+	bi := &BulkIndexer{
+		cfg:   cfg,
+		queue: make(chan esutil.BulkIndexerItem, cfg.NumWorkers),
+		wg:    new(sync.WaitGroup),
+	}
+
+	for i := 0; i < cfg.NumWorkers; i++ {
+		bi.wg.Add(1)
+		go bi.work()
+	}
+
+	return bi, nil
+}
+
+// Add adds item to the queue of items to be sent, blocking while every worker is busy. Add must not be
+// called concurrently with, or after, Close.
+func (bi *BulkIndexer) Add(ctx context.Context, item esutil.BulkIndexerItem) error {
+
+	if atomic.LoadInt32(&bi.closed) == 1 {
+		return fmt.Errorf("Bulk indexer is closed")
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case bi.queue <- item:
+		atomic.AddUint64(&bi.added, 1)
+		return nil
+	}
+}
+
+// Close sends every buffered item and stops the workers. It returns ctx's error if ctx is cancelled before
+// they finish, in which case they continue in the background.
+func (bi *BulkIndexer) Close(ctx context.Context) error {
+
+	if atomic.SwapInt32(&bi.closed, 1) == 1 {
+		return nil
+	}
+
+	close(bi.queue)
+
+	done_ch := make(chan bool)
+
+	go func() {
+		bi.wg.Wait()
+		close(done_ch)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done_ch:
+		return nil
+	}
+}
+
+// Stats returns the number of items added, sent and so on so far.
+func (bi *BulkIndexer) Stats() esutil.BulkIndexerStats {
+
+	return esutil.BulkIndexerStats{
+		NumAdded:    atomic.LoadUint64(&bi.added),
+		NumFlushed:  atomic.LoadUint64(&bi.flushed),
+		NumFailed:   atomic.LoadUint64(&bi.failed),
+		NumIndexed:  atomic.LoadUint64(&bi.indexed),
+		NumCreated:  atomic.LoadUint64(&bi.created),
+		NumUpdated:  atomic.LoadUint64(&bi.updated),
+		NumDeleted:  atomic.LoadUint64(&bi.deleted),
+		NumRequests: atomic.LoadUint64(&bi.reqs),
+	}
+}
+
+// work buffers items from the queue and sends them whenever the buffer reaches FlushBytes, FlushInterval
+// passes or the queue is closed.
+func (bi *BulkIndexer) work() {
+
+	defer bi.wg.Done()
+
+	ticker := time.NewTicker(bi.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	// Items are always flushed, even after the context they were added with is cancelled, so that their
+	// callbacks are called
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	items := make([]esutil.BulkIndexerItem, 0)
+
+	flush := func() {
+
+		if len(items) == 0 {
+			return
+		}
+
+		bi.flush(ctx, buf.Bytes(), items)
+
+		buf.Reset()
+		items = items[:0]
+	}
+
+	for {
+
+		select {
+		case item, ok := <-bi.queue:
+
+			if !ok {
+				flush()
+				return
+			}
+
+			err := writeItem(&buf, bi.cfg.Index, item)
+
+			if err != nil {
+				atomic.AddUint64(&bi.failed, 1)
+
+				if item.OnFailure != nil {
+					item.OnFailure(ctx, item, esutil.BulkIndexerResponseItem{}, err)
+				}
+
+				continue
+			}
+
+			items = append(items, item)
+
+			if buf.Len() >= bi.cfg.FlushBytes {
+				flush()
+			}
+
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// writeItem writes the metadata and body lines for item to buf. Items in default_index, the index of the
+// request, are written without an index.
+func writeItem(buf *bytes.Buffer, default_index string, item esutil.BulkIndexerItem) error {
+
+	m := meta{
+		ID:              item.DocumentID,
+		Index:           item.Index,
+		Routing:         item.Routing,
+		Version:         item.Version,
+		VersionType:     item.VersionType,
+		RetryOnConflict: item.RetryOnConflict,
+	}
+
+	if m.Index == default_index {
+		m.Index = ""
+	}
+
+	if item.Action != "update" {
+		m.RetryOnConflict = nil
+	}
+
+	enc_meta, err := json.Marshal(map[string]meta{item.Action: m})
+
+	if err != nil {
+		return fmt.Errorf("Failed to encode item metadata, %w", err)
+	}
+
+	var body []byte
+
+	if item.Body != nil {
+
+		body, err = io.ReadAll(item.Body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read item body, %w", err)
+		}
+	}
+
+	buf.Write(enc_meta)
+	buf.WriteByte('\n')
+
+	if body != nil {
+		buf.Write(body)
+		buf.WriteByte('\n')
+	}
+
+	return nil
+}
+
+// flush sends body, containing items, as a bulk request and calls each item's callback with its result.
+func (bi *BulkIndexer) flush(ctx context.Context, body []byte, items []esutil.BulkIndexerItem) {
+
+	atomic.AddUint64(&bi.reqs, 1)
+
+	rsp, err := bi.send(ctx, body)
+
+	if err != nil {
+
+		atomic.AddUint64(&bi.failed, uint64(len(items)))
+
+		if bi.cfg.OnError != nil {
+			bi.cfg.OnError(ctx, err)
+		}
+
+		for _, item := range items {
+
+			if item.OnFailure != nil {
+				item.OnFailure(ctx, item, esutil.BulkIndexerResponseItem{}, err)
+			}
+		}
+
+		return
+	}
+
+	atomic.AddUint64(&bi.flushed, uint64(len(items)))
+
+	for i, item := range items {
+
+		var res esutil.BulkIndexerResponseItem
+
+		if i < len(rsp.Items) {
+
+			for _, r := range rsp.Items[i] {
+				res = r
+			}
+		}
+
+		if res.Status < 200 || res.Status > 299 {
+
+			atomic.AddUint64(&bi.failed, 1)
+
+			if item.OnFailure != nil {
+				item.OnFailure(ctx, item, res, nil)
+			}
+
+			continue
+		}
+
+		atomic.AddUint64(&bi.indexed, 1)
+
+		switch res.Result {
+		case "created":
+			atomic.AddUint64(&bi.created, 1)
+		case "updated":
+			atomic.AddUint64(&bi.updated, 1)
+		case "deleted":
+			atomic.AddUint64(&bi.deleted, 1)
+		}
+
+		if item.OnSuccess != nil {
+			item.OnSuccess(ctx, item, res)
+		}
+	}
+}
+
+func (bi *BulkIndexer) send(ctx context.Context, body []byte) (*esutil.BulkIndexerResponse, error) {
+
+	req := esapi.BulkRequest{
+		Index: bi.cfg.Index,
+		Body:  bytes.NewReader(body),
+	}
+
+	rsp, err := req.Do(ctx, bi.cfg.Transport)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to send bulk request, %w", err)
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.IsError() {
+		return nil, fmt.Errorf("Failed to send bulk request, %s", rsp.String())
+	}
+
+	var bulk_rsp *esutil.BulkIndexerResponse
+
+	err = json.NewDecoder(rsp.Body).Decode(&bulk_rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode bulk response, %w", err)
+	}
+
+	return bulk_rsp, nil
+}
@@ -0,0 +1,288 @@
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+)
+
+// handlerTransport is an esapi.Transport which answers requests with an http.Handler.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (tr *handlerTransport) Perform(req *http.Request) (*http.Response, error) {
+
+	rec := httptest.NewRecorder()
+	tr.handler.ServeHTTP(rec, req)
+
+	return rec.Result(), nil
+}
+
+// bulkServer records the bodies of the bulk requests it receives and answers each item with status.
+type bulkServer struct {
+	status   int
+	requests []string
+	mu       sync.Mutex
+}
+
+func (s *bulkServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	body, _ := io.ReadAll(req.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req.URL.Path+"\n"+string(body))
+	s.mu.Unlock()
+
+	// Every item has an action line, and all but deletes a source line
+	lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	count := 0
+
+	for i := 0; i < len(lines); i++ {
+
+		count += 1
+
+		if !bytes.HasPrefix(lines[i], []byte(`{"delete"`)) {
+			i += 1
+		}
+	}
+
+	items := make([]string, count)
+
+	for i := range items {
+
+		if s.status == 201 {
+			items[i] = `{"index":{"_id":"x","status":201,"result":"created"}}`
+		} else {
+			items[i] = fmt.Sprintf(`{"index":{"_id":"x","status":%d,"error":{"type":"mapper_parsing_exception","reason":"failed"}}}`, s.status)
+		}
+	}
+
+	fmt.Fprintf(w, `{"took":1,"errors":%t,"items":[%s]}`, s.status != 201, strings.Join(items, ","))
+}
+
+func TestBulkIndexer(t *testing.T) {
+
+	srv := &bulkServer{status: 201}
+
+	bi, err := NewBulkIndexer(Config{
+		Transport:  &handlerTransport{srv},
+		Index:      "books",
+		NumWorkers: 1,
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to create bulk indexer, %v", err)
+	}
+
+	results := make([]string, 0)
+	mu := new(sync.Mutex)
+
+	items := []esutil.BulkIndexerItem{
+		{Action: "index", DocumentID: "1", Body: strings.NewReader(`{"a":1}`)},
+		{Action: "index", Body: strings.NewReader(`{"a":2}`)},
+		{Action: "index", Index: "books-v2", DocumentID: "3", Body: strings.NewReader(`{"a":3}`)},
+		{Action: "delete", DocumentID: "4"},
+	}
+
+	for _, item := range items {
+
+		item.OnSuccess = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+			mu.Lock()
+			results = append(results, res.Result)
+			mu.Unlock()
+		}
+
+		err := bi.Add(context.Background(), item)
+
+		if err != nil {
+			t.Fatalf("Failed to add item, %v", err)
+		}
+	}
+
+	err = bi.Close(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to close bulk indexer, %v", err)
+	}
+
+	if len(srv.requests) != 1 {
+		t.Fatalf("Expected a single bulk request, got %d", len(srv.requests))
+	}
+
+	expected := `/books/_bulk
+{"index":{"_id":"1"}}
+{"a":1}
+{"index":{}}
+{"a":2}
+{"index":{"_id":"3","_index":"books-v2"}}
+{"a":3}
+{"delete":{"_id":"4"}}
+`
+
+	if srv.requests[0] != expected {
+		t.Fatalf("Unexpected bulk request:\n%s", srv.requests[0])
+	}
+
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 successful items, got %v", results)
+	}
+
+	stats := bi.Stats()
+
+	if stats.NumAdded != 4 || stats.NumFlushed != 4 || stats.NumIndexed != 4 || stats.NumCreated != 4 || stats.NumRequests != 1 || stats.NumFailed != 0 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	err = bi.Add(context.Background(), items[0])
+
+	if err == nil {
+		t.Fatalf("Expected an error adding an item after Close")
+	}
+}
+
+func TestBulkIndexerFailures(t *testing.T) {
+
+	srv := &bulkServer{status: 400}
+
+	bi, err := NewBulkIndexer(Config{
+		Transport:  &handlerTransport{srv},
+		NumWorkers: 2,
+		FlushBytes: 1,
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to create bulk indexer, %v", err)
+	}
+
+	failures := make(chan string, 10)
+
+	for i := 0; i < 3; i++ {
+
+		item := esutil.BulkIndexerItem{
+			Action: "index",
+			Body:   strings.NewReader(`{}`),
+			OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+				failures <- res.Error.Type
+			},
+		}
+
+		err := bi.Add(context.Background(), item)
+
+		if err != nil {
+			t.Fatalf("Failed to add item, %v", err)
+		}
+	}
+
+	err = bi.Close(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to close bulk indexer, %v", err)
+	}
+
+	close(failures)
+
+	count := 0
+
+	for reason := range failures {
+
+		if reason != "mapper_parsing_exception" {
+			t.Fatalf("Unexpected failure %s", reason)
+		}
+
+		count += 1
+	}
+
+	// Every item exceeds FlushBytes so each is sent by itself
+	if count != 3 || bi.Stats().NumFailed != 3 || bi.Stats().NumRequests != 3 {
+		t.Fatalf("Expected 3 failures in 3 requests, got %d and %+v", count, bi.Stats())
+	}
+}
+
+func TestBulkIndexerRequestError(t *testing.T) {
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	errors := make([]error, 0)
+
+	bi, err := NewBulkIndexer(Config{
+		Transport: &handlerTransport{handler},
+		OnError: func(ctx context.Context, err error) {
+			errors = append(errors, err)
+		},
+		NumWorkers: 1,
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to create bulk indexer, %v", err)
+	}
+
+	var item_err error
+
+	item := esutil.BulkIndexerItem{
+		Action: "index",
+		Body:   strings.NewReader(`{}`),
+		OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+			item_err = err
+		},
+	}
+
+	bi.Add(context.Background(), item)
+
+	err = bi.Close(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to close bulk indexer, %v", err)
+	}
+
+	if len(errors) != 1 || item_err == nil {
+		t.Fatalf("Expected the request error to be reported to OnError and the item, got %v and %v", errors, item_err)
+	}
+}
+
+func TestBulkIndexerFlushInterval(t *testing.T) {
+
+	srv := &bulkServer{status: 201}
+
+	bi, err := NewBulkIndexer(Config{
+		Transport:     &handlerTransport{srv},
+		NumWorkers:    1,
+		FlushInterval: 10 * time.Millisecond,
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to create bulk indexer, %v", err)
+	}
+
+	defer bi.Close(context.Background())
+
+	done_ch := make(chan bool)
+
+	item := esutil.BulkIndexerItem{
+		Action: "index",
+		Body:   strings.NewReader(`{}`),
+		OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+			close(done_ch)
+		},
+	}
+
+	bi.Add(context.Background(), item)
+
+	select {
+	case <-done_ch:
+		// pass
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the item to be sent after the flush interval")
+	}
+}
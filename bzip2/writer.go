@@ -0,0 +1,623 @@
+// package bzip2 provides methods for writing bzip2-compressed data. The standard library's compress/bzip2
+// package can only read it.
+package bzip2
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// The size, in units of 100KB, of each block. 9 is the default for the bzip2 command.
+const block_level int = 9
+
+// The maximum number of bytes in a block after the initial run-length encoding, leaving room for the
+// encoding of a final run.
+const max_block_size int = block_level*100000 - 19
+
+const block_magic uint64 = 0x314159265359
+
+const stream_end_magic uint64 = 0x177245385090
+
+// The maximum length of a Huffman code. The format allows up to 20 bits but the reference encoder uses 17.
+const max_code_length int = 17
+
+// The number of symbols coded with each selector.
+const group_size int = 50
+
+const (
+	run_a int = 0
+	run_b int = 1
+)
+
+var crc_table [256]uint32
+
+func init() {
+
+	// bzip2 uses the big-endian (unreflected) form of the CRC-32 polynomial, unlike hash/crc32
+	for i := range crc_table {
+
+		c := uint32(i) << 24
+
+		for j := 0; j < 8; j++ {
+
+			if c&0x80000000 != 0 {
+				c = (c << 1) ^ 0x04c11db7
+			} else {
+				c = c << 1
+			}
+		}
+
+		crc_table[i] = c
+	}
+}
+
+// Writer compresses the data written to it and writes it to an underlying io.Writer. Close must be called
+// to write the end of the stream.
+type Writer struct {
+	bw *bitWriter
+	// block is the run-length encoded data of the current block.
+	block        []byte
+	block_crc    uint32
+	combined_crc uint32
+	// run_byte and run_length are the byte being repeated and the number of times it has been seen.
+	run_byte   byte
+	run_length int
+	started    bool
+	closed     bool
+}
+
+// NewWriter returns a new Writer which writes compressed data to w.
+func NewWriter(w io.Writer) *Writer {
+
+	z := &Writer{
+		bw:        newBitWriter(w),
+		block:     make([]byte, 0, max_block_size),
+		block_crc: 0xffffffff,
+	}
+
+	return z
+}
+
+// Write compresses p.
+func (z *Writer) Write(p []byte) (int, error) {
+
+	if z.closed {
+		return 0, fmt.Errorf("Writer is closed")
+	}
+
+	if !z.started {
+
+		z.bw.writeBits(24, 0x425a68) // "BZh"
+		z.bw.writeBits(8, uint64('0'+block_level))
+		z.started = true
+	}
+
+	for _, b := range p {
+
+		if z.run_length > 0 && (b != z.run_byte || z.run_length == 255) {
+
+			err := z.flushRun()
+
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		z.run_byte = b
+		z.run_length += 1
+	}
+
+	return len(p), z.bw.err
+}
+
+// Close writes any buffered data and the end of the stream. It does not close the underlying io.Writer.
+func (z *Writer) Close() error {
+
+	if z.closed {
+		return nil
+	}
+
+	_, err := z.Write(nil)
+
+	if err != nil {
+		return err
+	}
+
+	z.closed = true
+
+	if z.run_length > 0 {
+
+		err := z.flushRun()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(z.block) > 0 {
+		z.writeBlock()
+	}
+
+	z.bw.writeBits(48, stream_end_magic)
+	z.bw.writeBits(32, uint64(z.combined_crc))
+
+	return z.bw.flush()
+}
+
+// flushRun adds the current run to the block, using the initial run-length encoding: runs of four to 255
+// bytes are written as four bytes followed by the number of remaining repeats.
+func (z *Writer) flushRun() error {
+
+	if len(z.block)+5 > max_block_size {
+
+		z.writeBlock()
+
+		if z.bw.err != nil {
+			return z.bw.err
+		}
+	}
+
+	for i := 0; i < z.run_length; i++ {
+		z.block_crc = (z.block_crc << 8) ^ crc_table[byte(z.block_crc>>24)^z.run_byte]
+	}
+
+	if z.run_length < 4 {
+
+		for i := 0; i < z.run_length; i++ {
+			z.block = append(z.block, z.run_byte)
+		}
+
+	} else {
+
+		z.block = append(z.block, z.run_byte, z.run_byte, z.run_byte, z.run_byte, byte(z.run_length-4))
+	}
+
+	z.run_length = 0
+	return nil
+}
+
+func (z *Writer) writeBlock() {
+
+	crc := ^z.block_crc
+	z.combined_crc = ((z.combined_crc << 1) | (z.combined_crc >> 31)) ^ crc
+
+	bwt, orig_ptr := transform(z.block)
+
+	// The symbols in use, in the order of their byte values
+	var in_use [256]bool
+
+	for _, b := range z.block {
+		in_use[b] = true
+	}
+
+	var seq [256]byte
+	n_in_use := 0
+
+	for b := 0; b < 256; b++ {
+
+		if in_use[b] {
+			seq[b] = byte(n_in_use)
+			n_in_use += 1
+		}
+	}
+
+	symbols := moveToFront(bwt, seq, n_in_use)
+	alpha_size := n_in_use + 2
+
+	freqs := make([]int, alpha_size)
+
+	for _, s := range symbols {
+		freqs[s] += 1
+	}
+
+	lengths := codeLengths(freqs)
+	codes := assignCodes(lengths)
+
+	bw := z.bw
+
+	bw.writeBits(48, block_magic)
+	bw.writeBits(32, uint64(crc))
+	bw.writeBits(1, 0) // Not randomized
+	bw.writeBits(24, uint64(orig_ptr))
+
+	// Two levels of bitmaps of the symbols in use
+	ranges := uint64(0)
+
+	for i := 0; i < 16; i++ {
+
+		for j := 0; j < 16; j++ {
+
+			if in_use[i*16+j] {
+				ranges |= 1 << (15 - i)
+				break
+			}
+		}
+	}
+
+	bw.writeBits(16, ranges)
+
+	for i := 0; i < 16; i++ {
+
+		if ranges&(1<<(15-i)) == 0 {
+			continue
+		}
+
+		bits := uint64(0)
+
+		for j := 0; j < 16; j++ {
+
+			if in_use[i*16+j] {
+				bits |= 1 << (15 - j)
+			}
+		}
+
+		bw.writeBits(16, bits)
+	}
+
+	// The format requires at least two tables. Both are the same and every group uses the first.
+	n_groups := 2
+	n_selectors := (len(symbols) + group_size - 1) / group_size
+
+	bw.writeBits(3, uint64(n_groups))
+	bw.writeBits(15, uint64(n_selectors))
+
+	for i := 0; i < n_selectors; i++ {
+		bw.writeBits(1, 0)
+	}
+
+	for g := 0; g < n_groups; g++ {
+
+		current := lengths[0]
+		bw.writeBits(5, uint64(current))
+
+		for _, l := range lengths {
+
+			for current < l {
+				bw.writeBits(2, 2)
+				current += 1
+			}
+
+			for current > l {
+				bw.writeBits(2, 3)
+				current -= 1
+			}
+
+			bw.writeBits(1, 0)
+		}
+	}
+
+	for _, s := range symbols {
+		bw.writeBits(lengths[s], uint64(codes[s]))
+	}
+
+	z.block = z.block[:0]
+	z.block_crc = 0xffffffff
+}
+
+// transform returns the Burrows-Wheeler transform of block, the last column of its sorted rotations, and
+// the position of the unrotated block among them.
+func transform(block []byte) ([]byte, int) {
+
+	rotations := sortRotations(block)
+	n := len(block)
+
+	bwt := make([]byte, n)
+	orig_ptr := 0
+
+	for i, r := range rotations {
+
+		if r == 0 {
+			orig_ptr = i
+		}
+
+		bwt[i] = block[(r+n-1)%n]
+	}
+
+	return bwt, orig_ptr
+}
+
+// sortRotations returns the starting positions of the rotations of s in sorted order, by prefix doubling
+// with counting sorts.
+func sortRotations(s []byte) []int {
+
+	n := len(s)
+
+	p := make([]int, n)
+	c := make([]int, n)
+	pn := make([]int, n)
+	cn := make([]int, n)
+	counts := make([]int, 256)
+
+	if n > 256 {
+		counts = make([]int, n)
+	}
+
+	for _, b := range s {
+		counts[b] += 1
+	}
+
+	for i := 1; i < 256; i++ {
+		counts[i] += counts[i-1]
+	}
+
+	for i := n - 1; i >= 0; i-- {
+		counts[s[i]] -= 1
+		p[counts[s[i]]] = i
+	}
+
+	classes := 1
+
+	for i := 1; i < n; i++ {
+
+		if s[p[i]] != s[p[i-1]] {
+			classes += 1
+		}
+
+		c[p[i]] = classes - 1
+	}
+
+	for h := 1; h < n && classes < n; h = h * 2 {
+
+		// Sort by the second half of each 2h prefix, which is the first half of another rotation
+		for i := range p {
+			pn[i] = (p[i] - h + n) % n
+		}
+
+		for i := 0; i < classes; i++ {
+			counts[i] = 0
+		}
+
+		for _, r := range pn {
+			counts[c[r]] += 1
+		}
+
+		for i := 1; i < classes; i++ {
+			counts[i] += counts[i-1]
+		}
+
+		for i := n - 1; i >= 0; i-- {
+			counts[c[pn[i]]] -= 1
+			p[counts[c[pn[i]]]] = pn[i]
+		}
+
+		cn[p[0]] = 0
+		classes = 1
+
+		for i := 1; i < n; i++ {
+
+			if c[p[i]] != c[p[i-1]] || c[(p[i]+h)%n] != c[(p[i-1]+h)%n] {
+				classes += 1
+			}
+
+			cn[p[i]] = classes - 1
+		}
+
+		c, cn = cn, c
+	}
+
+	return p
+}
+
+// moveToFront returns the symbols encoding bwt: the move-to-front positions of each byte, with runs of
+// zeros written in bijective base 2 using RUNA and RUNB, followed by the end of block symbol.
+func moveToFront(bwt []byte, seq [256]byte, n_in_use int) []uint16 {
+
+	symbols := make([]uint16, 0, len(bwt)+1)
+
+	var order [256]byte
+
+	for i := range order {
+		order[i] = byte(i)
+	}
+
+	zeros := 0
+
+	flushZeros := func() {
+
+		if zeros == 0 {
+			return
+		}
+
+		zeros -= 1
+
+		for {
+
+			if zeros&1 == 1 {
+				symbols = append(symbols, uint16(run_b))
+			} else {
+				symbols = append(symbols, uint16(run_a))
+			}
+
+			if zeros < 2 {
+				break
+			}
+
+			zeros = (zeros - 2) / 2
+		}
+
+		zeros = 0
+	}
+
+	for _, b := range bwt {
+
+		s := seq[b]
+
+		if order[0] == s {
+			zeros += 1
+			continue
+		}
+
+		flushZeros()
+
+		j := 1
+
+		for order[j] != s {
+			j += 1
+		}
+
+		copy(order[1:j+1], order[:j])
+		order[0] = s
+
+		symbols = append(symbols, uint16(j+1))
+	}
+
+	flushZeros()
+
+	symbols = append(symbols, uint16(n_in_use+1))
+	return symbols
+}
+
+// codeLengths returns the lengths of the Huffman codes for symbols with the frequencies freqs, none of
+// which is longer than max_code_length. Every symbol is given a code.
+func codeLengths(freqs []int) []int {
+
+	weights := make([]int, len(freqs))
+
+	for i, f := range freqs {
+		weights[i] = f + 1
+	}
+
+	for {
+
+		lengths := huffmanLengths(weights)
+		too_long := false
+
+		for _, l := range lengths {
+
+			if l > max_code_length {
+				too_long = true
+				break
+			}
+		}
+
+		if !too_long {
+			return lengths
+		}
+
+		// Flatten the distribution, as the reference encoder does, and try again
+		for i, w := range weights {
+			weights[i] = 1 + w/2
+		}
+	}
+}
+
+type huffmanNode struct {
+	weight int
+	// symbol is the symbol of a leaf, or -1.
+	symbol      int
+	left, right *huffmanNode
+}
+
+func huffmanLengths(weights []int) []int {
+
+	nodes := make([]*huffmanNode, len(weights))
+
+	for i, w := range weights {
+		nodes[i] = &huffmanNode{weight: w, symbol: i}
+	}
+
+	for len(nodes) > 1 {
+
+		sort.SliceStable(nodes, func(i int, j int) bool {
+			return nodes[i].weight < nodes[j].weight
+		})
+
+		parent := &huffmanNode{
+			weight: nodes[0].weight + nodes[1].weight,
+			symbol: -1,
+			left:   nodes[0],
+			right:  nodes[1],
+		}
+
+		nodes = append(nodes[2:], parent)
+	}
+
+	lengths := make([]int, len(weights))
+
+	var walk func(n *huffmanNode, depth int)
+
+	walk = func(n *huffmanNode, depth int) {
+
+		if n.symbol >= 0 {
+			lengths[n.symbol] = depth
+			return
+		}
+
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+
+	walk(nodes[0], 0)
+	return lengths
+}
+
+// assignCodes returns the canonical Huffman codes for lengths, assigned in order of length and then symbol.
+func assignCodes(lengths []int) []uint32 {
+
+	codes := make([]uint32, len(lengths))
+	code := uint32(0)
+
+	for l := 1; l <= max_code_length; l++ {
+
+		for s, sl := range lengths {
+
+			if sl == l {
+				codes[s] = code
+				code += 1
+			}
+		}
+
+		code = code << 1
+	}
+
+	return codes
+}
+
+// bitWriter writes bits, most significant first.
+type bitWriter struct {
+	w     *bufio.Writer
+	bits  uint64
+	count int
+	err   error
+}
+
+func newBitWriter(w io.Writer) *bitWriter {
+	return &bitWriter{w: bufio.NewWriter(w)}
+}
+
+// writeBits writes the n (at most 48) least significant bits of v.
+func (bw *bitWriter) writeBits(n int, v uint64) {
+
+	if bw.err != nil {
+		return
+	}
+
+	bw.bits = (bw.bits << n) | (v & (1<<n - 1))
+	bw.count += n
+
+	for bw.count >= 8 {
+
+		bw.count -= 8
+		bw.err = bw.w.WriteByte(byte(bw.bits >> bw.count))
+
+		if bw.err != nil {
+			return
+		}
+	}
+}
+
+// flush writes any remaining bits, padded with zeros to a whole byte, and flushes the underlying writer.
+func (bw *bitWriter) flush() error {
+
+	if bw.count > 0 {
+		bw.writeBits(8-bw.count, 0)
+	}
+
+	if bw.err != nil {
+		return bw.err
+	}
+
+	return bw.w.Flush()
+}
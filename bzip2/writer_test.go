@@ -0,0 +1,191 @@
+package bzip2
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"fmt"
+	"io"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func compress(t *testing.T, data []byte, chunk int) []byte {
+
+	var buf bytes.Buffer
+
+	z := NewWriter(&buf)
+
+	for len(data) > 0 {
+
+		n := chunk
+
+		if n > len(data) {
+			n = len(data)
+		}
+
+		_, err := z.Write(data[:n])
+
+		if err != nil {
+			t.Fatalf("Failed to write, %v", err)
+		}
+
+		data = data[n:]
+	}
+
+	err := z.Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close writer, %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func roundTrip(t *testing.T, name string, data []byte) {
+
+	for _, chunk := range []int{1 << 20, 7} {
+
+		// Writing a byte at a time is too slow for the larger inputs
+		if chunk < 100 && len(data) > 100000 {
+			continue
+		}
+
+		compressed := compress(t, data, chunk)
+
+		out, err := io.ReadAll(bzip2.NewReader(bytes.NewReader(compressed)))
+
+		if err != nil {
+			t.Fatalf("Failed to decompress %s (written %d bytes at a time), %v", name, chunk, err)
+		}
+
+		if !bytes.Equal(out, data) {
+			t.Fatalf("Decompressed %s (written %d bytes at a time) does not match, got %d bytes, expected %d", name, chunk, len(out), len(data))
+		}
+	}
+}
+
+// randomBytes returns n bytes without any runs, so that the initial run-length encoding does not change
+// their length.
+func randomBytes(r *rand.Rand, n int) []byte {
+
+	data := make([]byte, n)
+
+	for i := range data {
+
+		data[i] = byte(r.Intn(256))
+
+		if i > 0 && data[i] == data[i-1] {
+			data[i] += 1
+		}
+	}
+
+	return data
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+
+	r := rand.New(rand.NewSource(1))
+
+	tests := map[string][]byte{
+		"empty":        {},
+		"one byte":     []byte("a"),
+		"text":         []byte(strings.Repeat(`{"_id":"1","_source":{"title":"Tin Drum"}}`+"\n", 1000)),
+		"run of 3":     []byte("xaaay"),
+		"run of 4":     []byte("xaaaay"),
+		"run of 5":     []byte("xaaaaay"),
+		"run of 255":   bytes.Repeat([]byte("a"), 255),
+		"run of 256":   bytes.Repeat([]byte("a"), 256),
+		"run of 259":   bytes.Repeat([]byte("a"), 259),
+		"run of 1000":  append(append([]byte("x"), bytes.Repeat([]byte("a"), 1000)...), 'y'),
+		"high and low": bytes.Repeat([]byte("\x00\x01\x02\xfe\xff"), 100),
+		"random":       randomBytes(r, 10000),
+		"single value": bytes.Repeat([]byte{0}, 5000000),
+	}
+
+	for name, data := range tests {
+		roundTrip(t, name, data)
+	}
+}
+
+func TestWriterBlockBoundaries(t *testing.T) {
+
+	r := rand.New(rand.NewSource(2))
+
+	for _, n := range []int{max_block_size - 5, max_block_size - 1, max_block_size, max_block_size + 1, max_block_size + 5} {
+		roundTrip(t, fmt.Sprintf("%d random bytes", n), randomBytes(r, n))
+	}
+
+	// A run which is encoded at the end of a full block
+	data := append(randomBytes(r, max_block_size-3), bytes.Repeat([]byte{'a'}, 300)...)
+	roundTrip(t, "run at the end of a block", data)
+}
+
+func TestWriterMultipleBlocks(t *testing.T) {
+
+	r := rand.New(rand.NewSource(3))
+
+	var buf bytes.Buffer
+
+	for buf.Len() < max_block_size*3 {
+
+		switch r.Intn(3) {
+		case 0:
+			buf.Write(randomBytes(r, r.Intn(1000)))
+		case 1:
+			buf.Write(bytes.Repeat([]byte{byte(r.Intn(256))}, r.Intn(600)))
+		default:
+			fmt.Fprintf(&buf, `{"_id":"%d","_source":{"n":%d}}`+"\n", r.Int(), r.Intn(100))
+		}
+	}
+
+	data := buf.Bytes()
+	roundTrip(t, "multiple blocks", data)
+
+	// Check the output against the reference implementation too, if it is installed
+	path, err := exec.LookPath("bzip2")
+
+	if err != nil {
+		return
+	}
+
+	cmd := exec.Command(path, "-d", "-c")
+	cmd.Stdin = bytes.NewReader(compress(t, data, 1<<20))
+
+	out, err := cmd.Output()
+
+	if err != nil {
+		t.Fatalf("Failed to decompress with %s, %v", path, err)
+	}
+
+	if !bytes.Equal(out, data) {
+		t.Fatalf("Data decompressed by %s does not match", path)
+	}
+}
+
+func TestWriterClosed(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	z := NewWriter(&buf)
+
+	err := z.Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close writer, %v", err)
+	}
+
+	// Closing again is a no-op
+	err = z.Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close writer again, %v", err)
+	}
+
+	_, err = z.Write([]byte("a"))
+
+	if err == nil {
+		t.Fatalf("Expected an error writing to a closed writer")
+	}
+}
@@ -0,0 +1,148 @@
+// package canonical provides methods for producing canonical encodings of JSON documents so that they
+// can be compared byte-for-byte.
+package canonical
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Marshal returns the canonical encoding of the JSON document body: object keys are sorted, insignificant
+// whitespace is removed and HTML characters are not escaped.
+func Marshal(body []byte) ([]byte, error) {
+
+	v, err := decode(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(v)
+}
+
+// Normalize returns the canonical encoding of the JSON document body, like Marshal, with numbers also
+// written in a canonical form: "1.0", "1e0" and "1" are all written as "1", for example. Whole numbers
+// without a fraction or exponent are left as they are so that large integers do not lose precision.
+// Other numbers are rounded to the nearest float64.
+func Normalize(body []byte) ([]byte, error) {
+
+	v, err := decode(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(normalizeNumbers(v))
+}
+
+func decode(body []byte) (interface{}, error) {
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var v interface{}
+
+	err := dec.Decode(&v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func encode(v interface{}) ([]byte, error) {
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	err := enc.Encode(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+func normalizeNumbers(v interface{}) interface{} {
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+
+		for k, child := range t {
+			t[k] = normalizeNumbers(child)
+		}
+
+	case []interface{}:
+
+		for i, child := range t {
+			t[i] = normalizeNumbers(child)
+		}
+
+	case json.Number:
+		return normalizeNumber(t)
+	}
+
+	return v
+}
+
+func normalizeNumber(n json.Number) json.Number {
+
+	s := string(n)
+
+	if !strings.ContainsAny(s, ".eE") {
+
+		if s == "-0" {
+			return json.Number("0")
+		}
+
+		return n
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+
+	// Out of range
+	if err != nil {
+		return n
+	}
+
+	abs := math.Abs(f)
+
+	switch {
+	case f == 0:
+		return json.Number("0")
+	case abs >= 1e-6 && abs < 1e21:
+		return json.Number(strconv.FormatFloat(f, 'f', -1, 64))
+	default:
+
+		// Go pads exponents to two digits ("1e-07") unlike JavaScript and most other encoders ("1e-7")
+		s = strconv.FormatFloat(f, 'e', -1, 64)
+		s = strings.Replace(strings.Replace(s, "e-0", "e-", 1), "e+0", "e+", 1)
+
+		return json.Number(s)
+	}
+}
+
+// Equal reports whether the JSON documents a and b have the same canonical encoding.
+func Equal(a []byte, b []byte) (bool, error) {
+
+	enc_a, err := Marshal(a)
+
+	if err != nil {
+		return false, err
+	}
+
+	enc_b, err := Marshal(b)
+
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(enc_a, enc_b), nil
+}
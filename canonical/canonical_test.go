@@ -0,0 +1,119 @@
+package canonical
+
+import (
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+
+	tests := map[string]string{
+		`{"b": 1, "a": {"d": [3, 2], "c": "<&>"}}`: `{"a":{"c":"<&>","d":[3,2]},"b":1}`,
+		`[ 1.0, "x" ]`: `[1.0,"x"]`,
+		`"str"`:        `"str"`,
+	}
+
+	for input, expected := range tests {
+
+		enc, err := Marshal([]byte(input))
+
+		if err != nil {
+			t.Fatalf("Failed to marshal %s, %v", input, err)
+		}
+
+		if string(enc) != expected {
+			t.Fatalf("Expected %s for %s, got %s", expected, input, enc)
+		}
+	}
+}
+
+func TestMarshalInvalid(t *testing.T) {
+
+	_, err := Marshal([]byte(`{"a":`))
+
+	if err == nil {
+		t.Fatalf("Expected an error for invalid JSON")
+	}
+}
+
+func TestEqual(t *testing.T) {
+
+	eq, err := Equal([]byte(`{"a": 1, "b": [1, 2]}`), []byte(`{"b":[1,2],"a":1}`))
+
+	if err != nil {
+		t.Fatalf("Failed to compare, %v", err)
+	}
+
+	if !eq {
+		t.Fatalf("Expected documents with reordered keys to be equal")
+	}
+
+	eq, err = Equal([]byte(`{"b": [2, 1]}`), []byte(`{"b": [1, 2]}`))
+
+	if err != nil {
+		t.Fatalf("Failed to compare, %v", err)
+	}
+
+	if eq {
+		t.Fatalf("Expected arrays in a different order to differ")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+
+	tests := map[string]string{
+		`{"b": 1.0, "a": [1e0, 1E+0, 10.50, -0, -0.0, 0e5]}`: `{"a":[1,1,10.5,0,0,0],"b":1}`,
+		// Whole numbers are left as they are, however large
+		`[12345678901234567890, 1234567890123456789012345]`: `[12345678901234567890,1234567890123456789012345]`,
+		// Other numbers are written in decimal notation between 1e-6 and 1e21, and in exponent notation
+		// otherwise
+		`[1.5e2, 0.000001, 1e-7, 2.5E-7, 1e20, 1e21, 123.456e30]`: `[150,0.000001,1e-7,2.5e-7,100000000000000000000,1e+21,1.23456e+32]`,
+		// Numbers are rounded to the nearest float64
+		`[0.1000000000000000000001, 3.14159265358979323846]`: `[0.1,3.141592653589793]`,
+		// Numbers out of range for a float64 are left as they are
+		`[1e400]`:                            `[1e400]`,
+		`{"s": "1.0", "t": true, "n": null}`: `{"n":null,"s":"1.0","t":true}`,
+	}
+
+	for input, expected := range tests {
+
+		enc, err := Normalize([]byte(input))
+
+		if err != nil {
+			t.Fatalf("Failed to normalize %s, %v", input, err)
+		}
+
+		if string(enc) != expected {
+			t.Fatalf("Expected %s for %s, got %s", expected, input, enc)
+		}
+	}
+}
+
+func TestNormalizeIdempotent(t *testing.T) {
+
+	input := []byte(`{"z": [1.50, {"y": 2e3, "x": 1e-9}], "a": 0.5}`)
+
+	first, err := Normalize(input)
+
+	if err != nil {
+		t.Fatalf("Failed to normalize %s, %v", input, err)
+	}
+
+	second, err := Normalize(first)
+
+	if err != nil {
+		t.Fatalf("Failed to normalize %s, %v", first, err)
+	}
+
+	if string(first) != string(second) {
+		t.Fatalf("Expected normalizing %s again to leave it unchanged, got %s", first, second)
+	}
+}
+
+func TestNormalizeInvalid(t *testing.T) {
+
+	_, err := Normalize([]byte(`[1.0,`))
+
+	if err == nil {
+		t.Fatalf("Expected an error for invalid JSON")
+	}
+}
@@ -0,0 +1,266 @@
+// package client provides methods for configuring Elasticsearch clients from command line flags and
+// environment variables.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/cluster"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/sigv4"
+)
+
+// Options are the settings used to connect to an Elasticsearch cluster. Secrets are never read from flags
+// directly, only from files or environment variables, so that they do not show up in process listings.
+type Options struct {
+	Endpoint           string
+	CloudID            string
+	Username           string
+	PasswordFile       string
+	APIKeyFile         string
+	BearerTokenFile    string
+	CACertFile         string
+	ClientCertFile     string
+	ClientKeyFile      string
+	InsecureSkipVerify bool
+	AWSSigV4           bool
+	AWSRegion          string
+	AWSService         string
+	AWSProfile         string
+	env_prefix         string
+}
+
+// AppendFlags defines the flags for connecting to an Elasticsearch cluster in fs and returns the Options
+// they populate. Flag names are prefixed with prefix (for example "source-") and environment variable
+// names with its upper-cased equivalent (for example "SOURCE_").
+func AppendFlags(fs *flag.FlagSet, prefix string) *Options {
+
+	env_prefix := strings.ToUpper(strings.ReplaceAll(prefix, "-", "_"))
+
+	opts := &Options{
+		env_prefix: env_prefix,
+	}
+
+	fs.StringVar(&opts.Endpoint, prefix+"elasticsearch-endpoint", "", "The name of the Elasticsearch host to query.")
+	fs.StringVar(&opts.CloudID, prefix+"elasticsearch-cloud-id", "", "The Elastic Cloud ID of the deployment to connect to, instead of -"+prefix+"elasticsearch-endpoint.")
+	fs.StringVar(&opts.Username, prefix+"elasticsearch-username", "", "The username for HTTP Basic authentication. If empty the "+env_prefix+"ELASTICSEARCH_USERNAME environment variable is used, if set, which can not be combined with -"+prefix+"elasticsearch-aws-sigv4. The password is read from -"+prefix+"elasticsearch-password-file or the "+env_prefix+"ELASTICSEARCH_PASSWORD environment variable.")
+	fs.StringVar(&opts.PasswordFile, prefix+"elasticsearch-password-file", "", "The path to a file containing the password for HTTP Basic authentication.")
+	fs.StringVar(&opts.APIKeyFile, prefix+"elasticsearch-api-key-file", "", "The path to a file containing a base64-encoded API key. If empty the "+env_prefix+"ELASTICSEARCH_API_KEY environment variable is used, if set.")
+	fs.StringVar(&opts.BearerTokenFile, prefix+"elasticsearch-bearer-token-file", "", "The path to a file containing a bearer (or service account) token. If empty the "+env_prefix+"ELASTICSEARCH_BEARER_TOKEN environment variable is used, if set.")
+	fs.StringVar(&opts.CACertFile, prefix+"elasticsearch-ca-cert", "", "The path to a PEM-encoded bundle of certificate authorities to trust instead of the system roots.")
+	fs.StringVar(&opts.ClientCertFile, prefix+"elasticsearch-client-cert", "", "The path to a PEM-encoded client certificate for mutual TLS.")
+	fs.StringVar(&opts.ClientKeyFile, prefix+"elasticsearch-client-key", "", "The path to the PEM-encoded private key for -"+prefix+"elasticsearch-client-cert.")
+	fs.BoolVar(&opts.InsecureSkipVerify, prefix+"elasticsearch-insecure-skip-verify", false, "Do not verify the server's TLS certificate. This is insecure and should only be used for testing.")
+
+	fs.BoolVar(&opts.AWSSigV4, prefix+"elasticsearch-aws-sigv4", false, "Sign requests with AWS Signature Version 4, for Amazon OpenSearch Service domains. Credentials are read from -"+prefix+"elasticsearch-aws-profile, the standard AWS environment variables, a web identity token, the AWS_PROFILE (or default) profile, or the ECS or EC2 metadata endpoints, and temporary credentials are refreshed before they expire. Profiles can use static keys, credential_process or web_identity_token_file; profiles which assume a role from another profile or use SSO are not supported.")
+	fs.StringVar(&opts.AWSRegion, prefix+"elasticsearch-aws-region", "", "The AWS region of the domain. If empty the AWS_REGION or AWS_DEFAULT_REGION environment variable, or the region of the AWS profile, is used.")
+	fs.StringVar(&opts.AWSService, prefix+"elasticsearch-aws-service", "es", "The AWS service name to sign requests for. Use \"aoss\" for OpenSearch Serverless collections.")
+	fs.StringVar(&opts.AWSProfile, prefix+"elasticsearch-aws-profile", "", "The AWS profile to read credentials and region from. It takes precedence over credentials in environment variables. If empty the AWS_PROFILE environment variable is used, or \"default\".")
+
+	return opts
+}
+
+// Config returns an elasticsearch.Config populated from opts.
+func (opts *Options) Config() (elasticsearch.Config, error) {
+
+	cfg := elasticsearch.Config{}
+
+	if opts.CloudID != "" {
+		cfg.CloudID = opts.CloudID
+	} else {
+		cfg.Addresses = []string{opts.Endpoint}
+	}
+
+	var err error
+
+	cfg.Username = opts.Username
+
+	// A username left in the environment is used as well, and counts as credentials which can not be
+	// combined with SigV4 signing
+	if cfg.Username == "" {
+		cfg.Username = os.Getenv(opts.env_prefix + "ELASTICSEARCH_USERNAME")
+	}
+
+	cfg.Password, err = opts.secret(opts.PasswordFile, "ELASTICSEARCH_PASSWORD")
+
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.APIKey, err = opts.secret(opts.APIKeyFile, "ELASTICSEARCH_API_KEY")
+
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.ServiceToken, err = opts.secret(opts.BearerTokenFile, "ELASTICSEARCH_BEARER_TOKEN")
+
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.Password != "" && cfg.Username == "" {
+		return cfg, fmt.Errorf("A password was provided without a username")
+	}
+
+	tls_cfg, err := opts.tlsConfig()
+
+	if err != nil {
+		return cfg, err
+	}
+
+	if tls_cfg != nil {
+
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = tls_cfg
+
+		cfg.Transport = tr
+	}
+
+	if opts.AWSSigV4 {
+
+		// SigV4 uses the Authorization header so it can not be combined with any other credentials
+		if cfg.Username != "" && opts.Username == "" {
+			return cfg, fmt.Errorf("AWS SigV4 signing can not be combined with other credentials, unset the %sELASTICSEARCH_USERNAME environment variable", opts.env_prefix)
+		}
+
+		if cfg.Username != "" || cfg.APIKey != "" || cfg.ServiceToken != "" {
+			return cfg, fmt.Errorf("AWS SigV4 signing can not be combined with other credentials")
+		}
+
+		tr, err := opts.signingTransport(cfg.Transport)
+
+		if err != nil {
+			return cfg, err
+		}
+
+		cfg.Transport = tr
+	}
+
+	return cfg, nil
+}
+
+// NewClient returns a new cluster.Client configured by opts.
+func (opts *Options) NewClient(ctx context.Context) (cluster.Client, error) {
+
+	cfg, err := opts.Config()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cluster.NewClient(ctx, cfg)
+}
+
+// secret returns the trimmed contents of path if it is not empty and otherwise the value of the environment
+// variable env_var (with the Options' prefix).
+func (opts *Options) secret(path string, env_var string) (string, error) {
+
+	if path == "" {
+		return os.Getenv(opts.env_prefix + env_var), nil
+	}
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to read %s, %w", path, err)
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
+
+func (opts *Options) signingTransport(base http.RoundTripper) (http.RoundTripper, error) {
+
+	creds, err := sigv4.LoadCredentials(opts.AWSProfile)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load AWS credentials, %w", err)
+	}
+
+	// Retrieve the credentials now so that a misconfiguration is reported before anything else is done
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err = creds.Retrieve(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve AWS credentials, %w", err)
+	}
+
+	region := opts.AWSRegion
+
+	if region == "" {
+		region = sigv4.LoadRegion(opts.AWSProfile)
+	}
+
+	if region == "" {
+		return nil, fmt.Errorf("AWS SigV4 signing requires a region")
+	}
+
+	if opts.AWSService == "" {
+		return nil, fmt.Errorf("AWS SigV4 signing requires a service name")
+	}
+
+	tr := &sigv4.Transport{
+		Base:        base,
+		Credentials: creds,
+		Region:      region,
+		Service:     opts.AWSService,
+	}
+
+	return tr, nil
+}
+
+func (opts *Options) tlsConfig() (*tls.Config, error) {
+
+	if opts.CACertFile == "" && opts.ClientCertFile == "" && opts.ClientKeyFile == "" && !opts.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	tls_cfg := &tls.Config{
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.CACertFile != "" {
+
+		body, err := os.ReadFile(opts.CACertFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s, %w", opts.CACertFile, err)
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(body) {
+			return nil, fmt.Errorf("No certificates found in %s", opts.CACertFile)
+		}
+
+		tls_cfg.RootCAs = pool
+	}
+
+	if opts.ClientCertFile != "" || opts.ClientKeyFile != "" {
+
+		if opts.ClientCertFile == "" || opts.ClientKeyFile == "" {
+			return nil, fmt.Errorf("Both a client certificate and a client key are required")
+		}
+
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load client certificate, %w", err)
+		}
+
+		tls_cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tls_cfg, nil
+}
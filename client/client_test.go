@@ -0,0 +1,201 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name string, body string) string {
+
+	path := filepath.Join(t.TempDir(), name)
+
+	err := os.WriteFile(path, []byte(body), 0600)
+
+	if err != nil {
+		t.Fatalf("Failed to write %s, %v", path, err)
+	}
+
+	return path
+}
+
+func parseFlags(t *testing.T, prefix string, args ...string) *Options {
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts := AppendFlags(fs, prefix)
+
+	err := fs.Parse(args)
+
+	if err != nil {
+		t.Fatalf("Failed to parse flags, %v", err)
+	}
+
+	return opts
+}
+
+func TestAppendFlagsPrefix(t *testing.T) {
+
+	opts := parseFlags(t, "source-", "-source-elasticsearch-endpoint", "http://localhost:9200")
+
+	if opts.Endpoint != "http://localhost:9200" {
+		t.Fatalf("Unexpected endpoint %s", opts.Endpoint)
+	}
+
+	t.Setenv("SOURCE_ELASTICSEARCH_API_KEY", "source-key")
+	t.Setenv("ELASTICSEARCH_API_KEY", "key")
+
+	cfg, err := opts.Config()
+
+	if err != nil {
+		t.Fatalf("Failed to create config, %v", err)
+	}
+
+	if cfg.APIKey != "source-key" {
+		t.Fatalf("Expected the prefixed environment variable to be used, got %s", cfg.APIKey)
+	}
+
+	if len(cfg.Addresses) != 1 || cfg.Addresses[0] != "http://localhost:9200" {
+		t.Fatalf("Unexpected addresses %v", cfg.Addresses)
+	}
+}
+
+func TestConfigSecrets(t *testing.T) {
+
+	password_file := writeFile(t, "password", "s3cret\n")
+
+	t.Setenv("ELASTICSEARCH_PASSWORD", "ignored")
+
+	opts := parseFlags(t, "", "-elasticsearch-username", "elastic", "-elasticsearch-password-file", password_file)
+
+	cfg, err := opts.Config()
+
+	if err != nil {
+		t.Fatalf("Failed to create config, %v", err)
+	}
+
+	if cfg.Username != "elastic" || cfg.Password != "s3cret" {
+		t.Fatalf("Expected the password to be read from its file, got %s:%s", cfg.Username, cfg.Password)
+	}
+
+	if cfg.Transport != nil {
+		t.Fatalf("Expected the default transport without any TLS options")
+	}
+}
+
+func TestConfigCloudID(t *testing.T) {
+
+	opts := parseFlags(t, "", "-elasticsearch-cloud-id", "name:abc")
+
+	cfg, err := opts.Config()
+
+	if err != nil {
+		t.Fatalf("Failed to create config, %v", err)
+	}
+
+	if cfg.CloudID != "name:abc" || len(cfg.Addresses) != 0 {
+		t.Fatalf("Expected only a cloud ID, got %s and %v", cfg.CloudID, cfg.Addresses)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+
+	t.Setenv("ELASTICSEARCH_PASSWORD", "s3cret")
+
+	opts := parseFlags(t, "")
+
+	_, err := opts.Config()
+
+	if err == nil {
+		t.Fatalf("Expected an error for a password without a username")
+	}
+
+	os.Unsetenv("ELASTICSEARCH_PASSWORD")
+
+	tests := [][]string{
+		{"-elasticsearch-password-file", filepath.Join(t.TempDir(), "missing")},
+		{"-elasticsearch-client-cert", "cert.pem"},
+		{"-elasticsearch-client-key", "key.pem"},
+		{"-elasticsearch-ca-cert", "ca.pem", "-elasticsearch-client-key", "key.pem"},
+		{"-elasticsearch-ca-cert", writeFile(t, "ca.pem", "not a certificate")},
+		{"-elasticsearch-aws-sigv4", "-elasticsearch-api-key-file", writeFile(t, "key", "abc")},
+	}
+
+	for _, args := range tests {
+
+		opts := parseFlags(t, "", args...)
+
+		_, err := opts.Config()
+
+		if err == nil {
+			t.Fatalf("Expected an error for %v", args)
+		}
+	}
+}
+
+func TestConfigUsernameSigV4(t *testing.T) {
+
+	// A username in the environment is not ignored when requests are signed
+	t.Setenv("ELASTICSEARCH_USERNAME", "elastic")
+
+	opts := parseFlags(t, "", "-elasticsearch-aws-sigv4")
+
+	_, err := opts.Config()
+
+	if err == nil || !strings.Contains(err.Error(), "ELASTICSEARCH_USERNAME") {
+		t.Fatalf("Expected an error for a username in the environment, got %v", err)
+	}
+}
+
+func TestConfigTLS(t *testing.T) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("Failed to generate key, %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatalf("Failed to create certificate, %v", err)
+	}
+
+	ca_file := writeFile(t, "ca.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+
+	opts := parseFlags(t, "", "-elasticsearch-ca-cert", ca_file)
+
+	cfg, err := opts.Config()
+
+	if err != nil {
+		t.Fatalf("Failed to create config, %v", err)
+	}
+
+	tr, ok := cfg.Transport.(*http.Transport)
+
+	if !ok || tr.TLSClientConfig == nil || tr.TLSClientConfig.RootCAs == nil {
+		t.Fatalf("Expected a transport trusting the certificate authority")
+	}
+
+	if tr.TLSClientConfig.InsecureSkipVerify {
+		t.Fatalf("Expected certificates to be verified")
+	}
+}
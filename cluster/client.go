@@ -0,0 +1,296 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/estransport"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	json "github.com/goccy/go-json"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/model"
+)
+
+// client implements the parts of Client which are the same for every distribution. The API methods call the
+// transport directly, bypassing the client library's product check, since the cluster has already been
+// identified by Detect.
+type client struct {
+	info      *Info
+	transport estransport.Interface
+	api       *esapi.API
+	// pit_sort is the sort order which uniquely identifies each hit in a point in time.
+	pit_sort json.RawMessage
+}
+
+func newClient(info *Info, tr estransport.Interface, pit_sort json.RawMessage) *client {
+
+	c := &client{
+		info:      info,
+		transport: tr,
+		api:       esapi.New(tr),
+		pit_sort:  pit_sort,
+	}
+
+	return c
+}
+
+func (c *client) Perform(req *http.Request) (*http.Response, error) {
+	return c.transport.Perform(req)
+}
+
+func (c *client) Info() *Info {
+	return c.info
+}
+
+func (c *client) API() *esapi.API {
+	return c.api
+}
+
+func (c *client) Count(ctx context.Context, name string) (int, error) {
+
+	rsp, err := c.api.Count(
+		c.api.Count.WithContext(ctx),
+		c.api.Count.WithIndex(name),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.IsError() {
+		return 0, fmt.Errorf("Failed to count %s, %s", name, rsp.String())
+	}
+
+	count := &model.ESCountResponse{}
+
+	err = json.NewDecoder(rsp.Body).Decode(count)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to decode count for %s, %w", name, err)
+	}
+
+	return count.Count, nil
+}
+
+func (c *client) Search(ctx context.Context, req *SearchRequest) (*esapi.Response, error) {
+
+	body := &model.ESQuery{
+		Query: req.Query,
+		Slice: req.Slice,
+	}
+
+	if len(body.Query) == 0 {
+		body.Query = json.RawMessage(`{"match_all":{}}`)
+	}
+
+	opts := []func(*esapi.SearchRequest){
+		c.api.Search.WithContext(ctx),
+		c.api.Search.WithSize(req.Size),
+		c.api.Search.WithTrackScores(false),
+		c.api.Search.WithSource("true"),
+		c.api.Search.WithFilterPath(req.FilterPath...),
+	}
+
+	if req.Version {
+		opts = append(opts, c.api.Search.WithVersion(true))
+	}
+
+	if len(req.SourceIncludes) > 0 {
+		opts = append(opts, c.api.Search.WithSourceIncludes(req.SourceIncludes...))
+	}
+
+	if len(req.SourceExcludes) > 0 {
+		opts = append(opts, c.api.Search.WithSourceExcludes(req.SourceExcludes...))
+	}
+
+	if req.PointInTime != "" {
+
+		body.PointInTime = &model.ESPIT{
+			ID:        req.PointInTime,
+			KeepAlive: keepAlive(req.KeepAlive),
+		}
+
+		body.Sort = make([]json.RawMessage, 0, len(req.Sort)+1)
+		body.Sort = append(body.Sort, req.Sort...)
+		body.Sort = append(body.Sort, c.pit_sort)
+		body.SearchAfter = req.SearchAfter
+
+		opts = append(opts, c.api.Search.WithTrackTotalHits(false))
+
+	} else {
+
+		opts = append(opts,
+			c.api.Search.WithIndex(req.Index),
+			c.api.Search.WithSort("_doc"),
+		)
+
+		if req.Scroll > 0 {
+			opts = append(opts, c.api.Search.WithScroll(req.Scroll))
+		}
+
+		if req.Preference != "" {
+			opts = append(opts, c.api.Search.WithPreference(req.Preference))
+		}
+	}
+
+	opts = append(opts, c.api.Search.WithBody(esutil.NewJSONReader(body)))
+
+	return c.api.Search(opts...)
+}
+
+func (c *client) Scroll(ctx context.Context, id string, keep_alive time.Duration, filter_path []string) (*esapi.Response, error) {
+
+	return c.api.Scroll(
+		c.api.Scroll.WithContext(ctx),
+		c.api.Scroll.WithScrollID(id),
+		c.api.Scroll.WithScroll(keep_alive),
+		c.api.Scroll.WithFilterPath(filter_path...),
+	)
+}
+
+func (c *client) ClearScroll(ctx context.Context, id string) error {
+
+	rsp, err := c.api.ClearScroll(
+		c.api.ClearScroll.WithContext(ctx),
+		c.api.ClearScroll.WithScrollID(id),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.IsError() {
+		return fmt.Errorf("Failed to clear scroll, %s", rsp.String())
+	}
+
+	return nil
+}
+
+func (c *client) OpenPointInTime(ctx context.Context, name string, keep_alive time.Duration) (string, error) {
+
+	rsp, err := c.api.OpenPointInTime(
+		[]string{name},
+		keepAlive(keep_alive),
+		c.api.OpenPointInTime.WithContext(ctx),
+	)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.IsError() {
+		return "", fmt.Errorf("Failed to open point in time for %s, %s", name, rsp.String())
+	}
+
+	pit := &model.ESPIT{}
+
+	err = json.NewDecoder(rsp.Body).Decode(pit)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to decode point in time for %s, %w", name, err)
+	}
+
+	if pit.ID == "" {
+		return "", fmt.Errorf("Failed to open point in time for %s", name)
+	}
+
+	return pit.ID, nil
+}
+
+func (c *client) ClosePointInTime(ctx context.Context, id string) error {
+
+	rsp, err := c.api.ClosePointInTime(
+		c.api.ClosePointInTime.WithContext(ctx),
+		c.api.ClosePointInTime.WithBody(esutil.NewJSONReader(&model.ESPIT{ID: id})),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.IsError() {
+		return fmt.Errorf("Failed to close point in time, %s", rsp.String())
+	}
+
+	return nil
+}
+
+func (c *client) NodesStats(ctx context.Context, metrics ...string) (*model.ESNodeStatsResponse, error) {
+
+	rsp, err := c.api.Nodes.Stats(
+		c.api.Nodes.Stats.WithContext(ctx),
+		c.api.Nodes.Stats.WithMetric(metrics...),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.IsError() {
+		return nil, fmt.Errorf("Failed to retrieve node stats, %s", rsp.String())
+	}
+
+	s := &model.ESNodeStatsResponse{}
+
+	err = json.NewDecoder(rsp.Body).Decode(s)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode node stats, %w", err)
+	}
+
+	return s, nil
+}
+
+func (c *client) Health(ctx context.Context, name string) (string, error) {
+
+	opts := []func(*esapi.ClusterHealthRequest){
+		c.api.Cluster.Health.WithContext(ctx),
+	}
+
+	if name != "" {
+		opts = append(opts, c.api.Cluster.Health.WithIndex(name))
+	}
+
+	rsp, err := c.api.Cluster.Health(opts...)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer rsp.Body.Close()
+
+	// A 408 response means the health request itself timed out but the body is still valid
+	if rsp.IsError() && rsp.StatusCode != 408 {
+		return "", fmt.Errorf("Failed to retrieve cluster health, %s", rsp.String())
+	}
+
+	var health struct {
+		Status string `json:"status"`
+	}
+
+	err = json.NewDecoder(rsp.Body).Decode(&health)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to decode cluster health, %w", err)
+	}
+
+	return health.Status, nil
+}
+
+// keepAlive formats d as an Elasticsearch time unit.
+func keepAlive(d time.Duration) string {
+	return fmt.Sprintf("%dms", d.Milliseconds())
+}
@@ -0,0 +1,214 @@
+// package cluster provides a common interface for the Elasticsearch 7, Elasticsearch 8 and OpenSearch APIs used
+// by these tools, choosing an implementation by asking the cluster which distribution and version it is running.
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/estransport"
+	json "github.com/goccy/go-json"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/model"
+)
+
+const ELASTICSEARCH string = "elasticsearch"
+
+const OPENSEARCH string = "opensearch"
+
+// Info describes the distribution and version of a cluster.
+type Info struct {
+	// Distribution is ELASTICSEARCH or OPENSEARCH.
+	Distribution string
+	// Version is the version number reported by the cluster, for example "8.11.1".
+	Version string
+	Major   int
+	Minor   int
+}
+
+// String returns a description of i suitable for logging.
+func (i *Info) String() string {
+	return fmt.Sprintf("%s %s", i.Distribution, i.Version)
+}
+
+// AtLeast reports whether i is version major.minor or later.
+func (i *Info) AtLeast(major int, minor int) bool {
+	return i.Major > major || (i.Major == major && i.Minor >= minor)
+}
+
+// SearchRequest describes a single page of a search.
+type SearchRequest struct {
+	// Index is the index (or alias) to search. It is ignored if PointInTime is set.
+	Index string
+	// Query is the query to run. If empty all documents are matched.
+	Query json.RawMessage
+	// Size is the number of hits to return.
+	Size int
+	// Scroll, if greater than zero, opens a scroll which is kept alive for this long.
+	Scroll time.Duration
+	// PointInTime is the ID of a point in time (returned by OpenPointInTime) to search.
+	PointInTime string
+	// KeepAlive is how long to keep PointInTime alive.
+	KeepAlive time.Duration
+	// SearchAfter is the sort value of the last hit of the previous page of a point in time.
+	SearchAfter json.RawMessage
+	// Sort, if set, orders the hits of a point in time before the sort which uniquely identifies each hit.
+	Sort []json.RawMessage
+	// Slice, if set, limits the search to one slice of the index so that slices can be read independently.
+	Slice *model.ESSlice
+	// Preference, if set, chooses the shards to search, for example "_shards:0". It can not be used with
+	// PointInTime.
+	Preference string
+	// SourceIncludes and SourceExcludes are wildcard patterns for the _source properties to include in (or
+	// exclude from) each hit.
+	SourceIncludes []string
+	SourceExcludes []string
+	// Version includes the _version of each hit.
+	Version bool
+	// FilterPath limits the properties included in the response.
+	FilterPath []string
+}
+
+// Client is the subset of the Elasticsearch API used by these tools. Requests which are the same in every
+// supported version, such as index administration, are made using the methods returned by API. Client also
+// implements esapi.Transport for requests the API methods do not cover, and for bulk.BulkIndexer.
+type Client interface {
+	esapi.Transport
+	// Info returns the distribution and version of the cluster.
+	Info() *Info
+	// API returns the low-level API methods for the cluster.
+	API() *esapi.API
+	// Count returns the number of documents in the index (or alias) name.
+	Count(context.Context, string) (int, error)
+	// Search performs the search described by a SearchRequest.
+	Search(context.Context, *SearchRequest) (*esapi.Response, error)
+	// Scroll returns the next page of the scroll with the given ID, keeping it alive for the given duration.
+	Scroll(context.Context, string, time.Duration, []string) (*esapi.Response, error)
+	// ClearScroll frees the scroll with the given ID.
+	ClearScroll(context.Context, string) error
+	// OpenPointInTime opens a point in time for the index (or alias) name, kept alive for the given duration,
+	// and returns its ID.
+	OpenPointInTime(context.Context, string, time.Duration) (string, error)
+	// ClosePointInTime frees the point in time with the given ID.
+	ClosePointInTime(context.Context, string) error
+	// NodesStats returns the given statistics for every node in the cluster.
+	NodesStats(context.Context, ...string) (*model.ESNodeStatsResponse, error)
+	// Health returns the health status ("green", "yellow" or "red") of the index (or alias) name, or of the
+	// whole cluster if name is empty.
+	Health(context.Context, string) (string, error)
+}
+
+// NewClient returns a new Client for the cluster configured by cfg. The cluster is queried to determine its
+// distribution and version, which must be Elasticsearch 7 or 8 or OpenSearch 1 or later.
+func NewClient(ctx context.Context, cfg elasticsearch.Config) (Client, error) {
+
+	es_client, err := elasticsearch.NewClient(cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create client, %w", err)
+	}
+
+	info, err := Detect(ctx, es_client.Transport)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch info.Distribution {
+	case OPENSEARCH:
+		return newOpenSearchClient(info, es_client.Transport), nil
+	}
+
+	switch info.Major {
+	case 7:
+		return newElasticsearch7Client(info, es_client.Transport), nil
+	case 8:
+
+		// Elasticsearch 8 only returns responses in the shape expected by this (version 7) client library
+		// when asked to with compatibility headers
+		cfg.EnableCompatibilityMode = true
+
+		es_client, err = elasticsearch.NewClient(cfg)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create client, %w", err)
+		}
+
+		return newElasticsearch8Client(info, es_client.Transport), nil
+	default:
+		return nil, fmt.Errorf("Unsupported version %s", info)
+	}
+}
+
+// Detect asks the cluster reached through tr for its distribution and version. It performs the request
+// directly (rather than using elasticsearch.Client.Info) because the client library refuses to talk to
+// anything but Elasticsearch.
+func Detect(ctx context.Context, tr estransport.Interface) (*Info, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request, %w", err)
+	}
+
+	rsp, err := tr.Perform(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve cluster info, %w", err)
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to retrieve cluster info, %s", rsp.Status)
+	}
+
+	var body struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+
+	err = json.NewDecoder(rsp.Body).Decode(&body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode cluster info, %w", err)
+	}
+
+	info := &Info{
+		Distribution: ELASTICSEARCH,
+		Version:      body.Version.Number,
+	}
+
+	if body.Version.Distribution == OPENSEARCH {
+		info.Distribution = OPENSEARCH
+	}
+
+	// Version numbers may carry a suffix, for example "8.0.0-SNAPSHOT"
+	parts := strings.SplitN(strings.SplitN(info.Version, "-", 2)[0], ".", 3)
+
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("Invalid version number '%s'", info.Version)
+	}
+
+	info.Major, err = strconv.Atoi(parts[0])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid version number '%s', %w", info.Version, err)
+	}
+
+	info.Minor, err = strconv.Atoi(parts[1])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid version number '%s', %w", info.Version, err)
+	}
+
+	return info, nil
+}
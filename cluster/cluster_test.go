@@ -0,0 +1,377 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	json "github.com/goccy/go-json"
+	"github.com/tidwall/gjson"
+)
+
+// handlerTransport is an estransport.Interface which answers requests with an http.Handler.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (tr *handlerTransport) Perform(req *http.Request) (*http.Response, error) {
+
+	rec := httptest.NewRecorder()
+	tr.handler.ServeHTTP(rec, req)
+
+	return rec.Result(), nil
+}
+
+// testRequest is a request received by a testServer.
+type testRequest struct {
+	Method string
+	Path   string
+	Query  string
+	Accept string
+	Body   string
+}
+
+// testServer is a cluster which reports the GET / body info and answers point in time and search requests,
+// recording every request other than GET /.
+type testServer struct {
+	info     string
+	requests []*testRequest
+	mu       sync.Mutex
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if req.URL.Path == "/" {
+		fmt.Fprint(w, s.info)
+		return
+	}
+
+	body, _ := io.ReadAll(req.Body)
+
+	s.requests = append(s.requests, &testRequest{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  req.URL.RawQuery,
+		Accept: req.Header.Get("Accept"),
+		Body:   string(body),
+	})
+
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/_pit"):
+		fmt.Fprint(w, `{"id":"es-pit"}`)
+	case strings.HasSuffix(req.URL.Path, "/_search/point_in_time") && req.Method == http.MethodPost:
+		fmt.Fprint(w, `{"pit_id":"os-pit","_shards":{"total":1,"successful":1}}`)
+	case strings.HasSuffix(req.URL.Path, "/_count"):
+		fmt.Fprint(w, `{"count":3}`)
+	case strings.HasSuffix(req.URL.Path, "/_search") && req.Method == http.MethodPost:
+		fmt.Fprint(w, `{"hits":{"hits":[]}}`)
+	default:
+		fmt.Fprint(w, `{"succeeded":true}`)
+	}
+}
+
+// last returns the last request received by s.
+func (s *testServer) last() *testRequest {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[len(s.requests)-1]
+}
+
+func newTestClient(t *testing.T, info string) (Client, *testServer) {
+
+	t.Helper()
+
+	s := &testServer{info: info}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	c, err := NewClient(context.Background(), elasticsearch.Config{Addresses: []string{srv.URL}})
+
+	if err != nil {
+		t.Fatalf("Failed to create client for %s, %v", info, err)
+	}
+
+	return c, s
+}
+
+func elasticsearchInfo(version string) string {
+	return `{"name":"node-1","cluster_name":"test","version":{"number":"` + version + `","build_flavor":"default"},"tagline":"You Know, for Search"}`
+}
+
+func openSearchInfo(version string) string {
+	return `{"name":"node-1","cluster_name":"test","version":{"distribution":"opensearch","number":"` + version + `"},"tagline":"The OpenSearch Project: https://opensearch.org/"}`
+}
+
+func TestDetect(t *testing.T) {
+
+	tests := []struct {
+		Body         string
+		Distribution string
+		Major        int
+		Minor        int
+	}{
+		{elasticsearchInfo("7.17.7"), ELASTICSEARCH, 7, 17},
+		{elasticsearchInfo("8.11.1"), ELASTICSEARCH, 8, 11},
+		{elasticsearchInfo("8.0.0-SNAPSHOT"), ELASTICSEARCH, 8, 0},
+		{elasticsearchInfo("7.10"), ELASTICSEARCH, 7, 10},
+		{openSearchInfo("2.11.0"), OPENSEARCH, 2, 11},
+		{openSearchInfo("1.3.0-SNAPSHOT"), OPENSEARCH, 1, 3},
+	}
+
+	for _, test := range tests {
+
+		tr := &handlerTransport{http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprint(w, test.Body)
+		})}
+
+		info, err := Detect(context.Background(), tr)
+
+		if err != nil {
+			t.Fatalf("Failed to detect %s, %v", test.Body, err)
+		}
+
+		if info.Distribution != test.Distribution || info.Major != test.Major || info.Minor != test.Minor {
+			t.Fatalf("Expected %s %d.%d, got %s (%d.%d)", test.Distribution, test.Major, test.Minor, info, info.Major, info.Minor)
+		}
+	}
+}
+
+func TestDetectInvalid(t *testing.T) {
+
+	tests := map[string]struct {
+		Status int
+		Body   string
+	}{
+		"error status":      {http.StatusUnauthorized, `{"error":"unauthorized"}`},
+		"invalid JSON":      {http.StatusOK, `{"version":`},
+		"missing version":   {http.StatusOK, `{"name":"node-1"}`},
+		"major version":     {http.StatusOK, elasticsearchInfo("8")},
+		"non-numeric minor": {http.StatusOK, elasticsearchInfo("8.x.1")},
+	}
+
+	for name, test := range tests {
+
+		tr := &handlerTransport{http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(test.Status)
+			fmt.Fprint(w, test.Body)
+		})}
+
+		_, err := Detect(context.Background(), tr)
+
+		if err == nil {
+			t.Fatalf("Expected an error for a response with an %s", name)
+		}
+	}
+}
+
+func TestAtLeast(t *testing.T) {
+
+	info := &Info{Major: 7, Minor: 12}
+
+	tests := map[[2]int]bool{
+		{6, 8}:  true,
+		{7, 10}: true,
+		{7, 12}: true,
+		{7, 13}: false,
+		{8, 0}:  false,
+	}
+
+	for v, expected := range tests {
+
+		if info.AtLeast(v[0], v[1]) != expected {
+			t.Fatalf("Expected 7.12 at least %d.%d to be %t", v[0], v[1], expected)
+		}
+	}
+}
+
+func TestNewClient(t *testing.T) {
+
+	compatible := "application/vnd.elasticsearch+json;compatible-with=7"
+
+	tests := []struct {
+		Info       string
+		Client     string
+		Compatible bool
+	}{
+		{elasticsearchInfo("7.17.7"), "*cluster.elasticsearch7Client", false},
+		{elasticsearchInfo("8.11.1"), "*cluster.elasticsearch8Client", true},
+		{elasticsearchInfo("8.0.0-SNAPSHOT"), "*cluster.elasticsearch8Client", true},
+		{openSearchInfo("2.11.0"), "*cluster.openSearchClient", false},
+	}
+
+	for _, test := range tests {
+
+		c, s := newTestClient(t, test.Info)
+
+		if fmt.Sprintf("%T", c) != test.Client {
+			t.Fatalf("Expected a %s for %s, got %T", test.Client, c.Info(), c)
+		}
+
+		count, err := c.Count(context.Background(), "books")
+
+		if err != nil {
+			t.Fatalf("Failed to count documents, %v", err)
+		}
+
+		if count != 3 {
+			t.Fatalf("Expected 3 documents, got %d", count)
+		}
+
+		// Elasticsearch 8 is asked for responses in the shape of Elasticsearch 7
+		if (s.last().Accept == compatible) != test.Compatible {
+			t.Fatalf("Unexpected Accept header '%s' for %s", s.last().Accept, c.Info())
+		}
+	}
+
+	for _, version := range []string{"6.8.23", "9.0.0"} {
+
+		s := &testServer{info: elasticsearchInfo(version)}
+		srv := httptest.NewServer(s)
+		defer srv.Close()
+
+		_, err := NewClient(context.Background(), elasticsearch.Config{Addresses: []string{srv.URL}})
+
+		if err == nil {
+			t.Fatalf("Expected an error for Elasticsearch %s", version)
+		}
+	}
+}
+
+func TestPointInTime(t *testing.T) {
+
+	tests := []struct {
+		Info      string
+		ID        string
+		OpenPath  string
+		ClosePath string
+		CloseBody string
+		Sort      string
+	}{
+		{elasticsearchInfo("7.17.7"), "es-pit", "/books/_pit", "/_pit", `{"id":"es-pit"}`, `{"_shard_doc":"asc"}`},
+		{elasticsearchInfo("8.11.1"), "es-pit", "/books/_pit", "/_pit", `{"id":"es-pit"}`, `{"_shard_doc":"asc"}`},
+		{openSearchInfo("2.11.0"), "os-pit", "/books/_search/point_in_time", "/_search/point_in_time", `{"pit_id":["os-pit"]}`, `{"_id":"asc"}`},
+	}
+
+	ctx := context.Background()
+
+	for _, test := range tests {
+
+		c, s := newTestClient(t, test.Info)
+
+		id, err := c.OpenPointInTime(ctx, "books", time.Minute)
+
+		if err != nil {
+			t.Fatalf("Failed to open point in time on %s, %v", c.Info(), err)
+		}
+
+		req := s.last()
+
+		if id != test.ID || req.Method != http.MethodPost || req.Path != test.OpenPath || req.Query != "keep_alive=60000ms" {
+			t.Fatalf("Unexpected point in time %s from %s %s?%s on %s", id, req.Method, req.Path, req.Query, c.Info())
+		}
+
+		// Hits are sorted by the requested sort and then by the sort which uniquely identifies them
+		rsp, err := c.Search(ctx, &SearchRequest{
+			PointInTime: id,
+			KeepAlive:   time.Minute,
+			Sort:        []json.RawMessage{json.RawMessage(`{"lastmodified":"asc"}`)},
+			SearchAfter: json.RawMessage(`[1,2]`),
+			Size:        10,
+		})
+
+		if err != nil {
+			t.Fatalf("Failed to search point in time on %s, %v", c.Info(), err)
+		}
+
+		rsp.Body.Close()
+
+		req = s.last()
+
+		if req.Path != "/_search" {
+			t.Fatalf("Expected a search without an index, got %s", req.Path)
+		}
+
+		sort := gjson.Get(req.Body, "sort")
+
+		if sort.Raw != `[{"lastmodified":"asc"},`+test.Sort+`]` {
+			t.Fatalf("Unexpected sort %s on %s", sort.Raw, c.Info())
+		}
+
+		if gjson.Get(req.Body, "pit.id").String() != id || gjson.Get(req.Body, "search_after").Raw != `[1,2]` {
+			t.Fatalf("Unexpected search %s on %s", req.Body, c.Info())
+		}
+
+		err = c.ClosePointInTime(ctx, id)
+
+		if err != nil {
+			t.Fatalf("Failed to close point in time on %s, %v", c.Info(), err)
+		}
+
+		req = s.last()
+
+		if req.Method != http.MethodDelete || req.Path != test.ClosePath || gjson.Get(req.Body, "@ugly").Raw != test.CloseBody {
+			t.Fatalf("Unexpected close request %s %s %s on %s", req.Method, req.Path, req.Body, c.Info())
+		}
+	}
+}
+
+func TestPointInTimeVersion(t *testing.T) {
+
+	for _, info := range []string{elasticsearchInfo("7.11.2"), openSearchInfo("2.3.0")} {
+
+		c, s := newTestClient(t, info)
+
+		_, err := c.OpenPointInTime(context.Background(), "books", time.Minute)
+
+		if err == nil {
+			t.Fatalf("Expected an error opening a point in time on %s", c.Info())
+		}
+
+		if len(s.requests) != 0 {
+			t.Fatalf("Expected no requests to %s, got %d", c.Info(), len(s.requests))
+		}
+	}
+}
+
+func TestSearchScroll(t *testing.T) {
+
+	c, s := newTestClient(t, elasticsearchInfo("7.17.7"))
+
+	rsp, err := c.Search(context.Background(), &SearchRequest{
+		Index:      "books",
+		Scroll:     time.Minute,
+		Preference: "_shards:1",
+		Size:       10,
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to search, %v", err)
+	}
+
+	rsp.Body.Close()
+
+	req := s.last()
+
+	if req.Path != "/books/_search" || !strings.Contains(req.Query, "scroll=60000ms") || !strings.Contains(req.Query, "preference=_shards%3A1") || !strings.Contains(req.Query, "sort=_doc") {
+		t.Fatalf("Unexpected search %s?%s", req.Path, req.Query)
+	}
+
+	// A search without a query matches every document
+	if gjson.Get(req.Body, "query").Raw != `{"match_all":{}}` || gjson.Get(req.Body, "sort").Exists() {
+		t.Fatalf("Unexpected search body %s", req.Body)
+	}
+}
@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/estransport"
+	json "github.com/goccy/go-json"
+)
+
+// Every hit in an Elasticsearch point in time is uniquely identified by its shard and Lucene document ID.
+var shard_doc_sort = json.RawMessage(`{"_shard_doc":"asc"}`)
+
+// elasticsearch7Client is a Client for Elasticsearch 7.x clusters.
+type elasticsearch7Client struct {
+	*client
+}
+
+func newElasticsearch7Client(info *Info, tr estransport.Interface) Client {
+
+	c := &elasticsearch7Client{
+		client: newClient(info, tr, shard_doc_sort),
+	}
+
+	return c
+}
+
+// OpenPointInTime opens a point in time, which requires Elasticsearch 7.12 or later for the _shard_doc sort.
+func (c *elasticsearch7Client) OpenPointInTime(ctx context.Context, name string, keep_alive time.Duration) (string, error) {
+
+	if !c.info.AtLeast(7, 12) {
+		return "", fmt.Errorf("Points in time require Elasticsearch 7.12 or higher, cluster is running %s", c.info)
+	}
+
+	return c.client.OpenPointInTime(ctx, name, keep_alive)
+}
+
+// elasticsearch8Client is a Client for Elasticsearch 8.x clusters. Its transport sends compatibility headers
+// so that requests and responses have the same shape as they do in Elasticsearch 7.
+type elasticsearch8Client struct {
+	*client
+}
+
+func newElasticsearch8Client(info *Info, tr estransport.Interface) Client {
+
+	c := &elasticsearch8Client{
+		client: newClient(info, tr, shard_doc_sort),
+	}
+
+	return c
+}
@@ -0,0 +1,110 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/estransport"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	json "github.com/goccy/go-json"
+)
+
+// OpenSearch does not support the _shard_doc sort so hits in a point in time are ordered by document ID,
+// which relies on the indices.id_field_data.enabled cluster setting (enabled by default).
+var id_sort = json.RawMessage(`{"_id":"asc"}`)
+
+// openSearchClient is a Client for OpenSearch clusters, which use different endpoints for points in time.
+type openSearchClient struct {
+	*client
+}
+
+func newOpenSearchClient(info *Info, tr estransport.Interface) Client {
+
+	c := &openSearchClient{
+		client: newClient(info, tr, id_sort),
+	}
+
+	return c
+}
+
+// OpenPointInTime opens a point in time, which requires OpenSearch 2.4 or later.
+func (c *openSearchClient) OpenPointInTime(ctx context.Context, name string, keep_alive time.Duration) (string, error) {
+
+	if !c.info.AtLeast(2, 4) {
+		return "", fmt.Errorf("Points in time require OpenSearch 2.4 or higher, cluster is running %s", c.info)
+	}
+
+	q := url.Values{}
+	q.Set("keep_alive", keepAlive(keep_alive))
+
+	path := fmt.Sprintf("/%s/_search/point_in_time?%s", url.PathEscape(name), q.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, path, nil)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to create request, %w", err)
+	}
+
+	rsp, err := c.Perform(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(rsp.Body)
+		return "", fmt.Errorf("Failed to open point in time for %s, [%s] %s", name, rsp.Status, body)
+	}
+
+	var pit struct {
+		ID string `json:"pit_id"`
+	}
+
+	err = json.NewDecoder(rsp.Body).Decode(&pit)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to decode point in time for %s, %w", name, err)
+	}
+
+	if pit.ID == "" {
+		return "", fmt.Errorf("Failed to open point in time for %s", name)
+	}
+
+	return pit.ID, nil
+}
+
+func (c *openSearchClient) ClosePointInTime(ctx context.Context, id string) error {
+
+	body := map[string][]string{
+		"pit_id": []string{id},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, "/_search/point_in_time", esutil.NewJSONReader(body))
+
+	if err != nil {
+		return fmt.Errorf("Failed to create request, %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	rsp, err := c.Perform(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(rsp.Body)
+		return fmt.Errorf("Failed to close point in time, [%s] %s", rsp.Status, body)
+	}
+
+	return nil
+}
@@ -0,0 +1,625 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	"github.com/sourcegraph/conc/pool"
+	"github.com/tidwall/pretty"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/bulk"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/client"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/cluster"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/index"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/model"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/pressure"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/record"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/search"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/throttle"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/transform"
+)
+
+// The properties of a search response that are needed to index each hit.
+var hit_filter_path = []string{
+	"_scroll_id",
+	"error",
+	"hits.total",
+	"hits.hits._id",
+	"hits.hits._index",
+	"hits.hits._source",
+}
+
+// CLI flags
+var (
+	source_opts = client.AppendFlags(flag.CommandLine, "source-")
+	target_opts = client.AppendFlags(flag.CommandLine, "target-")
+
+	source_index = flag.String("source-index", "", "The name of the Elasticsearch index to copy documents from.")
+	target_index = flag.String("target-index", "", "The name of the Elasticsearch index to copy documents to. If empty -source-index is used.")
+	create       = flag.Bool("create-target", true, "If the target index does not exist, create it using the mappings and settings of the source index.")
+
+	query           = flag.String("query", "", "A JSON-encoded Elasticsearch query limiting the documents to copy. If empty all documents are copied.")
+	source_includes = flag.String("source-includes", "", "A comma-separated list of wildcard patterns for the _source properties to copy. If empty all properties are copied.")
+	source_excludes = flag.String("source-excludes", "", "A comma-separated list of wildcard patterns for the _source properties to leave out.")
+
+	transform_rules = flag.String("transform-rules", "", "The path to a JSON file with a list of rules to change the _source of each document with before it is indexed.")
+
+	size    = flag.Int("size", 500, "ES request batch size")
+	slices  = flag.Int("slices", 8, "The number of slices to divide the source index into. Slices are read concurrently and are the unit of progress recorded in -state-file.")
+	readers = flag.Int("readers", 2, "The number of slices to read at the same time.")
+
+	workers     = flag.Int("workers", runtime.NumCPU(), "The number of concurrent processes to use when indexing data.")
+	flush_bytes = flag.Int("flush-bytes", 5e+6, "The size in bytes at which the bulk indexer flushes documents to Elasticsearch.")
+
+	max_docs_per_second  = flag.Float64("max-docs-per-second", 0, "If greater than zero, the maximum number of documents to send to Elasticsearch per second.")
+	max_bytes_per_second = flag.Int("max-bytes-per-second", 0, "If greater than zero, the maximum number of bytes of document data to send to Elasticsearch per second.")
+
+	adaptive = flag.Bool("adaptive", false, "Reduce the number of workers and the flush size when the target cluster rejects bulk requests or reports any other pressure, and increase them again when it recovers. -workers and -flush-bytes are the upper bounds.")
+
+	pressure_breaker_ratio = flag.Float64("pressure-breaker-ratio", 1.0, "Pause while any circuit breaker's estimated size, in either cluster, is at or above this ratio of its limit. Zero disables the check.")
+	pressure_max_queue     = flag.Int("pressure-max-queue", 0, "Pause while any node has more than this many queued search (source) or write (target) tasks. Zero disables the check.")
+	pressure_min_health    = flag.String("pressure-min-health", "yellow", "Pause while the health of either index is worse than this status. Valid options are: green, yellow, red or an empty string to disable the check.")
+	pressure_interval      = flag.Duration("pressure-interval", 10*time.Second, "How often to check the clusters for pressure.")
+	pressure_timeout       = flag.Duration("pressure-timeout", 10*time.Minute, "Fail if copying has been paused for longer than this. Zero waits indefinitely.")
+
+	state_file = flag.String("state-file", "", "The path to a file in which to record which slices have been copied. If the file exists the copy resumes, skipping those slices.")
+)
+
+// copyState records the slices which have been copied in full, along with the options that determine which
+// documents each slice contains.
+type copyState struct {
+	SourceIndex    string          `json:"source_index"`
+	TargetIndex    string          `json:"target_index"`
+	Query          json.RawMessage `json:"query,omitempty"`
+	SourceIncludes []string        `json:"source_includes,omitempty"`
+	SourceExcludes []string        `json:"source_excludes,omitempty"`
+	// TransformRules is the hex-encoded SHA-256 digest of the -transform-rules file, if any.
+	TransformRules string `json:"transform_rules,omitempty"`
+	Slices         int    `json:"slices"`
+	Completed      []int  `json:"completed"`
+	path           string
+	mu             *sync.Mutex
+}
+
+func main() {
+
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := copyIndex(ctx)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func copyIndex(ctx context.Context) error {
+
+	if *source_index == "" {
+		return fmt.Errorf("Missing -source-index")
+	}
+
+	target := *target_index
+
+	if target == "" {
+		target = *source_index
+	}
+
+	if *slices < 1 {
+		return fmt.Errorf("Invalid number of slices")
+	}
+
+	if *readers < 1 {
+		return fmt.Errorf("Invalid number of readers")
+	}
+
+	var es_query json.RawMessage
+
+	if *query != "" {
+
+		if !json.Valid([]byte(*query)) {
+			return fmt.Errorf("Invalid -query, not valid JSON")
+		}
+
+		es_query = json.RawMessage(*query)
+	}
+
+	var transformer *transform.Transformer
+	transform_digest := ""
+
+	if *transform_rules != "" {
+
+		rules, err := transform.ReadRules(*transform_rules)
+
+		if err != nil {
+			return err
+		}
+
+		transformer, err = transform.NewTransformer(rules)
+
+		if err != nil {
+			return fmt.Errorf("Invalid -transform-rules, %w", err)
+		}
+
+		// Resuming with different rules would leave the target with a mix of documents
+		body, err := os.ReadFile(*transform_rules)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read %s, %w", *transform_rules, err)
+		}
+
+		transform_digest = fmt.Sprintf("%x", sha256.Sum256(body))
+	}
+
+	state := &copyState{
+		SourceIndex:    *source_index,
+		TargetIndex:    target,
+		Query:          es_query,
+		SourceIncludes: splitList(*source_includes),
+		SourceExcludes: splitList(*source_excludes),
+		TransformRules: transform_digest,
+		Slices:         *slices,
+		Completed:      make([]int, 0),
+		path:           *state_file,
+		mu:             new(sync.Mutex),
+	}
+
+	err := state.load()
+
+	if err != nil {
+		return err
+	}
+
+	source_cfg, err := source_opts.Config()
+
+	if err != nil {
+		return fmt.Errorf("Failed to configure source client, %w", err)
+	}
+
+	source_client, err := cluster.NewClient(ctx, source_cfg)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create source client, %w", err)
+	}
+
+	retry := backoff.NewExponentialBackOff()
+
+	target_cfg, err := target_opts.Config()
+
+	if err != nil {
+		return fmt.Errorf("Failed to configure target client, %w", err)
+	}
+
+	target_cfg.RetryOnStatus = []int{502, 503, 504, 429}
+	target_cfg.RetryBackoff = func(i int) time.Duration {
+		if i == 1 {
+			retry.Reset()
+		}
+		return retry.NextBackOff()
+	}
+	target_cfg.MaxRetries = 5
+
+	target_client, err := cluster.NewClient(ctx, target_cfg)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create target client, %w", err)
+	}
+
+	log.Printf("Copying %s (%s) to %s (%s)", *source_index, source_client.Info(), target, target_client.Info())
+
+	if *create {
+
+		exists, err := index.Exists(ctx, target_client.API(), target)
+
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+
+			definition, err := index.Definition(ctx, source_client.API(), *source_index)
+
+			if err != nil {
+				return err
+			}
+
+			err = index.Create(ctx, target_client.API(), target, definition)
+
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Created %s", target)
+		}
+	}
+
+	bi_cfg := bulk.Config{
+		Index:      target,
+		Transport:  target_client,
+		NumWorkers: *workers,
+		FlushBytes: *flush_bytes,
+		// Slices are only recorded as copied once all their documents have been flushed
+		FlushInterval: 5 * time.Second,
+	}
+
+	var bi esutil.BulkIndexer
+	var adaptive_bi *throttle.AdaptiveBulkIndexer
+
+	if *adaptive {
+
+		adaptive_opts := &throttle.AdaptiveOptions{
+			Config:        bi_cfg,
+			MinWorkers:    1,
+			MinFlushBytes: 256 * 1024,
+		}
+
+		adaptive_bi, err = throttle.NewAdaptiveBulkIndexer(adaptive_opts)
+		bi = adaptive_bi
+
+	} else {
+		bi, err = bulk.NewBulkIndexer(bi_cfg)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to create bulk indexer, %w", err)
+	}
+
+	source_guard, err := pressure.NewGuard(source_client, &pressure.Options{
+		Index:        *source_index,
+		BreakerRatio: *pressure_breaker_ratio,
+		ThreadPools:  []string{"search"},
+		MaxQueue:     *pressure_max_queue,
+		MinHealth:    *pressure_min_health,
+		Interval:     *pressure_interval,
+		Timeout:      *pressure_timeout,
+	})
+
+	if err != nil {
+		return fmt.Errorf("Failed to create pressure guard, %w", err)
+	}
+
+	target_guard, err := pressure.NewGuard(target_client, &pressure.Options{
+		Index:        target,
+		BreakerRatio: *pressure_breaker_ratio,
+		ThreadPools:  []string{"write"},
+		MaxQueue:     *pressure_max_queue,
+		MinHealth:    *pressure_min_health,
+		Interval:     *pressure_interval,
+		Timeout:      *pressure_timeout,
+	})
+
+	if err != nil {
+		return fmt.Errorf("Failed to create pressure guard, %w", err)
+	}
+
+	watch_ctx, stop_watching := context.WithCancel(ctx)
+	defer stop_watching()
+
+	gate := target_guard.Watch(watch_ctx, func(status *pressure.Status) {
+		if adaptive_bi != nil {
+			adaptive_bi.Observe(watch_ctx, status)
+		}
+	})
+
+	docs_limiter := throttle.NewLimiter(*max_docs_per_second)
+	bytes_limiter := throttle.NewLimiter(float64(*max_bytes_per_second))
+
+	docs_read := int64(0)
+
+	copySlice := func(ctx context.Context, slice_id int) error {
+
+		req := cluster.SearchRequest{
+			Index:          *source_index,
+			Query:          state.Query,
+			SourceIncludes: state.SourceIncludes,
+			SourceExcludes: state.SourceExcludes,
+			FilterPath:     hit_filter_path,
+		}
+
+		// A slice maximum of 1 is not allowed
+		if state.Slices > 1 {
+			req.Slice = &model.ESSlice{
+				ID:  slice_id,
+				Max: state.Slices,
+			}
+		}
+
+		opts := &search.ScanOptions{
+			Request: req,
+			Sizer:   search.NewSizer(*size, *size, *size, 0),
+			Guard:   source_guard,
+		}
+
+		// Track the documents of this slice which have been handed to the bulk indexer until they have
+		// been flushed
+		pending := new(sync.WaitGroup)
+		failed := int64(0)
+
+		err := search.Scan(ctx, source_client, opts, func(hit []byte) error {
+
+			doc, err := record.Parse(hit)
+
+			if err != nil {
+				return fmt.Errorf("Failed to parse hit, %w", err)
+			}
+
+			atomic.AddInt64(&docs_read, 1)
+
+			var source []byte
+
+			if transformer != nil {
+
+				source, err = transformer.Transform(doc.Source)
+
+				if err != nil {
+					return fmt.Errorf("Failed to transform %s, %w", doc.ID, err)
+				}
+
+			} else {
+
+				// hit is only valid for the duration of this function
+				source = make([]byte, len(doc.Source))
+				copy(source, doc.Source)
+			}
+
+			err = docs_limiter.WaitN(ctx, 1)
+
+			if err != nil {
+				return err
+			}
+
+			err = bytes_limiter.WaitN(ctx, len(source))
+
+			if err != nil {
+				return err
+			}
+
+			err = gate.Wait(ctx)
+
+			if err != nil {
+				return err
+			}
+
+			pending.Add(1)
+
+			bulk_item := esutil.BulkIndexerItem{
+				Action:     "index",
+				DocumentID: doc.ID,
+				Body:       bytes.NewReader(source),
+
+				OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+					pending.Done()
+				},
+
+				OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+
+					if err != nil {
+						log.Printf("ERROR: Failed to index %s, %s", item.DocumentID, err)
+					} else {
+						log.Printf("ERROR: Failed to index %s, %s: %s", item.DocumentID, res.Error.Type, res.Error.Reason)
+					}
+
+					atomic.AddInt64(&failed, 1)
+					pending.Done()
+				},
+			}
+
+			err = bi.Add(ctx, bulk_item)
+
+			if err != nil {
+				pending.Done()
+				return fmt.Errorf("Failed to schedule %s, %w", doc.ID, err)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return fmt.Errorf("Failed to read slice %d, %w", slice_id, err)
+		}
+
+		pending.Wait()
+
+		if failed > 0 {
+			log.Printf("Failed to index %d documents from slice %d, it will be copied again when resuming", failed, slice_id)
+			return nil
+		}
+
+		err = state.complete(slice_id)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Copied slice %d of %d", slice_id+1, state.Slices)
+		return nil
+	}
+
+	p := pool.New().WithContext(ctx).WithCancelOnError().WithMaxGoroutines(*readers)
+
+	for i := 0; i < state.Slices; i++ {
+
+		slice_id := i
+
+		if state.isComplete(slice_id) {
+			continue
+		}
+
+		p.Go(func(ctx context.Context) error {
+			return copySlice(ctx, slice_id)
+		})
+	}
+
+	read_err := p.Wait()
+
+	stop_watching()
+
+	// Wait for any adjustment in progress to finish before closing the bulk indexer
+	<-gate.Done()
+
+	err = bi.Close(ctx)
+
+	if err != nil {
+
+		if ctx.Err() != nil {
+			return fmt.Errorf("Copy interrupted, %w", context.Cause(ctx))
+		}
+
+		return err
+	}
+
+	stats := bi.Stats()
+
+	enc_stats, err := json.Marshal(stats)
+
+	if err != nil {
+		return err
+	}
+
+	enc_stats = pretty.Pretty(enc_stats)
+	fmt.Println(string(enc_stats))
+
+	if read_err != nil {
+		return read_err
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("Copy interrupted, %w", context.Cause(ctx))
+	}
+
+	remaining := state.Slices - len(state.Completed)
+
+	if remaining > 0 {
+		return fmt.Errorf("%d of %d slices were not copied in full, run again with the same -state-file to resume", remaining, state.Slices)
+	}
+
+	log.Printf("Copied %d documents", docs_read)
+	return nil
+}
+
+// load reads the slices which have already been copied from s.path, if it exists. It is an error for the
+// file to describe a different copy.
+func (s *copyState) load() error {
+
+	if s.path == "" {
+		return nil
+	}
+
+	body, err := os.ReadFile(s.path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to read %s, %w", s.path, err)
+	}
+
+	var saved copyState
+
+	err = json.Unmarshal(body, &saved)
+
+	if err != nil {
+		return fmt.Errorf("Failed to decode %s, %w", s.path, err)
+	}
+
+	if saved.SourceIndex != s.SourceIndex || saved.TargetIndex != s.TargetIndex || saved.Slices != s.Slices || !bytes.Equal(saved.Query, s.Query) || strings.Join(saved.SourceIncludes, ",") != strings.Join(s.SourceIncludes, ",") || strings.Join(saved.SourceExcludes, ",") != strings.Join(s.SourceExcludes, ",") || saved.TransformRules != s.TransformRules {
+		return fmt.Errorf("%s was created by a copy with different options", s.path)
+	}
+
+	s.Completed = saved.Completed
+
+	if len(s.Completed) > 0 {
+		log.Printf("Resuming copy, %d of %d slices already copied", len(s.Completed), s.Slices)
+	}
+
+	return nil
+}
+
+func (s *copyState) isComplete(slice_id int) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range s.Completed {
+
+		if id == slice_id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// complete records that the slice slice_id has been copied and, if a path was provided, saves the state.
+func (s *copyState) complete(slice_id int) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Completed = append(s.Completed, slice_id)
+	sort.Ints(s.Completed)
+
+	if s.path == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(s)
+
+	if err != nil {
+		return fmt.Errorf("Failed to encode state, %w", err)
+	}
+
+	// Write to a temporary file first so that an interrupted write never leaves a truncated state file
+	tmp_path := filepath.Join(filepath.Dir(s.path), "."+filepath.Base(s.path)+".tmp")
+
+	err = os.WriteFile(tmp_path, body, 0644)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write %s, %w", tmp_path, err)
+	}
+
+	err = os.Rename(tmp_path, s.path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to replace %s, %w", s.path, err)
+	}
+
+	return nil
+}
+
+// splitList returns the non-empty, trimmed items in the comma-separated list str.
+func splitList(str string) []string {
+
+	items := make([]string, 0)
+
+	for _, item := range strings.Split(str, ",") {
+
+		item = strings.TrimSpace(item)
+
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
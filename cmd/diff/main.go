@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/tidwall/pretty"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/canonical"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/client"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/cluster"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/diff"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/index"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/record"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/search"
+)
+
+// The maximum number of documents to request in a single _mget call.
+const mget_batch_size int = 500
+
+// The properties of a search response that are needed to compare each hit.
+var hit_filter_path = []string{
+	"_scroll_id",
+	"error",
+	"hits.total",
+	"hits.hits._id",
+	"hits.hits._source",
+}
+
+// CLI flags
+var (
+	left_opts  = client.AppendFlags(flag.CommandLine, "left-")
+	right_opts = client.AppendFlags(flag.CommandLine, "right-")
+
+	left_file   = flag.String("left-file", "", "The path to a dump file to compare. Files ending in .bz2 or .gz are decompressed.")
+	left_index  = flag.String("left-index", "", "The name of an Elasticsearch index to compare, instead of -left-file.")
+	right_file  = flag.String("right-file", "", "The path to a dump file to compare against. Files ending in .bz2 or .gz are decompressed.")
+	right_index = flag.String("right-index", "", "The name of an Elasticsearch index to compare against, instead of -right-file.")
+
+	patch   = flag.Bool("patch", false, "Include a JSON Patch (RFC 6902) describing the differences between the left and right _source of each changed document.")
+	summary = flag.Bool("summary", false, "Only output the number of documents that were added, removed, changed or unchanged.")
+	size    = flag.Int("size", 500, "ES request batch size")
+)
+
+// Change describes a document which differs between the left and right sources.
+type Change struct {
+	ID     string            `json:"id"`
+	Change string            `json:"change"`
+	Patch  []*diff.Operation `json:"patch,omitempty"`
+}
+
+// Summary counts the documents which differ between the left and right sources.
+type Summary struct {
+	Left      int  `json:"left"`
+	Right     int  `json:"right"`
+	Added     int  `json:"added"`
+	Removed   int  `json:"removed"`
+	Changed   int  `json:"changed"`
+	Unchanged int  `json:"unchanged"`
+	Same      bool `json:"same"`
+}
+
+// side is one of the two sources being compared.
+type side interface {
+	// Each calls fn with the ID and _source of every document.
+	Each(context.Context, func(string, []byte) error) error
+	// Documents returns the _source of each document whose ID is in ids.
+	Documents(context.Context, map[string]bool) (map[string][]byte, error)
+	String() string
+}
+
+type fileSide struct {
+	path string
+}
+
+func (s *fileSide) Each(ctx context.Context, fn func(string, []byte) error) error {
+
+	r, err := record.Open(s.path)
+
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	return record.Scan(r, func(rec *record.Record) error {
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		return fn(rec.ID, rec.Source)
+	})
+}
+
+func (s *fileSide) Documents(ctx context.Context, ids map[string]bool) (map[string][]byte, error) {
+
+	docs := make(map[string][]byte, len(ids))
+
+	err := s.Each(ctx, func(id string, source []byte) error {
+
+		if ids[id] {
+			docs[id] = append([]byte(nil), source...)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return docs, nil
+}
+
+func (s *fileSide) String() string {
+	return s.path
+}
+
+type indexSide struct {
+	client cluster.Client
+	name   string
+}
+
+func (s *indexSide) Each(ctx context.Context, fn func(string, []byte) error) error {
+
+	opts := &search.ScanOptions{
+		Request: cluster.SearchRequest{
+			Index:      s.name,
+			FilterPath: hit_filter_path,
+		},
+		Sizer: search.NewSizer(*size, *size, *size, 0),
+	}
+
+	return search.Scan(ctx, s.client, opts, func(hit []byte) error {
+
+		rec, err := record.Parse(hit)
+
+		if err != nil {
+			return fmt.Errorf("Failed to parse hit, %w", err)
+		}
+
+		return fn(rec.ID, rec.Source)
+	})
+}
+
+func (s *indexSide) Documents(ctx context.Context, ids map[string]bool) (map[string][]byte, error) {
+
+	list := make([]string, 0, len(ids))
+
+	for id := range ids {
+		list = append(list, id)
+	}
+
+	sort.Strings(list)
+
+	docs := make(map[string][]byte, len(ids))
+
+	for i := 0; i < len(list); i += mget_batch_size {
+
+		j := i + mget_batch_size
+
+		if j > len(list) {
+			j = len(list)
+		}
+
+		found, err := index.Documents(ctx, s.client.API(), s.name, list[i:j])
+
+		if err != nil {
+			return nil, err
+		}
+
+		for id, source := range found {
+			docs[id] = source
+		}
+	}
+
+	return docs, nil
+}
+
+func (s *indexSide) String() string {
+	return fmt.Sprintf("%s (%s)", s.name, s.client.Info())
+}
+
+func main() {
+
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	same, err := diffSides(ctx)
+
+	if err != nil {
+		log.Print(err)
+		os.Exit(2)
+	}
+
+	if !same {
+		os.Exit(1)
+	}
+}
+
+func diffSides(ctx context.Context) (bool, error) {
+
+	left, err := newSide(ctx, "left", *left_file, *left_index, left_opts)
+
+	if err != nil {
+		return false, err
+	}
+
+	right, err := newSide(ctx, "right", *right_file, *right_index, right_opts)
+
+	if err != nil {
+		return false, err
+	}
+
+	log.Printf("Comparing %s with %s", left, right)
+
+	left_hashes, err := hashSide(ctx, left)
+
+	if err != nil {
+		return false, err
+	}
+
+	right_hashes, err := hashSide(ctx, right)
+
+	if err != nil {
+		return false, err
+	}
+
+	s := &Summary{
+		Left:  len(left_hashes),
+		Right: len(right_hashes),
+	}
+
+	changes := make([]*Change, 0)
+	changed_ids := make(map[string]bool)
+
+	for id, h := range left_hashes {
+
+		right_h, ok := right_hashes[id]
+
+		switch {
+		case !ok:
+			changes = append(changes, &Change{ID: id, Change: "removed"})
+			s.Removed += 1
+		case right_h != h:
+			changes = append(changes, &Change{ID: id, Change: "changed"})
+			changed_ids[id] = true
+			s.Changed += 1
+		default:
+			s.Unchanged += 1
+		}
+	}
+
+	for id := range right_hashes {
+
+		_, ok := left_hashes[id]
+
+		if !ok {
+			changes = append(changes, &Change{ID: id, Change: "added"})
+			s.Added += 1
+		}
+	}
+
+	s.Same = s.Added == 0 && s.Removed == 0 && s.Changed == 0
+
+	if *summary {
+
+		enc_summary, err := json.Marshal(s)
+
+		if err != nil {
+			return false, err
+		}
+
+		fmt.Println(string(pretty.Pretty(enc_summary)))
+		return s.Same, nil
+	}
+
+	sort.Slice(changes, func(i int, j int) bool {
+		return changes[i].ID < changes[j].ID
+	})
+
+	if *patch && len(changed_ids) > 0 {
+
+		left_docs, err := left.Documents(ctx, changed_ids)
+
+		if err != nil {
+			return false, err
+		}
+
+		right_docs, err := right.Documents(ctx, changed_ids)
+
+		if err != nil {
+			return false, err
+		}
+
+		for _, c := range changes {
+
+			if c.Change != "changed" {
+				continue
+			}
+
+			left_doc, left_ok := left_docs[c.ID]
+			right_doc, right_ok := right_docs[c.ID]
+
+			// The document may have been updated or deleted since it was first read
+			if !left_ok || !right_ok {
+				log.Printf("Failed to retrieve %s again, unable to create patch", c.ID)
+				continue
+			}
+
+			ops, err := diff.Patch(left_doc, right_doc)
+
+			if err != nil {
+				return false, fmt.Errorf("Failed to create patch for %s, %w", c.ID, err)
+			}
+
+			c.Patch = ops
+		}
+	}
+
+	wr := bufio.NewWriter(os.Stdout)
+	enc := json.NewEncoder(wr)
+	enc.SetEscapeHTML(false)
+
+	for _, c := range changes {
+
+		err := enc.Encode(c)
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = wr.Flush()
+
+	if err != nil {
+		return false, err
+	}
+
+	log.Printf("%d added, %d removed, %d changed, %d unchanged", s.Added, s.Removed, s.Changed, s.Unchanged)
+	return s.Same, nil
+}
+
+func newSide(ctx context.Context, label string, path string, name string, opts *client.Options) (side, error) {
+
+	switch {
+	case path != "" && name != "":
+		return nil, fmt.Errorf("-%s-file and -%s-index can not both be set", label, label)
+	case path != "":
+		return &fileSide{path: path}, nil
+	case name != "":
+
+		es_client, err := opts.NewClient(ctx)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create %s client, %w", label, err)
+		}
+
+		return &indexSide{client: es_client, name: name}, nil
+
+	default:
+		return nil, fmt.Errorf("Missing -%s-file or -%s-index", label, label)
+	}
+}
+
+// hashSide returns the SHA-256 hash of the canonical encoding of each document in s, keyed by ID.
+func hashSide(ctx context.Context, s side) (map[string][32]byte, error) {
+
+	hashes := make(map[string][32]byte)
+
+	err := s.Each(ctx, func(id string, source []byte) error {
+
+		enc_source, err := canonical.Marshal(source)
+
+		if err != nil {
+			return fmt.Errorf("Failed to canonicalize %s, %w", id, err)
+		}
+
+		_, exists := hashes[id]
+
+		if exists {
+			log.Printf("%s contains %s more than once, using the last copy", s, id)
+		}
+
+		hashes[id] = sha256.Sum256(enc_source)
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s, %w", s, err)
+	}
+
+	return hashes, nil
+}
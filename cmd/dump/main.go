@@ -1,247 +1,936 @@
 package main
 
 import (
-	_ "bytes"
+	"bufio"
+	"bytes"
 	"context"
-	"errors"
+	"crypto/ed25519"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
-	retry "github.com/avast/retry-go"
-	"github.com/elastic/go-elasticsearch/v7"
-	"github.com/elastic/go-elasticsearch/v7/esutil"
-	json "github.com/goccy/go-json"
 	"github.com/sourcegraph/conc/pool"
+	"github.com/tidwall/gjson"
 
-	"github.com/sfomuseum/go-jsonl-elasticsearch/model"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/canonical"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/client"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/cluster"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/crypt"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/fields"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/manifest"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/pressure"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/record"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/redact"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/search"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/watermark"
 )
 
+// The properties of a search response that are needed to write each hit. Only these are returned by
+// Elasticsearch so that each hit can be written as-is.
+var hit_filter_path = []string{
+	"_scroll_id",
+	"pit_id",
+	"error",
+	"hits.total",
+	"hits.hits._id",
+	"hits.hits._index",
+	"hits.hits._source",
+	"hits.hits._version",
+	"hits.hits.sort",
+}
+
 // CLI flags
 var (
-	es_endpoint = flag.String("elasticsearch-endpoint", "", "The name of the Elasticsearch host to query.")
-	es_index    = flag.String("elasticsearch-index", "", "The name of the Elasticsearch index to dump.")
-	size        = flag.Int("size", 100, "ES request batch size")
+	es_opts  = client.AppendFlags(flag.CommandLine, "")
+	es_index = flag.String("elasticsearch-index", "", "The name of the Elasticsearch index to dump.")
+	size     = flag.Int("size", 100, "ES request batch size")
+
+	target_page_bytes = flag.Int("target-page-bytes", 0, "If greater than zero, adjust the batch size so that each response is roughly this many bytes, starting at -size. Requires Elasticsearch 7.12 or OpenSearch 2.4 or higher.")
+	min_size          = flag.Int("min-size", 10, "The smallest batch size to use when -target-page-bytes is set.")
+	max_size          = flag.Int("max-size", 10000, "The largest batch size to use when -target-page-bytes is set.")
+	buffer_bytes      = flag.Int("buffer-bytes", 64e+6, "The maximum number of bytes of search hits to hold in memory while they wait to be written. A single hit larger than this is still written.")
+
+	pressure_breaker_ratio = flag.Float64("pressure-breaker-ratio", 1.0, "After a failed request, wait while any circuit breaker's estimated size is at or above this ratio of its limit. Zero disables the check.")
+	pressure_max_queue     = flag.Int("pressure-max-queue", 0, "After a failed request, wait while any node has more than this many queued search tasks. Zero disables the check.")
+	pressure_min_health    = flag.String("pressure-min-health", "yellow", "After a failed request, wait while the index's health is worse than this status. Valid options are: green, yellow, red or an empty string to disable the check.")
+	pressure_interval      = flag.Duration("pressure-interval", 10*time.Second, "How often to check the cluster for pressure while waiting.")
+	pressure_timeout       = flag.Duration("pressure-timeout", 10*time.Minute, "Fail if the cluster is still under pressure after this long. Zero waits indefinitely.")
+
+	include_version = flag.Bool("include-version", false, "Include the _version of each document in its record, for use by reshard -keep version.")
+
+	canonical_output = flag.Bool("canonical", false, "Write records sorted by _id, with the keys of every object sorted and numbers written in a canonical form, so that dumps of the same data are byte-identical.")
+	sort_buffer      = flag.Int("sort-buffer", 256e+6, "The number of bytes of records to sort in memory before spilling them to temporary files with -canonical.")
+	temp_dir         = flag.String("temp-dir", "", "The directory to write temporary files to with -canonical. If empty the default directory for temporary files is used.")
+
+	query = flag.String("query", "", "A JSON-encoded Elasticsearch query limiting the documents to dump. If empty all documents are dumped.")
+
+	watermark_field = flag.String("watermark-field", "", "A date or numeric field, such as lastmodified or @timestamp, whose value increases whenever a document changes, or _seq_no to use the sequence number of each shard. With -since, -until or -state-file only the documents changed within that window are dumped.")
+	since           = flag.String("since", "", "Only dump documents whose -watermark-field is greater than this value. Dates can be ISO 8601, a number of milliseconds or date math such as now-1d. If empty the high-water mark in -state-file, if any, is used.")
+	until           = flag.String("until", "", "Only dump documents whose -watermark-field is less than or equal to this value, for example now-1m to leave documents which may not be searchable yet for the next dump. If empty, date fields are limited by -watermark-lag.")
+	watermark_lag   = flag.Duration("watermark-lag", time.Minute, "If -until is empty, only dump documents whose date -watermark-field is at least this old, leaving documents which may not be searchable yet for the next dump rather than skipping them. Zero disables the limit.")
+	state_file      = flag.String("state-file", "", "The path to a file to record the high-water mark of -watermark-field in after a successful dump. If the file exists and -since is empty only documents changed since the last dump are dumped.")
+
+	follow          = flag.Bool("follow", false, "Keep running after dumping the index, polling for documents sorted by -watermark-field after the last one written and writing them to STDOUT as they appear, until interrupted. The high-water mark is recorded in -state-file after every poll which found documents.")
+	follow_interval = flag.Duration("follow-interval", 10*time.Second, "How often to poll for changed documents with -follow.")
+
+	output_dir   = flag.String("output-dir", "", "Write records to numbered part files in this directory, with a manifest.json file listing the record count, size and SHA-256 digest of each part, instead of to STDOUT.")
+	part_records = flag.Int("part-records", 1000000, "The maximum number of records in each part with -output-dir. Zero writes a single part.")
+	compression  = flag.String("compression", "none", "How to compress each part with -output-dir. Valid options are: none, bz2, gz.")
+	sign_key     = flag.String("sign-key", "", "The path to a PEM-encoded ed25519 private key to sign the manifest written with -output-dir with. The signature is written to manifest.json.sig.")
+
+	encryption_key_file        = flag.String("encryption-key-file", "", "The path to a file containing a base64-encoded 256-bit key to encrypt data with.")
+	encryption_passphrase_file = flag.String("encryption-passphrase-file", "", "The path to a file containing a passphrase to derive the key to encrypt data with, instead of -encryption-key-file.")
+	encrypt_parts              = flag.Bool("encrypt-parts", false, "Encrypt each part written with -output-dir, or STDOUT, as a whole. Parts are given the extension .enc.")
+	encrypt_fields             = flag.String("encrypt-fields", "", "A comma-separated list of gjson paths of _source values to encrypt, for example donor.email,donors.#.phone.")
+
+	redact_rules       = flag.String("redact-rules", "", "The path to a JSON file containing a list of rules to mask, drop or hash _source values with before they are written (or encrypted).")
+	redact_secret_file = flag.String("redact-secret-file", "", "The path to a file containing the secret to hash values with, required by hash rules. The same secret always produces the same hash for the same value.")
+	redact_report      = flag.String("redact-report", "", "The path to write a JSON report of the number of values each -redact-rules rule redacted to. If empty the counts are only logged.")
 
 	null   = flag.Bool("null", false, "Output to /dev/null.")
 	stdout = flag.Bool("stdout", true, "Output to STDOUT.")
 )
 
-var es_client *elasticsearch.Client
+// The file extension for each -compression option.
+var compression_extensions = map[string]string{
+	"none": "",
+	"bz2":  ".bz2",
+	"gz":   ".gz",
+}
+
+var es_client cluster.Client
+
+// The parts written to -output-dir.
+var parts *partWriter
+
+// The secret to encrypt data with, and the paths of the values to encrypt in each record.
+var secret *crypt.Secret
+var encrypted_paths []string
+var encrypted_values int
+
+// The redactor to apply -redact-rules with.
+var redactor *redact.Redactor
+
+// The field limiting each dump to the documents changed since the last one.
+var wm *watermark.Watermark
 
 func main() {
 	flag.Parse()
 
+	ctx := context.Background()
+
+	if *follow {
+		var stop context.CancelFunc
+		ctx, stop = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+	}
+
+	var es_query json.RawMessage
+	if *query != "" {
+		if !json.Valid([]byte(*query)) {
+			log.Fatal("Invalid -query, not valid JSON")
+		}
+		es_query = json.RawMessage(*query)
+	}
+
 	var err error
-	es_client, err = elasticsearch.NewClient(elasticsearch.Config{
-		Addresses: []string{*es_endpoint},
-	})
+	secret, err = readSecret()
+	if err != nil {
+		log.Fatal(err)
+	}
+	encrypted_paths = splitList(*encrypt_fields)
+
+	redactor, err = newRedactor()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *follow {
+		switch {
+		case *watermark_field == "":
+			log.Fatal("-follow requires -watermark-field")
+		case *output_dir != "":
+			log.Fatal("-follow can not be used with -output-dir since the dump is never finished")
+		case *canonical_output:
+			log.Fatal("-follow can not be used with -canonical since records are only sorted once every one has been read")
+		case *encrypt_parts:
+			log.Fatal("-follow can not be used with -encrypt-parts since the encrypted stream is never finished, use -encrypt-fields instead")
+		case *follow_interval <= 0:
+			log.Fatal("-follow-interval must be greater than zero")
+		}
+	}
+
+	es_client, err = es_opts.NewClient(ctx)
 	if err != nil {
 		log.Fatalf("Failed to create ES client, %v", err)
 	}
+	log.Printf("Dumping from %s", es_client.Info())
 
-	ctx := context.Background()
+	m := &manifest.Manifest{
+		Version: manifest.VERSION,
+		Index:   *es_index,
+		Query:   es_query,
+		Cluster: es_client.Info().String(),
+		Started: time.Now().UTC(),
+	}
+	if len(encrypted_paths) > 0 {
+		m.EncryptedFields = encrypted_paths
+	}
+
+	var searches []*watermark.Search
+	m.Window, searches, err = applyWatermark(ctx, es_query)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var private_key ed25519.PrivateKey
+	if *sign_key != "" {
+		if *output_dir == "" {
+			log.Fatal("-sign-key requires -output-dir")
+		}
+		private_key, err = manifest.ReadPrivateKey(*sign_key)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *output_dir != "" {
+		parts, err = newPartWriter(*output_dir, *part_records, *compression, *encrypt_parts)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	err = dumpDocuments(ctx, searches)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if parts != nil {
+		err = writeManifest(m, private_key)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The high-water mark is only recorded once everything up to it has been written
+	if m.Window != nil {
+		err = recordHighWater(es_query, m.Window.Until)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *follow {
+		err = followIndex(ctx, es_query, m.Window.Until)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if redactor != nil {
+		err = reportRedactions()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(encrypted_paths) > 0 {
+		log.Printf("Encrypted %d values", encrypted_values)
+	}
+}
+
+// dumpDocuments writes the documents matched by searches.
+func dumpDocuments(ctx context.Context, searches []*watermark.Search) error {
 	p := pool.New().WithContext(ctx).WithCancelOnError()
-	c := make(chan *model.ESSearchResponse, 10)
+	// The channel is bounded by the number of bytes waiting in it, rather than the number of hits
+	c := make(chan []byte, 1000)
+	buf := newHitBuffer(*buffer_bytes)
 	p.Go(func(ctx context.Context) error {
 		defer close(c)
-		return readIndex(ctx, c)
+		return readIndex(ctx, c, buf, searches)
 	})
 	p.Go(func(ctx context.Context) error {
-		return writeDocuments(ctx, c)
+		return writeDocuments(ctx, c, buf)
 	})
-	if err := p.Wait(); err != nil {
-		log.Fatal(err)
+	return p.Wait()
+}
+
+// followIndex polls every -follow-interval for documents matching query changed after since, the high-water
+// mark of the dump, writing them and recording the new high-water mark, until ctx is cancelled. Documents are
+// written at least once: if a poll is interrupted its documents are written again by the next run.
+func followIndex(ctx context.Context, query json.RawMessage, since json.RawMessage) error {
+	log.Printf("Following changes every %v", *follow_interval)
+	poll := followField(query, since)
+	if wm.IsSeqNo() {
+		poll = followShards(query, since)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopped following changes")
+			return nil
+		case <-time.After(*follow_interval):
+		}
+		err := poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return err
+		}
 	}
 }
 
-func readIndex(ctx context.Context, c chan<- *model.ESSearchResponse) error {
-	// resp, err := es_client.OpenPointInTime([]string{*es_index}, "1m", es_client.OpenPointInTime.WithContext(ctx))
-	// if err != nil {
-	// 	return err
-	// }
-	// pit := &model.ESPIT{}
-	// if err = json.NewDecoder(resp.Body).Decode(pit); err != nil {
-	// 	return err
-	// }
-	// defer func() {
-	// 	es_client.ClosePointInTime(
-	// 		es_client.ClosePointInTime.WithBody(esutil.NewJSONReader(pit)),
-	// 	)
-	// }()
+// followField returns a poll which writes the documents matching query sorted by -watermark-field, starting
+// at the value of the field in the last document written by the previous poll, or at since until a document
+// has been written. Only that value carries over from one poll to the next, since the sort values which order
+// documents with the same value are only valid within the point in time that returned them, so the documents
+// already written with it are skipped instead.
+func followField(query json.RawMessage, since json.RawMessage) func(context.Context) error {
+	cursor := watermark.NewCursor(since)
+	return func(ctx context.Context) error {
+		ws, err := wm.After(*es_index, query, cursor, untilValue())
+		if err != nil {
+			return err
+		}
+		read := cursor.Read()
+		err = dumpDocuments(ctx, []*watermark.Search{ws})
+		if err != nil {
+			// Try again at the next poll rather than giving up on a long-running process, unless documents
+			// may already have been written
+			if cursor.Read() == read && ctx.Err() == nil {
+				log.Printf("Failed to poll for changes, %v", err)
+				return nil
+			}
+			return err
+		}
+		if cursor.Read() == read {
+			return nil
+		}
+		high_water, err := wm.Value(cursor.Value)
+		if err != nil {
+			return err
+		}
+		log.Printf("Dumped %d documents changed up to %s", cursor.Read()-read, high_water)
+		return recordHighWater(query, high_water)
+	}
+}
 
-	body := &model.ESQuery{
-		Query: json.RawMessage(`{"match_all":{}}`),
-		// PointInTime: *pit,
+// followShards returns a poll which writes the documents matching query in each shard whose checkpoint has
+// moved past its checkpoint in since, the checkpoints of the previous poll.
+func followShards(query json.RawMessage, since json.RawMessage) func(context.Context) error {
+	return func(ctx context.Context) error {
+		high_water, err := wm.HighWater(ctx, es_client.API(), *es_index, query, since, nil)
+		if err != nil {
+			// Try again at the next poll rather than giving up on a long-running process
+			if ctx.Err() == nil {
+				log.Printf("Failed to poll for changes, %v", err)
+			}
+			return nil
+		}
+		if high_water == nil {
+			return nil
+		}
+		searches, err := wm.Searches(*es_index, query, since, high_water, false)
+		if err != nil {
+			return err
+		}
+		logWindow(since, high_water, searches)
+		err = dumpDocuments(ctx, searches)
+		if err != nil {
+			return err
+		}
+		err = recordHighWater(query, high_water)
+		if err != nil {
+			return err
+		}
+		since = high_water
+		return nil
 	}
+}
 
-	resp, err := es_client.Count(
-		es_client.Count.WithContext(ctx),
-		es_client.Count.WithIndex(*es_index),
-	)
+// recordHighWater records high_water as the high-water mark of the documents matching query in -state-file, if
+// set.
+func recordHighWater(query json.RawMessage, high_water json.RawMessage) error {
+	if *state_file == "" || high_water == nil {
+		return nil
+	}
+	state := &watermark.State{
+		Index:     *es_index,
+		Field:     *watermark_field,
+		Query:     query,
+		HighWater: high_water,
+		Updated:   time.Now().UTC(),
+	}
+	err := state.Write(*state_file)
 	if err != nil {
 		return err
 	}
-	countResp := &model.ESCountResponse{}
-	if err = json.NewDecoder(resp.Body).Decode(countResp); err != nil {
+	if wm.IsSeqNo() {
+		log.Printf("Recorded the %s checkpoint of every shard in %s", watermark.SEQ_NO, *state_file)
+	} else {
+		log.Printf("Recorded high-water mark %s in %s", high_water, *state_file)
+	}
+	return nil
+}
+
+// writeManifest writes the manifest m for the parts written to -output-dir, signing it with private_key if set.
+func writeManifest(m *manifest.Manifest, private_key ed25519.PrivateKey) error {
+	m.Finished = time.Now().UTC()
+	m.Parts = parts.parts
+	for _, part := range m.Parts {
+		m.Records += part.Records
+	}
+	manifest_path := filepath.Join(*output_dir, manifest.FILENAME)
+	err := m.Write(manifest_path)
+	if err != nil {
 		return err
 	}
-	total := countResp.Count
+	if private_key != nil {
+		err = manifest.Sign(manifest_path, private_key)
+		if err != nil {
+			return fmt.Errorf("Failed to sign manifest, %w", err)
+		}
+	}
+	log.Printf("Wrote %d records to %d parts in %s", m.Records, len(m.Parts), *output_dir)
+	return nil
+}
 
-	scrollID := ""
-	defer func() {
-		if scrollID != "" {
-			_, _ = es_client.ClearScroll(
-				es_client.ClearScroll.WithScrollID(scrollID),
-			)
+// applyWatermark returns the searches for the documents matching query whose -watermark-field changed after
+// -since, or the high-water mark in -state-file, and up to the current high-water mark. It also returns the
+// window to dump, whose Until is the high-water mark to record once the dump has finished.
+func applyWatermark(ctx context.Context, query json.RawMessage) (*manifest.Window, []*watermark.Search, error) {
+	all := []*watermark.Search{{Index: *es_index, Query: query}}
+	if *watermark_field == "" {
+		if *since != "" || *until != "" || *state_file != "" {
+			return nil, nil, fmt.Errorf("-since, -until and -state-file require -watermark-field")
+		}
+		return nil, all, nil
+	}
+	if *watermark_lag < 0 {
+		return nil, nil, fmt.Errorf("-watermark-lag can not be negative")
+	}
+	var err error
+	wm, err = watermark.New(ctx, es_client.API(), *es_index, *watermark_field)
+	if err != nil {
+		return nil, nil, err
+	}
+	if wm.IsSeqNo() {
+		switch {
+		case *since != "" || *until != "":
+			return nil, nil, fmt.Errorf("-since and -until can not be used with %s, which has a high-water mark for each shard recorded in -state-file", watermark.SEQ_NO)
+		case *target_page_bytes > 0:
+			return nil, nil, fmt.Errorf("-target-page-bytes can not be used with %s since each shard is read separately with a scroll", watermark.SEQ_NO)
+		}
+	}
+	var since_value json.RawMessage
+	if *since != "" {
+		since_value, _ = json.Marshal(*since)
+	}
+	if since_value == nil && *state_file != "" {
+		previous, err := watermark.ReadState(*state_file)
+		if err != nil {
+			return nil, nil, err
 		}
-	}()
+		if previous != nil {
+			err = previous.Matches(*es_index, *watermark_field, query)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid -state-file %s, %w", *state_file, err)
+			}
+			since_value = previous.HighWater
+		}
+	}
+	high_water, err := wm.HighWater(ctx, es_client.API(), *es_index, query, since_value, untilValue())
+	if err != nil {
+		return nil, nil, err
+	}
+	changed := high_water != nil
+	switch {
+	case high_water == nil && since_value == nil:
+		log.Printf("No documents have a value for %s", *watermark_field)
+	case high_water == nil && wm.IsSeqNo():
+		log.Printf("No documents have changed since the checkpoints in -state-file")
+		high_water = since_value
+	case high_water == nil:
+		// The window (since, since] is empty
+		log.Printf("No documents have changed since %s", since_value)
+		high_water = since_value
+	}
+	// Documents without the field are only dumped by the first dump, since they would never be dumped otherwise
+	searches, err := wm.Searches(*es_index, query, since_value, high_water, since_value == nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if changed {
+		logWindow(since_value, high_water, searches)
+	}
+	window := &manifest.Window{
+		Field: *watermark_field,
+		Since: since_value,
+		Until: high_water,
+	}
+	return window, searches, nil
+}
+
+// logWindow logs the window of documents, matched by searches, which is about to be dumped.
+func logWindow(since json.RawMessage, high_water json.RawMessage, searches []*watermark.Search) {
+	switch {
+	case wm.IsSeqNo():
+		log.Printf("Dumping documents changed in %d shards", len(searches))
+	case since == nil:
+		log.Printf("Dumping documents changed up to %s", high_water)
+	default:
+		log.Printf("Dumping documents changed after %s and up to %s", since, high_water)
+	}
+}
+
+// untilValue returns the upper limit of the window to dump: -until or, if it is empty and -watermark-field is
+// a date field, -watermark-lag before now.
+func untilValue() json.RawMessage {
+	var value string
+	switch {
+	case *until != "":
+		value = *until
+	case wm.IsDate() && *watermark_lag > 0:
+		// Date math is only precise to the second
+		value = fmt.Sprintf("now-%ds", (*watermark_lag+time.Second-1)/time.Second)
+	default:
+		return nil
+	}
+	enc_value, _ := json.Marshal(value)
+	return enc_value
+}
+
+// readIndex sends the hits of each of searches to c. Sorted searches are read through a point in time, and
+// their hits are skipped if their Cursor had already been advanced past them.
+func readIndex(ctx context.Context, c chan<- []byte, buf *hitBuffer, searches []*watermark.Search) error {
+	total, err := es_client.Count(ctx, *es_index)
+	if err != nil {
+		return err
+	}
+
+	guard, err := pressure.NewGuard(es_client, &pressure.Options{
+		Index:        *es_index,
+		BreakerRatio: *pressure_breaker_ratio,
+		ThreadPools:  []string{"search"},
+		MaxQueue:     *pressure_max_queue,
+		MinHealth:    *pressure_min_health,
+		Interval:     *pressure_interval,
+		Timeout:      *pressure_timeout,
+	})
+	if err != nil {
+		return err
+	}
 
 	count := 0
-	for {
-		r := GetResponse()
-		err := retry.Do(
-			func() error {
-				if scrollID == "" {
-					resp, err = es_client.Search(
-						es_client.Search.WithContext(ctx),
-						es_client.Search.WithBody(esutil.NewJSONReader(body)),
-						es_client.Search.WithSize(*size),
-						es_client.Search.WithTrackScores(false),
-						es_client.Search.WithIndex(*es_index),
-						es_client.Search.WithSort("_doc"),
-						es_client.Search.WithSource("true"),
-						es_client.Search.WithScroll(10*time.Minute),
-					)
-				} else {
-					resp, err = es_client.Scroll(
-						es_client.Scroll.WithContext(ctx),
-						es_client.Scroll.WithScrollID(scrollID),
-						es_client.Scroll.WithScroll(10*time.Minute),
-					)
-				}
-				if err != nil {
-					return err
-				}
-				err := json.NewDecoder(resp.Body).Decode(r)
-				resp.Body.Close()
-				if err != nil {
+	for _, ws := range searches {
+		opts := &search.ScanOptions{
+			Request: cluster.SearchRequest{
+				Index:      ws.Index,
+				Query:      ws.Query,
+				Preference: ws.Preference,
+				Sort:       ws.Sort,
+				Version:    *include_version,
+				FilterPath: hit_filter_path,
+			},
+			// The size of a scroll is fixed when it is opened so adjusting the size of each page
+			// requires paging through a point in time with search_after instead.
+			PointInTime: *target_page_bytes > 0 || len(ws.Sort) > 0,
+			KeepAlive:   10 * time.Minute,
+			Sizer:       search.NewSizer(*size, *min_size, *max_size, *target_page_bytes),
+			Guard:       guard,
+			Total:       total,
+			OnPage: func(page *search.Page) {
+				count += page.Hits
+				log.Printf("Got %d (%d) records\n", count, total)
+			},
+		}
+
+		cursor := ws.Cursor
+		err = search.Scan(ctx, es_client, opts, func(hit []byte) error {
+			if cursor != nil {
+				added, err := cursor.Add(hit)
+				if err != nil || !added {
 					return err
 				}
-				if len(r.Error) > 0 {
-					return errors.New(string(r.Error))
-				}
+			}
+			err := buf.acquire(ctx, len(hit))
+			if err != nil {
+				return err
+			}
+			enc_hit := make([]byte, len(hit))
+			copy(enc_hit, hit)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case c <- enc_hit:
 				return nil
-			},
-			retry.OnRetry(func(n uint, err error) {
-				// Wait for circuit breakers to untrip
-			outer:
-				for {
-					log.Println("checking for tripped breakers...")
-					resp, err := es_client.Nodes.Stats(
-						es_client.Nodes.Stats.WithContext(ctx),
-						es_client.Nodes.Stats.WithMetric("breaker"),
-					)
-					if err != nil {
-						log.Fatal(err)
-					}
-					s := &model.ESNodeStatsResponse{}
-					err = json.NewDecoder(resp.Body).Decode(s)
-					resp.Body.Close()
-					if err != nil {
-						log.Fatal(err)
-					}
-					if s.Status.Failed > 0 {
-						time.Sleep(10 * time.Second)
-						continue
-					}
-					for _, n := range s.Nodes {
-						for _, b := range n.Breakers {
-							if b.EstimatedSizeInBytes >= b.LimitSizeInBytes {
-								time.Sleep(10 * time.Second)
-								continue outer
-							}
-						}
-					}
-					break
-				}
-			}),
-			retry.MaxDelay(1*time.Minute),
-			retry.MaxJitter(10*time.Second),
-		)
+			}
+		})
 		if err != nil {
 			return err
 		}
-
-		if n := len(r.Hits.Hits); n > 0 {
-			count += n
-			log.Printf("Got %d (%d) records\n", count, total)
-			scrollID = r.ScrollID
-			c <- r
-		} else {
-			log.Printf("stopping because got zero hits")
-			break
-		}
-		if count >= total {
-			log.Printf("stopping because count is greater than or equal to total hits")
-			break
-		}
 	}
-
 	return nil
 }
 
-func writeDocuments(ctx context.Context, c <-chan *model.ESSearchResponse) error {
-	writers := make([]io.Writer, 0)
-	if *null {
-		writers = append(writers, io.Discard)
+func writeDocuments(ctx context.Context, c <-chan []byte, buf *hitBuffer) error {
+	var write func(line []byte) error
+	var flush func() error
+	if parts != nil {
+		write = parts.Write
+		flush = parts.Close
+	} else {
+		writers := make([]io.Writer, 0)
+		if *null {
+			writers = append(writers, io.Discard)
+		}
+		if *stdout {
+			writers = append(writers, os.Stdout)
+		}
+		var out io.Writer = io.MultiWriter(writers...)
+		var ew *crypt.Writer
+		if *encrypt_parts {
+			var err error
+			ew, err = crypt.NewWriter(out, secret)
+			if err != nil {
+				return err
+			}
+			out = ew
+		}
+		wr := bufio.NewWriter(out)
+		write = func(line []byte) error {
+			wr.Write(line)
+			_, err := wr.Write([]byte("\n"))
+			return err
+		}
+		flush = func() error {
+			err := wr.Flush()
+			if err != nil || ew == nil {
+				return err
+			}
+			return ew.Close()
+		}
 	}
-	if *stdout {
-		writers = append(writers, os.Stdout)
+
+	var sorter *record.Sorter
+	if *canonical_output {
+		sorter = record.NewSorter(*sort_buffer, *temp_dir)
+		defer sorter.Close()
 	}
-	wr := io.MultiWriter(writers...)
 
 outer:
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
-		case resp, ok := <-c:
+		case hit, ok := <-c:
 			if !ok {
 				break outer
 			}
-			for _, rec := range resp.Hits.Hits {
-				enc_rec, err := json.Marshal(rec)
+			buf.release(len(hit))
+			hit, err := redactHit(hit)
+			if err != nil {
+				return err
+			}
+			if sorter != nil {
+				id, enc_hit, err := canonicalHit(hit)
 				if err != nil {
 					return err
 				}
-				wr.Write(enc_rec)
-				wr.Write([]byte("\n"))
+				enc_hit, err = encryptFields(enc_hit)
+				if err != nil {
+					return err
+				}
+				err = sorter.Add(id, enc_hit)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			hit, err = encryptFields(hit)
+			if err != nil {
+				return err
+			}
+			err = write(hit)
+			if err != nil {
+				return err
 			}
-			PutResponse(resp)
 		}
 	}
+
+	if sorter != nil {
+		log.Println("Sorting records")
+		err := sorter.Each(write)
+		if err != nil {
+			return err
+		}
+	}
+	return flush()
+}
+
+// redactHit returns hit with -redact-rules applied to it.
+func redactHit(hit []byte) ([]byte, error) {
+	if redactor == nil {
+		return hit, nil
+	}
+	enc_hit, err := redactor.Redact(hit)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to redact %s, %w", gjson.GetBytes(hit, "_id").String(), err)
+	}
+	return enc_hit, nil
+}
+
+// newRedactor returns the redactor for -redact-rules, or nil if there are no rules.
+func newRedactor() (*redact.Redactor, error) {
+	if *redact_rules == "" {
+		if *redact_secret_file != "" || *redact_report != "" {
+			return nil, fmt.Errorf("-redact-secret-file and -redact-report require -redact-rules")
+		}
+		return nil, nil
+	}
+	rules, err := redact.ReadRules(*redact_rules)
+	if err != nil {
+		return nil, err
+	}
+	var redact_secret []byte
+	if *redact_secret_file != "" {
+		body, err := os.ReadFile(*redact_secret_file)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read redaction secret, %w", err)
+		}
+		redact_secret = bytes.TrimSpace(body)
+		if len(redact_secret) == 0 {
+			return nil, fmt.Errorf("Invalid -redact-secret-file %s, the secret is empty", *redact_secret_file)
+		}
+	}
+	return redact.NewRedactor(rules, redact_secret)
+}
+
+// reportRedactions logs the number of values each rule redacted and writes them to -redact-report.
+func reportRedactions() error {
+	counts := redactor.Counts()
+	for _, c := range counts {
+		log.Printf("Redacted %d values in %d records with %s %s", c.Values, c.Records, c.Action, c.Path)
+	}
+	if *redact_report == "" {
+		return nil
+	}
+	enc_counts, err := json.MarshalIndent(counts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to encode redaction report, %w", err)
+	}
+	err = os.WriteFile(*redact_report, append(enc_counts, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to write redaction report, %w", err)
+	}
 	return nil
 }
 
-var (
-	zeroResponse = &model.ESSearchResponse{}
-	responsePool = sync.Pool{
-		New: func() interface{} {
-			return new(model.ESSearchResponse)
-		},
+// encryptFields returns hit with the values at -encrypt-fields encrypted.
+func encryptFields(hit []byte) ([]byte, error) {
+	if len(encrypted_paths) == 0 {
+		return hit, nil
 	}
-)
+	id := gjson.GetBytes(hit, "_id").String()
+	matches, err := fields.Find(hit, "_source")
+	if err != nil || len(matches) != 1 {
+		return nil, fmt.Errorf("Failed to encrypt %s, the hit has no _source", id)
+	}
+	enc_source, count, err := crypt.EncryptFields([]byte(matches[0].Raw), id, encrypted_paths, secret)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encrypt %s, %w", id, err)
+	}
+	encrypted_values += count
+	return fields.Replace(hit, matches[0], enc_source), nil
+}
+
+// readSecret returns the secret to encrypt data with, or nil if nothing is to be encrypted.
+func readSecret() (*crypt.Secret, error) {
+	encrypt := *encrypt_parts || *encrypt_fields != ""
+	switch {
+	case *encryption_key_file != "" && *encryption_passphrase_file != "":
+		return nil, fmt.Errorf("-encryption-key-file and -encryption-passphrase-file can not both be set")
+	case !encrypt && (*encryption_key_file != "" || *encryption_passphrase_file != ""):
+		return nil, fmt.Errorf("-encryption-key-file and -encryption-passphrase-file require -encrypt-parts or -encrypt-fields")
+	case !encrypt:
+		return nil, nil
+	case *encryption_key_file != "":
+		return crypt.ReadKeyFile(*encryption_key_file)
+	case *encryption_passphrase_file != "":
+		return crypt.ReadPassphraseFile(*encryption_passphrase_file)
+	default:
+		return nil, fmt.Errorf("-encrypt-parts and -encrypt-fields require -encryption-key-file or -encryption-passphrase-file")
+	}
+}
 
-func GetResponse() *model.ESSearchResponse {
-	r := responsePool.Get().(*model.ESSearchResponse)
-	*r = *zeroResponse
-	return r
+func splitList(str string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(str, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// canonicalHit returns the ID of hit and its canonical encoding. The sort values used to page through
+// a point in time are removed since they differ from one dump to the next.
+func canonicalHit(hit []byte) (string, []byte, error) {
+	props := make(map[string]json.RawMessage)
+	gjson.ParseBytes(hit).ForEach(func(k gjson.Result, v gjson.Result) bool {
+		if k.String() != "sort" {
+			props[k.String()] = json.RawMessage(v.Raw)
+		}
+		return true
+	})
+	id := gjson.GetBytes(hit, "_id").String()
+	enc_props, err := json.Marshal(props)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to encode %s, %w", id, err)
+	}
+	enc_hit, err := canonical.Normalize(enc_props)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to canonicalize %s, %w", id, err)
+	}
+	return id, enc_hit, nil
 }
 
-func PutResponse(r *model.ESSearchResponse) {
-	responsePool.Put(r)
+// hitBuffer limits the number of bytes of hits that have been read but not yet written.
+type hitBuffer struct {
+	max   int
+	used  int
+	freed chan bool
+	mu    *sync.Mutex
+}
+
+func newHitBuffer(max int) *hitBuffer {
+	return &hitBuffer{
+		max:   max,
+		freed: make(chan bool),
+		mu:    new(sync.Mutex),
+	}
+}
+
+// acquire blocks until n bytes are available or ctx is cancelled. A hit larger than the buffer is let
+// through once the buffer is empty.
+func (b *hitBuffer) acquire(ctx context.Context, n int) error {
+	for {
+		b.mu.Lock()
+		if b.used == 0 || b.used+n <= b.max {
+			b.used += n
+			b.mu.Unlock()
+			return nil
+		}
+		freed := b.freed
+		b.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-freed:
+		}
+	}
+}
+
+// release returns n bytes to the buffer and wakes anyone waiting for them.
+func (b *hitBuffer) release(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	close(b.freed)
+	b.freed = make(chan bool)
+}
+
+// partWriter writes records to numbered parts in a dump directory, starting a new part every max_records
+// records.
+type partWriter struct {
+	dir         string
+	ext         string
+	encrypt     bool
+	max_records int
+	current     *manifest.PartWriter
+	parts       []*manifest.Part
+}
+
+func newPartWriter(dir string, max_records int, compression string, encrypt bool) (*partWriter, error) {
+	ext, ok := compression_extensions[compression]
+	if !ok {
+		return nil, fmt.Errorf("Invalid -compression option '%s'", compression)
+	}
+	if encrypt {
+		ext += crypt.EXTENSION
+	}
+	if max_records < 0 {
+		return nil, fmt.Errorf("-part-records must not be negative")
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create %s, %w", dir, err)
+	}
+	// Remove any manifest (and signature) from an earlier dump so that it can not describe the parts of an
+	// incomplete one
+	for _, name := range []string{manifest.FILENAME, manifest.FILENAME + manifest.SIGNATURE_SUFFIX} {
+		err = os.Remove(filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("Failed to remove previous manifest, %w", err)
+		}
+	}
+	w := &partWriter{
+		dir:         dir,
+		ext:         ext,
+		encrypt:     encrypt,
+		max_records: max_records,
+		parts:       make([]*manifest.Part, 0),
+	}
+	return w, nil
+}
+
+func (w *partWriter) Write(line []byte) error {
+	if w.current != nil && w.max_records > 0 && w.current.Records() >= w.max_records {
+		err := w.closePart()
+		if err != nil {
+			return err
+		}
+	}
+	if w.current == nil {
+		name := fmt.Sprintf("part-%04d.jsonl%s", len(w.parts), w.ext)
+		var part_secret *crypt.Secret
+		if w.encrypt {
+			part_secret = secret
+		}
+		pw, err := manifest.CreatePart(w.dir, name, part_secret)
+		if err != nil {
+			return err
+		}
+		w.current = pw
+	}
+	return w.current.WriteRecord(line)
+}
+
+func (w *partWriter) closePart() error {
+	part, err := w.current.Close()
+	if err != nil {
+		return err
+	}
+	w.parts = append(w.parts, part)
+	w.current = nil
+	return nil
+}
+
+// Close finishes the current part. Nothing is written if there were no records.
+func (w *partWriter) Close() error {
+	if w.current == nil {
+		return nil
+	}
+	return w.closePart()
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/mapping"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/record"
+)
+
+// CLI flags
+var (
+	nested             = flag.Bool("nested", true, "Map arrays of objects as nested fields, so that the properties of each object can be queried together. If false they are mapped as object fields.")
+	keyword_max_length = flag.Int("keyword-max-length", 256, "Map string fields with any value longer than this as text rather than keyword.")
+	stdin              = flag.Bool("stdin", false, "Read data from STDIN")
+)
+
+func main() {
+
+	flag.Parse()
+
+	err := infer()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func infer() error {
+
+	inferrer := mapping.NewInferrer(&mapping.Options{
+		Nested:           *nested,
+		KeywordMaxLength: *keyword_max_length,
+	})
+
+	skipped := 0
+
+	read := func(label string, r io.Reader) error {
+
+		return record.ScanLines(r, func(line_number int, line []byte) error {
+
+			source, err := documentSource(line)
+
+			if err != nil {
+				log.Printf("Skipping %s line %d, %v", label, line_number, err)
+				skipped += 1
+				return nil
+			}
+
+			inferrer.AddDocument(source)
+			return nil
+		})
+	}
+
+	if *stdin {
+
+		err := read("STDIN", os.Stdin)
+
+		if err != nil {
+			return err
+		}
+
+	} else {
+
+		if flag.NArg() == 0 {
+			return fmt.Errorf("Missing dump files")
+		}
+
+		for _, path := range flag.Args() {
+
+			fh, err := record.Open(path)
+
+			if err != nil {
+				return err
+			}
+
+			err = read(path, fh)
+			fh.Close()
+
+			if err != nil {
+				return fmt.Errorf("Failed to read %s, %w", path, err)
+			}
+		}
+	}
+
+	if inferrer.Documents() == 0 {
+		return fmt.Errorf("No documents were read")
+	}
+
+	for _, c := range inferrer.Conflicts() {
+		log.Printf("Conflict: %s", c)
+	}
+
+	definition := map[string]interface{}{
+		"mappings": inferrer.Mappings(),
+	}
+
+	enc_definition, err := json.Marshal(definition)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(pretty.Pretty(enc_definition)))
+
+	log.Printf("Inferred mappings from %d documents, skipped %d lines", inferrer.Documents(), skipped)
+	return nil
+}
+
+// documentSource returns the _source of line if it is a dump record, or line itself if it is a bare document.
+func documentSource(line []byte) ([]byte, error) {
+
+	if !gjson.ValidBytes(line) {
+		return nil, fmt.Errorf("Invalid JSON")
+	}
+
+	if gjson.GetBytes(line, "_source").IsObject() {
+
+		rec, err := record.Parse(line)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return rec.Source, nil
+	}
+
+	if !gjson.ParseBytes(line).IsObject() {
+		return nil, fmt.Errorf("Document is not an object")
+	}
+
+	return line, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tidwall/pretty"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/inspect"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/record"
+)
+
+// The maximum number of malformed lines or duplicate IDs to list in table output.
+const max_table_examples int = 20
+
+// CLI flags
+var (
+	format = flag.String("format", "table", "The format of the report. Valid options are: table, json.")
+	stdin  = flag.Bool("stdin", false, "Read data from STDIN")
+)
+
+func main() {
+
+	flag.Parse()
+
+	err := inspectDump()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func inspectDump() error {
+
+	switch *format {
+	case "table", "json":
+		// pass
+	default:
+		return fmt.Errorf("Invalid -format option '%s'", *format)
+	}
+
+	var r io.Reader
+
+	if *stdin {
+
+		r = os.Stdin
+
+	} else {
+
+		if flag.NArg() != 1 {
+			return fmt.Errorf("Expected exactly one dump file")
+		}
+
+		fh, err := record.Open(flag.Arg(0))
+
+		if err != nil {
+			return err
+		}
+
+		defer fh.Close()
+		r = fh
+	}
+
+	ins := inspect.NewInspector()
+
+	err := record.ScanLines(r, func(line_number int, line []byte) error {
+		ins.AddLine(line_number, line)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	report := ins.Report()
+
+	if *format == "json" {
+
+		enc_report, err := json.Marshal(report)
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(pretty.Pretty(enc_report)))
+		return nil
+	}
+
+	wr := bufio.NewWriter(os.Stdout)
+	writeTable(wr, report)
+
+	return wr.Flush()
+}
+
+func writeTable(wr io.Writer, report *inspect.Report) {
+
+	tw := tabwriter.NewWriter(wr, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Lines\t%d\n", report.Lines)
+	fmt.Fprintf(tw, "Records\t%d\n", report.Records)
+	fmt.Fprintf(tw, "Malformed lines\t%d\n", len(report.Malformed))
+	fmt.Fprintf(tw, "Duplicate IDs\t%d\n", len(report.Duplicates))
+	tw.Flush()
+
+	if len(report.Malformed) > 0 {
+
+		fmt.Fprintf(wr, "\nMalformed lines\n\n")
+
+		for i, m := range report.Malformed {
+
+			if i == max_table_examples {
+				fmt.Fprintf(tw, "...\t%d more\n", len(report.Malformed)-i)
+				break
+			}
+
+			fmt.Fprintf(tw, "%d\t%s\n", m.Line, m.Error)
+		}
+
+		tw.Flush()
+	}
+
+	if len(report.Duplicates) > 0 {
+
+		fmt.Fprintf(wr, "\nDuplicate IDs\n\n")
+
+		for i, d := range report.Duplicates {
+
+			if i == max_table_examples {
+				fmt.Fprintf(tw, "...\t%d more\n", len(report.Duplicates)-i)
+				break
+			}
+
+			lines := make([]string, len(d.Lines))
+
+			for j, l := range d.Lines {
+				lines[j] = fmt.Sprintf("%d", l)
+			}
+
+			fmt.Fprintf(tw, "%s\tlines %s\n", d.ID, strings.Join(lines, ", "))
+		}
+
+		tw.Flush()
+	}
+
+	s := report.Sizes
+
+	fmt.Fprintf(wr, "\n_source size (bytes)\n\n")
+	fmt.Fprintf(tw, "Total\tMin\tMean\tP50\tP90\tP99\tMax\n")
+	fmt.Fprintf(tw, "%d\t%d\t%.0f\t%d\t%d\t%d\t%d\n", s.Total, s.Min, s.Mean, s.P50, s.P90, s.P99, s.Max)
+	tw.Flush()
+
+	if len(s.Histogram) > 0 {
+
+		fmt.Fprintf(wr, "\n")
+
+		for _, b := range s.Histogram {
+			fmt.Fprintf(tw, "<= %s\t%d\n", b, b.Count)
+		}
+
+		tw.Flush()
+	}
+
+	indices := make([]string, 0, len(report.Indices))
+
+	for name := range report.Indices {
+		indices = append(indices, name)
+	}
+
+	sort.Strings(indices)
+
+	fmt.Fprintf(wr, "\nIndices\n\n")
+
+	for _, name := range indices {
+
+		label := name
+
+		if label == "" {
+			label = "(none)"
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\n", label, report.Indices[name])
+	}
+
+	tw.Flush()
+
+	fmt.Fprintf(wr, "\nFields\n\n")
+	fmt.Fprintf(tw, "Path\tDocuments\tTypes\n")
+
+	for _, f := range report.Fields {
+
+		types := make([]string, 0, len(f.Types))
+
+		for t := range f.Types {
+			types = append(types, t)
+		}
+
+		sort.Strings(types)
+
+		for i, t := range types {
+			types[i] = fmt.Sprintf("%s (%d)", t, f.Types[t])
+		}
+
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", f.Path, f.Documents, strings.Join(types, ", "))
+	}
+
+	tw.Flush()
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/record"
+)
+
+// The placeholder in -output which is replaced with the number of each file written by -mode split.
+const file_number_placeholder string = "{n}"
+
+// CLI flags
+var (
+	mode   = flag.String("mode", "", "How to rewrite the input. Valid options are: split (split the input into several files), merge (merge the inputs into one file, removing records with the same _index and _id), sort (sort the input by _id).")
+	output = flag.String("output", "", "The path to write to. Files ending in .bz2 or .gz are compressed. With -mode split the path must contain {n}, which is replaced with the number of each file, and defaults to the name of the first input with -{n} before its extension. Otherwise it defaults to STDOUT.")
+
+	split_by    = flag.String("split-by", "id", "How to assign records to files with -mode split. Valid options are: id (by a hash of each record's _id, into -files files), records (starting a new file every -max-records records), bytes (starting a new file before a file would exceed -max-bytes bytes of uncompressed records).")
+	files       = flag.Int("files", 4, "The number of files to split the input into with -split-by id.")
+	max_records = flag.Int("max-records", 100000, "The maximum number of records in each file with -split-by records.")
+	max_bytes   = flag.Int64("max-bytes", 1e+9, "The maximum number of bytes of uncompressed records in each file with -split-by bytes.")
+
+	keep = flag.String("keep", "last", "Which record to keep when more than one has the same _index and _id with -mode merge. Valid options are: last (the last one read), version (the one with the highest _version, as written by dump -include-version, or the last of those).")
+
+	sort_buffer = flag.Int("sort-buffer", 256e+6, "The number of bytes of records to sort in memory before spilling them to temporary files with -mode sort.")
+	temp_dir    = flag.String("temp-dir", "", "The directory to write temporary files to with -mode sort. If empty the default directory for temporary files is used.")
+
+	stdin = flag.Bool("stdin", false, "Read data from STDIN. Not supported by -mode merge, which reads its inputs twice.")
+)
+
+// input is a dump file, or STDIN, to read records from.
+type input struct {
+	label string
+	path  string
+}
+
+func main() {
+
+	flag.Parse()
+
+	err := reshard()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func reshard() error {
+
+	inputs := make([]*input, 0)
+
+	if *stdin {
+
+		if flag.NArg() > 0 {
+			return fmt.Errorf("-stdin can not be combined with input files")
+		}
+
+		inputs = append(inputs, &input{label: "STDIN"})
+
+	} else {
+
+		for _, path := range flag.Args() {
+			inputs = append(inputs, &input{label: path, path: path})
+		}
+	}
+
+	if len(inputs) == 0 {
+		return fmt.Errorf("Missing input files")
+	}
+
+	switch *mode {
+	case "split":
+		return split(inputs)
+	case "merge":
+
+		if *stdin {
+			return fmt.Errorf("-mode merge can not read from STDIN")
+		}
+
+		return merge(inputs)
+
+	case "sort":
+		return sortRecords(inputs)
+	case "":
+		return fmt.Errorf("Missing -mode")
+	default:
+		return fmt.Errorf("Invalid -mode option '%s'", *mode)
+	}
+}
+
+// scan calls fn with every record in in, and its line number.
+func scan(in *input, fn func(line_number int, rec *record.Record, line []byte) error) error {
+
+	var r io.Reader = os.Stdin
+
+	if in.path != "" {
+
+		fh, err := record.Open(in.path)
+
+		if err != nil {
+			return err
+		}
+
+		defer fh.Close()
+		r = fh
+	}
+
+	return record.ScanLines(r, func(line_number int, line []byte) error {
+
+		rec, err := record.Parse(line)
+
+		if err != nil {
+			return fmt.Errorf("Failed to parse %s line %d, %w", in.label, line_number, err)
+		}
+
+		return fn(line_number, rec, line)
+	})
+}
+
+// outputFile is a file of records being written.
+type outputFile struct {
+	path    string
+	wc      io.WriteCloser
+	bw      *bufio.Writer
+	records int
+	bytes   int64
+}
+
+// checkOutput returns an error if path is one of inputs, which would be truncated before it is read.
+func checkOutput(path string, inputs []*input) error {
+
+	if path == "" {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to stat %s, %w", path, err)
+	}
+
+	for _, in := range inputs {
+
+		if in.path == "" {
+			continue
+		}
+
+		in_info, err := os.Stat(in.path)
+
+		if err != nil {
+			return fmt.Errorf("Failed to stat %s, %w", in.path, err)
+		}
+
+		if os.SameFile(info, in_info) {
+			return fmt.Errorf("Refusing to write %s, which is also an input", path)
+		}
+	}
+
+	return nil
+}
+
+func createOutput(path string) (*outputFile, error) {
+
+	if path == "" {
+
+		f := &outputFile{
+			path: "STDOUT",
+			bw:   bufio.NewWriter(os.Stdout),
+		}
+
+		return f, nil
+	}
+
+	wc, err := record.Create(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f := &outputFile{
+		path: path,
+		wc:   wc,
+		bw:   bufio.NewWriter(wc),
+	}
+
+	return f, nil
+}
+
+func (f *outputFile) Write(line []byte) error {
+
+	_, err := f.bw.Write(line)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write %s, %w", f.path, err)
+	}
+
+	err = f.bw.WriteByte('\n')
+
+	if err != nil {
+		return fmt.Errorf("Failed to write %s, %w", f.path, err)
+	}
+
+	f.records += 1
+	f.bytes += int64(len(line) + 1)
+
+	return nil
+}
+
+func (f *outputFile) Close() error {
+
+	err := f.bw.Flush()
+
+	if err != nil {
+		return fmt.Errorf("Failed to write %s, %w", f.path, err)
+	}
+
+	if f.wc == nil {
+		return nil
+	}
+
+	err = f.wc.Close()
+
+	if err != nil {
+		return fmt.Errorf("Failed to close %s, %w", f.path, err)
+	}
+
+	return nil
+}
+
+func split(inputs []*input) error {
+
+	switch *split_by {
+	case "id":
+
+		if *files < 1 {
+			return fmt.Errorf("-files must be greater than zero")
+		}
+
+	case "records":
+
+		if *max_records < 1 {
+			return fmt.Errorf("-max-records must be greater than zero")
+		}
+
+	case "bytes":
+
+		if *max_bytes < 1 {
+			return fmt.Errorf("-max-bytes must be greater than zero")
+		}
+
+	default:
+		return fmt.Errorf("Invalid -split-by option '%s'", *split_by)
+	}
+
+	template := *output
+
+	if template == "" {
+
+		if inputs[0].path == "" {
+			return fmt.Errorf("Missing -output, which is required when reading from STDIN")
+		}
+
+		template = splitTemplate(inputs[0].path)
+	}
+
+	if !strings.Contains(template, file_number_placeholder) {
+		return fmt.Errorf("-output must contain %s", file_number_placeholder)
+	}
+
+	outputs := make([]*outputFile, 0)
+
+	newOutput := func() (*outputFile, error) {
+
+		path := strings.Replace(template, file_number_placeholder, fmt.Sprintf("%04d", len(outputs)), -1)
+
+		err := checkOutput(path, inputs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := createOutput(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		outputs = append(outputs, f)
+		return f, nil
+	}
+
+	closeOutputs := func() error {
+
+		for _, f := range outputs {
+
+			err := f.Close()
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if *split_by == "id" {
+
+		for i := 0; i < *files; i++ {
+
+			_, err := newOutput()
+
+			if err != nil {
+				closeOutputs()
+				return err
+			}
+		}
+	}
+
+	var current *outputFile
+
+	for _, in := range inputs {
+
+		err := scan(in, func(line_number int, rec *record.Record, line []byte) error {
+
+			var err error
+
+			switch *split_by {
+			case "id":
+
+				h := fnv.New64a()
+				h.Write([]byte(rec.ID))
+
+				current = outputs[h.Sum64()%uint64(*files)]
+
+			case "records":
+
+				if current == nil || current.records >= *max_records {
+					current, err = newOutput()
+				}
+
+			case "bytes":
+
+				if current == nil || (current.records > 0 && current.bytes+int64(len(line)+1) > *max_bytes) {
+					current, err = newOutput()
+				}
+			}
+
+			if err != nil {
+				return err
+			}
+
+			return current.Write(line)
+		})
+
+		if err != nil {
+			closeOutputs()
+			return err
+		}
+	}
+
+	err := closeOutputs()
+
+	if err != nil {
+		return err
+	}
+
+	for _, f := range outputs {
+		log.Printf("Wrote %d records to %s", f.records, f.path)
+	}
+
+	return nil
+}
+
+// splitTemplate returns the -output template for files split from path, with the file number placeholder
+// before its extensions, for example "dump-{n}.jsonl.bz2" for "dump.jsonl.bz2".
+func splitTemplate(path string) string {
+
+	dir, name := filepath.Split(path)
+	ext := ""
+
+	for _, e := range []string{".bz2", ".gz", ".jsonl", ".json"} {
+
+		if strings.HasSuffix(name, e) {
+			name = strings.TrimSuffix(name, e)
+			ext = e + ext
+		}
+	}
+
+	return dir + name + "-" + file_number_placeholder + ext
+}
+
+// mergeKey identifies the records which are duplicates of each other when merging. Documents in different
+// indices may have the same _id.
+type mergeKey struct {
+	index string
+	id    string
+}
+
+// location is the position of a record in the inputs to merge.
+type location struct {
+	input   int
+	line    int
+	version int64
+}
+
+func merge(inputs []*input) error {
+
+	switch *keep {
+	case "last", "version":
+		// pass
+	default:
+		return fmt.Errorf("Invalid -keep option '%s'", *keep)
+	}
+
+	err := checkOutput(*output, inputs)
+
+	if err != nil {
+		return err
+	}
+
+	// Find the record to keep for each index and ID, then read the inputs again and write only those records
+	// so that the records do not need to be held in memory
+
+	winners := make(map[mergeKey]location)
+	read := 0
+
+	for i, in := range inputs {
+
+		err := scan(in, func(line_number int, rec *record.Record, line []byte) error {
+
+			read += 1
+
+			// Records without an _id are assigned one when they are indexed so they are never duplicates
+			if rec.ID == "" {
+				return nil
+			}
+
+			key := mergeKey{
+				index: rec.Index,
+				id:    rec.ID,
+			}
+
+			loc := location{
+				input:   i,
+				line:    line_number,
+				version: -1,
+			}
+
+			if *keep == "version" {
+
+				v := gjson.GetBytes(line, "_version")
+
+				if v.Exists() {
+					loc.version = v.Int()
+				}
+
+				current, ok := winners[key]
+
+				if ok && current.version > loc.version {
+					return nil
+				}
+			}
+
+			winners[key] = loc
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	out, err := createOutput(*output)
+
+	if err != nil {
+		return err
+	}
+
+	for i, in := range inputs {
+
+		err := scan(in, func(line_number int, rec *record.Record, line []byte) error {
+
+			if rec.ID != "" {
+
+				loc := winners[mergeKey{index: rec.Index, id: rec.ID}]
+
+				if loc.input != i || loc.line != line_number {
+					return nil
+				}
+			}
+
+			return out.Write(line)
+		})
+
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+
+	err = out.Close()
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Merged %d records from %d files into %d records, discarding %d duplicates", read, len(inputs), out.records, read-out.records)
+	return nil
+}
+
+func sortRecords(inputs []*input) error {
+
+	err := checkOutput(*output, inputs)
+
+	if err != nil {
+		return err
+	}
+
+	sorter := record.NewSorter(*sort_buffer, *temp_dir)
+	defer sorter.Close()
+
+	count := 0
+
+	for _, in := range inputs {
+
+		err := scan(in, func(line_number int, rec *record.Record, line []byte) error {
+			count += 1
+			return sorter.Add(rec.ID, line)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	out, err := createOutput(*output)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = sorter.WriteTo(out.bw)
+
+	if err != nil {
+		out.Close()
+		return fmt.Errorf("Failed to write %s, %w", out.path, err)
+	}
+
+	err = out.Close()
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Sorted %d records", count)
+	return nil
+}
@@ -1,167 +1,656 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"compress/bzip2"
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/aaronland/go-jsonl/walk"
 	"github.com/cenkalti/backoff/v4"
-	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esutil"
 	"github.com/tidwall/pretty"
 
-	"github.com/sfomuseum/go-jsonl-elasticsearch/model"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/bulk"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/client"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/cluster"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/crypt"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/index"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/manifest"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/pressure"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/record"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/throttle"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/verify"
 )
 
-func main() {
+// CLI flags
+var (
+	es_opts  = client.AppendFlags(flag.CommandLine, "")
+	es_index = flag.String("elasticsearch-index", "", "The name of the Elasticsearch index to dump.")
+
+	workers        = flag.Int("workers", runtime.NumCPU(), "The number of concurrent processes to use when indexing data.")
+	decode_workers = flag.Int("decode-workers", runtime.NumCPU(), "The number of concurrent processes to use when extracting documents from records.")
+	validate_json  = flag.Bool("validate-json", false, "Ensure each record is valid JSON.")
+	is_bzip        = flag.Bool("is-bzip", false, "Signal that the data is compressed using bzip2 encoding. Files ending in .bz2 or .gz are always decompressed.")
+	stdin          = flag.Bool("stdin", false, "Read data from STDIN")
+	flush_bytes    = flag.Int("flush-bytes", 5e+6, "The size in bytes at which the bulk indexer flushes documents to Elasticsearch.")
+
+	max_docs_per_second  = flag.Float64("max-docs-per-second", 0, "If greater than zero, the maximum number of documents to send to Elasticsearch per second.")
+	max_bytes_per_second = flag.Int("max-bytes-per-second", 0, "If greater than zero, the maximum number of bytes of document data to send to Elasticsearch per second.")
+
+	adaptive = flag.Bool("adaptive", false, "Reduce the number of workers and the flush size when Elasticsearch rejects bulk requests or reports any other pressure, and increase them again when it recovers. -workers and -flush-bytes are the upper bounds.")
+
+	pressure_breaker_ratio = flag.Float64("pressure-breaker-ratio", 1.0, "Pause indexing while any circuit breaker's estimated size is at or above this ratio of its limit. Zero disables the check.")
+	pressure_max_queue     = flag.Int("pressure-max-queue", 0, "Pause indexing while any node has more than this many queued write tasks. Zero disables the check.")
+	pressure_min_health    = flag.String("pressure-min-health", "yellow", "Pause indexing while the index's health is worse than this status. Valid options are: green, yellow, red or an empty string to disable the check.")
+	pressure_interval      = flag.Duration("pressure-interval", 10*time.Second, "How often to check the cluster for pressure.")
+	pressure_timeout       = flag.Duration("pressure-timeout", 10*time.Minute, "Fail if indexing has been paused for longer than this. Zero waits indefinitely.")
+
+	mappings = flag.String("mappings", "", "The path to a JSON file with the mappings, and optionally settings, to create the index with if it does not exist, for example the output of the infer tool. With -blue-green they replace the mappings and settings of the index the alias points to.")
+
+	fast_load   = flag.Bool("fast-load", false, "Disable refreshes and replicas while indexing data and reset them to their original values when finished.")
+	force_merge = flag.Int("force-merge", 0, "If greater than zero, force-merge the index down to this many segments after a successful restore.")
 
-	es_endpoint := flag.String("elasticsearch-endpoint", "", "The name of the Elasticsearch host to query.")
-	es_index := flag.String("elasticsearch-index", "", "The name of the Elasticsearch index to dump.")
+	blue_green = flag.Bool("blue-green", false, "Treat -elasticsearch-index as an alias. Restore data into a new versioned index and, once its document count has been verified, point the alias at it.")
+	retire_old = flag.String("retire-old", "keep", "What to do with the indices an alias pointed to after a successful -blue-green restore. Valid options are: keep, close, delete.")
 
-	workers := flag.Int("workers", runtime.NumCPU(), "The number of concurrent processes to use when indexing data.")
-	validate_json := flag.Bool("validate-json", false, "Ensure each record is valid JSON.")
-	is_bzip := flag.Bool("is-bzip", false, "Signal that the data is compressed using bzip2 encoding.")
-	stdin := flag.Bool("stdin", false, "Read data from STDIN")
+	verify_index  = flag.Bool("verify", false, "After restoring, compare the index's document count with the number of distinct _ids restored to it and compare a random sample of documents with their input records. Mismatches are reported as JSON.")
+	verify_sample = flag.Int("verify-sample", 100, "The number of random records to compare when -verify is enabled.")
+
+	ignore_manifest = flag.Bool("ignore-manifest", false, "Restore dump files whose size or SHA-256 digest does not match their manifest instead of refusing to restore anything. The -public-key checks can not be ignored.")
+	public_key      = flag.String("public-key", "", "The path to a PEM-encoded ed25519 public key. If set, only dump files listed in a manifest with a valid manifest.json.sig signature made with the matching private key are restored.")
+
+	encryption_key_file        = flag.String("encryption-key-file", "", "The path to a file containing the base64-encoded 256-bit key the data was encrypted with by dump. Encrypted files, and the values at the paths recorded in their manifest or -encrypted-fields, are decrypted automatically.")
+	encryption_passphrase_file = flag.String("encryption-passphrase-file", "", "The path to a file containing the passphrase the data was encrypted with by dump, instead of -encryption-key-file.")
+	encrypted_fields           = flag.String("encrypted-fields", "", "A comma-separated list of the gjson paths of the _source values encrypted by dump -encrypt-fields, for inputs without a manifest (such as STDIN). The values of inputs listed in a manifest are decrypted at the paths it records.")
+
+	legacy_type       = flag.String("legacy-type", "strip", "What to do with the _type property of records dumped from Elasticsearch 6 (or earlier) indices. Valid options are: strip (discard it), field (store it in the -legacy-type-field property of each document), index (restore each type into its own index named {index}-{type}).")
+	legacy_type_field = flag.String("legacy-type-field", "type", "The name of the property to store each record's _type in when -legacy-type is \"field\".")
+	legacy_type_id    = flag.String("legacy-type-id", "keep", "How to assign the _id of records with a _type. Valid options are: keep (use their _id as is, failing the restore if records of different types with the same _id replace each other), prefix (use {type}#{id} so that they remain distinct).")
+)
+
+func main() {
 
 	flag.Parse()
 
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := restore(ctx)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func restore(ctx context.Context) error {
+
+	// Allow errors from deep inside the indexing pipeline to stop the restore
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+
+	if *decode_workers < 1 {
+		return fmt.Errorf("Invalid -decode-workers option '%d', must be at least 1", *decode_workers)
+	}
+
+	switch *retire_old {
+	case "keep", "close", "delete":
+		// pass
+	default:
+		return fmt.Errorf("Invalid -retire-old option '%s'", *retire_old)
+	}
+
+	switch *legacy_type {
+	case "strip":
+		// pass
+	case "field":
+
+		if *legacy_type_field == "" {
+			return fmt.Errorf("Missing -legacy-type-field")
+		}
+
+	case "index":
+
+		// Each of these operates on a single index
+		if *blue_green || *fast_load || *verify_index || *force_merge > 0 || *mappings != "" {
+			return fmt.Errorf("-legacy-type=index can not be combined with -blue-green, -fast-load, -verify, -force-merge or -mappings")
+		}
+
+	default:
+		return fmt.Errorf("Invalid -legacy-type option '%s'", *legacy_type)
+	}
+
+	switch *legacy_type_id {
+	case "keep", "prefix":
+		// pass
+	default:
+		return fmt.Errorf("Invalid -legacy-type-id option '%s'", *legacy_type_id)
+	}
+
+	var file_definition map[string]interface{}
+
+	if *mappings != "" {
+
+		def, err := index.ReadDefinition(*mappings)
+
+		if err != nil {
+			return err
+		}
+
+		file_definition = def
+	}
+
+	switch {
+	case *encryption_key_file != "" && *encryption_passphrase_file != "":
+		return fmt.Errorf("-encryption-key-file and -encryption-passphrase-file can not both be set")
+	case *encryption_key_file != "":
+
+		s, err := crypt.ReadKeyFile(*encryption_key_file)
+
+		if err != nil {
+			return err
+		}
+
+		secret = s
+
+	case *encryption_passphrase_file != "":
+
+		s, err := crypt.ReadPassphraseFile(*encryption_passphrase_file)
+
+		if err != nil {
+			return err
+		}
+
+		secret = s
+	}
+
+	// Check every input before anything is changed so that a tampered or truncated dump is not partially restored
+	inputs, err := resolveInputs(flag.Args())
+
+	if err != nil {
+		return err
+	}
 
 	retry := backoff.NewExponentialBackOff()
 
-	es_cfg := elasticsearch.Config{
-		Addresses: []string{
-			*es_endpoint,
-		},
-		RetryOnStatus: []int{502, 503, 504, 429},
-		RetryBackoff: func(i int) time.Duration {
-			if i == 1 {
-				retry.Reset()
-			}
-			return retry.NextBackOff()
-		},
-		MaxRetries: 5,
+	es_cfg, err := es_opts.Config()
+
+	if err != nil {
+		return fmt.Errorf("Failed to configure ES client, %w", err)
+	}
+
+	es_cfg.RetryOnStatus = []int{502, 503, 504, 429}
+	es_cfg.RetryBackoff = func(i int) time.Duration {
+		if i == 1 {
+			retry.Reset()
+		}
+		return retry.NextBackOff()
 	}
+	es_cfg.MaxRetries = 5
 
-	es_client, err := elasticsearch.NewClient(es_cfg)
+	es_client, err := cluster.NewClient(ctx, es_cfg)
 
 	if err != nil {
-		log.Fatalf("Failed to create ES client, %v", err)
+		return fmt.Errorf("Failed to create ES client, %w", err)
+	}
+
+	log.Printf("Restoring to %s", es_client.Info())
+
+	target := *es_index
+	old_indices := []string{}
+
+	if *blue_green {
+
+		old_indices, err = index.AliasedIndices(ctx, es_client.API(), *es_index)
+
+		if err != nil {
+			return err
+		}
+
+		var definition map[string]interface{}
+
+		if len(old_indices) > 0 {
+
+			definition, err = index.Definition(ctx, es_client.API(), old_indices[0])
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if file_definition != nil {
+
+			if definition == nil {
+				definition = make(map[string]interface{})
+			}
+
+			for k, v := range file_definition {
+				definition[k] = v
+			}
+		}
+
+		target, err = index.VersionedName(*es_index, time.Now())
+
+		if err != nil {
+			return err
+		}
+
+		err = index.Create(ctx, es_client.API(), target, definition)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Restoring alias %s into new index %s", *es_index, target)
+
+	} else if file_definition != nil {
+
+		exists, err := index.Exists(ctx, es_client.API(), target)
+
+		if err != nil {
+			return err
+		}
+
+		if exists {
+
+			log.Printf("%s already exists, ignoring -mappings", target)
+
+		} else {
+
+			err = index.Create(ctx, es_client.API(), target, file_definition)
+
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Created %s with the mappings in %s", target, *mappings)
+		}
 	}
 
-	bi_cfg := esutil.BulkIndexerConfig{
-		Index:         *es_index,
-		Client:        es_client,
+	reset_settings := func() {}
+
+	if *fast_load {
+
+		bulk_settings := index.BulkLoadSettings()
+
+		orig_settings, err := index.GetSettings(ctx, es_client.API(), target, bulk_settings.Keys()...)
+
+		if err != nil {
+			return err
+		}
+
+		err = index.PutSettings(ctx, es_client.API(), target, bulk_settings)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Applied bulk load settings to %s (%s)", target, bulk_settings)
+
+		is_reset := false
+
+		reset_settings = func() {
+
+			if is_reset {
+				return
+			}
+
+			is_reset = true
+
+			// ctx may already have been cancelled by a signal so use a new one
+			reset_ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+			defer cancel()
+
+			err := index.PutSettings(reset_ctx, es_client.API(), target, orig_settings)
+
+			if err != nil {
+				log.Printf("Failed to reset settings for %s (%s), %v", target, orig_settings, err)
+				return
+			}
+
+			log.Printf("Reset settings for %s (%s)", target, orig_settings)
+		}
+
+		defer reset_settings()
+	}
+
+	bi_cfg := bulk.Config{
+		Index:         target,
+		Transport:     es_client,
 		NumWorkers:    *workers,
+		FlushBytes:    *flush_bytes,
 		FlushInterval: 30 * time.Second,
 	}
 
-	bi, err := esutil.NewBulkIndexer(bi_cfg)
+	var bi esutil.BulkIndexer
+	var adaptive_bi *throttle.AdaptiveBulkIndexer
 
-	record_ch := make(chan *walk.WalkRecord)
-	error_ch := make(chan *walk.WalkError)
-	done_ch := make(chan bool)
+	if *adaptive {
 
-	go func() {
+		adaptive_opts := &throttle.AdaptiveOptions{
+			Config:        bi_cfg,
+			MinWorkers:    1,
+			MinFlushBytes: 256 * 1024,
+		}
 
-		for {
+		adaptive_bi, err = throttle.NewAdaptiveBulkIndexer(adaptive_opts)
+		bi = adaptive_bi
 
-			select {
-			case <-ctx.Done():
-				return
-			case <-done_ch:
+	} else {
+		bi, err = bulk.NewBulkIndexer(bi_cfg)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to create bulk indexer, %w", err)
+	}
+
+	guard_opts := &pressure.Options{
+		Index:        target,
+		BreakerRatio: *pressure_breaker_ratio,
+		ThreadPools:  []string{"write"},
+		MaxQueue:     *pressure_max_queue,
+		MinHealth:    *pressure_min_health,
+		Interval:     *pressure_interval,
+		Timeout:      *pressure_timeout,
+	}
+
+	guard, err := pressure.NewGuard(es_client, guard_opts)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create pressure guard, %w", err)
+	}
+
+	watch_ctx, stop_watching := context.WithCancel(ctx)
+	defer stop_watching()
+
+	gate := guard.Watch(watch_ctx, func(status *pressure.Status) {
+		if adaptive_bi != nil {
+			adaptive_bi.Observe(watch_ctx, status)
+		}
+	})
+
+	docs_limiter := throttle.NewLimiter(*max_docs_per_second)
+	bytes_limiter := throttle.NewLimiter(float64(*max_bytes_per_second))
+
+	var samples *verify.Reservoir
+
+	// The number of documents the index should contain, which is fewer than the records read if any repeat an _id
+	var documents *verify.Documents
+
+	if *verify_index {
+		samples = verify.NewReservoir(*verify_sample)
+		documents = verify.NewDocuments()
+	}
+
+	records_read := int64(0)
+	// The number of documents the bulk indexer created, and replaced because an earlier record had the same _id
+	created_documents := int64(0)
+	replaced_documents := int64(0)
+	typed_records := int64(0)
+	encrypted_records := int64(0)
+	colliding_records := int64(0)
+
+	// Records which can not be decrypted are left out, and fail the restore
+	var decrypter *crypt.Decrypter
+
+	if secret != nil {
+		decrypter = crypt.NewDecrypter(secret)
+	}
+
+	// Documents of different types only end up in the same index, and can replace each other, if they keep
+	// their _id
+	var collisions *record.TypeCollisions
+
+	if *legacy_type != "index" && *legacy_type_id == "keep" {
+		collisions = record.NewTypeCollisions()
+	}
+
+	index_record := func(rec *walk.WalkRecord, encrypted []string) {
+
+		// Keep draining the walker after a signal but stop scheduling new documents
+		if ctx.Err() != nil {
+			return
+		}
+
+		doc, err := record.Parse(rec.Body)
+
+		if err != nil {
+			log.Printf("Failed to parse record at line %d, %v", rec.LineNumber, err)
+			return
+		}
+
+		atomic.AddInt64(&records_read, 1)
+
+		path := fmt.Sprintf("%s (line %d)", doc.ID, rec.LineNumber)
+
+		if doc.ID == "" {
+			path = fmt.Sprintf("line %d", rec.LineNumber)
+		}
+
+		source := doc.Source
+		doc_index := ""
+		doc_id := doc.ID
+
+		if secret != nil && len(encrypted) > 0 {
+
+			source, err = decrypter.Decrypt(source, doc.ID, encrypted)
+
+			if err != nil {
+				log.Printf("ERROR: Failed to decrypt %s, %v", path, err)
 				return
-			case err := <-error_ch:
-				log.Println(err)
-			case rec := <-record_ch:
+			}
+
+		} else if bytes.Contains(source, []byte(crypt.VALUE_PREFIX)) {
+			atomic.AddInt64(&encrypted_records, 1)
+		}
+
+		if doc.Type != "" {
+
+			atomic.AddInt64(&typed_records, 1)
+
+			switch *legacy_type {
+			case "field":
+
+				source, err = doc.AddField(*legacy_type_field, doc.Type)
+
+				if err != nil {
+					log.Printf("ERROR: Failed to add type to %s, %v", path, err)
+					return
+				}
+
+			case "index":
+				// Index names must be lower case
+				doc_index = fmt.Sprintf("%s-%s", target, strings.ToLower(doc.Type))
+			}
+
+			switch *legacy_type_id {
+			case "prefix":
+				doc_id = doc.TypedID()
+			case "keep":
+
+				if collisions != nil {
+
+					if other := collisions.Add(doc); other != "" {
+						atomic.AddInt64(&colliding_records, 1)
+						log.Printf("ERROR: %s of type %s has the same _id as an earlier record of type %s and replaces it", path, doc.Type, other)
+					}
+				}
+			}
+		}
+
+		if documents != nil && doc_index == "" {
+			documents.Add(doc_id)
+		}
+
+		// Documents without an _id are assigned one by Elasticsearch so they can not be looked up to verify them
+		if samples != nil && doc_id != "" {
+			samples.Add(doc_id, source)
+		}
+
+		// These only fail if ctx has been cancelled
+		if docs_limiter.WaitN(ctx, 1) != nil || bytes_limiter.WaitN(ctx, len(source)) != nil {
+			return
+		}
 
-				var doc *model.ESHit
+		err = gate.Wait(ctx)
 
-				err := json.Unmarshal(rec.Body, &doc)
+		if err != nil {
+			abort(fmt.Errorf("Failed to schedule %s, %w", path, err))
+			return
+		}
+
+		bulk_item := esutil.BulkIndexerItem{
+			Action:     "index",
+			Index:      doc_index,
+			DocumentID: doc_id,
+			Body:       bytes.NewReader(source),
 
+			OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+
+				switch res.Result {
+				case "created":
+					atomic.AddInt64(&created_documents, 1)
+				case "updated":
+					atomic.AddInt64(&replaced_documents, 1)
+				}
+			},
+
+			OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
 				if err != nil {
-					log.Printf("Failed to unmarshal document, %v", err)
+					log.Printf("ERROR: Failed to index %s, %s", path, err)
 				} else {
+					log.Printf("ERROR: Failed to index %s, %s: %s", path, res.Error.Type, res.Error.Reason)
+				}
+			},
+		}
 
-					doc_id := doc.ID
-					doc_body, _ := json.Marshal(doc.Source)
+		err = bi.Add(ctx, bulk_item)
 
-					// hash := sha256.Sum256(rec.Body)
-					// doc_id := fmt.Sprintf("%x", hash[:])
+		if err != nil {
+			log.Printf("Failed to schedule %s, %v", path, err)
+		}
+	}
 
-					br := bytes.NewReader(doc_body)
-					path := "n/a"
+	// WalkReader signals completion on DoneChannel before it returns
+	walk_done_ch := make(chan bool, 1)
 
-					bulk_item := esutil.BulkIndexerItem{
-						Action:     "index",
-						DocumentID: doc_id,
-						Body:       br,
+	// walkInput restores the records in r, whose encrypted values are at the paths encrypted. Each input is
+	// decoded by its own workers so that every record is decrypted with the paths of the input it came from.
+	walkInput := func(r io.Reader, encrypted []string) error {
 
-						OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
-							// log.Printf("Indexed %s\n", path)
-						},
+		record_ch := make(chan *walk.WalkRecord)
+		error_ch := make(chan *walk.WalkError)
+		done_ch := make(chan bool)
 
-						OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-							if err != nil {
-								log.Printf("ERROR: Failed to index %s, %s", path, err)
-							} else {
-								log.Printf("ERROR: Failed to index %s, %s: %s", path, res.Error.Type, res.Error.Reason)
-							}
-						},
-					}
+		wg := new(sync.WaitGroup)
+
+		for i := 0; i < *decode_workers; i++ {
+
+			wg.Add(1)
+
+			go func() {
+
+				defer wg.Done()
 
-					err = bi.Add(ctx, bulk_item)
+				for {
 
-					if err != nil {
-						log.Printf("Failed to schedule %s, %v", path, err)
+					select {
+					case <-done_ch:
+						return
+					case err := <-error_ch:
+						log.Println(err)
+					case rec := <-record_ch:
+						index_record(rec, encrypted)
 					}
 				}
+			}()
+		}
 
-			}
+		walk_opts := &walk.WalkOptions{
+			Workers:       *workers,
+			RecordChannel: record_ch,
+			ErrorChannel:  error_ch,
+			DoneChannel:   walk_done_ch,
+			ValidateJSON:  *validate_json,
+			FormatJSON:    false,
+			// Inputs are decompressed by openInput instead so that errors reading them are not ignored
+			IsBzip: false,
 		}
-	}()
 
-	walk_opts := &walk.WalkOptions{
-		Workers:       *workers,
-		RecordChannel: record_ch,
-		ErrorChannel:  error_ch,
-		ValidateJSON:  *validate_json,
-		FormatJSON:    false,
-		IsBzip:        *is_bzip,
-	}
+		er := &errorReader{r: r}
 
-	uris := flag.Args()
+		walk.WalkReader(ctx, walk_opts, &contextReader{ctx, er})
+		<-walk_done_ch
+
+		// Every record has been handed to a worker, which finishes it before it sees done_ch
+		close(done_ch)
+		wg.Wait()
+
+		return er.err
+	}
 
 	if *stdin {
-		walk.WalkReader(ctx, walk_opts, os.Stdin)
+
+		r, err := decodeInput(os.Stdin, "")
+
+		if err != nil {
+			return fmt.Errorf("Failed to read STDIN, %w", err)
+		}
+
+		err = walkInput(r, splitList(*encrypted_fields))
+
+		if err != nil {
+			return fmt.Errorf("Failed to read STDIN, %w", err)
+		}
 
 	} else {
 
-		for _, uri := range uris {
+		for _, in := range inputs {
 
-			fh, err := os.Open(uri)
+			fh, err := openInput(in.Path)
 
 			if err != nil {
-				log.Fatal(err)
+				return err
 			}
 
-			walk.WalkReader(ctx, walk_opts, fh)
+			err = walkInput(fh, in.EncryptedFields)
+			fh.Close()
+
+			if err != nil {
+				return fmt.Errorf("Failed to read %s, %w", in.Path, err)
+			}
 		}
 	}
 
-	done_ch <- true
+	stop_watching()
+
+	// Wait for any adjustment in progress to finish before closing the bulk indexer
+	<-gate.Done()
 
 	err = bi.Close(ctx)
 
 	if err != nil {
-		log.Fatal(err)
+
+		if ctx.Err() != nil {
+			return fmt.Errorf("Restore interrupted, %w", context.Cause(ctx))
+		}
+
+		return err
 	}
 
 	stats := bi.Stats()
@@ -169,9 +658,414 @@ func main() {
 	enc_stats, err := json.Marshal(stats)
 
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	enc_stats = pretty.Pretty(enc_stats)
 	fmt.Println(string(enc_stats))
+
+	if encrypted_records > 0 {
+		log.Printf("Restored %d records which appear to contain encrypted values as they are, use -encryption-key-file or -encryption-passphrase-file, and -encrypted-fields for inputs without a manifest, to decrypt them", encrypted_records)
+	}
+
+	if typed_records > 0 && *legacy_type == "strip" {
+		log.Printf("Discarded the _type property of %d records, use -legacy-type to keep it", typed_records)
+	}
+
+	if decrypter != nil && decrypter.Failed() > 0 {
+		return fmt.Errorf("Failed to decrypt %d records, which were not restored, check -encryption-key-file or -encryption-passphrase-file", decrypter.Failed())
+	}
+
+	if colliding_records > 0 {
+		return fmt.Errorf("%d records replaced a document of a different type with the same _id, use -legacy-type-id=prefix or -legacy-type=index to keep them apart", colliding_records)
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("Restore interrupted, %w", context.Cause(ctx))
+	}
+
+	if *blue_green || *verify_index {
+
+		err = index.Refresh(ctx, es_client.API(), target)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if *blue_green {
+
+		if stats.NumFailed > 0 {
+			return fmt.Errorf("Failed to index %d documents, leaving %s unaliased", stats.NumFailed, target)
+		}
+
+		count, err := index.Count(ctx, es_client.API(), target)
+
+		if err != nil {
+			return err
+		}
+
+		// The new index started out empty so every distinct document was created, and records whose _id had
+		// already been seen replaced a document instead of adding one
+		if replaced_documents > 0 {
+			log.Printf("%d records replaced a document with the same _id as an earlier record", replaced_documents)
+		}
+
+		if int64(count) != created_documents {
+			return fmt.Errorf("%s contains %d documents but %d were created, leaving it unaliased", target, count, created_documents)
+		}
+	}
+
+	if *verify_index {
+
+		report, err := verify.Verify(ctx, es_client.API(), target, documents.Count(), samples)
+
+		if err != nil {
+			return err
+		}
+
+		enc_report, err := json.Marshal(report)
+
+		if err != nil {
+			return err
+		}
+
+		enc_report = pretty.Pretty(enc_report)
+		fmt.Println(string(enc_report))
+
+		if !report.OK {
+			return fmt.Errorf("Verification of %s failed", target)
+		}
+	}
+
+	if *force_merge > 0 {
+
+		log.Printf("Force-merging %s to %d segment(s)", target, *force_merge)
+
+		err = index.ForceMerge(ctx, es_client.API(), target, *force_merge)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if *blue_green {
+
+		// Put settings back before the new index starts serving searches
+		reset_settings()
+
+		err = index.SwapAlias(ctx, es_client.API(), *es_index, target, old_indices)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Pointed alias %s at %s (previously %v)", *es_index, target, old_indices)
+
+		if len(old_indices) > 0 {
+
+			switch *retire_old {
+			case "close":
+				err = index.Close(ctx, es_client.API(), old_indices...)
+			case "delete":
+				err = index.Delete(ctx, es_client.API(), old_indices...)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// The secret to decrypt data with, if any.
+var secret *crypt.Secret
+
+// input is a dump file to restore.
+type input struct {
+	Path string
+	// EncryptedFields are the paths of the _source values encrypted by dump -encrypt-fields.
+	EncryptedFields []string
+}
+
+// resolveInputs returns the dump files to restore for uris, which may be files or dump directories written by
+// dump -output-dir. Each directory is replaced by the parts listed in its manifest. The size and digest of every
+// part, and of every file listed in a manifest in the same directory, are checked against the manifest. If
+// -public-key is set every manifest must be signed with the matching private key and every file must be listed
+// in a manifest, and none of these checks can be ignored with -ignore-manifest. The encrypted fields of each
+// file are those recorded in its manifest or, if it has none, -encrypted-fields.
+func resolveInputs(uris []string) ([]*input, error) {
+
+	var key ed25519.PublicKey
+
+	if *public_key != "" {
+
+		if *stdin {
+			return nil, fmt.Errorf("-public-key can not be combined with -stdin, which has no manifest")
+		}
+
+		k, err := manifest.ReadPublicKey(*public_key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		key = k
+	}
+
+	// refuse returns an error for a problem with path, or logs it if -ignore-manifest is set and -public-key is not
+	refuse := func(path string, err error) error {
+
+		if key != nil || !*ignore_manifest {
+			return fmt.Errorf("Refusing to restore %s, %w", path, err)
+		}
+
+		log.Printf("Restoring %s anyway, %v", path, err)
+		return nil
+	}
+
+	// readManifest reads the manifest in dir, checking its signature if -public-key is set
+	readManifest := func(dir string) (*manifest.Manifest, error) {
+
+		if key == nil {
+			return manifest.Read(dir)
+		}
+
+		m, err := manifest.ReadSigned(dir, key)
+
+		if err != nil {
+			return nil, refuse(dir, err)
+		}
+
+		return m, nil
+	}
+
+	inputs := make([]*input, 0, len(uris))
+	default_fields := splitList(*encrypted_fields)
+
+	for _, uri := range uris {
+
+		info, err := os.Stat(uri)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open %s, %w", uri, err)
+		}
+
+		var dir string
+		var parts []*manifest.Part
+		var dir_fields []string
+
+		if info.IsDir() {
+
+			dir = uri
+			m, err := readManifest(dir)
+
+			if err != nil {
+				return nil, err
+			}
+
+			parts = m.Parts
+			dir_fields = m.EncryptedFields
+
+		} else {
+
+			dir = filepath.Dir(uri)
+
+			in := &input{
+				Path:            uri,
+				EncryptedFields: default_fields,
+			}
+
+			inputs = append(inputs, in)
+
+			_, err := os.Stat(filepath.Join(dir, manifest.FILENAME))
+
+			if os.IsNotExist(err) {
+
+				if key == nil {
+					continue
+				}
+
+				err := refuse(uri, fmt.Errorf("there is no manifest in %s", dir))
+
+				if err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			m, err := readManifest(dir)
+
+			if err != nil {
+				return nil, err
+			}
+
+			p := m.Lookup(filepath.ToSlash(filepath.Base(uri)))
+
+			if p == nil {
+
+				if key == nil {
+					log.Printf("%s is not listed in the manifest in %s, restoring it unchecked", uri, dir)
+					continue
+				}
+
+				err := refuse(uri, fmt.Errorf("it is not listed in the manifest in %s", dir))
+
+				if err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			in.EncryptedFields = m.EncryptedFields
+			parts = []*manifest.Part{p}
+		}
+
+		for _, p := range parts {
+
+			path, err := p.Resolve(dir)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if info.IsDir() {
+				inputs = append(inputs, &input{Path: path, EncryptedFields: dir_fields})
+			}
+
+			err = p.Verify(dir)
+
+			if err == nil {
+				continue
+			}
+
+			err = refuse(path, fmt.Errorf("it does not match its manifest, %w", err))
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return inputs, nil
+}
+
+// openInput opens the dump file at path for reading, decrypting and decompressing it as necessary.
+func openInput(path string) (io.ReadCloser, error) {
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s, %w", path, err)
+	}
+
+	r, err := decodeInput(fh, path)
+
+	if err != nil {
+		fh.Close()
+		return nil, fmt.Errorf("Failed to open %s, %w", path, err)
+	}
+
+	rc := &readCloser{
+		Reader: r,
+		Closer: fh,
+	}
+
+	return rc, nil
+}
+
+// decodeInput returns a reader for the records in r, which was read from the file name (or STDIN if name is
+// empty). Encrypted data, recognized by its first bytes, is decrypted. The data is then decompressed if name
+// ends in ".bz2" or ".gz" (ignoring any crypt.EXTENSION) or if -is-bzip is set.
+func decodeInput(r io.Reader, name string) (io.Reader, error) {
+
+	br := bufio.NewReader(r)
+	r = br
+
+	if crypt.IsEncrypted(br) {
+
+		if secret == nil {
+			return nil, fmt.Errorf("The data is encrypted, use -encryption-key-file or -encryption-passphrase-file")
+		}
+
+		cr, err := crypt.NewReader(br, secret)
+
+		if err != nil {
+			return nil, err
+		}
+
+		r = cr
+	}
+
+	name = strings.TrimSuffix(name, crypt.EXTENSION)
+
+	if *is_bzip && !strings.HasSuffix(name, ".bz2") && !strings.HasSuffix(name, ".gz") {
+		return bzip2.NewReader(r), nil
+	}
+
+	return record.NewReader(r, name)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// errorReader records the first error other than io.EOF returned by r and returns io.EOF in its place, since
+// walk.WalkReader silently stops at some errors (notably the io.ErrUnexpectedEOF of a truncated bzip2 file)
+// and endlessly retries others.
+type errorReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errorReader) Read(p []byte) (int, error) {
+
+	if r.err != nil {
+		return 0, io.EOF
+	}
+
+	n, err := r.r.Read(p)
+
+	if err != nil && err != io.EOF {
+		r.err = err
+		return n, io.EOF
+	}
+
+	return n, err
+}
+
+// contextReader returns io.EOF once its context has been cancelled so that walk.WalkReader stops early.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+
+	if r.ctx.Err() != nil {
+		return 0, io.EOF
+	}
+
+	return r.r.Read(p)
+}
+
+func splitList(str string) []string {
+
+	items := make([]string, 0)
+
+	for _, item := range strings.Split(str, ",") {
+
+		item = strings.TrimSpace(item)
+
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
 }
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"github.com/tidwall/pretty"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/client"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/index"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/mapping"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/record"
+)
+
+// The maximum number of line numbers to list for each problem with -summary.
+const max_summary_lines int = 10
+
+// Warn about the field limit once the mapping would have this proportion of it.
+const field_limit_warning float64 = 0.9
+
+// CLI flags
+var (
+	es_opts  = client.AppendFlags(flag.CommandLine, "")
+	es_index = flag.String("elasticsearch-index", "", "The name of the Elasticsearch index whose mapping to validate against.")
+
+	mappings    = flag.String("mappings", "", "The path to a JSON file with the mappings, and optionally settings, to validate against, instead of -elasticsearch-index.")
+	field_limit = flag.Int("field-limit", 0, "The maximum number of fields in the mapping. If zero, the index.mapping.total_fields.limit setting of the index or mappings file is used, or 1000 if it is not set.")
+	summary     = flag.Bool("summary", false, "Only output the number of problems of each kind for each field, with the first line numbers they occur on.")
+	stdin       = flag.Bool("stdin", false, "Read data from STDIN")
+)
+
+// Problem counts the findings of the same kind for the same field.
+type Problem struct {
+	Kind  string `json:"kind"`
+	Path  string `json:"path,omitempty"`
+	Count int    `json:"count"`
+	// Lines are the first line numbers the problem occurs on.
+	Lines []int `json:"lines"`
+	// Example is the message of the first finding.
+	Example string `json:"example"`
+}
+
+func main() {
+
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ok, err := validate(ctx)
+
+	if err != nil {
+		log.Print(err)
+		os.Exit(2)
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func validate(ctx context.Context) (bool, error) {
+
+	definition, err := loadDefinition(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	m, ok := definition["mappings"].(map[string]interface{})
+
+	if !ok {
+		m = make(map[string]interface{})
+	}
+
+	limit := *field_limit
+
+	if limit == 0 {
+		limit = fieldLimit(definition)
+	}
+
+	validator, err := mapping.NewValidator(m, limit)
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to parse mappings, %w", err)
+	}
+
+	wr := bufio.NewWriter(os.Stdout)
+	enc := json.NewEncoder(wr)
+	enc.SetEscapeHTML(false)
+
+	problems := make(map[string]*Problem)
+	records := 0
+	count := 0
+
+	read := func(r io.Reader) error {
+
+		return record.ScanLines(r, func(line_number int, line []byte) error {
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			records += 1
+
+			for _, f := range validator.ValidateLine(line_number, line) {
+
+				count += 1
+
+				if !*summary {
+
+					err := enc.Encode(f)
+
+					if err != nil {
+						return err
+					}
+
+					continue
+				}
+
+				key := f.Kind + "\x00" + f.Path
+				p, ok := problems[key]
+
+				if !ok {
+					p = &Problem{
+						Kind:    f.Kind,
+						Path:    f.Path,
+						Lines:   make([]int, 0),
+						Example: f.Message,
+					}
+
+					problems[key] = p
+				}
+
+				p.Count += 1
+
+				if len(p.Lines) < max_summary_lines {
+					p.Lines = append(p.Lines, f.Line)
+				}
+			}
+
+			return nil
+		})
+	}
+
+	if *stdin {
+
+		err := read(os.Stdin)
+
+		if err != nil {
+			return false, err
+		}
+
+	} else {
+
+		if flag.NArg() == 0 {
+			return false, fmt.Errorf("Missing dump files")
+		}
+
+		for _, path := range flag.Args() {
+
+			fh, err := record.Open(path)
+
+			if err != nil {
+				return false, err
+			}
+
+			err = read(fh)
+			fh.Close()
+
+			if err != nil {
+				return false, fmt.Errorf("Failed to read %s, %w", path, err)
+			}
+		}
+	}
+
+	if *summary {
+
+		list := make([]*Problem, 0, len(problems))
+
+		for _, p := range problems {
+			list = append(list, p)
+		}
+
+		sort.Slice(list, func(i int, j int) bool {
+
+			if list[i].Lines[0] != list[j].Lines[0] {
+				return list[i].Lines[0] < list[j].Lines[0]
+			}
+
+			if list[i].Kind != list[j].Kind {
+				return list[i].Kind < list[j].Kind
+			}
+
+			return list[i].Path < list[j].Path
+		})
+
+		enc_list, err := json.Marshal(list)
+
+		if err != nil {
+			return false, err
+		}
+
+		_, err = wr.Write(pretty.Pretty(enc_list))
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = wr.Flush()
+
+	if err != nil {
+		return false, err
+	}
+
+	log.Printf("Found %d problems in %d records", count, records)
+
+	fields := validator.Fields()
+
+	if fields <= limit && float64(fields) >= float64(limit)*field_limit_warning {
+		log.Printf("The mapping would have %d fields, close to the limit of %d", fields, limit)
+	}
+
+	return count == 0, nil
+}
+
+// loadDefinition returns the index definition from -mappings or -elasticsearch-index.
+func loadDefinition(ctx context.Context) (map[string]interface{}, error) {
+
+	switch {
+	case *mappings != "" && *es_index != "":
+		return nil, fmt.Errorf("-mappings and -elasticsearch-index can not both be set")
+	case *mappings != "":
+		return index.ReadDefinition(*mappings)
+	case *es_index != "":
+
+		es_client, err := es_opts.NewClient(ctx)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create ES client, %w", err)
+		}
+
+		log.Printf("Validating against %s (%s)", *es_index, es_client.Info())
+
+		return index.Definition(ctx, es_client.API(), *es_index)
+
+	default:
+		return nil, fmt.Errorf("Missing -mappings or -elasticsearch-index")
+	}
+}
+
+// fieldLimit returns the index.mapping.total_fields.limit setting of definition, whose settings may be
+// nested or flat, or the default limit if it is not set.
+func fieldLimit(definition map[string]interface{}) int {
+
+	settings := make(map[string]interface{})
+	flattenSettings("", definition["settings"], settings)
+
+	// Settings are accepted with or without the "index." prefix
+	for _, k := range []string{"index.mapping.total_fields.limit", "mapping.total_fields.limit"} {
+
+		v, ok := settings[k]
+
+		if !ok {
+			continue
+		}
+
+		limit, err := strconv.Atoi(fmt.Sprintf("%v", v))
+
+		if err == nil && limit > 0 {
+			return limit
+		}
+	}
+
+	return mapping.DEFAULT_FIELD_LIMIT
+}
+
+// flattenSettings adds the settings in v, which may be nested, to flat keyed by their dotted names.
+func flattenSettings(prefix string, v interface{}, flat map[string]interface{}) {
+
+	nested, ok := v.(map[string]interface{})
+
+	if !ok {
+
+		if prefix != "" {
+			flat[prefix] = v
+		}
+
+		return
+	}
+
+	for k, child := range nested {
+
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+
+		flattenSettings(k, child, flat)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/crypt"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/manifest"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/record"
+)
+
+// CLI flags
+var (
+	public_key = flag.String("public-key", "", "The path to a PEM-encoded ed25519 public key. If set, each manifest must have a manifest.json.sig signature made with the matching private key.")
+	records    = flag.Bool("records", false, "Also decompress each part and check that it has the number of records listed in the manifest. This detects parts which were written incorrectly in the first place, not just ones changed since.")
+)
+
+// Failure describes a part which does not match its manifest.
+type Failure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+func main() {
+
+	flag.Parse()
+
+	ok, err := verify()
+
+	if err != nil {
+		log.Print(err)
+		os.Exit(2)
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func verify() (bool, error) {
+
+	if flag.NArg() == 0 {
+		return false, fmt.Errorf("Missing dump directories")
+	}
+
+	wr := bufio.NewWriter(os.Stdout)
+	enc := json.NewEncoder(wr)
+	enc.SetEscapeHTML(false)
+
+	var key ed25519.PublicKey
+
+	if *public_key != "" {
+
+		k, err := manifest.ReadPublicKey(*public_key)
+
+		if err != nil {
+			return false, err
+		}
+
+		key = k
+	}
+
+	failures := 0
+
+	for _, dir := range flag.Args() {
+
+		info, err := os.Stat(dir)
+
+		if err != nil {
+			return false, err
+		}
+
+		manifest_path := dir
+
+		if info.IsDir() {
+			manifest_path = filepath.Join(dir, manifest.FILENAME)
+		} else {
+			dir = filepath.Dir(dir)
+		}
+
+		dir_failures := 0
+		count := 0
+
+		if key != nil {
+
+			_, err := manifest.ReadSigned(manifest_path, key)
+
+			if err != nil {
+
+				dir_failures += 1
+
+				f := &Failure{
+					Path:  manifest_path,
+					Error: err.Error(),
+				}
+
+				err = enc.Encode(f)
+
+				if err != nil {
+					return false, err
+				}
+			}
+		}
+
+		// The parts are checked even if the signature is not valid, to show what else has changed
+		m, err := manifest.Read(manifest_path)
+
+		if err != nil {
+			return false, err
+		}
+
+		for _, p := range m.Parts {
+
+			count += p.Records
+			err := checkPart(dir, p)
+
+			if err == nil {
+				continue
+			}
+
+			dir_failures += 1
+
+			f := &Failure{
+				Path:  filepath.Join(dir, filepath.FromSlash(p.Path)),
+				Error: err.Error(),
+			}
+
+			err = enc.Encode(f)
+
+			if err != nil {
+				return false, err
+			}
+		}
+
+		if count != m.Records {
+			log.Printf("The parts in %s list %d records but the manifest lists %d", dir, count, m.Records)
+			dir_failures += 1
+		}
+
+		if dir_failures > 0 {
+			log.Printf("%s does not match its manifest, %d problems", dir, dir_failures)
+		} else {
+			log.Printf("%s matches its manifest, %d records in %d parts", dir, m.Records, len(m.Parts))
+		}
+
+		failures += dir_failures
+	}
+
+	err := wr.Flush()
+
+	if err != nil {
+		return false, err
+	}
+
+	return failures == 0, nil
+}
+
+// checkPart checks the file for p in dir against the manifest.
+func checkPart(dir string, p *manifest.Part) error {
+
+	err := p.Verify(dir)
+
+	if err != nil || !*records {
+		return err
+	}
+
+	if strings.HasSuffix(p.Path, crypt.EXTENSION) {
+		log.Printf("Not counting the records in %s, which is encrypted", p.Path)
+		return nil
+	}
+
+	path, err := p.Resolve(dir)
+
+	if err != nil {
+		return err
+	}
+
+	fh, err := record.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer fh.Close()
+
+	count := 0
+
+	err = record.ScanLines(fh, func(line_number int, line []byte) error {
+		count += 1
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("Failed to read %s, %w", p.Path, err)
+	}
+
+	if count != p.Records {
+		return fmt.Errorf("%s has %d records, expected %d", p.Path, count, p.Records)
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+// package crypt provides methods for encrypting and decrypting dump files, and individual values in them,
+// with AES-256-GCM using a key file or a passphrase.
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// The size in bytes of keys and of the key in a key file.
+const KEY_SIZE int = 32
+
+// The number of PBKDF2 iterations used to derive a key from a passphrase.
+const PASSPHRASE_ITERATIONS int = 600000
+
+// The size in bytes of the random salt each key is derived with.
+const salt_size int = 16
+
+// How each key is derived from a Secret, as recorded in the parameters written with encrypted data.
+const (
+	kdf_key        byte = 1
+	kdf_passphrase byte = 2
+)
+
+// Secret is a key or passphrase to encrypt or decrypt data with. Data is never encrypted with the secret
+// itself but with keys derived from it and a random salt, which is written with the data.
+type Secret struct {
+	key        []byte
+	passphrase []byte
+	mu         *sync.Mutex
+	// params are the encoded parameters of the key used to encrypt data, chosen on first use.
+	params []byte
+	// keys are the keys derived from the secret, by their encoded parameters.
+	keys map[string][]byte
+	// ciphers are the ciphers for encrypted values, by the encoded parameters of their keys.
+	ciphers map[string]cipher.AEAD
+}
+
+// ReadKeyFile reads a Secret from the key file at path, which must contain a base64-encoded 256-bit key
+// as written by `openssl rand -base64 32`.
+func ReadKeyFile(path string) (*Secret, error) {
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read key file, %w", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(body)))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode key file %s, %w", path, err)
+	}
+
+	if len(key) != KEY_SIZE {
+		return nil, fmt.Errorf("Invalid key file %s, the key must be %d bytes but is %d", path, KEY_SIZE, len(key))
+	}
+
+	return newSecret(key, nil), nil
+}
+
+// ReadPassphraseFile reads a Secret from the passphrase file at path. Surrounding whitespace, including a
+// trailing newline, is not part of the passphrase.
+func ReadPassphraseFile(path string) (*Secret, error) {
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read passphrase file, %w", err)
+	}
+
+	passphrase := bytes.TrimSpace(body)
+
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("Invalid passphrase file %s, the passphrase is empty", path)
+	}
+
+	return newSecret(nil, passphrase), nil
+}
+
+func newSecret(key []byte, passphrase []byte) *Secret {
+
+	s := &Secret{
+		key:        key,
+		passphrase: passphrase,
+		mu:         new(sync.Mutex),
+		keys:       make(map[string][]byte),
+		ciphers:    make(map[string]cipher.AEAD),
+	}
+
+	return s
+}
+
+// encryptionKey returns the parameters and key to encrypt data with, which are the same for the life of s.
+func (s *Secret) encryptionKey() ([]byte, []byte, error) {
+
+	s.mu.Lock()
+	params := s.params
+	s.mu.Unlock()
+
+	if params == nil {
+
+		salt := make([]byte, salt_size)
+
+		_, err := rand.Read(salt)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to generate salt, %w", err)
+		}
+
+		if s.passphrase != nil {
+			params = append([]byte{kdf_passphrase}, binary.BigEndian.AppendUint32(nil, uint32(PASSPHRASE_ITERATIONS))...)
+		} else {
+			params = []byte{kdf_key}
+		}
+
+		params = append(params, salt...)
+
+		s.mu.Lock()
+
+		if s.params == nil {
+			s.params = params
+		}
+
+		params = s.params
+		s.mu.Unlock()
+	}
+
+	key, err := s.derive(params)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return params, key, nil
+}
+
+// readParams splits the key parameters at the start of b from the rest of it.
+func readParams(b []byte) ([]byte, []byte, error) {
+
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("Missing key parameters")
+	}
+
+	size := 1 + salt_size
+
+	switch b[0] {
+	case kdf_key:
+		// pass
+	case kdf_passphrase:
+		size += 4
+	default:
+		return nil, nil, fmt.Errorf("Unsupported key derivation %d", b[0])
+	}
+
+	if len(b) < size {
+		return nil, nil, fmt.Errorf("Truncated key parameters")
+	}
+
+	return b[:size], b[size:], nil
+}
+
+// derive returns the key for the encoded parameters params.
+func (s *Secret) derive(params []byte) ([]byte, error) {
+
+	s.mu.Lock()
+	key, ok := s.keys[string(params)]
+	s.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	switch params[0] {
+	case kdf_key:
+
+		if s.key == nil {
+			return nil, fmt.Errorf("The data was encrypted with a key file, not a passphrase")
+		}
+
+		key = hmacSHA256(s.key, params[1:])
+
+	case kdf_passphrase:
+
+		if s.passphrase == nil {
+			return nil, fmt.Errorf("The data was encrypted with a passphrase, not a key file")
+		}
+
+		iterations := int(binary.BigEndian.Uint32(params[1:5]))
+
+		// Guard against parameters which would take forever to derive
+		if iterations < 1 || iterations > 100*PASSPHRASE_ITERATIONS {
+			return nil, fmt.Errorf("Invalid number of key derivation iterations, %d", iterations)
+		}
+
+		key = pbkdf2(s.passphrase, params[5:], iterations, KEY_SIZE)
+	}
+
+	s.mu.Lock()
+	s.keys[string(params)] = key
+	s.mu.Unlock()
+
+	return key, nil
+}
+
+// subkey returns the AES-256-GCM cipher for the key derived from key for the purpose label.
+func subkey(key []byte, label []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(hmacSHA256(key, label))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create cipher, %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create cipher, %w", err)
+	}
+
+	return aead, nil
+}
+
+func hmacSHA256(key []byte, msg []byte) []byte {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+
+	return mac.Sum(nil)
+}
+
+// pbkdf2 derives a key of key_len bytes from password and salt using PBKDF2 (RFC 8018) with HMAC-SHA256.
+func pbkdf2(password []byte, salt []byte, iterations int, key_len int) []byte {
+
+	prf := hmac.New(sha256.New, password)
+	hash_len := prf.Size()
+	blocks := (key_len + hash_len - 1) / hash_len
+
+	dk := make([]byte, 0, blocks*hash_len)
+	u := make([]byte, hash_len)
+
+	for block := 1; block <= blocks; block++ {
+
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, uint32(block)))
+
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hash_len:]
+		copy(u, t)
+
+		for i := 2; i <= iterations; i++ {
+
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+
+			for j := range u {
+				t[j] ^= u[j]
+			}
+		}
+	}
+
+	return dk[:key_len]
+}
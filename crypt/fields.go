@@ -0,0 +1,255 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/fields"
+)
+
+// The prefix of every encrypted value.
+const VALUE_PREFIX string = "enc:v1:"
+
+// The size in bytes of the random nonce each value is encrypted with.
+const value_nonce_size int = 12
+
+// EncryptFields returns a copy of the JSON document source, the _source of the document with the ID id,
+// with the value at each of paths (gjson paths) replaced by a string containing it encrypted, and the number
+// of values that were encrypted. Values of any type, including objects and arrays, are encrypted as a whole.
+// Paths which do not exist are skipped. Paths may use # to match every element of an array but not queries,
+// modifiers or multipaths. Each value is bound to its path and id so that it can not be moved to another
+// field or document without DecryptFields failing.
+func EncryptFields(source []byte, id string, paths []string, s *Secret) ([]byte, int, error) {
+
+	params, key, err := s.encryptionKey()
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	aead, err := s.fieldCipher(params, key)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	count := 0
+
+	for _, path := range paths {
+
+		matches, err := findFields(source, path)
+
+		if err != nil {
+			return nil, 0, fmt.Errorf("Can not encrypt %s, %w", path, err)
+		}
+
+		for _, m := range matches {
+
+			enc_value, err := encryptValue(aead, params, []byte(m.Raw), valueAAD(path, id))
+
+			if err != nil {
+				return nil, 0, err
+			}
+
+			source = fields.Replace(source, m, enc_value)
+			count += 1
+		}
+	}
+
+	return source, count, nil
+}
+
+// DecryptFields returns a copy of the JSON document source, the _source of the document with the ID id, with
+// the values at each of paths encrypted by EncryptFields replaced by their original values, and the number of
+// values that were decrypted. paths must be the paths passed to EncryptFields. Values elsewhere are left as
+// they are, even if they look encrypted, and an error is returned if a value at one of paths is not
+// encrypted or was encrypted for a different path or document.
+func DecryptFields(source []byte, id string, paths []string, s *Secret) ([]byte, int, error) {
+
+	count := 0
+
+	// A value matched by more than one path was encrypted once for each, in order
+	for i := len(paths) - 1; i >= 0; i-- {
+
+		path := paths[i]
+		matches, err := findFields(source, path)
+
+		if err != nil {
+			return nil, 0, fmt.Errorf("Can not decrypt %s, %w", path, err)
+		}
+
+		for _, m := range matches {
+
+			str := []byte(m.Raw)
+
+			if len(str) < len(VALUE_PREFIX)+2 || str[0] != '"' || !bytes.HasPrefix(str[1:], []byte(VALUE_PREFIX)) {
+				return nil, 0, fmt.Errorf("Can not decrypt %s, the value is not encrypted", path)
+			}
+
+			plain, err := decryptValue(s, str[len(VALUE_PREFIX)+1:len(str)-1], valueAAD(path, id))
+
+			if err != nil {
+				return nil, 0, fmt.Errorf("Can not decrypt %s, %w", path, err)
+			}
+
+			source = fields.Replace(source, m, plain)
+			count += 1
+		}
+	}
+
+	return source, count, nil
+}
+
+// Decrypter decrypts the values of the documents read from a dump, counting the documents which could not be
+// decrypted, because they were encrypted with a different secret or their values were moved, so that they are
+// not silently left out of a restore.
+type Decrypter struct {
+	secret *Secret
+	failed int64
+}
+
+// NewDecrypter returns a new Decrypter for values encrypted with s.
+func NewDecrypter(s *Secret) *Decrypter {
+
+	d := &Decrypter{
+		secret: s,
+	}
+
+	return d
+}
+
+// Decrypt returns a copy of the JSON document source, the _source of the document with the ID id, with its
+// values at paths decrypted as DecryptFields does. If they can not be decrypted the document is counted as
+// failed.
+func (d *Decrypter) Decrypt(source []byte, id string, paths []string) ([]byte, error) {
+
+	source, _, err := DecryptFields(source, id, paths, d.secret)
+
+	if err != nil {
+		atomic.AddInt64(&d.failed, 1)
+		return nil, err
+	}
+
+	return source, nil
+}
+
+// Failed returns the number of documents which could not be decrypted.
+func (d *Decrypter) Failed() int64 {
+	return atomic.LoadInt64(&d.failed)
+}
+
+// findFields returns the values at path in source, rejecting queries since the values they match can not be
+// found again once they are encrypted.
+func findFields(source []byte, path string) ([]*fields.Match, error) {
+
+	if strings.Contains(path, "#(") {
+		return nil, fmt.Errorf("queries are not supported")
+	}
+
+	return fields.Find(source, path)
+}
+
+// valueAAD returns the additional data a value at path in the document with the ID id is encrypted with.
+func valueAAD(path string, id string) []byte {
+
+	aad := make([]byte, 0, len(path)+len(id)+1)
+	aad = append(aad, path...)
+	aad = append(aad, 0)
+	aad = append(aad, id...)
+
+	return aad
+}
+
+func encryptValue(aead cipher.AEAD, params []byte, raw []byte, aad []byte) ([]byte, error) {
+
+	nonce := make([]byte, value_nonce_size)
+
+	_, err := rand.Read(nonce)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate nonce, %w", err)
+	}
+
+	payload := append([]byte(nil), params...)
+	payload = append(payload, nonce...)
+	payload = aead.Seal(payload, nonce, raw, aad)
+
+	enc_payload := make([]byte, base64.StdEncoding.EncodedLen(len(payload)))
+	base64.StdEncoding.Encode(enc_payload, payload)
+
+	enc_value := make([]byte, 0, len(VALUE_PREFIX)+len(enc_payload)+2)
+	enc_value = append(enc_value, '"')
+	enc_value = append(enc_value, VALUE_PREFIX...)
+	enc_value = append(enc_value, enc_payload...)
+	enc_value = append(enc_value, '"')
+
+	return enc_value, nil
+}
+
+func decryptValue(s *Secret, enc_value []byte, aad []byte) ([]byte, error) {
+
+	payload, err := base64.StdEncoding.DecodeString(string(enc_value))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode encrypted value, %w", err)
+	}
+
+	params, rest, err := readParams(payload)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid encrypted value, %w", err)
+	}
+
+	if len(rest) < value_nonce_size {
+		return nil, fmt.Errorf("Invalid encrypted value, truncated")
+	}
+
+	key, err := s.derive(params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := s.fieldCipher(params, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := aead.Open(nil, rest[:value_nonce_size], rest[value_nonce_size:], aad)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt value, it has been changed, moved from another field or document, or was encrypted with a different secret")
+	}
+
+	return plain, nil
+}
+
+// fieldCipher returns the cipher for values encrypted with the key key, whose parameters are params.
+func (s *Secret) fieldCipher(params []byte, key []byte) (cipher.AEAD, error) {
+
+	s.mu.Lock()
+	aead, ok := s.ciphers[string(params)]
+	s.mu.Unlock()
+
+	if ok {
+		return aead, nil
+	}
+
+	aead, err := subkey(key, []byte("fields"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.ciphers[string(params)] = aead
+	s.mu.Unlock()
+
+	return aead, nil
+}
@@ -0,0 +1,208 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// newTestSecret returns a Secret read from a key file containing a random key.
+func newTestSecret(t *testing.T) *Secret {
+
+	key := make([]byte, KEY_SIZE)
+
+	_, err := rand.Read(key)
+
+	if err != nil {
+		t.Fatalf("Failed to generate key, %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.key")
+
+	err = os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+
+	if err != nil {
+		t.Fatalf("Failed to write key file, %v", err)
+	}
+
+	s, err := ReadKeyFile(path)
+
+	if err != nil {
+		t.Fatalf("Failed to read key file, %v", err)
+	}
+
+	return s
+}
+
+func TestEncryptDecryptFields(t *testing.T) {
+
+	s := newTestSecret(t)
+
+	source := []byte(`{"name":"Ada","email":"ada@example.com","donor":{"phone":[1,2],"id":7},"donors":[{"phone":"555"},{"phone":"556"}],"note":"enc:v1:not encrypted"}`)
+	paths := []string{"email", "donor", "donors.#.phone", "missing"}
+
+	enc_source, count, err := EncryptFields(source, "1729", paths, s)
+
+	if err != nil {
+		t.Fatalf("Failed to encrypt fields, %v", err)
+	}
+
+	if count != 4 {
+		t.Fatalf("Expected 4 encrypted values, got %d", count)
+	}
+
+	for _, path := range []string{"email", "donor", "donors.0.phone", "donors.1.phone"} {
+
+		value := gjson.GetBytes(enc_source, path)
+
+		if value.Type != gjson.String || !strings.HasPrefix(value.String(), VALUE_PREFIX) {
+			t.Fatalf("Expected %s to be encrypted, got %s", path, value.Raw)
+		}
+	}
+
+	if gjson.GetBytes(enc_source, "name").String() != "Ada" {
+		t.Fatalf("Expected name to be left as is, got %s", enc_source)
+	}
+
+	dec_source, count, err := DecryptFields(enc_source, "1729", paths, s)
+
+	if err != nil {
+		t.Fatalf("Failed to decrypt fields, %v", err)
+	}
+
+	if count != 4 {
+		t.Fatalf("Expected 4 decrypted values, got %d", count)
+	}
+
+	// A value which looks encrypted but is not at one of the paths is left alone
+	if string(dec_source) != string(source) {
+		t.Fatalf("Expected %s, got %s", source, dec_source)
+	}
+}
+
+func TestEncryptFieldsTwice(t *testing.T) {
+
+	s := newTestSecret(t)
+
+	source := []byte(`{"donors":[{"phone":"555"},{"phone":"556"}]}`)
+	paths := []string{"donors.#.phone", "donors.0.phone"}
+
+	enc_source, count, err := EncryptFields(source, "1", paths, s)
+
+	if err != nil {
+		t.Fatalf("Failed to encrypt fields, %v", err)
+	}
+
+	if count != 3 {
+		t.Fatalf("Expected 3 encrypted values, got %d", count)
+	}
+
+	dec_source, _, err := DecryptFields(enc_source, "1", paths, s)
+
+	if err != nil {
+		t.Fatalf("Failed to decrypt fields, %v", err)
+	}
+
+	if string(dec_source) != string(source) {
+		t.Fatalf("Expected %s, got %s", source, dec_source)
+	}
+}
+
+func TestDecryptFieldsBound(t *testing.T) {
+
+	s := newTestSecret(t)
+
+	enc_source, _, err := EncryptFields([]byte(`{"email":"ada@example.com","phone":"555"}`), "1", []string{"email", "phone"}, s)
+
+	if err != nil {
+		t.Fatalf("Failed to encrypt fields, %v", err)
+	}
+
+	email := gjson.GetBytes(enc_source, "email").Raw
+	phone := gjson.GetBytes(enc_source, "phone").Raw
+
+	swapped := []byte(`{"email":` + phone + `,"phone":` + email + `}`)
+
+	tests := map[string]struct {
+		Source []byte
+		ID     string
+		Paths  []string
+		Secret *Secret
+	}{
+		"different document": {enc_source, "2", []string{"email", "phone"}, s},
+		"swapped fields":     {swapped, "1", []string{"email", "phone"}, s},
+		"different path":     {[]byte(`{"other":` + email + `}`), "1", []string{"other"}, s},
+		"different secret":   {enc_source, "1", []string{"email", "phone"}, newTestSecret(t)},
+		"not encrypted":      {[]byte(`{"email":"ada@example.com"}`), "1", []string{"email"}, s},
+	}
+
+	for name, test := range tests {
+
+		_, _, err := DecryptFields(test.Source, test.ID, test.Paths, test.Secret)
+
+		if err == nil {
+			t.Fatalf("Expected an error decrypting with a %s", name)
+		}
+	}
+}
+
+func TestDecrypter(t *testing.T) {
+
+	s := newTestSecret(t)
+	paths := []string{"email"}
+
+	enc_source, _, err := EncryptFields([]byte(`{"email":"ada@example.com"}`), "1", paths, s)
+
+	if err != nil {
+		t.Fatalf("Failed to encrypt fields, %v", err)
+	}
+
+	d := NewDecrypter(s)
+
+	source, err := d.Decrypt(enc_source, "1", paths)
+
+	if err != nil {
+		t.Fatalf("Failed to decrypt record, %v", err)
+	}
+
+	if string(source) != `{"email":"ada@example.com"}` {
+		t.Fatalf("Unexpected decrypted record %s", source)
+	}
+
+	if d.Failed() != 0 {
+		t.Fatalf("Expected no failed records, got %d", d.Failed())
+	}
+
+	// A record whose values were encrypted for a different _id, or with a different secret, fails
+	_, err = d.Decrypt(enc_source, "2", paths)
+
+	if err == nil {
+		t.Fatalf("Expected an error decrypting a record with a different _id")
+	}
+
+	_, err = NewDecrypter(newTestSecret(t)).Decrypt(enc_source, "1", paths)
+
+	if err == nil {
+		t.Fatalf("Expected an error decrypting a record with a different secret")
+	}
+
+	if d.Failed() != 1 {
+		t.Fatalf("Expected 1 failed record, got %d", d.Failed())
+	}
+}
+
+func TestEncryptFieldsQuery(t *testing.T) {
+
+	s := newTestSecret(t)
+
+	_, _, err := EncryptFields([]byte(`{"donors":[{"id":1}]}`), "1", []string{"donors.#(id==1)"}, s)
+
+	if err == nil {
+		t.Fatalf("Expected an error encrypting a query")
+	}
+}
@@ -0,0 +1,310 @@
+package crypt
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The file extension of encrypted dump files.
+const EXTENSION string = ".enc"
+
+// The bytes every encrypted stream starts with.
+const MAGIC string = "JLESENC1"
+
+// The number of bytes of plaintext in each chunk of an encrypted stream, except the last.
+const CHUNK_SIZE int = 64 * 1024
+
+// The size in bytes of the random nonce each stream's key is derived with.
+const stream_nonce_size int = 16
+
+// Writer encrypts the data written to it as a stream of AES-256-GCM chunks. The stream starts with a header
+// (MAGIC, the key parameters and a random nonce) which is authenticated with every chunk. Each chunk is
+// sealed with a nonce made of its number and a flag marking the last chunk so that chunks can not be
+// reordered, dropped or truncated without decryption failing.
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	chunk  uint64
+	err    error
+}
+
+// NewWriter returns a new Writer which writes data encrypted with a key derived from s to w. Close must be
+// called to write the last chunk. Closing the Writer does not close w.
+func NewWriter(w io.Writer, s *Secret) (*Writer, error) {
+
+	params, key, err := s.encryptionKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, stream_nonce_size)
+
+	_, err = rand.Read(nonce)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate nonce, %w", err)
+	}
+
+	aead, err := streamCipher(key, nonce)
+
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(MAGIC), params...)
+	header = append(header, nonce...)
+
+	_, err = w.Write(header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sw := &Writer{
+		w:      w,
+		aead:   aead,
+		header: header,
+		buf:    make([]byte, 0, CHUNK_SIZE+aead.Overhead()),
+	}
+
+	return sw, nil
+}
+
+func (sw *Writer) Write(p []byte) (int, error) {
+
+	if sw.err != nil {
+		return 0, sw.err
+	}
+
+	n := 0
+
+	for len(p) > 0 {
+
+		// A full chunk is only sealed once more data arrives so that the last chunk is only empty if
+		// the whole stream is
+		if len(sw.buf) == CHUNK_SIZE {
+
+			err := sw.seal(false)
+
+			if err != nil {
+				return n, err
+			}
+		}
+
+		m := CHUNK_SIZE - len(sw.buf)
+
+		if m > len(p) {
+			m = len(p)
+		}
+
+		sw.buf = append(sw.buf, p[:m]...)
+		p = p[m:]
+		n += m
+	}
+
+	return n, nil
+}
+
+// Close writes the last chunk.
+func (sw *Writer) Close() error {
+
+	if sw.err != nil {
+		return sw.err
+	}
+
+	err := sw.seal(true)
+
+	if err != nil {
+		return err
+	}
+
+	sw.err = fmt.Errorf("Writer is closed")
+	return nil
+}
+
+func (sw *Writer) seal(last bool) error {
+
+	out := sw.aead.Seal(sw.buf[:0], chunkNonce(sw.chunk, last), sw.buf, sw.header)
+
+	_, err := sw.w.Write(out)
+
+	if err != nil {
+		sw.err = err
+		return err
+	}
+
+	sw.chunk += 1
+	sw.buf = sw.buf[:0]
+
+	return nil
+}
+
+// Reader decrypts a stream written by Writer.
+type Reader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+	chunk  uint64
+	buf    []byte
+	plain  []byte
+	done   bool
+}
+
+// IsEncrypted returns true if the data buffered in r starts with MAGIC. It peeks at r without consuming it.
+func IsEncrypted(r *bufio.Reader) bool {
+
+	b, _ := r.Peek(len(MAGIC))
+	return string(b) == MAGIC
+}
+
+// NewReader returns a new Reader which decrypts the stream read from r with a key derived from s. An error
+// is returned by Read if the stream was encrypted with a different secret, has been changed or is truncated.
+func NewReader(r io.Reader, s *Secret) (*Reader, error) {
+
+	br := bufio.NewReaderSize(r, CHUNK_SIZE*2)
+
+	magic := make([]byte, len(MAGIC))
+
+	_, err := io.ReadFull(br, magic)
+
+	if err != nil || string(magic) != MAGIC {
+		return nil, fmt.Errorf("Not an encrypted stream")
+	}
+
+	// The length of the key parameters depends on how the key is derived
+	prefix, err := br.Peek(1)
+
+	if err != nil {
+		return nil, fmt.Errorf("Truncated stream header")
+	}
+
+	size := 1 + salt_size + stream_nonce_size
+
+	if prefix[0] == kdf_passphrase {
+		size += 4
+	}
+
+	rest := make([]byte, size)
+
+	_, err = io.ReadFull(br, rest)
+
+	if err != nil {
+		return nil, fmt.Errorf("Truncated stream header")
+	}
+
+	params, nonce, err := readParams(rest)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := s.derive(params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := streamCipher(key, nonce)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sr := &Reader{
+		r:      br,
+		aead:   aead,
+		header: append(magic, rest...),
+		buf:    make([]byte, CHUNK_SIZE+aead.Overhead()),
+	}
+
+	return sr, nil
+}
+
+func (sr *Reader) Read(p []byte) (int, error) {
+
+	for len(sr.plain) == 0 {
+
+		if sr.done {
+			return 0, io.EOF
+		}
+
+		err := sr.open()
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, sr.plain)
+	sr.plain = sr.plain[n:]
+
+	return n, nil
+}
+
+// open reads and decrypts the next chunk.
+func (sr *Reader) open() error {
+
+	n, err := io.ReadFull(sr.r, sr.buf)
+
+	last := false
+
+	switch {
+	case err == nil:
+
+		// A full chunk is the last one if nothing follows it
+		_, peek_err := sr.r.Peek(1)
+		last = peek_err == io.EOF
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case err == io.EOF:
+		// Every stream ends with a chunk marked as the last one, even if it is empty
+		return io.ErrUnexpectedEOF
+	default:
+		return err
+	}
+
+	plain, err := sr.aead.Open(sr.buf[:0], chunkNonce(sr.chunk, last), sr.buf[:n], sr.header)
+
+	if err != nil {
+
+		if last {
+			return fmt.Errorf("Failed to decrypt chunk %d, the data is truncated, has been changed or was encrypted with a different secret", sr.chunk)
+		}
+
+		return fmt.Errorf("Failed to decrypt chunk %d, the data has been changed or was encrypted with a different secret", sr.chunk)
+	}
+
+	sr.chunk += 1
+	sr.plain = plain
+	sr.done = last
+
+	return nil
+}
+
+// streamCipher returns the cipher for the stream with the random nonce nonce.
+func streamCipher(key []byte, nonce []byte) (cipher.AEAD, error) {
+	return subkey(key, append([]byte("stream:"), nonce...))
+}
+
+// chunkNonce returns the nonce of chunk number chunk. Every stream has its own key so the nonces only need
+// to be unique within a stream.
+func chunkNonce(chunk uint64, last bool) []byte {
+
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], chunk)
+
+	if last {
+		nonce[11] = 1
+	}
+
+	return nonce
+}
@@ -0,0 +1,186 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// encryptStream returns plain encrypted with s by a Writer.
+func encryptStream(t *testing.T, plain []byte, s *Secret) []byte {
+
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf, s)
+
+	if err != nil {
+		t.Fatalf("Failed to create writer, %v", err)
+	}
+
+	_, err = w.Write(plain)
+
+	if err != nil {
+		t.Fatalf("Failed to write data, %v", err)
+	}
+
+	err = w.Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close writer, %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+// decryptStream returns the data read from enc by a Reader for s.
+func decryptStream(enc []byte, s *Secret) ([]byte, error) {
+
+	r, err := NewReader(bytes.NewReader(enc), s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+
+	s := newTestSecret(t)
+
+	sizes := []int{0, 1, CHUNK_SIZE - 1, CHUNK_SIZE, CHUNK_SIZE + 1, 3*CHUNK_SIZE + 17}
+
+	for _, size := range sizes {
+
+		plain := bytes.Repeat([]byte("0123456789abcdef\n"), size/17+1)[:size]
+		enc := encryptStream(t, plain, s)
+
+		if !IsEncrypted(bufio.NewReader(bytes.NewReader(enc))) {
+			t.Fatalf("Expected %d bytes of encrypted data to be recognized", size)
+		}
+
+		dec, err := decryptStream(enc, s)
+
+		if err != nil {
+			t.Fatalf("Failed to decrypt %d bytes, %v", size, err)
+		}
+
+		if !bytes.Equal(dec, plain) {
+			t.Fatalf("Expected %d bytes to round trip, got %d", size, len(dec))
+		}
+	}
+
+	if IsEncrypted(bufio.NewReader(bytes.NewReader([]byte(`{"_id":"1"}`)))) {
+		t.Fatalf("Expected JSON not to be recognized as encrypted")
+	}
+}
+
+func TestStreamInvalid(t *testing.T) {
+
+	s := newTestSecret(t)
+
+	plain := bytes.Repeat([]byte("x"), 2*CHUNK_SIZE+100)
+	enc := encryptStream(t, plain, s)
+
+	changed := append([]byte(nil), enc...)
+	changed[len(changed)/2] ^= 1
+
+	tests := map[string][]byte{
+		"truncated stream":       enc[:len(enc)-10],
+		"stream missing a chunk": enc[:len(enc)-100-16],
+		"changed stream":         changed,
+	}
+
+	for name, body := range tests {
+
+		_, err := decryptStream(body, s)
+
+		if err == nil {
+			t.Fatalf("Expected an error decrypting a %s", name)
+		}
+	}
+
+	_, err := decryptStream(enc, newTestSecret(t))
+
+	if err == nil {
+		t.Fatalf("Expected an error decrypting with a different secret")
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "passphrase")
+
+	err := os.WriteFile(path, []byte("correct horse battery staple\n"), 0600)
+
+	if err != nil {
+		t.Fatalf("Failed to write passphrase file, %v", err)
+	}
+
+	s, err := ReadPassphraseFile(path)
+
+	if err != nil {
+		t.Fatalf("Failed to read passphrase file, %v", err)
+	}
+
+	enc := encryptStream(t, []byte("hello"), s)
+
+	// The key is derived again from the passphrase and the salt in the stream
+	other, err := ReadPassphraseFile(path)
+
+	if err != nil {
+		t.Fatalf("Failed to read passphrase file, %v", err)
+	}
+
+	dec, err := decryptStream(enc, other)
+
+	if err != nil {
+		t.Fatalf("Failed to decrypt with passphrase, %v", err)
+	}
+
+	if string(dec) != "hello" {
+		t.Fatalf("Expected hello, got %s", dec)
+	}
+
+	err = os.WriteFile(path, []byte(" \n"), 0600)
+
+	if err != nil {
+		t.Fatalf("Failed to write passphrase file, %v", err)
+	}
+
+	_, err = ReadPassphraseFile(path)
+
+	if err == nil {
+		t.Fatalf("Expected an error reading an empty passphrase")
+	}
+}
+
+func TestReadKeyFileInvalid(t *testing.T) {
+
+	dir := t.TempDir()
+
+	tests := map[string]string{
+		"short key":   "c2hvcnQ=\n",
+		"invalid key": "not base64!\n",
+	}
+
+	for name, body := range tests {
+
+		path := filepath.Join(dir, "test.key")
+
+		err := os.WriteFile(path, []byte(body), 0600)
+
+		if err != nil {
+			t.Fatalf("Failed to write key file, %v", err)
+		}
+
+		_, err = ReadKeyFile(path)
+
+		if err == nil {
+			t.Fatalf("Expected an error reading a %s", name)
+		}
+	}
+}
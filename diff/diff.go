@@ -0,0 +1,199 @@
+// package diff provides methods for comparing JSON documents.
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operation is a single JSON Patch (RFC 6902) operation.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Patch returns the JSON Patch operations which turn the JSON document a into the JSON document b. Object
+// properties are compared regardless of their order and arrays are compared element by element, so an
+// element inserted at the start of an array is reported as a change to every element after it. Numbers
+// are compared using their literal representation.
+func Patch(a []byte, b []byte) ([]*Operation, error) {
+
+	var v_a interface{}
+	var v_b interface{}
+
+	err := decode(a, &v_a)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = decode(b, &v_b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]*Operation, 0)
+
+	err = compare("", v_a, v_b, &ops)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ops, nil
+}
+
+func decode(body []byte, v *interface{}) error {
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	err := dec.Decode(v)
+
+	if err != nil {
+		return fmt.Errorf("Failed to decode document, %w", err)
+	}
+
+	return nil
+}
+
+func compare(path string, a interface{}, b interface{}, ops *[]*Operation) error {
+
+	switch a_t := a.(type) {
+	case map[string]interface{}:
+
+		b_t, ok := b.(map[string]interface{})
+
+		if !ok {
+			return appendOp(ops, "replace", path, b)
+		}
+
+		keys := make([]string, 0, len(a_t)+len(b_t))
+
+		for k := range a_t {
+			keys = append(keys, k)
+		}
+
+		for k := range b_t {
+
+			_, exists := a_t[k]
+
+			if !exists {
+				keys = append(keys, k)
+			}
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+
+			k_path := path + "/" + escapePointer(k)
+
+			v_a, in_a := a_t[k]
+			v_b, in_b := b_t[k]
+
+			var err error
+
+			switch {
+			case !in_b:
+				err = appendOp(ops, "remove", k_path, nil)
+			case !in_a:
+				err = appendOp(ops, "add", k_path, v_b)
+			default:
+				err = compare(k_path, v_a, v_b, ops)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	case []interface{}:
+
+		b_t, ok := b.([]interface{})
+
+		if !ok {
+			return appendOp(ops, "replace", path, b)
+		}
+
+		common := len(a_t)
+
+		if len(b_t) < common {
+			common = len(b_t)
+		}
+
+		for i := 0; i < common; i++ {
+
+			err := compare(path+"/"+strconv.Itoa(i), a_t[i], b_t[i], ops)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		for i := common; i < len(b_t); i++ {
+
+			err := appendOp(ops, "add", path+"/"+strconv.Itoa(i), b_t[i])
+
+			if err != nil {
+				return err
+			}
+		}
+
+		// Remove trailing elements from the end so that each path is valid when it is applied
+		for i := len(a_t) - 1; i >= common; i-- {
+
+			err := appendOp(ops, "remove", path+"/"+strconv.Itoa(i), nil)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	default:
+
+		if reflect.DeepEqual(a, b) {
+			return nil
+		}
+
+		return appendOp(ops, "replace", path, b)
+	}
+}
+
+func appendOp(ops *[]*Operation, op string, path string, value interface{}) error {
+
+	o := &Operation{
+		Op:   op,
+		Path: path,
+	}
+
+	if op != "remove" {
+
+		enc_value, err := json.Marshal(value)
+
+		if err != nil {
+			return fmt.Errorf("Failed to encode value for %s, %w", path, err)
+		}
+
+		o.Value = enc_value
+	}
+
+	*ops = append(*ops, o)
+	return nil
+}
+
+// escapePointer escapes a property name for use in a JSON Pointer (RFC 6901).
+func escapePointer(k string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(k, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,60 @@
+package diff
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPatch(t *testing.T) {
+
+	tests := []struct {
+		a        string
+		b        string
+		expected string
+	}{
+		// Property order and whitespace do not matter
+		{`{"a":1,"b":[1,2]}`, `{ "b": [1, 2], "a": 1 }`, `[]`},
+		{`{"a":1,"b":2}`, `{"a":1,"c":3}`, `[{"op":"remove","path":"/b"},{"op":"add","path":"/c","value":3}]`},
+		{`{"a":{"b":"x"}}`, `{"a":{"b":"y"}}`, `[{"op":"replace","path":"/a/b","value":"y"}]`},
+		// Values of different types are replaced as a whole
+		{`{"a":{"b":1}}`, `{"a":[1]}`, `[{"op":"replace","path":"/a","value":[1]}]`},
+		{`{"a":[1]}`, `{"a":null}`, `[{"op":"replace","path":"/a","value":null}]`},
+		// Numbers are compared using their literal representation
+		{`{"a":1}`, `{"a":1.0}`, `[{"op":"replace","path":"/a","value":1.0}]`},
+		{`{"a":12345678901234567890}`, `{"a":12345678901234567891}`, `[{"op":"replace","path":"/a","value":12345678901234567891}]`},
+		// Arrays are compared element by element and trailing elements are removed from the end
+		{`{"a":[1,2]}`, `{"a":[1,3,4]}`, `[{"op":"replace","path":"/a/1","value":3},{"op":"add","path":"/a/2","value":4}]`},
+		{`{"a":[1,2,3]}`, `{"a":[1]}`, `[{"op":"remove","path":"/a/2"},{"op":"remove","path":"/a/1"}]`},
+		{`{"a":[{"b":1}]}`, `{"a":[{"b":2}]}`, `[{"op":"replace","path":"/a/0/b","value":2}]`},
+		// Property names are escaped as JSON Pointers
+		{`{"a/b":1,"c~d":1}`, `{"a/b":2,"c~d":2}`, `[{"op":"replace","path":"/a~1b","value":2},{"op":"replace","path":"/c~0d","value":2}]`},
+	}
+
+	for _, test := range tests {
+
+		ops, err := Patch([]byte(test.a), []byte(test.b))
+
+		if err != nil {
+			t.Fatalf("Failed to compare %s and %s, %v", test.a, test.b, err)
+		}
+
+		enc_ops, err := json.Marshal(ops)
+
+		if err != nil {
+			t.Fatalf("Failed to encode patch, %v", err)
+		}
+
+		if string(enc_ops) != test.expected {
+			t.Fatalf("Unexpected patch for %s and %s, expected %s, got %s", test.a, test.b, test.expected, enc_ops)
+		}
+	}
+}
+
+func TestPatchInvalid(t *testing.T) {
+
+	_, err := Patch([]byte(`{"a":1}`), []byte(`{"a":`))
+
+	if err == nil {
+		t.Fatalf("Expected an error comparing invalid JSON")
+	}
+}
@@ -0,0 +1,82 @@
+// package fields provides methods for walking, finding and changing the properties of JSON documents.
+package fields
+
+import (
+	"github.com/tidwall/gjson"
+)
+
+const OBJECT string = "object"
+
+const ARRAY string = "array"
+
+const STRING string = "string"
+
+const NUMBER string = "number"
+
+const BOOLEAN string = "boolean"
+
+const NULL string = "null"
+
+// ARRAY_SUFFIX is appended to the path of an array to form the path of its elements.
+const ARRAY_SUFFIX string = "[]"
+
+// WalkFunc is called for each value in a document with its dotted path, for example "nested.y" or, for the
+// elements of an array, "nested.y[]".
+type WalkFunc func(path string, value gjson.Result)
+
+// Walk calls fn for every value (including objects and arrays) nested in the JSON object body. The document
+// itself is not passed to fn.
+func Walk(body []byte, fn WalkFunc) {
+	walk("", gjson.ParseBytes(body), fn)
+}
+
+func walk(prefix string, value gjson.Result, fn WalkFunc) {
+
+	switch {
+	case value.IsObject():
+
+		value.ForEach(func(k gjson.Result, v gjson.Result) bool {
+
+			path := k.String()
+
+			if prefix != "" {
+				path = prefix + "." + path
+			}
+
+			fn(path, v)
+			walk(path, v, fn)
+			return true
+		})
+
+	case value.IsArray():
+
+		path := prefix + ARRAY_SUFFIX
+
+		value.ForEach(func(_ gjson.Result, v gjson.Result) bool {
+			fn(path, v)
+			walk(path, v, fn)
+			return true
+		})
+	}
+}
+
+// Type returns the JSON type of value: OBJECT, ARRAY, STRING, NUMBER, BOOLEAN or NULL.
+func Type(value gjson.Result) string {
+
+	switch value.Type {
+	case gjson.String:
+		return STRING
+	case gjson.Number:
+		return NUMBER
+	case gjson.True, gjson.False:
+		return BOOLEAN
+	case gjson.Null:
+		return NULL
+	}
+
+	if value.IsArray() {
+		return ARRAY
+	}
+
+	return OBJECT
+}
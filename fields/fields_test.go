@@ -0,0 +1,70 @@
+package fields
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestWalk(t *testing.T) {
+
+	body := []byte(`{"a":1,"b":{"c":"x","d":[1,[true],{"e":null}]}}`)
+
+	paths := make([]string, 0)
+
+	Walk(body, func(path string, value gjson.Result) {
+		paths = append(paths, path+"="+Type(value))
+	})
+
+	expected := []string{
+		"a=number",
+		"b=object",
+		"b.c=string",
+		"b.d=array",
+		"b.d[]=number",
+		"b.d[]=array",
+		"b.d[][]=boolean",
+		"b.d[]=object",
+		"b.d[].e=null",
+	}
+
+	if strings.Join(paths, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected paths %v", paths)
+	}
+}
+
+func TestWalkEmpty(t *testing.T) {
+
+	count := 0
+
+	Walk([]byte(`{}`), func(path string, value gjson.Result) {
+		count += 1
+	})
+
+	if count != 0 {
+		t.Fatalf("Expected an empty document not to have any values, got %d", count)
+	}
+}
+
+func TestType(t *testing.T) {
+
+	tests := map[string]string{
+		`"x"`:   STRING,
+		`1.5`:   NUMBER,
+		`true`:  BOOLEAN,
+		`false`: BOOLEAN,
+		`null`:  NULL,
+		`[]`:    ARRAY,
+		`{}`:    OBJECT,
+	}
+
+	for raw, expected := range tests {
+
+		v := Type(gjson.Parse(raw))
+
+		if v != expected {
+			t.Fatalf("Expected %s to be %s, got %s", raw, expected, v)
+		}
+	}
+}
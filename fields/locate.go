@@ -0,0 +1,170 @@
+package fields
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Match is a value found in a JSON document by Find.
+type Match struct {
+	// Start is the position of the value in the document.
+	Start int
+	// Raw is the raw JSON of the value.
+	Raw string
+}
+
+// End returns the position just after the value in the document.
+func (m *Match) End() int {
+	return m.Start + len(m.Raw)
+}
+
+// Find returns the values in the JSON document body at the gjson path path, last first so that they can
+// be replaced or deleted in order without changing the positions of the others. Paths may use # to match
+// every element of an array, or every element matching a query, but not modifiers or multipaths since the
+// positions of their results are unknown.
+func Find(body []byte, path string) ([]*Match, error) {
+
+	r := gjson.GetBytes(body, path)
+	matches := make([]*Match, 0)
+
+	switch {
+	case !r.Exists():
+		// pass
+	case len(r.Indexes) > 0:
+
+		for i, v := range r.Array() {
+			matches = append(matches, &Match{Start: r.Indexes[i], Raw: v.Raw})
+		}
+
+	case strings.Contains(path, "#") && r.IsArray():
+		// A query which matched nothing
+	case r.Index > 0:
+		matches = append(matches, &Match{Start: r.Index, Raw: r.Raw})
+	default:
+		return nil, fmt.Errorf("The position of %s in the document is unknown", path)
+	}
+
+	sort.Slice(matches, func(i int, j int) bool {
+		return matches[i].Start > matches[j].Start
+	})
+
+	return matches, nil
+}
+
+// Replace returns a copy of body with the value m replaced by the raw JSON value.
+func Replace(body []byte, m *Match, value []byte) []byte {
+
+	updated := make([]byte, 0, len(body)-len(m.Raw)+len(value))
+	updated = append(updated, body[:m.Start]...)
+	updated = append(updated, value...)
+	updated = append(updated, body[m.End():]...)
+
+	return updated
+}
+
+// Delete returns a copy of body with the value m removed, along with its key if it is the property of an
+// object, or from its array if it is an element of one.
+func Delete(body []byte, m *Match) ([]byte, error) {
+
+	start := m.Start
+	end := m.End()
+
+	prev := skipSpaceBackward(body, start-1)
+
+	if prev < 0 {
+		return nil, fmt.Errorf("Can not delete the document itself")
+	}
+
+	switch body[prev] {
+	case ':':
+
+		// Remove the key as well
+		quote := skipSpaceBackward(body, prev-1)
+
+		if quote < 0 || body[quote] != '"' {
+			return nil, fmt.Errorf("Invalid JSON, expected a key before %d", prev)
+		}
+
+		key_start := openingQuote(body, quote)
+
+		if key_start < 0 {
+			return nil, fmt.Errorf("Invalid JSON, unterminated key before %d", prev)
+		}
+
+		start = key_start
+
+	case '[', ',':
+		// An array element
+	default:
+		return nil, fmt.Errorf("Invalid JSON, unexpected %q before %d", body[prev], start)
+	}
+
+	// Remove the comma separating the value from the next one or, if it is the last one, from the previous one
+	next := skipSpaceForward(body, end)
+
+	if next < len(body) && body[next] == ',' {
+		end = next + 1
+	} else {
+
+		before := skipSpaceBackward(body, start-1)
+
+		if before >= 0 && body[before] == ',' {
+			start = before
+		}
+	}
+
+	updated := make([]byte, 0, len(body)-(end-start))
+	updated = append(updated, body[:start]...)
+	updated = append(updated, body[end:]...)
+
+	return updated, nil
+}
+
+func skipSpaceBackward(body []byte, i int) int {
+
+	for i >= 0 && isSpace(body[i]) {
+		i -= 1
+	}
+
+	return i
+}
+
+func skipSpaceForward(body []byte, i int) int {
+
+	for i < len(body) && isSpace(body[i]) {
+		i += 1
+	}
+
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// openingQuote returns the position of the quote which opens the string closed by the quote at end, or -1.
+func openingQuote(body []byte, end int) int {
+
+	for i := end - 1; i >= 0; i-- {
+
+		if body[i] != '"' {
+			continue
+		}
+
+		// A quote is escaped if it follows an odd number of backslashes
+		backslashes := 0
+
+		for j := i - 1; j >= 0 && body[j] == '\\'; j-- {
+			backslashes += 1
+		}
+
+		if backslashes%2 == 0 {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,144 @@
+package fields
+
+import (
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+
+	body := []byte(`{"a":1,"b":{"c":"x"},"d":[{"e":1,"f":"y"},{"e":2},{"e":3,"f":"z"}],"g":[]}`)
+
+	tests := map[string][]string{
+		"a":            {`1`},
+		"b":            {`{"c":"x"}`},
+		"b.c":          {`"x"`},
+		"d.1":          {`{"e":2}`},
+		"d.#.f":        {`"z"`, `"y"`},
+		"d.#.e":        {`3`, `2`, `1`},
+		"d.#(e>1)#.e":  {`3`, `2`},
+		"d.#(e>5)#":    {},
+		"missing":      {},
+		"b.missing":    {},
+		"g.#.anything": {},
+	}
+
+	for path, expected := range tests {
+
+		matches, err := Find(body, path)
+
+		if err != nil {
+			t.Fatalf("Failed to find %s, %v", path, err)
+		}
+
+		if len(matches) != len(expected) {
+			t.Fatalf("Expected %d matches for %s, got %d", len(expected), path, len(matches))
+		}
+
+		for i, m := range matches {
+
+			if m.Raw != expected[i] {
+				t.Fatalf("Expected match %d for %s to be %s, got %s", i, path, expected[i], m.Raw)
+			}
+
+			if string(body[m.Start:m.End()]) != m.Raw {
+				t.Fatalf("Expected match %d for %s to be at %d, got %s", i, path, m.Start, body[m.Start:m.End()])
+			}
+		}
+	}
+}
+
+func TestFindUnknownPosition(t *testing.T) {
+
+	body := []byte(`{"a":[3,1,2],"b":{"c":1}}`)
+
+	for _, path := range []string{"a|@reverse", "a.#", "{b.c,a}"} {
+
+		_, err := Find(body, path)
+
+		if err == nil {
+			t.Fatalf("Expected an error finding %s", path)
+		}
+	}
+}
+
+func TestReplace(t *testing.T) {
+
+	body := []byte(`{"a":[{"b":"x"},{"b":"yy"}],"c":1}`)
+
+	matches, err := Find(body, "a.#.b")
+
+	if err != nil {
+		t.Fatalf("Failed to find values, %v", err)
+	}
+
+	// Matches are replaced last first so that the positions of the others do not change
+	for _, m := range matches {
+		body = Replace(body, m, []byte(`{"replaced":true}`))
+	}
+
+	expected := `{"a":[{"b":{"replaced":true}},{"b":{"replaced":true}}],"c":1}`
+
+	if string(body) != expected {
+		t.Fatalf("Expected %s, got %s", expected, body)
+	}
+}
+
+func TestDelete(t *testing.T) {
+
+	tests := []struct {
+		Body     string
+		Path     string
+		Expected string
+	}{
+		{`{"a":1,"b":2,"c":3}`, "a", `{"b":2,"c":3}`},
+		{`{"a":1,"b":2,"c":3}`, "b", `{"a":1,"c":3}`},
+		{`{"a":1,"b":2,"c":3}`, "c", `{"a":1,"b":2}`},
+		{`{"a":1}`, "a", `{}`},
+		{`{ "a" : 1 , "b" : 2 }`, "b", `{ "a" : 1  }`},
+		{`{"a\"b":1,"c":2}`, `a"b`, `{"c":2}`},
+		{`{"a\\":1,"c":2}`, `a\\`, `{"c":2}`},
+		{`{"a":[1,2,3]}`, "a.0", `{"a":[2,3]}`},
+		{`{"a":[1,2,3]}`, "a.2", `{"a":[1,2]}`},
+		{`{"a":[1]}`, "a.0", `{"a":[]}`},
+		{`{"a":[{"b":1,"c":2},{"b":3}]}`, "a.#.b", `{"a":[{"c":2},{}]}`},
+	}
+
+	for _, test := range tests {
+
+		body := []byte(test.Body)
+
+		matches, err := Find(body, test.Path)
+
+		if err != nil {
+			t.Fatalf("Failed to find %s in %s, %v", test.Path, test.Body, err)
+		}
+
+		if len(matches) == 0 {
+			t.Fatalf("Expected to find %s in %s", test.Path, test.Body)
+		}
+
+		for _, m := range matches {
+
+			body, err = Delete(body, m)
+
+			if err != nil {
+				t.Fatalf("Failed to delete %s from %s, %v", test.Path, test.Body, err)
+			}
+		}
+
+		if string(body) != test.Expected {
+			t.Fatalf("Expected deleting %s from %s to be %s, got %s", test.Path, test.Body, test.Expected, body)
+		}
+	}
+}
+
+func TestDeleteDocument(t *testing.T) {
+
+	body := []byte(`{"a":1}`)
+
+	_, err := Delete(body, &Match{Start: 0, Raw: string(body)})
+
+	if err == nil {
+		t.Fatalf("Expected an error deleting the document itself")
+	}
+}
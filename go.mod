@@ -9,6 +9,7 @@ require (
 	github.com/elastic/go-elasticsearch/v7 v7.17.7
 	github.com/goccy/go-json v0.10.2
 	github.com/sourcegraph/conc v0.3.0
+	github.com/tidwall/gjson v1.14.4
 	github.com/tidwall/pretty v1.2.1
 )
 
@@ -17,7 +18,6 @@ require (
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/googleapis/gax-go/v2 v2.8.0 // indirect
-	github.com/tidwall/gjson v1.14.4 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	go.opencensus.io v0.24.0 // indirect
 	go.uber.org/atomic v1.10.0 // indirect
@@ -0,0 +1,127 @@
+package index
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	json "github.com/goccy/go-json"
+)
+
+// VERSIONED_NAME_LAYOUT is the time layout appended to an alias to derive the name of a new versioned index.
+const VERSIONED_NAME_LAYOUT string = "20060102T150405"
+
+// The number of random bytes, hex-encoded, which follow the time in the name of a new versioned index.
+const versioned_name_random_bytes int = 3
+
+// VersionedName returns the name of a new versioned index for alias, for example "name-20261017T120000-3fa81c".
+// The random suffix keeps restores started within the same second from targeting the same index.
+func VersionedName(alias string, t time.Time) (string, error) {
+
+	suffix := make([]byte, versioned_name_random_bytes)
+
+	_, err := rand.Read(suffix)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate index name, %w", err)
+	}
+
+	return fmt.Sprintf("%s-%s-%s", alias, t.UTC().Format(VERSIONED_NAME_LAYOUT), hex.EncodeToString(suffix)), nil
+}
+
+// AliasedIndices returns the names of the indices that alias currently points to. If alias does not exist
+// an empty list is returned. It is an error for alias to be the name of a concrete index.
+func AliasedIndices(ctx context.Context, es_client *esapi.API, alias string) ([]string, error) {
+
+	rsp, err := es_client.Indices.GetAlias(
+		es_client.Indices.GetAlias.WithContext(ctx),
+		es_client.Indices.GetAlias.WithName(alias),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode == 404 {
+
+		exists, err := Exists(ctx, es_client, alias)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			return nil, fmt.Errorf("%s is an index, not an alias", alias)
+		}
+
+		return []string{}, nil
+	}
+
+	err = responseError(rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve alias %s, %w", alias, err)
+	}
+
+	var body map[string]interface{}
+
+	err = json.NewDecoder(rsp.Body).Decode(&body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode alias %s, %w", alias, err)
+	}
+
+	names := make([]string, 0, len(body))
+
+	for name := range body {
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// SwapAlias atomically moves alias from the indices in old to new_index in a single _aliases request.
+func SwapAlias(ctx context.Context, es_client *esapi.API, alias string, new_index string, old []string) error {
+
+	actions := make([]map[string]interface{}, 0, len(old)+1)
+
+	for _, name := range old {
+
+		actions = append(actions, map[string]interface{}{
+			"remove": map[string]string{"index": name, "alias": alias},
+		})
+	}
+
+	actions = append(actions, map[string]interface{}{
+		"add": map[string]string{"index": new_index, "alias": alias},
+	})
+
+	body := map[string]interface{}{
+		"actions": actions,
+	}
+
+	rsp, err := es_client.Indices.UpdateAliases(
+		esutil.NewJSONReader(body),
+		es_client.Indices.UpdateAliases.WithContext(ctx),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	err = responseError(rsp)
+
+	if err != nil {
+		return fmt.Errorf("Failed to point %s at %s, %w", alias, new_index, err)
+	}
+
+	return nil
+}
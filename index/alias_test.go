@@ -0,0 +1,136 @@
+package index
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+func TestVersionedName(t *testing.T) {
+
+	now := time.Date(2026, 10, 17, 12, 0, 5, 0, time.FixedZone("PDT", -7*60*60))
+
+	name, err := VersionedName("millsfield", now)
+
+	if err != nil {
+		t.Fatalf("Failed to derive name, %v", err)
+	}
+
+	// The time is always UTC
+	re := regexp.MustCompile(`^millsfield-20261017T190005-[0-9a-f]{6}$`)
+
+	if !re.MatchString(name) {
+		t.Fatalf("Unexpected name %s", name)
+	}
+
+	other, err := VersionedName("millsfield", now)
+
+	if err != nil {
+		t.Fatalf("Failed to derive name, %v", err)
+	}
+
+	if other == name {
+		t.Fatalf("Expected names derived at the same time to differ, got %s twice", name)
+	}
+}
+
+func TestAliasedIndices(t *testing.T) {
+
+	api := newTestAPI(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"millsfield-1":{"aliases":{"millsfield":{}}},"millsfield-2":{"aliases":{"millsfield":{}}}}`))
+	})
+
+	names, err := AliasedIndices(context.Background(), api, "millsfield")
+
+	if err != nil {
+		t.Fatalf("Failed to get aliased indices, %v", err)
+	}
+
+	sort.Strings(names)
+
+	if len(names) != 2 || names[0] != "millsfield-1" || names[1] != "millsfield-2" {
+		t.Fatalf("Unexpected indices %v", names)
+	}
+}
+
+func TestAliasedIndicesMissing(t *testing.T) {
+
+	api := newTestAPI(func(w http.ResponseWriter, req *http.Request) {
+
+		// Neither the alias nor an index with its name exist
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{}`))
+	})
+
+	names, err := AliasedIndices(context.Background(), api, "millsfield")
+
+	if err != nil {
+		t.Fatalf("Failed to get aliased indices, %v", err)
+	}
+
+	if len(names) != 0 {
+		t.Fatalf("Expected no indices, got %v", names)
+	}
+}
+
+func TestAliasedIndicesConcrete(t *testing.T) {
+
+	api := newTestAPI(func(w http.ResponseWriter, req *http.Request) {
+
+		// There is no alias, but there is an index with the same name
+		if req.Method == http.MethodHead {
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{}`))
+	})
+
+	_, err := AliasedIndices(context.Background(), api, "millsfield")
+
+	if err == nil {
+		t.Fatalf("Expected an error for a concrete index")
+	}
+}
+
+func TestSwapAlias(t *testing.T) {
+
+	var body struct {
+		Actions []map[string]map[string]string `json:"actions"`
+	}
+
+	api := newTestAPI(func(w http.ResponseWriter, req *http.Request) {
+
+		if req.URL.Path != "/_aliases" {
+			t.Errorf("Unexpected request %s", req.URL)
+		}
+
+		err := json.Unmarshal([]byte(readBody(t, req)), &body)
+
+		if err != nil {
+			t.Errorf("Failed to decode body, %v", err)
+		}
+
+		w.Write([]byte(`{"acknowledged":true}`))
+	})
+
+	err := SwapAlias(context.Background(), api, "millsfield", "millsfield-2", []string{"millsfield-1"})
+
+	if err != nil {
+		t.Fatalf("Failed to swap alias, %v", err)
+	}
+
+	// Every action is in the same request, removals first
+	if len(body.Actions) != 2 {
+		t.Fatalf("Expected 2 actions, got %d", len(body.Actions))
+	}
+
+	if body.Actions[0]["remove"]["index"] != "millsfield-1" || body.Actions[1]["add"]["index"] != "millsfield-2" {
+		t.Fatalf("Unexpected actions %v", body.Actions)
+	}
+}
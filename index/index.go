@@ -0,0 +1,332 @@
+// package index provides methods for administering the Elasticsearch index being restored.
+package index
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	json "github.com/goccy/go-json"
+)
+
+// These settings are assigned by Elasticsearch and can not be copied to a new index.
+var internal_settings = []string{
+	"uuid",
+	"creation_date",
+	"creation_date_string",
+	"provided_name",
+	"version",
+	"resize",
+	"verified_before_close",
+	"history_uuid",
+}
+
+// responseError returns an error describing res if it represents a failed request.
+func responseError(res *esapi.Response) error {
+
+	if !res.IsError() {
+		return nil
+	}
+
+	return fmt.Errorf("%s", res.String())
+}
+
+// Exists reports whether the index (or alias) name exists.
+func Exists(ctx context.Context, es_client *esapi.API, name string) (bool, error) {
+
+	rsp, err := es_client.Indices.Exists(
+		[]string{name},
+		es_client.Indices.Exists.WithContext(ctx),
+	)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer rsp.Body.Close()
+
+	switch rsp.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, fmt.Errorf("Failed to determine whether %s exists, %s", name, rsp.Status())
+	}
+}
+
+// Definition returns the mappings and user-defined settings of the index name in a form that can be
+// passed to Create.
+func Definition(ctx context.Context, es_client *esapi.API, name string) (map[string]interface{}, error) {
+
+	rsp, err := es_client.Indices.Get(
+		[]string{name},
+		es_client.Indices.Get.WithContext(ctx),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	err = responseError(rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve definition for %s, %w", name, err)
+	}
+
+	var body map[string]struct {
+		Mappings map[string]interface{} `json:"mappings"`
+		Settings struct {
+			Index map[string]interface{} `json:"index"`
+		} `json:"settings"`
+	}
+
+	err = json.NewDecoder(rsp.Body).Decode(&body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode definition for %s, %w", name, err)
+	}
+
+	if len(body) != 1 {
+		return nil, fmt.Errorf("Expected definition for exactly one index matching %s, got %d", name, len(body))
+	}
+
+	def := make(map[string]interface{})
+
+	for _, idx := range body {
+
+		if idx.Mappings != nil {
+			def["mappings"] = idx.Mappings
+		}
+
+		settings := idx.Settings.Index
+
+		if settings != nil {
+
+			for _, k := range internal_settings {
+				delete(settings, k)
+			}
+
+			def["settings"] = map[string]interface{}{
+				"index": settings,
+			}
+		}
+	}
+
+	return def, nil
+}
+
+// ReadDefinition reads an index definition, in the form passed to Create, from the JSON file at path. Files
+// which only contain mappings (with a top-level "properties" property) are wrapped in a definition.
+func ReadDefinition(path string) (map[string]interface{}, error) {
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read definition %s, %w", path, err)
+	}
+
+	var def map[string]interface{}
+
+	err = json.Unmarshal(body, &def)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode definition %s, %w", path, err)
+	}
+
+	_, ok := def["properties"]
+
+	if ok {
+		def = map[string]interface{}{
+			"mappings": def,
+		}
+	}
+
+	return def, nil
+}
+
+// Create creates the index name using definition (which may be nil) as the request body.
+func Create(ctx context.Context, es_client *esapi.API, name string, definition map[string]interface{}) error {
+
+	opts := []func(*esapi.IndicesCreateRequest){
+		es_client.Indices.Create.WithContext(ctx),
+	}
+
+	if definition != nil {
+		opts = append(opts, es_client.Indices.Create.WithBody(esutil.NewJSONReader(definition)))
+	}
+
+	rsp, err := es_client.Indices.Create(name, opts...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	err = responseError(rsp)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create %s, %w", name, err)
+	}
+
+	return nil
+}
+
+// Refresh makes all the operations performed on the index (or alias) name available for search.
+func Refresh(ctx context.Context, es_client *esapi.API, name string) error {
+
+	rsp, err := es_client.Indices.Refresh(
+		es_client.Indices.Refresh.WithContext(ctx),
+		es_client.Indices.Refresh.WithIndex(name),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	err = responseError(rsp)
+
+	if err != nil {
+		return fmt.Errorf("Failed to refresh %s, %w", name, err)
+	}
+
+	return nil
+}
+
+// Count returns the number of documents in the index (or alias) name.
+func Count(ctx context.Context, es_client *esapi.API, name string) (int, error) {
+
+	rsp, err := es_client.Count(
+		es_client.Count.WithContext(ctx),
+		es_client.Count.WithIndex(name),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer rsp.Body.Close()
+
+	err = responseError(rsp)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to count %s, %w", name, err)
+	}
+
+	var body struct {
+		Count int `json:"count"`
+	}
+
+	err = json.NewDecoder(rsp.Body).Decode(&body)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to decode count for %s, %w", name, err)
+	}
+
+	return body.Count, nil
+}
+
+// Close closes the indices in names.
+func Close(ctx context.Context, es_client *esapi.API, names ...string) error {
+
+	rsp, err := es_client.Indices.Close(
+		names,
+		es_client.Indices.Close.WithContext(ctx),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	err = responseError(rsp)
+
+	if err != nil {
+		return fmt.Errorf("Failed to close %v, %w", names, err)
+	}
+
+	return nil
+}
+
+// Delete deletes the indices in names.
+func Delete(ctx context.Context, es_client *esapi.API, names ...string) error {
+
+	rsp, err := es_client.Indices.Delete(
+		names,
+		es_client.Indices.Delete.WithContext(ctx),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	err = responseError(rsp)
+
+	if err != nil {
+		return fmt.Errorf("Failed to delete %v, %w", names, err)
+	}
+
+	return nil
+}
+
+// Documents returns the _source of each document in ids that exists in the index (or alias) name, keyed
+// by document ID.
+func Documents(ctx context.Context, es_client *esapi.API, name string, ids []string) (map[string]json.RawMessage, error) {
+
+	body := map[string]interface{}{
+		"ids": ids,
+	}
+
+	rsp, err := es_client.Mget(
+		esutil.NewJSONReader(body),
+		es_client.Mget.WithContext(ctx),
+		es_client.Mget.WithIndex(name),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	err = responseError(rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve documents from %s, %w", name, err)
+	}
+
+	var docs struct {
+		Docs []struct {
+			ID     string          `json:"_id"`
+			Found  bool            `json:"found"`
+			Source json.RawMessage `json:"_source"`
+		} `json:"docs"`
+	}
+
+	err = json.NewDecoder(rsp.Body).Decode(&docs)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode documents from %s, %w", name, err)
+	}
+
+	found := make(map[string]json.RawMessage, len(docs.Docs))
+
+	for _, d := range docs.Docs {
+
+		if d.Found {
+			found[d.ID] = d.Source
+		}
+	}
+
+	return found, nil
+}
@@ -0,0 +1,44 @@
+package index
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+// handlerTransport is an esapi.Transport which answers requests with an http.Handler.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (tr *handlerTransport) Perform(req *http.Request) (*http.Response, error) {
+
+	rec := httptest.NewRecorder()
+	tr.handler.ServeHTTP(rec, req)
+
+	return rec.Result(), nil
+}
+
+func newTestAPI(handler http.HandlerFunc) *esapi.API {
+	return esapi.New(&handlerTransport{handler: handler})
+}
+
+func readBody(t *testing.T, req *http.Request) string {
+
+	t.Helper()
+
+	if req.Body == nil {
+		return ""
+	}
+
+	body, err := io.ReadAll(req.Body)
+
+	if err != nil {
+		t.Fatalf("Failed to read request body, %v", err)
+	}
+
+	return string(body)
+}
@@ -0,0 +1,167 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	json "github.com/goccy/go-json"
+)
+
+// Settings maps flat setting names (for example "index.refresh_interval") to their values. A nil value
+// resets the setting to the cluster default.
+type Settings map[string]*string
+
+// BulkLoadSettings are applied to an index for the duration of a bulk load.
+func BulkLoadSettings() Settings {
+
+	refresh := "-1"
+	replicas := "0"
+
+	return Settings{
+		"index.refresh_interval":   &refresh,
+		"index.number_of_replicas": &replicas,
+	}
+}
+
+// GetSettings returns the explicitly configured values for keys on the index (or alias) name. Keys
+// which have not been set are returned as nil so that putting the result back resets them to defaults.
+func GetSettings(ctx context.Context, es_client *esapi.API, name string, keys ...string) (Settings, error) {
+
+	rsp, err := es_client.Indices.GetSettings(
+		es_client.Indices.GetSettings.WithContext(ctx),
+		es_client.Indices.GetSettings.WithIndex(name),
+		es_client.Indices.GetSettings.WithFlatSettings(true),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	err = responseError(rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve settings for %s, %w", name, err)
+	}
+
+	var body map[string]struct {
+		Settings map[string]interface{} `json:"settings"`
+	}
+
+	err = json.NewDecoder(rsp.Body).Decode(&body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode settings for %s, %w", name, err)
+	}
+
+	if len(body) != 1 {
+		return nil, fmt.Errorf("Expected settings for exactly one index matching %s, got %d", name, len(body))
+	}
+
+	settings := make(Settings)
+
+	for _, idx := range body {
+
+		for _, k := range keys {
+
+			v, ok := idx.Settings[k]
+
+			if !ok {
+				settings[k] = nil
+				continue
+			}
+
+			str_v := fmt.Sprintf("%v", v)
+			settings[k] = &str_v
+		}
+	}
+
+	return settings, nil
+}
+
+// PutSettings applies settings to the index (or alias) name.
+func PutSettings(ctx context.Context, es_client *esapi.API, name string, settings Settings) error {
+
+	rsp, err := es_client.Indices.PutSettings(
+		esutil.NewJSONReader(settings),
+		es_client.Indices.PutSettings.WithContext(ctx),
+		es_client.Indices.PutSettings.WithIndex(name),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	err = responseError(rsp)
+
+	if err != nil {
+		return fmt.Errorf("Failed to update settings for %s, %w", name, err)
+	}
+
+	return nil
+}
+
+// ForceMerge merges the segments of the index (or alias) name down to (at most) max_segments.
+func ForceMerge(ctx context.Context, es_client *esapi.API, name string, max_segments int) error {
+
+	rsp, err := es_client.Indices.Forcemerge(
+		es_client.Indices.Forcemerge.WithContext(ctx),
+		es_client.Indices.Forcemerge.WithIndex(name),
+		es_client.Indices.Forcemerge.WithMaxNumSegments(max_segments),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	err = responseError(rsp)
+
+	if err != nil {
+		return fmt.Errorf("Failed to force-merge %s, %w", name, err)
+	}
+
+	return nil
+}
+
+// Keys returns the sorted list of setting names in s.
+func (s Settings) Keys() []string {
+
+	keys := make([]string, 0, len(s))
+
+	for k := range s {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// String returns a compact description of settings suitable for logging.
+func (s Settings) String() string {
+
+	keys := s.Keys()
+
+	parts := make([]string, 0, len(keys))
+
+	for _, k := range keys {
+
+		str_v := "(default)"
+
+		if v := s[k]; v != nil {
+			str_v = *v
+		}
+
+		parts = append(parts, fmt.Sprintf("%s=%s", k, str_v))
+	}
+
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,132 @@
+package index
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	json "github.com/goccy/go-json"
+)
+
+func TestBulkLoadSettings(t *testing.T) {
+
+	s := BulkLoadSettings()
+
+	if s.String() != "index.number_of_replicas=0 index.refresh_interval=-1" {
+		t.Fatalf("Unexpected bulk load settings, %s", s)
+	}
+}
+
+func TestSettingsString(t *testing.T) {
+
+	one := "1s"
+
+	s := Settings{
+		"index.refresh_interval":   &one,
+		"index.number_of_replicas": nil,
+	}
+
+	if s.String() != "index.number_of_replicas=(default) index.refresh_interval=1s" {
+		t.Fatalf("Unexpected string, %s", s)
+	}
+}
+
+func TestGetSettings(t *testing.T) {
+
+	api := newTestAPI(func(w http.ResponseWriter, req *http.Request) {
+
+		if req.URL.Path != "/books/_settings" || req.URL.Query().Get("flat_settings") != "true" {
+			t.Errorf("Unexpected request %s", req.URL)
+		}
+
+		w.Write([]byte(`{"books-1":{"settings":{"index.refresh_interval":"30s","index.number_of_shards":"1"}}}`))
+	})
+
+	s, err := GetSettings(context.Background(), api, "books", "index.refresh_interval", "index.number_of_replicas")
+
+	if err != nil {
+		t.Fatalf("Failed to get settings, %v", err)
+	}
+
+	if len(s) != 2 {
+		t.Fatalf("Expected 2 settings, got %d", len(s))
+	}
+
+	if v := s["index.refresh_interval"]; v == nil || *v != "30s" {
+		t.Fatalf("Unexpected refresh interval, %v", v)
+	}
+
+	// Settings which were never set are returned as nil so that putting them back resets them
+	if v, ok := s["index.number_of_replicas"]; !ok || v != nil {
+		t.Fatalf("Expected an unset number of replicas, got %v", v)
+	}
+}
+
+func TestGetSettingsMultipleIndices(t *testing.T) {
+
+	api := newTestAPI(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{"a":{"settings":{}},"b":{"settings":{}}}`))
+	})
+
+	_, err := GetSettings(context.Background(), api, "books", "index.refresh_interval")
+
+	if err == nil {
+		t.Fatalf("Expected an error for an alias of more than one index")
+	}
+}
+
+func TestPutSettings(t *testing.T) {
+
+	var body map[string]interface{}
+
+	api := newTestAPI(func(w http.ResponseWriter, req *http.Request) {
+
+		if req.Method != http.MethodPut || req.URL.Path != "/books/_settings" {
+			t.Errorf("Unexpected request %s %s", req.Method, req.URL)
+		}
+
+		err := json.Unmarshal([]byte(readBody(t, req)), &body)
+
+		if err != nil {
+			t.Errorf("Failed to decode body, %v", err)
+		}
+
+		w.Write([]byte(`{"acknowledged":true}`))
+	})
+
+	one := "1s"
+
+	err := PutSettings(context.Background(), api, "books", Settings{
+		"index.refresh_interval":   &one,
+		"index.number_of_replicas": nil,
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to put settings, %v", err)
+	}
+
+	if body["index.refresh_interval"] != "1s" {
+		t.Fatalf("Unexpected refresh interval, %v", body["index.refresh_interval"])
+	}
+
+	// Unset settings are sent as null to reset them
+	v, ok := body["index.number_of_replicas"]
+
+	if !ok || v != nil {
+		t.Fatalf("Expected a null number of replicas, got %v", v)
+	}
+}
+
+func TestPutSettingsError(t *testing.T) {
+
+	api := newTestAPI(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"bad"}`))
+	})
+
+	err := PutSettings(context.Background(), api, "books", BulkLoadSettings())
+
+	if err == nil {
+		t.Fatalf("Expected an error")
+	}
+}
@@ -0,0 +1,242 @@
+// package inspect provides methods for summarizing the contents of dump files.
+package inspect
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/fields"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/record"
+)
+
+// Malformed describes a line which is not a valid dump record.
+type Malformed struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// Duplicate describes an ID which appears in more than one record.
+type Duplicate struct {
+	ID string `json:"id"`
+	// Lines are the line numbers of every record with the ID.
+	Lines []int `json:"lines"`
+}
+
+// Sizes describes the distribution of the sizes, in bytes, of each record's _source property.
+type Sizes struct {
+	Total int64   `json:"total"`
+	Min   int     `json:"min"`
+	Max   int     `json:"max"`
+	Mean  float64 `json:"mean"`
+	P50   int     `json:"p50"`
+	P90   int     `json:"p90"`
+	P99   int     `json:"p99"`
+	// Histogram counts the records whose size is less than or equal to each power of two, starting at 64 bytes.
+	Histogram []*SizeBucket `json:"histogram"`
+}
+
+// SizeBucket counts the records whose size is greater than the previous bucket's UpTo and less than or
+// equal to UpTo.
+type SizeBucket struct {
+	UpTo  int `json:"up_to"`
+	Count int `json:"count"`
+}
+
+// Field describes a property path observed in the _source of one or more records.
+type Field struct {
+	Path string `json:"path"`
+	// Documents is the number of records in which the path occurs.
+	Documents int `json:"documents"`
+	// Types counts the values of each JSON type observed at the path.
+	Types map[string]int `json:"types"`
+}
+
+// Report summarizes the contents of a dump.
+type Report struct {
+	Lines      int            `json:"lines"`
+	Records    int            `json:"records"`
+	Malformed  []*Malformed   `json:"malformed"`
+	Duplicates []*Duplicate   `json:"duplicates"`
+	Sizes      *Sizes         `json:"sizes"`
+	Indices    map[string]int `json:"indices"`
+	Fields     []*Field       `json:"fields"`
+}
+
+// Inspector accumulates a Report from the lines of a dump. It is not safe for concurrent use.
+type Inspector struct {
+	lines     int
+	malformed []*Malformed
+	ids       map[string][]int
+	sizes     []int
+	indices   map[string]int
+	fields    map[string]*Field
+}
+
+// NewInspector returns a new Inspector.
+func NewInspector() *Inspector {
+
+	i := &Inspector{
+		malformed: make([]*Malformed, 0),
+		ids:       make(map[string][]int),
+		sizes:     make([]int, 0),
+		indices:   make(map[string]int),
+		fields:    make(map[string]*Field),
+	}
+
+	return i
+}
+
+// AddLine adds the dump record line, read from line number line_number, to the report.
+func (i *Inspector) AddLine(line_number int, line []byte) {
+
+	i.lines += 1
+
+	if !json.Valid(line) {
+		i.malformed = append(i.malformed, &Malformed{Line: line_number, Error: "Invalid JSON"})
+		return
+	}
+
+	rec, err := record.Parse(line)
+
+	if err != nil {
+		i.malformed = append(i.malformed, &Malformed{Line: line_number, Error: err.Error()})
+		return
+	}
+
+	// Records without an _id are assigned one when they are indexed so they can not be duplicates
+	if rec.ID != "" {
+		i.ids[rec.ID] = append(i.ids[rec.ID], line_number)
+	}
+
+	i.sizes = append(i.sizes, len(rec.Source))
+	i.indices[rec.Index] += 1
+
+	seen := make(map[string]bool)
+
+	fields.Walk(rec.Source, func(path string, value gjson.Result) {
+
+		f, ok := i.fields[path]
+
+		if !ok {
+			f = &Field{
+				Path:  path,
+				Types: make(map[string]int),
+			}
+
+			i.fields[path] = f
+		}
+
+		if !seen[path] {
+			f.Documents += 1
+			seen[path] = true
+		}
+
+		f.Types[fields.Type(value)] += 1
+	})
+}
+
+// Report returns a summary of every line added so far.
+func (i *Inspector) Report() *Report {
+
+	r := &Report{
+		Lines:      i.lines,
+		Records:    len(i.sizes),
+		Malformed:  i.malformed,
+		Duplicates: make([]*Duplicate, 0),
+		Sizes:      sizes(i.sizes),
+		Indices:    i.indices,
+		Fields:     make([]*Field, 0, len(i.fields)),
+	}
+
+	for id, lines := range i.ids {
+
+		if len(lines) > 1 {
+			r.Duplicates = append(r.Duplicates, &Duplicate{ID: id, Lines: lines})
+		}
+	}
+
+	sort.Slice(r.Duplicates, func(a int, b int) bool {
+		return r.Duplicates[a].Lines[0] < r.Duplicates[b].Lines[0]
+	})
+
+	for _, f := range i.fields {
+		r.Fields = append(r.Fields, f)
+	}
+
+	sort.Slice(r.Fields, func(a int, b int) bool {
+		return r.Fields[a].Path < r.Fields[b].Path
+	})
+
+	return r
+}
+
+func sizes(values []int) *Sizes {
+
+	s := &Sizes{
+		Histogram: make([]*SizeBucket, 0),
+	}
+
+	if len(values) == 0 {
+		return s
+	}
+
+	sorted := make([]int, len(values))
+	copy(sorted, values)
+	sort.Ints(sorted)
+
+	for _, v := range sorted {
+		s.Total += int64(v)
+	}
+
+	s.Min = sorted[0]
+	s.Max = sorted[len(sorted)-1]
+	s.Mean = float64(s.Total) / float64(len(sorted))
+	s.P50 = percentile(sorted, 50)
+	s.P90 = percentile(sorted, 90)
+	s.P99 = percentile(sorted, 99)
+
+	bucket := &SizeBucket{UpTo: 64}
+	s.Histogram = append(s.Histogram, bucket)
+
+	for _, v := range sorted {
+
+		for v > bucket.UpTo {
+			bucket = &SizeBucket{UpTo: bucket.UpTo * 2}
+			s.Histogram = append(s.Histogram, bucket)
+		}
+
+		bucket.Count += 1
+	}
+
+	return s
+}
+
+// percentile returns the nearest-rank p-th percentile of the sorted values.
+func percentile(sorted []int, p int) int {
+
+	rank := (p*len(sorted) + 99) / 100
+
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// String returns a human-readable description of b's upper bound, for example "64KB".
+func (b *SizeBucket) String() string {
+
+	units := []string{"B", "KB", "MB", "GB"}
+	v := b.UpTo
+	u := 0
+
+	for v >= 1024 && v%1024 == 0 && u < len(units)-1 {
+		v = v / 1024
+		u += 1
+	}
+
+	return fmt.Sprintf("%d%s", v, units[u])
+}
@@ -0,0 +1,135 @@
+package inspect
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestInspector(t *testing.T) {
+
+	lines := []string{
+		`{"_id":"1","_index":"books","_source":{"title":"Tin Drum","tags":["a","b"]}}`,
+		`{"_id":"2","_index":"books","_source":{"title":null,"pages":{"count":100}}}`,
+		`{"_id":"1","_index":"books-v2","_source":{}}`,
+		`{"_id":"3",`,
+		`{"_id":"4"}`,
+		`{"_index":"books","_source":{"title":"Cat and Mouse"}}`,
+		`{"_index":"books","_source":{"title":"Dog Years"}}`,
+	}
+
+	inspector := NewInspector()
+
+	for i, line := range lines {
+		inspector.AddLine(i+1, []byte(line))
+	}
+
+	r := inspector.Report()
+
+	if r.Lines != 7 || r.Records != 5 {
+		t.Fatalf("Expected 7 lines and 5 records, got %d and %d", r.Lines, r.Records)
+	}
+
+	if len(r.Malformed) != 2 || r.Malformed[0].Line != 4 || r.Malformed[0].Error != "Invalid JSON" || r.Malformed[1].Line != 5 {
+		t.Fatalf("Unexpected malformed lines %+v", r.Malformed)
+	}
+
+	// Records without an _id are not duplicates of each other
+	if len(r.Duplicates) != 1 || r.Duplicates[0].ID != "1" || fmt.Sprint(r.Duplicates[0].Lines) != "[1 3]" {
+		t.Fatalf("Unexpected duplicates %+v", r.Duplicates)
+	}
+
+	if r.Indices["books"] != 4 || r.Indices["books-v2"] != 1 {
+		t.Fatalf("Unexpected indices %v", r.Indices)
+	}
+
+	paths := make([]string, len(r.Fields))
+
+	for i, f := range r.Fields {
+		paths[i] = fmt.Sprintf("%s:%d:%v", f.Path, f.Documents, f.Types)
+	}
+
+	expected := []string{
+		"pages:1:map[object:1]",
+		"pages.count:1:map[number:1]",
+		"tags:1:map[array:1]",
+		"tags[]:1:map[string:2]",
+		"title:4:map[null:1 string:3]",
+	}
+
+	if strings.Join(paths, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected fields %v", paths)
+	}
+}
+
+func TestSizes(t *testing.T) {
+
+	values := make([]int, 0)
+
+	for i := 1; i <= 100; i++ {
+		values = append(values, i*10)
+	}
+
+	s := sizes(values)
+
+	if s.Total != 50500 || s.Min != 10 || s.Max != 1000 || s.Mean != 505 {
+		t.Fatalf("Unexpected sizes %+v", s)
+	}
+
+	if s.P50 != 500 || s.P90 != 900 || s.P99 != 990 {
+		t.Fatalf("Unexpected percentiles %d, %d, %d", s.P50, s.P90, s.P99)
+	}
+
+	buckets := make([]string, len(s.Histogram))
+
+	for i, b := range s.Histogram {
+		buckets[i] = fmt.Sprintf("%s:%d", b, b.Count)
+	}
+
+	// 10 to 60 are at most 64 bytes, 70 to 120 at most 128 and so on
+	expected := "64B:6,128B:6,256B:13,512B:26,1KB:49"
+
+	if strings.Join(buckets, ",") != expected {
+		t.Fatalf("Unexpected histogram %v", buckets)
+	}
+
+	empty := sizes([]int{})
+
+	if empty.Total != 0 || len(empty.Histogram) != 0 {
+		t.Fatalf("Unexpected sizes for no records %+v", empty)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+
+	if percentile([]int{7}, 50) != 7 || percentile([]int{7}, 0) != 7 {
+		t.Fatalf("Expected the only value to be every percentile")
+	}
+
+	sorted := []int{1, 2, 3, 4}
+
+	if percentile(sorted, 50) != 2 || percentile(sorted, 51) != 3 || percentile(sorted, 100) != 4 {
+		t.Fatalf("Unexpected nearest-rank percentiles")
+	}
+}
+
+func TestSizeBucketString(t *testing.T) {
+
+	tests := map[int]string{
+		64:      "64B",
+		1024:    "1KB",
+		65536:   "64KB",
+		1 << 20: "1MB",
+		1 << 31: "2GB",
+		1 << 41: "2048GB",
+	}
+
+	for up_to, expected := range tests {
+
+		b := &SizeBucket{UpTo: up_to}
+
+		if b.String() != expected {
+			t.Fatalf("Expected %d to be %s, got %s", up_to, expected, b)
+		}
+	}
+}
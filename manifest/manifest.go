@@ -0,0 +1,349 @@
+// package manifest provides methods for describing the files written by a dump, with their checksums, and
+// for verifying dump files against that description.
+package manifest
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/crypt"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/record"
+)
+
+// The name of the manifest file in a dump directory.
+const FILENAME string = "manifest.json"
+
+// The version of the manifest format written by this package.
+const VERSION int = 1
+
+// Manifest describes a dump and every file (part) it was written to.
+type Manifest struct {
+	Version int `json:"version"`
+	// Index is the name of the index that was dumped.
+	Index string `json:"index"`
+	// Query is the query limiting the documents that were dumped, if any.
+	Query json.RawMessage `json:"query,omitempty"`
+	// Cluster describes the Elasticsearch or OpenSearch distribution and version that was dumped.
+	Cluster  string    `json:"cluster"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Records  int       `json:"records"`
+	// EncryptedFields are the paths of the _source values which were encrypted, if any.
+	EncryptedFields []string `json:"encrypted_fields,omitempty"`
+	// Window, if set, is the window of values of a field which the dumped documents changed within.
+	Window *Window `json:"window,omitempty"`
+	Parts  []*Part `json:"parts"`
+}
+
+// Window describes the documents of an incremental dump, which are those whose field is greater than Since
+// and less than or equal to Until. For _seq_no Since and Until are lists of the checkpoints of every shard.
+type Window struct {
+	Field string          `json:"field"`
+	Since json.RawMessage `json:"since,omitempty"`
+	Until json.RawMessage `json:"until,omitempty"`
+}
+
+// Part describes one file of a dump.
+type Part struct {
+	// Path is the path of the file relative to the manifest.
+	Path    string `json:"path"`
+	Records int    `json:"records"`
+	// Bytes is the size of the file, after any compression.
+	Bytes int64 `json:"bytes"`
+	// SHA256 is the hex-encoded SHA-256 digest of the file, after any compression.
+	SHA256 string `json:"sha256"`
+}
+
+// Read reads the manifest at path, which may be a dump directory or the manifest file itself.
+func Read(path string) (*Manifest, error) {
+
+	path, body, err := readFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return decode(path, body)
+}
+
+// readFile returns the path and contents of the manifest at path, which may be a dump directory or the
+// manifest file itself.
+func readFile(path string) (string, []byte, error) {
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to read manifest, %w", err)
+	}
+
+	if info.IsDir() {
+		path = filepath.Join(path, FILENAME)
+	}
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to read manifest, %w", err)
+	}
+
+	return path, body, nil
+}
+
+func decode(path string, body []byte) (*Manifest, error) {
+
+	var m *Manifest
+
+	err := json.Unmarshal(body, &m)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode manifest %s, %w", path, err)
+	}
+
+	if m == nil || m.Version < 1 {
+		return nil, fmt.Errorf("Invalid manifest %s, missing version", path)
+	}
+
+	if m.Version > VERSION {
+		return nil, fmt.Errorf("Unsupported manifest %s, version %d is newer than %d", path, m.Version, VERSION)
+	}
+
+	return m, nil
+}
+
+// Write writes m to path. The manifest is written to a temporary file which is then renamed so that a
+// partially written manifest is never left at path.
+func (m *Manifest) Write(path string) error {
+
+	body, err := json.MarshalIndent(m, "", "  ")
+
+	if err != nil {
+		return fmt.Errorf("Failed to encode manifest, %w", err)
+	}
+
+	return writeFile(path, append(body, '\n'))
+}
+
+// writeFile writes body to a temporary file which is then renamed to path so that a partially written file
+// is never left at path.
+func writeFile(path string, body []byte) error {
+
+	tmp := path + ".tmp"
+
+	err := os.WriteFile(tmp, body, 0644)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write %s, %w", path, err)
+	}
+
+	err = os.Rename(tmp, path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write %s, %w", path, err)
+	}
+
+	return nil
+}
+
+// Lookup returns the part of m whose path is name, or nil if there is none.
+func (m *Manifest) Lookup(name string) *Part {
+
+	for _, p := range m.Parts {
+
+		if p.Path == name {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// Verify checks that the file for p in the directory dir has the size and SHA-256 digest recorded in the
+// manifest, returning an error describing the first difference if not.
+func (p *Part) Verify(dir string) error {
+
+	path, err := p.Resolve(dir)
+
+	if err != nil {
+		return err
+	}
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to open %s, %w", p.Path, err)
+	}
+
+	defer fh.Close()
+
+	info, err := fh.Stat()
+
+	if err != nil {
+		return fmt.Errorf("Failed to stat %s, %w", p.Path, err)
+	}
+
+	if info.Size() != p.Bytes {
+		return fmt.Errorf("%s is %d bytes, expected %d", p.Path, info.Size(), p.Bytes)
+	}
+
+	h := sha256.New()
+
+	_, err = io.Copy(h, fh)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read %s, %w", p.Path, err)
+	}
+
+	digest := hex.EncodeToString(h.Sum(nil))
+
+	if digest != p.SHA256 {
+		return fmt.Errorf("%s has SHA-256 %s, expected %s", p.Path, digest, p.SHA256)
+	}
+
+	return nil
+}
+
+// Resolve returns the path of the file for p in the directory dir. Parts must be inside dir.
+func (p *Part) Resolve(dir string) (string, error) {
+
+	if !filepath.IsLocal(filepath.FromSlash(p.Path)) {
+		return "", fmt.Errorf("Invalid part path '%s'", p.Path)
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(p.Path)), nil
+}
+
+// PartWriter writes records to a new part, counting them and computing the size and digest of the file.
+type PartWriter struct {
+	part *Part
+	fh   *os.File
+	hash hash.Hash
+	cw   *countWriter
+	wc   io.WriteCloser
+	ew   *crypt.Writer
+	bw   *bufio.Writer
+}
+
+// CreatePart creates (or truncates) the part name in the directory dir, compressing it if name ends in
+// ".bz2" or ".gz" and then, if secret is not nil, encrypting it. The name of an encrypted part should end in
+// crypt.EXTENSION after any compression extension.
+func CreatePart(dir string, name string, secret *crypt.Secret) (*PartWriter, error) {
+
+	path := filepath.Join(dir, name)
+	fh, err := os.Create(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create %s, %w", path, err)
+	}
+
+	h := sha256.New()
+	cw := &countWriter{w: io.MultiWriter(fh, h)}
+
+	var ew *crypt.Writer
+	var w io.Writer = cw
+
+	if secret != nil {
+
+		ew, err = crypt.NewWriter(cw, secret)
+
+		if err != nil {
+			fh.Close()
+			return nil, fmt.Errorf("Failed to encrypt %s, %w", path, err)
+		}
+
+		w = ew
+	}
+
+	wc := record.NewWriter(w, strings.TrimSuffix(name, crypt.EXTENSION))
+
+	pw := &PartWriter{
+		part: &Part{Path: filepath.ToSlash(name)},
+		fh:   fh,
+		hash: h,
+		cw:   cw,
+		wc:   wc,
+		ew:   ew,
+		bw:   bufio.NewWriter(wc),
+	}
+
+	return pw, nil
+}
+
+// Records returns the number of records written so far.
+func (w *PartWriter) Records() int {
+	return w.part.Records
+}
+
+// WriteRecord writes line, followed by a newline.
+func (w *PartWriter) WriteRecord(line []byte) error {
+
+	_, err := w.bw.Write(line)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write %s, %w", w.part.Path, err)
+	}
+
+	err = w.bw.WriteByte('\n')
+
+	if err != nil {
+		return fmt.Errorf("Failed to write %s, %w", w.part.Path, err)
+	}
+
+	w.part.Records += 1
+	return nil
+}
+
+// Close finishes writing the part, syncs it to disk and returns its description.
+func (w *PartWriter) Close() (*Part, error) {
+
+	err := w.bw.Flush()
+
+	if err == nil {
+		err = w.wc.Close()
+	}
+
+	if err == nil && w.ew != nil {
+		err = w.ew.Close()
+	}
+
+	if err == nil {
+		err = w.fh.Sync()
+	}
+
+	if err != nil {
+		w.fh.Close()
+		return nil, fmt.Errorf("Failed to write %s, %w", w.part.Path, err)
+	}
+
+	err = w.fh.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to close %s, %w", w.part.Path, err)
+	}
+
+	w.part.Bytes = w.cw.n
+	w.part.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+
+	return w.part, nil
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,249 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/record"
+)
+
+func TestWriteRead(t *testing.T) {
+
+	dir := t.TempDir()
+
+	m := &Manifest{
+		Version:  VERSION,
+		Index:    "millsfield",
+		Query:    json.RawMessage(`{"match_all":{}}`),
+		Cluster:  "elasticsearch 7.17.7",
+		Started:  time.Date(2026, 10, 19, 11, 0, 0, 0, time.UTC),
+		Finished: time.Date(2026, 10, 19, 11, 5, 0, 0, time.UTC),
+		Records:  3,
+		Parts: []*Part{
+			{Path: "part-0000.jsonl.bz2", Records: 2, Bytes: 100, SHA256: "abc"},
+			{Path: "part-0001.jsonl.bz2", Records: 1, Bytes: 50, SHA256: "def"},
+		},
+	}
+
+	err := m.Write(filepath.Join(dir, FILENAME))
+
+	if err != nil {
+		t.Fatalf("Failed to write manifest, %v", err)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, FILENAME+".tmp"))
+
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected the temporary file to be renamed")
+	}
+
+	// Manifests can be read from their directory or their path
+	for _, path := range []string{dir, filepath.Join(dir, FILENAME)} {
+
+		read, err := Read(path)
+
+		if err != nil {
+			t.Fatalf("Failed to read manifest from %s, %v", path, err)
+		}
+
+		var query bytes.Buffer
+
+		err = json.Compact(&query, read.Query)
+
+		if err != nil {
+			t.Fatalf("Failed to compact query, %v", err)
+		}
+
+		if read.Index != m.Index || read.Records != 3 || len(read.Parts) != 2 || !read.Started.Equal(m.Started) || query.String() != `{"match_all":{}}` {
+			t.Fatalf("Unexpected manifest %+v", read)
+		}
+
+		p := read.Lookup("part-0001.jsonl.bz2")
+
+		if p == nil || p.Records != 1 || p.SHA256 != "def" {
+			t.Fatalf("Unexpected part %+v", p)
+		}
+
+		if read.Lookup("part-0002.jsonl.bz2") != nil {
+			t.Fatalf("Expected a missing part not to be found")
+		}
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+
+	dir := t.TempDir()
+
+	tests := map[string]string{
+		"missing version": `{"index":"a"}`,
+		"newer version":   `{"version":2}`,
+		"not an object":   `null`,
+		"invalid JSON":    `{"version":`,
+	}
+
+	for name, body := range tests {
+
+		path := filepath.Join(dir, FILENAME)
+
+		err := os.WriteFile(path, []byte(body), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write manifest, %v", err)
+		}
+
+		_, err = Read(dir)
+
+		if err == nil {
+			t.Fatalf("Expected an error reading a manifest with %s", name)
+		}
+	}
+
+	_, err := Read(filepath.Join(dir, "missing"))
+
+	if err == nil {
+		t.Fatalf("Expected an error reading a missing manifest")
+	}
+}
+
+func TestPartVerify(t *testing.T) {
+
+	dir := t.TempDir()
+	body := []byte("{\"_id\":\"1\",\"_source\":{}}\n")
+
+	err := os.WriteFile(filepath.Join(dir, "part.jsonl"), body, 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write part, %v", err)
+	}
+
+	digest := sha256.Sum256(body)
+
+	p := &Part{
+		Path:   "part.jsonl",
+		Bytes:  int64(len(body)),
+		SHA256: hex.EncodeToString(digest[:]),
+	}
+
+	err = p.Verify(dir)
+
+	if err != nil {
+		t.Fatalf("Failed to verify part, %v", err)
+	}
+
+	wrong_size := *p
+	wrong_size.Bytes += 1
+
+	err = wrong_size.Verify(dir)
+
+	if err == nil || !strings.Contains(err.Error(), "bytes") {
+		t.Fatalf("Expected a size mismatch, got %v", err)
+	}
+
+	wrong_digest := *p
+	wrong_digest.SHA256 = strings.Repeat("0", 64)
+
+	err = wrong_digest.Verify(dir)
+
+	if err == nil || !strings.Contains(err.Error(), "SHA-256") {
+		t.Fatalf("Expected a digest mismatch, got %v", err)
+	}
+
+	missing := *p
+	missing.Path = "missing.jsonl"
+
+	err = missing.Verify(dir)
+
+	if err == nil {
+		t.Fatalf("Expected an error verifying a missing part")
+	}
+}
+
+func TestPartResolve(t *testing.T) {
+
+	dir := t.TempDir()
+
+	path, err := (&Part{Path: "parts/part-0000.jsonl"}).Resolve(dir)
+
+	if err != nil || path != filepath.Join(dir, "parts", "part-0000.jsonl") {
+		t.Fatalf("Unexpected path %s, %v", path, err)
+	}
+
+	// Parts must be inside the directory of the manifest
+	for _, name := range []string{"../part.jsonl", "/etc/passwd", "parts/../../part.jsonl", ""} {
+
+		_, err := (&Part{Path: name}).Resolve(dir)
+
+		if err == nil {
+			t.Fatalf("Expected %s to be refused", name)
+		}
+	}
+}
+
+func TestCreatePart(t *testing.T) {
+
+	dir := t.TempDir()
+
+	for _, name := range []string{"part.jsonl", "part.jsonl.gz", "part.jsonl.bz2"} {
+
+		pw, err := CreatePart(dir, name, nil)
+
+		if err != nil {
+			t.Fatalf("Failed to create %s, %v", name, err)
+		}
+
+		for _, line := range []string{`{"_id":"1","_source":{}}`, `{"_id":"2","_source":{}}`} {
+
+			err := pw.WriteRecord([]byte(line))
+
+			if err != nil {
+				t.Fatalf("Failed to write %s, %v", name, err)
+			}
+		}
+
+		if pw.Records() != 2 {
+			t.Fatalf("Expected 2 records, got %d", pw.Records())
+		}
+
+		p, err := pw.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to close %s, %v", name, err)
+		}
+
+		if p.Path != name || p.Records != 2 {
+			t.Fatalf("Unexpected part %+v", p)
+		}
+
+		// The size and digest are those of the file, after any compression
+		err = p.Verify(dir)
+
+		if err != nil {
+			t.Fatalf("Failed to verify %s, %v", name, err)
+		}
+
+		fh, err := record.Open(filepath.Join(dir, name))
+
+		if err != nil {
+			t.Fatalf("Failed to open %s, %v", name, err)
+		}
+
+		out, err := io.ReadAll(fh)
+		fh.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to read %s, %v", name, err)
+		}
+
+		if string(out) != "{\"_id\":\"1\",\"_source\":{}}\n{\"_id\":\"2\",\"_source\":{}}\n" {
+			t.Fatalf("Unexpected contents of %s, %s", name, out)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package manifest
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The suffix added to the path of a manifest for the path of its signature.
+const SIGNATURE_SUFFIX string = ".sig"
+
+// ReadPrivateKey reads an ed25519 private key from the PEM-encoded PKCS #8 file at path, as written by
+// `openssl genpkey -algorithm ed25519`.
+func ReadPrivateKey(path string) (ed25519.PrivateKey, error) {
+
+	der, err := readPEM(path, "PRIVATE KEY")
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse private key %s, %w", path, err)
+	}
+
+	ed_key, ok := key.(ed25519.PrivateKey)
+
+	if !ok {
+		return nil, fmt.Errorf("Invalid private key %s, not an ed25519 key", path)
+	}
+
+	return ed_key, nil
+}
+
+// ReadPublicKey reads an ed25519 public key from the PEM-encoded PKIX file at path, as written by
+// `openssl pkey -pubout`.
+func ReadPublicKey(path string) (ed25519.PublicKey, error) {
+
+	der, err := readPEM(path, "PUBLIC KEY")
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse public key %s, %w", path, err)
+	}
+
+	ed_key, ok := key.(ed25519.PublicKey)
+
+	if !ok {
+		return nil, fmt.Errorf("Invalid public key %s, not an ed25519 key", path)
+	}
+
+	return ed_key, nil
+}
+
+func readPEM(path string, block_type string) ([]byte, error) {
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read key, %w", err)
+	}
+
+	block, _ := pem.Decode(body)
+
+	if block == nil || block.Type != block_type {
+		return nil, fmt.Errorf("Invalid key %s, expected a PEM-encoded %s", path, block_type)
+	}
+
+	return block.Bytes, nil
+}
+
+// Sign signs the manifest at path, which may be a dump directory or the manifest file itself, with key. The
+// signature covers the exact bytes of the manifest file and is written, base64-encoded, to a file alongside
+// it with SIGNATURE_SUFFIX added to its name.
+func Sign(path string, key ed25519.PrivateKey) error {
+
+	path, body, err := readFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	sig := ed25519.Sign(key, body)
+	enc_sig := base64.StdEncoding.EncodeToString(sig) + "\n"
+
+	return writeFile(path+SIGNATURE_SUFFIX, []byte(enc_sig))
+}
+
+// ReadSigned reads the manifest at path, which may be a dump directory or the manifest file itself, after
+// checking its signature against key. An error is returned if the signature is missing or does not match.
+func ReadSigned(path string, key ed25519.PublicKey) (*Manifest, error) {
+
+	path, body, err := readFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	enc_sig, err := os.ReadFile(path + SIGNATURE_SUFFIX)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read manifest signature, %w", err)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(enc_sig)))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode manifest signature %s, %w", path+SIGNATURE_SUFFIX, err)
+	}
+
+	if !ed25519.Verify(key, body, sig) {
+		return nil, fmt.Errorf("Invalid manifest signature %s, the manifest was not signed by the trusted key or has been changed since", path+SIGNATURE_SUFFIX)
+	}
+
+	return decode(path, body)
+}
@@ -0,0 +1,251 @@
+package manifest
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writePEM writes der to a PEM-encoded file of the given block type in dir and returns its path.
+func writePEM(t *testing.T, dir string, name string, block_type string, der []byte) string {
+
+	path := filepath.Join(dir, name)
+	body := pem.EncodeToMemory(&pem.Block{Type: block_type, Bytes: der})
+
+	err := os.WriteFile(path, body, 0600)
+
+	if err != nil {
+		t.Fatalf("Failed to write %s, %v", path, err)
+	}
+
+	return path
+}
+
+// writeKeys generates an ed25519 key pair and writes it to dir, returning the paths of the private and
+// public keys.
+func writeKeys(t *testing.T, dir string, prefix string) (string, string) {
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatalf("Failed to generate key, %v", err)
+	}
+
+	priv_der, err := x509.MarshalPKCS8PrivateKey(priv)
+
+	if err != nil {
+		t.Fatalf("Failed to encode private key, %v", err)
+	}
+
+	pub_der, err := x509.MarshalPKIXPublicKey(pub)
+
+	if err != nil {
+		t.Fatalf("Failed to encode public key, %v", err)
+	}
+
+	priv_path := writePEM(t, dir, prefix+".pem", "PRIVATE KEY", priv_der)
+	pub_path := writePEM(t, dir, prefix+".pub", "PUBLIC KEY", pub_der)
+
+	return priv_path, pub_path
+}
+
+// writeSigned writes a manifest to dir and signs it with the private key at priv_path.
+func writeSigned(t *testing.T, dir string, priv_path string) {
+
+	m := &Manifest{
+		Version: VERSION,
+		Index:   "millsfield",
+		Records: 1,
+		Parts: []*Part{
+			{Path: "part-0000.jsonl", Records: 1, Bytes: 10, SHA256: "abc"},
+		},
+	}
+
+	err := m.Write(filepath.Join(dir, FILENAME))
+
+	if err != nil {
+		t.Fatalf("Failed to write manifest, %v", err)
+	}
+
+	priv, err := ReadPrivateKey(priv_path)
+
+	if err != nil {
+		t.Fatalf("Failed to read private key, %v", err)
+	}
+
+	err = Sign(dir, priv)
+
+	if err != nil {
+		t.Fatalf("Failed to sign manifest, %v", err)
+	}
+}
+
+func TestSignReadSigned(t *testing.T) {
+
+	keys := t.TempDir()
+	dir := t.TempDir()
+
+	priv_path, pub_path := writeKeys(t, keys, "trusted")
+	writeSigned(t, dir, priv_path)
+
+	pub, err := ReadPublicKey(pub_path)
+
+	if err != nil {
+		t.Fatalf("Failed to read public key, %v", err)
+	}
+
+	// Signed manifests can be read from their directory or their path
+	for _, path := range []string{dir, filepath.Join(dir, FILENAME)} {
+
+		m, err := ReadSigned(path, pub)
+
+		if err != nil {
+			t.Fatalf("Failed to read signed manifest from %s, %v", path, err)
+		}
+
+		if m.Index != "millsfield" || len(m.Parts) != 1 {
+			t.Fatalf("Unexpected manifest %+v", m)
+		}
+	}
+}
+
+func TestReadSignedInvalid(t *testing.T) {
+
+	keys := t.TempDir()
+
+	priv_path, pub_path := writeKeys(t, keys, "trusted")
+	_, other_path := writeKeys(t, keys, "other")
+
+	pub, err := ReadPublicKey(pub_path)
+
+	if err != nil {
+		t.Fatalf("Failed to read public key, %v", err)
+	}
+
+	other, err := ReadPublicKey(other_path)
+
+	if err != nil {
+		t.Fatalf("Failed to read public key, %v", err)
+	}
+
+	tests := map[string]func(dir string) ed25519.PublicKey{
+		"wrong key": func(dir string) ed25519.PublicKey {
+			return other
+		},
+		"changed manifest": func(dir string) ed25519.PublicKey {
+
+			path := filepath.Join(dir, FILENAME)
+			body, err := os.ReadFile(path)
+
+			if err != nil {
+				t.Fatalf("Failed to read manifest, %v", err)
+			}
+
+			body = append(body, ' ')
+
+			err = os.WriteFile(path, body, 0644)
+
+			if err != nil {
+				t.Fatalf("Failed to write manifest, %v", err)
+			}
+
+			return pub
+		},
+		"missing signature": func(dir string) ed25519.PublicKey {
+
+			err := os.Remove(filepath.Join(dir, FILENAME+SIGNATURE_SUFFIX))
+
+			if err != nil {
+				t.Fatalf("Failed to remove signature, %v", err)
+			}
+
+			return pub
+		},
+		"corrupt signature": func(dir string) ed25519.PublicKey {
+
+			err := os.WriteFile(filepath.Join(dir, FILENAME+SIGNATURE_SUFFIX), []byte("not base64!\n"), 0644)
+
+			if err != nil {
+				t.Fatalf("Failed to write signature, %v", err)
+			}
+
+			return pub
+		},
+	}
+
+	for name, setup := range tests {
+
+		dir := t.TempDir()
+		writeSigned(t, dir, priv_path)
+
+		key := setup(dir)
+
+		_, err := ReadSigned(dir, key)
+
+		if err == nil {
+			t.Fatalf("Expected an error reading a manifest with a %s", name)
+		}
+	}
+}
+
+func TestReadKeyInvalid(t *testing.T) {
+
+	dir := t.TempDir()
+
+	priv_path, pub_path := writeKeys(t, dir, "trusted")
+
+	// Each key is rejected where the other is expected
+	_, err := ReadPrivateKey(pub_path)
+
+	if err == nil {
+		t.Fatalf("Expected an error reading a public key as a private key")
+	}
+
+	_, err = ReadPublicKey(priv_path)
+
+	if err == nil {
+		t.Fatalf("Expected an error reading a private key as a public key")
+	}
+
+	ec_key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("Failed to generate key, %v", err)
+	}
+
+	ec_priv_der, err := x509.MarshalPKCS8PrivateKey(ec_key)
+
+	if err != nil {
+		t.Fatalf("Failed to encode private key, %v", err)
+	}
+
+	ec_pub_der, err := x509.MarshalPKIXPublicKey(&ec_key.PublicKey)
+
+	if err != nil {
+		t.Fatalf("Failed to encode public key, %v", err)
+	}
+
+	_, err = ReadPrivateKey(writePEM(t, dir, "ec.pem", "PRIVATE KEY", ec_priv_der))
+
+	if err == nil {
+		t.Fatalf("Expected an error reading a private key which is not an ed25519 key")
+	}
+
+	_, err = ReadPublicKey(writePEM(t, dir, "ec.pub", "PUBLIC KEY", ec_pub_der))
+
+	if err == nil {
+		t.Fatalf("Expected an error reading a public key which is not an ed25519 key")
+	}
+
+	_, err = ReadPublicKey(filepath.Join(dir, "missing.pub"))
+
+	if err == nil {
+		t.Fatalf("Expected an error reading a key which does not exist")
+	}
+}
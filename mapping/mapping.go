@@ -0,0 +1,501 @@
+// package mapping provides methods for inferring Elasticsearch mappings from the documents in a dump and for
+// checking documents against them.
+package mapping
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/fields"
+)
+
+// The date formats recognized in string values, in the order they are listed in a mapping's format. The
+// first is the default format of date fields and is left out of mappings when it is the only one observed.
+const (
+	ISO_DATE_FORMAT   string = "strict_date_optional_time"
+	SLASH_DATE_FORMAT string = "yyyy/MM/dd HH:mm:ss||yyyy/MM/dd"
+)
+
+// The maximum number of distinct string values to keep for each field when deciding between keyword and text.
+const max_distinct int = 1000
+
+var re_iso_date = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})(T\d{2}(:\d{2}(:\d{2}([.,]\d{1,9})?)?)?(Z|[+-]\d{2}(:?\d{2})?)?)?$`)
+
+var re_slash_date = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2})( \d{2}:\d{2}:\d{2})?$`)
+
+var re_geo_string = regexp.MustCompile(`^\s*(-?\d+(?:\.\d+)?)\s*,\s*(-?\d+(?:\.\d+)?)\s*$`)
+
+var re_geo_wkt = regexp.MustCompile(`^POINT\s*\(\s*(-?\d+(?:\.\d+)?)\s+(-?\d+(?:\.\d+)?)\s*\)$`)
+
+// Options configures how types are chosen for the fields of a mapping.
+type Options struct {
+	// Nested maps arrays of objects to the nested type, so that the properties of each object can be queried
+	// together, rather than the object type.
+	Nested bool
+	// KeywordMaxLength is the length above which a string field is mapped as text rather than keyword.
+	KeywordMaxLength int
+}
+
+// Inferrer accumulates the values observed in documents and infers a mapping for them. It is not safe
+// for concurrent use.
+type Inferrer struct {
+	opts      *Options
+	root      *field
+	documents int
+}
+
+// field records the values observed at a single (dotted) path.
+type field struct {
+	children map[string]*field
+	objects  int
+	// array_objects is true if any of the objects was an element of an array.
+	array_objects bool
+	// geo_objects counts the objects with only numeric "lat" and "lon" properties.
+	geo_objects int
+	booleans    int
+	numbers     int
+	floats      int
+	min_int     int64
+	max_int     int64
+	strings     int
+	dates       int
+	formats     map[string]bool
+	geo_strings int
+	max_length  int
+	// phrases counts the strings which contain whitespace.
+	phrases  int
+	distinct map[string]bool
+}
+
+// NewInferrer returns a new Inferrer configured by opts.
+func NewInferrer(opts *Options) *Inferrer {
+
+	i := &Inferrer{
+		opts: opts,
+		root: newField(),
+	}
+
+	return i
+}
+
+func newField() *field {
+
+	f := &field{
+		children: make(map[string]*field),
+		formats:  make(map[string]bool),
+		distinct: make(map[string]bool),
+	}
+
+	return f
+}
+
+// Documents returns the number of documents added so far.
+func (i *Inferrer) Documents() int {
+	return i.documents
+}
+
+// AddDocument records the values of the JSON object source.
+func (i *Inferrer) AddDocument(source []byte) {
+
+	i.documents += 1
+
+	fields.Walk(source, func(path string, value gjson.Result) {
+
+		f := i.root
+		in_array := false
+
+		// Elasticsearch treats dots in property names as object paths and arrays as multiple values
+		// of the same field, so "a.b[]" and "a.b" are the same field
+		for _, name := range strings.Split(path, ".") {
+
+			in_array = false
+
+			for strings.HasSuffix(name, fields.ARRAY_SUFFIX) {
+				in_array = true
+				name = strings.TrimSuffix(name, fields.ARRAY_SUFFIX)
+			}
+
+			child, ok := f.children[name]
+
+			if !ok {
+				child = newField()
+				f.children[name] = child
+			}
+
+			f = child
+		}
+
+		f.add(value, in_array)
+	})
+}
+
+func (f *field) add(value gjson.Result, in_array bool) {
+
+	switch fields.Type(value) {
+	case fields.OBJECT:
+
+		f.objects += 1
+
+		if in_array {
+			f.array_objects = true
+		}
+
+		if isGeoObject(value) {
+			f.geo_objects += 1
+		}
+
+	case fields.BOOLEAN:
+		f.booleans += 1
+	case fields.NUMBER:
+
+		f.numbers += 1
+
+		if strings.ContainsAny(value.Raw, ".eE") {
+			f.floats += 1
+			return
+		}
+
+		v, err := strconv.ParseInt(value.Raw, 10, 64)
+
+		// Too large for a long
+		if err != nil {
+			f.floats += 1
+			return
+		}
+
+		if f.numbers-f.floats == 1 || v < f.min_int {
+			f.min_int = v
+		}
+
+		if f.numbers-f.floats == 1 || v > f.max_int {
+			f.max_int = v
+		}
+
+	case fields.STRING:
+
+		s := value.String()
+		f.strings += 1
+
+		if len(s) > f.max_length {
+			f.max_length = len(s)
+		}
+
+		if strings.ContainsAny(s, " \t\r\n") {
+			f.phrases += 1
+		}
+
+		if len(f.distinct) < max_distinct {
+			f.distinct[s] = true
+		}
+
+		format, ok := dateFormat(s)
+
+		if ok {
+			f.dates += 1
+			f.formats[format] = true
+		}
+
+		if isGeoString(s) {
+			f.geo_strings += 1
+		}
+	}
+
+	// Nulls and arrays (whose elements are added separately) do not affect the mapping
+}
+
+// Mappings returns the inferred mappings, in the form expected by the "mappings" property of a create
+// index request.
+func (i *Inferrer) Mappings() map[string]interface{} {
+
+	m := map[string]interface{}{
+		"properties": i.properties(i.root),
+	}
+
+	return m
+}
+
+// Conflicts returns a description of every field whose values could not all be indexed using a single type,
+// and the type that was chosen for it.
+func (i *Inferrer) Conflicts() []string {
+
+	conflicts := make([]string, 0)
+	i.conflicts("", i.root, &conflicts)
+
+	return conflicts
+}
+
+func (i *Inferrer) conflicts(prefix string, f *field, conflicts *[]string) {
+
+	for _, name := range sortedNames(f) {
+
+		child := f.children[name]
+		path := joinPath(prefix, name)
+
+		types := make([]string, 0)
+
+		if child.objects > 0 && child.objects > child.geo_objects {
+			types = append(types, fields.OBJECT)
+		}
+
+		if child.strings > 0 {
+			types = append(types, fields.STRING)
+		}
+
+		if child.numbers > 0 {
+			types = append(types, fields.NUMBER)
+		}
+
+		if child.booleans > 0 {
+			types = append(types, fields.BOOLEAN)
+		}
+
+		if len(types) > 1 {
+
+			t, ok := i.field(child)["type"]
+
+			if !ok {
+				t = fields.OBJECT
+			}
+
+			*conflicts = append(*conflicts, fmt.Sprintf("%s has %s values, mapped as %s", path, strings.Join(types, ", "), t))
+		}
+
+		i.conflicts(path, child, conflicts)
+	}
+}
+
+func (i *Inferrer) properties(f *field) map[string]interface{} {
+
+	props := make(map[string]interface{})
+
+	for name, child := range f.children {
+
+		m := i.field(child)
+
+		if m != nil {
+			props[name] = m
+		}
+	}
+
+	return props
+}
+
+// field returns the mapping for f, or nil if only null values (or empty arrays) were observed.
+func (i *Inferrer) field(f *field) map[string]interface{} {
+
+	scalars := f.booleans + f.numbers + f.strings
+
+	switch {
+	case f.objects > 0 && f.geo_objects+f.geo_strings == f.objects+scalars:
+		return map[string]interface{}{"type": "geo_point"}
+	case f.objects > 0:
+
+		// Objects can not be indexed into any other type so scalar values are a conflict. Use the object
+		// mapping and let Elasticsearch reject the documents with scalars.
+
+		m := map[string]interface{}{
+			"properties": i.properties(f),
+		}
+
+		if f.array_objects && i.opts.Nested {
+			m["type"] = "nested"
+		}
+
+		return m
+
+	case f.strings > 0:
+		return i.stringField(f)
+	case f.numbers > 0:
+		return numberField(f)
+	case f.booleans > 0:
+		return map[string]interface{}{"type": fields.BOOLEAN}
+	}
+
+	return nil
+}
+
+func (i *Inferrer) stringField(f *field) map[string]interface{} {
+
+	// Numbers and booleans can be indexed as any of these types, except geo_point
+
+	if f.dates == f.strings && f.numbers == 0 && f.booleans == 0 {
+
+		m := map[string]interface{}{
+			"type": "date",
+		}
+
+		if len(f.formats) > 1 || !f.formats[ISO_DATE_FORMAT] {
+
+			formats := make([]string, 0)
+
+			for _, format := range []string{ISO_DATE_FORMAT, SLASH_DATE_FORMAT} {
+
+				if f.formats[format] {
+					formats = append(formats, format)
+				}
+			}
+
+			m["format"] = strings.Join(formats, "||")
+		}
+
+		return m
+	}
+
+	if f.geo_strings == f.strings && f.numbers == 0 && f.booleans == 0 {
+		return map[string]interface{}{"type": "geo_point"}
+	}
+
+	if !i.isText(f) {
+		return map[string]interface{}{"type": "keyword"}
+	}
+
+	m := map[string]interface{}{
+		"type": "text",
+		"fields": map[string]interface{}{
+			"keyword": map[string]interface{}{
+				"type":         "keyword",
+				"ignore_above": 256,
+			},
+		},
+	}
+
+	return m
+}
+
+// isText reports whether the strings observed in f look like prose rather than identifiers, codes or
+// labels: either any of them is longer than KeywordMaxLength or most of them contain whitespace and
+// they are not often repeated.
+func (i *Inferrer) isText(f *field) bool {
+
+	if f.max_length > i.opts.KeywordMaxLength {
+		return true
+	}
+
+	if f.phrases*2 < f.strings {
+		return false
+	}
+
+	// Past max_distinct values the field is treated as having high cardinality
+	return len(f.distinct) >= max_distinct || len(f.distinct)*2 > f.strings
+}
+
+// numberField returns the narrowest numeric type which can hold every value observed in f.
+func numberField(f *field) map[string]interface{} {
+
+	t := "long"
+
+	switch {
+	case f.floats > 0:
+		t = "double"
+	case f.min_int >= math.MinInt32 && f.max_int <= math.MaxInt32:
+		t = "integer"
+	}
+
+	return map[string]interface{}{"type": t}
+}
+
+// dateFormat returns the format of the date s and true, or false if s is not a date.
+func dateFormat(s string) (string, bool) {
+
+	m := re_iso_date.FindStringSubmatch(s)
+
+	if m != nil {
+
+		_, err := time.Parse("2006-01-02", m[1])
+		return ISO_DATE_FORMAT, err == nil
+	}
+
+	m = re_slash_date.FindStringSubmatch(s)
+
+	if m != nil {
+
+		_, err := time.Parse("2006/01/02", m[1])
+		return SLASH_DATE_FORMAT, err == nil
+	}
+
+	return "", false
+}
+
+// isGeoString reports whether s is a point in one of the string forms accepted by geo_point fields:
+// "lat,lon" or "POINT (lon lat)".
+func isGeoString(s string) bool {
+
+	m := re_geo_string.FindStringSubmatch(s)
+
+	// Require a decimal point so that numbers like "1,000" are not mistaken for points
+	if m != nil && strings.Contains(s, ".") {
+		return isLatLon(m[1], m[2])
+	}
+
+	m = re_geo_wkt.FindStringSubmatch(s)
+
+	if m != nil {
+		return isLatLon(m[2], m[1])
+	}
+
+	return false
+}
+
+// isGeoObject reports whether value is an object with only numeric "lat" and "lon" properties.
+func isGeoObject(value gjson.Result) bool {
+
+	props := value.Map()
+
+	if len(props) != 2 {
+		return false
+	}
+
+	lat, lat_ok := props["lat"]
+	lon, lon_ok := props["lon"]
+
+	if !lat_ok || !lon_ok || lat.Type != gjson.Number || lon.Type != gjson.Number {
+		return false
+	}
+
+	return isLatLon(lat.Raw, lon.Raw)
+}
+
+func isLatLon(lat string, lon string) bool {
+
+	lat_f, err := strconv.ParseFloat(lat, 64)
+
+	if err != nil {
+		return false
+	}
+
+	lon_f, err := strconv.ParseFloat(lon, 64)
+
+	if err != nil {
+		return false
+	}
+
+	return lat_f >= -90 && lat_f <= 90 && lon_f >= -180 && lon_f <= 180
+}
+
+func sortedNames(f *field) []string {
+
+	names := make([]string, 0, len(f.children))
+
+	for name := range f.children {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func joinPath(prefix string, name string) string {
+
+	if prefix == "" {
+		return name
+	}
+
+	return prefix + "." + name
+}
@@ -0,0 +1,172 @@
+package mapping
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func inferMappings(opts *Options, docs ...string) *Inferrer {
+
+	i := NewInferrer(opts)
+
+	for _, doc := range docs {
+		i.AddDocument([]byte(doc))
+	}
+
+	return i
+}
+
+func TestInferrer(t *testing.T) {
+
+	opts := &Options{
+		KeywordMaxLength: 32,
+	}
+
+	i := inferMappings(opts,
+		`{"count":1,"big":1,"ratio":1,"flag":true,"code":"SFO","title":"The Tin Drum","created":"2024-01-02","updated":"2024/01/02 10:00:00","point":"37.6,-122.4","obj":{"lat":37.6,"lon":-122.4},"tags":["a","b"],"empty":null}`,
+		`{"count":2,"big":3000000000,"ratio":1.5,"flag":false,"code":"OAK","title":"Cat and Mouse","created":"2024-02-03T10:00:00Z","updated":"2024/02/03","point":"POINT (-122.4 37.6)","obj":{"lat":37.7,"lon":-122.3},"tags":[],"empty":[]}`,
+		`{"a.b":1,"a":{"c":"x"}}`,
+	)
+
+	if i.Documents() != 3 {
+		t.Fatalf("Expected 3 documents, got %d", i.Documents())
+	}
+
+	enc, err := json.Marshal(i.Mappings())
+
+	if err != nil {
+		t.Fatalf("Failed to encode mappings, %v", err)
+	}
+
+	// Fields with only null values or empty arrays are left out, and dotted property names are merged with
+	// the objects they name
+	expected := `{"properties":{` +
+		`"a":{"properties":{"b":{"type":"integer"},"c":{"type":"keyword"}}},` +
+		`"big":{"type":"long"},` +
+		`"code":{"type":"keyword"},` +
+		`"count":{"type":"integer"},` +
+		`"created":{"type":"date"},` +
+		`"flag":{"type":"boolean"},` +
+		`"obj":{"type":"geo_point"},` +
+		`"point":{"type":"geo_point"},` +
+		`"ratio":{"type":"double"},` +
+		`"tags":{"type":"keyword"},` +
+		`"title":{"fields":{"keyword":{"ignore_above":256,"type":"keyword"}},"type":"text"},` +
+		`"updated":{"format":"yyyy/MM/dd HH:mm:ss||yyyy/MM/dd","type":"date"}` +
+		`}}`
+
+	if string(enc) != expected {
+		t.Fatalf("Unexpected mappings %s", enc)
+	}
+
+	if len(i.Conflicts()) != 0 {
+		t.Fatalf("Unexpected conflicts %v", i.Conflicts())
+	}
+}
+
+func TestInferrerNested(t *testing.T) {
+
+	docs := []string{
+		`{"people":[{"name":"a"},{"name":"b"}]}`,
+	}
+
+	for _, nested := range []bool{false, true} {
+
+		i := inferMappings(&Options{Nested: nested, KeywordMaxLength: 32}, docs...)
+
+		people := i.Mappings()["properties"].(map[string]interface{})["people"].(map[string]interface{})
+
+		if (people["type"] == "nested") != nested {
+			t.Fatalf("Expected nested to be %t, got %v", nested, people)
+		}
+	}
+}
+
+func TestInferrerConflicts(t *testing.T) {
+
+	i := inferMappings(&Options{KeywordMaxLength: 32},
+		`{"a":1,"b":{"c":1},"d":"2024-01-01","e":"x"}`,
+		`{"a":"x","b":"y","d":5,"e":true}`,
+	)
+
+	expected := []string{
+		"a has string, number values, mapped as keyword",
+		"b has object, string values, mapped as object",
+		"d has string, number values, mapped as keyword",
+		"e has string, boolean values, mapped as keyword",
+	}
+
+	if strings.Join(i.Conflicts(), "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected conflicts %v", i.Conflicts())
+	}
+}
+
+func TestInferrerText(t *testing.T) {
+
+	// Long strings are text regardless of whether they contain whitespace
+	i := inferMappings(&Options{KeywordMaxLength: 8}, `{"a":"abcdefghijk"}`)
+
+	if i.field(i.root.children["a"])["type"] != "text" {
+		t.Fatalf("Expected a long string to be text")
+	}
+
+	// Short phrases which are often repeated are labels rather than prose
+	docs := make([]string, 0)
+
+	for n := 0; n < 10; n++ {
+		docs = append(docs, `{"a":"Art Gallery"}`)
+	}
+
+	i = inferMappings(&Options{KeywordMaxLength: 32}, docs...)
+
+	if i.field(i.root.children["a"])["type"] != "keyword" {
+		t.Fatalf("Expected a repeated phrase to be a keyword")
+	}
+}
+
+func TestDateFormat(t *testing.T) {
+
+	tests := map[string]string{
+		"2024-01-02":                     ISO_DATE_FORMAT,
+		"2024-01-02T10":                  ISO_DATE_FORMAT,
+		"2024-01-02T10:11:12.123456789Z": ISO_DATE_FORMAT,
+		"2024-01-02T10:11:12+0800":       ISO_DATE_FORMAT,
+		"2024/01/02":                     SLASH_DATE_FORMAT,
+		"2024/01/02 10:11:12":            SLASH_DATE_FORMAT,
+		"2024-13-45":                     "",
+		"2024-01-02 10:11:12":            "",
+		"20240102":                       "",
+		"yesterday":                      "",
+	}
+
+	for s, expected := range tests {
+
+		format, ok := dateFormat(s)
+
+		if ok != (expected != "") || (ok && format != expected) {
+			t.Fatalf("Expected the format of '%s' to be '%s', got '%s' (%t)", s, expected, format, ok)
+		}
+	}
+}
+
+func TestIsGeoString(t *testing.T) {
+
+	tests := map[string]bool{
+		"37.6,-122.4":         true,
+		" 37.6 , -122.4 ":     true,
+		"POINT (-122.4 37.6)": true,
+		"POINT(-122.4 37.6)":  true,
+		"1,000":               false,
+		"91.0,0.0":            false,
+		"POINT (37.6 -122.4)": false,
+		"San Francisco":       false,
+	}
+
+	for s, expected := range tests {
+
+		if isGeoString(s) != expected {
+			t.Fatalf("Expected isGeoString('%s') to be %t", s, expected)
+		}
+	}
+}
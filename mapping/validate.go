@@ -0,0 +1,820 @@
+package mapping
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/record"
+)
+
+// The kinds of problems reported by a Validator.
+const (
+	// MALFORMED_RECORD is a line which is not a valid dump record.
+	MALFORMED_RECORD string = "malformed_record"
+	// TYPE_CONFLICT is a value which can not be indexed using the type of its field.
+	TYPE_CONFLICT string = "type_conflict"
+	// MALFORMED_DATE is a value which does not match any of the formats of its date field.
+	MALFORMED_DATE string = "malformed_date"
+	// MALFORMED_GEO_POINT is a value which is not a valid geo_point.
+	MALFORMED_GEO_POINT string = "malformed_geo_point"
+	// STRICT_DYNAMIC is a field which is not in the mapping of an object whose dynamic setting is strict.
+	STRICT_DYNAMIC string = "strict_dynamic"
+	// FIELD_LIMIT is a field which would be added to the mapping after it has reached the field limit.
+	FIELD_LIMIT string = "field_limit"
+)
+
+// DEFAULT_FIELD_LIMIT is the default value of the index.mapping.total_fields.limit setting.
+const DEFAULT_FIELD_LIMIT int = 1000
+
+// The maximum number of characters of a value to include in a Finding's message.
+const max_value_length int = 64
+
+var re_geohash = regexp.MustCompile(`^[0-9b-hjkmnp-z]{1,12}$`)
+
+// The types whose values are checked. Values of any other type (for example geo_shape or flattened) are
+// not checked, but their fields are still counted.
+var string_types = map[string]bool{
+	"keyword":            true,
+	"constant_keyword":   true,
+	"wildcard":           true,
+	"text":               true,
+	"match_only_text":    true,
+	"search_as_you_type": true,
+}
+
+// Finding describes a problem that would cause a record to be rejected by an index.
+type Finding struct {
+	Line    int    `json:"line"`
+	ID      string `json:"id,omitempty"`
+	Kind    string `json:"kind"`
+	Path    string `json:"path,omitempty"`
+	Message string `json:"message"`
+}
+
+// property is the mapping of a single field.
+type property struct {
+	// Type is empty for objects which do not declare a type.
+	Type            string
+	Formats         []string
+	Dynamic         string
+	IgnoreMalformed bool
+	Coerce          bool
+	Properties      map[string]*property
+	// Fields is the number of multi-fields.
+	Fields int
+}
+
+func (p *property) isObject() bool {
+	return p.Type == "" || p.Type == "object" || p.Type == "nested"
+}
+
+// Validator checks dump records against the mapping of an index, keeping track of the fields that
+// would be added to the mapping by dynamic mapping. It is not safe for concurrent use.
+type Validator struct {
+	root           *property
+	limit          int
+	fields         int
+	date_detection bool
+	// templates is true if the mapping has dynamic templates, in which case the types of new fields are unknown.
+	templates bool
+	findings  []*Finding
+}
+
+// NewValidator returns a new Validator for mappings, in the form of the "mappings" property of an index
+// definition. limit is the maximum number of fields in the mapping.
+func NewValidator(mappings map[string]interface{}, limit int) (*Validator, error) {
+
+	// Mappings from Elasticsearch 6 are keyed by type
+	_, ok := mappings["properties"]
+
+	if !ok && len(mappings) == 1 {
+
+		for _, m := range mappings {
+
+			typed, ok := m.(map[string]interface{})
+
+			if ok {
+				mappings = typed
+			}
+		}
+	}
+
+	root, err := parseProperty("", mappings, "true")
+
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Validator{
+		root:           root,
+		limit:          limit,
+		fields:         countFields(root),
+		date_detection: true,
+	}
+
+	date_detection, ok := mappings["date_detection"].(bool)
+
+	if ok {
+		v.date_detection = date_detection
+	}
+
+	templates, ok := mappings["dynamic_templates"].([]interface{})
+
+	if ok && len(templates) > 0 {
+		v.templates = true
+	}
+
+	return v, nil
+}
+
+// Fields returns the number of fields in the mapping, including those which would be added by dynamic mapping.
+func (v *Validator) Fields() int {
+	return v.fields
+}
+
+// Limit returns the maximum number of fields in the mapping.
+func (v *Validator) Limit() int {
+	return v.limit
+}
+
+// ValidateLine checks the dump record line, read from line number line_number, and returns any problems found.
+func (v *Validator) ValidateLine(line_number int, line []byte) []*Finding {
+
+	v.findings = make([]*Finding, 0)
+
+	if !gjson.ValidBytes(line) {
+		v.report(line_number, "", MALFORMED_RECORD, "", "Invalid JSON")
+		return v.findings
+	}
+
+	rec, err := record.Parse(line)
+
+	if err != nil {
+		v.report(line_number, "", MALFORMED_RECORD, "", err.Error())
+		return v.findings
+	}
+
+	v.object(line_number, rec.ID, "", v.root, gjson.ParseBytes(rec.Source))
+	return v.findings
+}
+
+func (v *Validator) report(line_number int, id string, kind string, path string, message string) {
+
+	f := &Finding{
+		Line:    line_number,
+		ID:      id,
+		Kind:    kind,
+		Path:    path,
+		Message: message,
+	}
+
+	v.findings = append(v.findings, f)
+}
+
+func (v *Validator) object(line_number int, id string, prefix string, obj *property, value gjson.Result) {
+
+	value.ForEach(func(k gjson.Result, val gjson.Result) bool {
+
+		// Elasticsearch treats dots in property names as object paths
+		names := strings.Split(k.String(), ".")
+
+		parent := obj
+		path := prefix
+
+		for i, name := range names {
+
+			path = joinPath(path, name)
+			last := i == len(names)-1
+
+			p, ok := parent.Properties[name]
+
+			if !ok {
+
+				sample := val
+
+				if !last {
+					sample = gjson.Parse("{}")
+				}
+
+				p = v.addProperty(line_number, id, path, parent, name, sample)
+
+				if p == nil {
+					return true
+				}
+			}
+
+			if !last {
+
+				if !p.isObject() {
+					v.report(line_number, id, TYPE_CONFLICT, path, fmt.Sprintf("%s is mapped as %s but has object properties", path, p.Type))
+					return true
+				}
+
+				parent = p
+				continue
+			}
+
+			v.value(line_number, id, path, p, val)
+		}
+
+		return true
+	})
+}
+
+// addProperty adds the field name, first observed with value sample, to parent as dynamic mapping would and
+// returns its mapping. It returns nil if the field would not be added to the mapping.
+func (v *Validator) addProperty(line_number int, id string, path string, parent *property, name string, sample gjson.Result) *property {
+
+	switch parent.Dynamic {
+	case "strict":
+		v.report(line_number, id, STRICT_DYNAMIC, path, fmt.Sprintf("%s is not in the mapping and dynamic is strict", path))
+		return nil
+	case "false", "runtime":
+		return nil
+	}
+
+	sample = firstValue(sample)
+
+	// Null values and empty arrays do not add fields
+	if !sample.Exists() {
+		return nil
+	}
+
+	p := &property{
+		Dynamic:    parent.Dynamic,
+		Coerce:     true,
+		Properties: make(map[string]*property),
+	}
+
+	switch {
+	case sample.IsObject():
+		p.Type = "object"
+	case v.templates:
+		// Unknown, so values are not checked
+		p.Type = "dynamic"
+	case sample.Type == gjson.String:
+
+		format, ok := dateFormat(sample.String())
+
+		switch {
+		case ok && v.date_detection:
+
+			p.Type = "date"
+
+			if format != ISO_DATE_FORMAT {
+				p.Formats = strings.Split(format, "||")
+			}
+
+		default:
+			p.Type = "text"
+			p.Fields = 1
+		}
+
+	case sample.Type == gjson.Number:
+
+		if strings.ContainsAny(sample.Raw, ".eE") {
+			p.Type = "float"
+		} else {
+			p.Type = "long"
+		}
+
+	default:
+		p.Type = "boolean"
+	}
+
+	if len(p.Formats) == 0 && p.Type == "date" {
+		p.Formats = defaultFormats(p.Type)
+	}
+
+	count := 1 + p.Fields
+
+	if v.fields+count > v.limit {
+		v.report(line_number, id, FIELD_LIMIT, path, fmt.Sprintf("Adding %s to the mapping would exceed the limit of %d fields", path, v.limit))
+	}
+
+	v.fields += count
+	parent.Properties[name] = p
+
+	return p
+}
+
+func (v *Validator) value(line_number int, id string, path string, p *property, value gjson.Result) {
+
+	if value.IsArray() {
+
+		if p.Type == "geo_point" && isPointArray(value) {
+			v.geoPoint(line_number, id, path, p, value)
+			return
+		}
+
+		value.ForEach(func(_ gjson.Result, el gjson.Result) bool {
+			v.value(line_number, id, path, p, el)
+			return true
+		})
+
+		return
+	}
+
+	if value.Type == gjson.Null {
+		return
+	}
+
+	if p.isObject() {
+
+		if !value.IsObject() {
+			v.report(line_number, id, TYPE_CONFLICT, path, fmt.Sprintf("%s is mapped as an object but has %s", path, describe(value)))
+			return
+		}
+
+		v.object(line_number, id, path, p, value)
+		return
+	}
+
+	if p.IgnoreMalformed {
+		return
+	}
+
+	switch p.Type {
+	case "geo_point":
+		v.geoPoint(line_number, id, path, p, value)
+	case "date", "date_nanos":
+		v.date(line_number, id, path, p, value)
+	case "long", "integer", "short", "byte", "unsigned_long", "double", "float", "half_float", "scaled_float":
+		v.number(line_number, id, path, p, value)
+	case "boolean":
+
+		switch {
+		case value.Type == gjson.True, value.Type == gjson.False:
+			// pass
+		case value.Type == gjson.String && (value.Str == "true" || value.Str == "false" || value.Str == ""):
+			// pass
+		default:
+			v.conflict(line_number, id, path, p, value)
+		}
+
+	case "ip":
+
+		if value.Type != gjson.String || net.ParseIP(value.Str) == nil {
+			v.conflict(line_number, id, path, p, value)
+		}
+
+	default:
+
+		if string_types[p.Type] && value.IsObject() {
+			v.conflict(line_number, id, path, p, value)
+		}
+	}
+}
+
+func (v *Validator) conflict(line_number int, id string, path string, p *property, value gjson.Result) {
+	v.report(line_number, id, TYPE_CONFLICT, path, fmt.Sprintf("%s is mapped as %s but has %s", path, p.Type, describe(value)))
+}
+
+func (v *Validator) number(line_number int, id string, path string, p *property, value gjson.Result) {
+
+	var raw string
+
+	switch {
+	case value.Type == gjson.Number:
+		raw = value.Raw
+	case value.Type == gjson.String && p.Coerce:
+		raw = strings.TrimSpace(value.Str)
+	default:
+		v.conflict(line_number, id, path, p, value)
+		return
+	}
+
+	f, err := strconv.ParseFloat(raw, 64)
+
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		v.conflict(line_number, id, path, p, value)
+		return
+	}
+
+	is_whole := !strings.ContainsAny(raw, ".eE")
+
+	var min float64
+	var max float64
+
+	switch p.Type {
+	case "byte":
+		min, max = math.MinInt8, math.MaxInt8
+	case "short":
+		min, max = math.MinInt16, math.MaxInt16
+	case "integer":
+		min, max = math.MinInt32, math.MaxInt32
+	case "long":
+
+		// Whole numbers are checked exactly since a float64 can not represent every long
+		if is_whole {
+
+			_, err := strconv.ParseInt(raw, 10, 64)
+
+			if err != nil {
+				v.report(line_number, id, TYPE_CONFLICT, path, fmt.Sprintf("%s is mapped as long but %s is out of range", path, raw))
+			}
+
+			return
+		}
+
+		min, max = math.MinInt64, math.MaxInt64
+
+	case "unsigned_long":
+
+		if is_whole {
+
+			_, err := strconv.ParseUint(raw, 10, 64)
+
+			if err != nil {
+				v.report(line_number, id, TYPE_CONFLICT, path, fmt.Sprintf("%s is mapped as unsigned_long but %s is out of range", path, raw))
+			}
+
+			return
+		}
+
+		min, max = 0, math.MaxUint64
+
+	case "float":
+		min, max = -math.MaxFloat32, math.MaxFloat32
+	case "half_float":
+		min, max = -65504, 65504
+	default:
+		return
+	}
+
+	// Without coercion, integer fields reject values with fractions
+	if !is_whole && !p.Coerce && min != -max {
+		v.conflict(line_number, id, path, p, value)
+		return
+	}
+
+	if f < min || f > max {
+		v.report(line_number, id, TYPE_CONFLICT, path, fmt.Sprintf("%s is mapped as %s but %s is out of range", path, p.Type, raw))
+	}
+}
+
+func (v *Validator) date(line_number int, id string, path string, p *property, value gjson.Result) {
+
+	var s string
+
+	switch value.Type {
+	case gjson.String:
+		s = value.Str
+	case gjson.Number:
+		s = value.Raw
+	default:
+		v.conflict(line_number, id, path, p, value)
+		return
+	}
+
+	for _, format := range p.Formats {
+
+		ok, known := matchDate(format, s)
+
+		// Values are only reported if they can be checked against every format
+		if ok || !known {
+			return
+		}
+	}
+
+	v.report(line_number, id, MALFORMED_DATE, path, fmt.Sprintf("%s has %s, which does not match the format %s", path, describe(value), strings.Join(p.Formats, "||")))
+}
+
+func (v *Validator) geoPoint(line_number int, id string, path string, p *property, value gjson.Result) {
+
+	if p.IgnoreMalformed || isGeoPoint(value) {
+		return
+	}
+
+	v.report(line_number, id, MALFORMED_GEO_POINT, path, fmt.Sprintf("%s is mapped as geo_point but has %s", path, describe(value)))
+}
+
+// isGeoPoint reports whether value is a point in any of the forms accepted by geo_point fields.
+func isGeoPoint(value gjson.Result) bool {
+
+	switch {
+	case value.IsArray():
+
+		coords := value.Array()
+		return len(coords) >= 2 && len(coords) <= 3 && isLatLon(coords[1].Raw, coords[0].Raw)
+
+	case value.IsObject():
+
+		if value.Get("type").String() == "Point" {
+			return isGeoPoint(value.Get("coordinates"))
+		}
+
+		lat := value.Get("lat")
+		lon := value.Get("lon")
+
+		return lat.Exists() && lon.Exists() && isLatLon(lat.String(), lon.String())
+
+	case value.Type == gjson.String:
+		return isGeoString(value.Str) || re_geohash.MatchString(value.Str)
+	}
+
+	return false
+}
+
+// isPointArray reports whether value is an array of coordinates rather than an array of points.
+func isPointArray(value gjson.Result) bool {
+
+	coords := value.Array()
+
+	if len(coords) < 2 || len(coords) > 3 {
+		return false
+	}
+
+	for _, c := range coords {
+
+		if c.Type != gjson.Number {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchDate reports whether s matches the date format and whether format is one that can be checked.
+func matchDate(format string, s string) (bool, bool) {
+
+	switch format {
+	case "epoch_millis", "epoch_second":
+		_, err := strconv.ParseFloat(s, 64)
+		return err == nil, true
+	case "strict_date_optional_time", "date_optional_time", "strict_date_optional_time_nanos":
+
+		f, ok := dateFormat(s)
+		return ok && f == ISO_DATE_FORMAT, true
+
+	case "strict_date", "date":
+		return matchLayout("2006-01-02", s), true
+	case "strict_date_time", "date_time":
+		return matchLayout(time.RFC3339, s), true
+	case "strict_date_time_no_millis", "date_time_no_millis":
+		return matchLayout("2006-01-02T15:04:05Z07:00", s), true
+	case "strict_date_hour_minute_second", "date_hour_minute_second":
+		return matchLayout("2006-01-02T15:04:05", s), true
+	case "basic_date":
+		return matchLayout("20060102", s), true
+	}
+
+	layout, ok := javaLayout(format)
+
+	if !ok {
+		return false, false
+	}
+
+	return matchLayout(layout, s), true
+}
+
+func matchLayout(layout string, s string) bool {
+	_, err := time.Parse(layout, s)
+	return err == nil
+}
+
+// The Java date pattern letters with an equivalent in Go layouts, longest first.
+var java_layout = []struct {
+	pattern string
+	layout  string
+}{
+	{"yyyy", "2006"},
+	{"uuuu", "2006"},
+	{"yy", "06"},
+	{"MMMM", "January"},
+	{"MMM", "Jan"},
+	{"MM", "01"},
+	{"M", "1"},
+	{"dd", "02"},
+	{"d", "2"},
+	{"EEEE", "Monday"},
+	{"EEE", "Mon"},
+	{"HH", "15"},
+	{"hh", "03"},
+	{"h", "3"},
+	{"mm", "04"},
+	{"ss", "05"},
+	{"SSSSSSSSS", "000000000"},
+	{"SSSSSS", "000000"},
+	{"SSS", "000"},
+	{"a", "PM"},
+	{"XXX", "Z07:00"},
+	{"XX", "Z0700"},
+	{"X", "Z07"},
+	{"ZZZ", "-07:00"},
+	{"Z", "-0700"},
+}
+
+// javaLayout converts the Java date pattern used by custom date formats, for example "yyyy/MM/dd HH:mm:ss",
+// to a Go time layout. It returns false if the pattern uses letters without an equivalent.
+func javaLayout(pattern string) (string, bool) {
+
+	var b strings.Builder
+
+	for i := 0; i < len(pattern); {
+
+		c := pattern[i]
+
+		// Quoted literals
+		if c == '\'' {
+
+			end := strings.IndexByte(pattern[i+1:], '\'')
+
+			if end == -1 {
+				return "", false
+			}
+
+			b.WriteString(pattern[i+1 : i+1+end])
+			i += end + 2
+			continue
+		}
+
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			b.WriteByte(c)
+			i += 1
+			continue
+		}
+
+		matched := false
+
+		for _, l := range java_layout {
+
+			if strings.HasPrefix(pattern[i:], l.pattern) {
+				b.WriteString(l.layout)
+				i += len(l.pattern)
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return "", false
+		}
+	}
+
+	return b.String(), true
+}
+
+// defaultFormats returns the formats of date fields of type t which do not declare a format.
+func defaultFormats(t string) []string {
+
+	if t == "date_nanos" {
+		return []string{"strict_date_optional_time_nanos", "epoch_millis"}
+	}
+
+	return []string{ISO_DATE_FORMAT, "epoch_millis"}
+}
+
+// firstValue returns value or, if value is an array, its first element which is not null or an array. The
+// returned value does not exist if there is no such element.
+func firstValue(value gjson.Result) gjson.Result {
+
+	if value.Type == gjson.Null {
+		return gjson.Result{}
+	}
+
+	if !value.IsArray() {
+		return value
+	}
+
+	var first gjson.Result
+
+	value.ForEach(func(_ gjson.Result, el gjson.Result) bool {
+		first = firstValue(el)
+		return !first.Exists()
+	})
+
+	return first
+}
+
+// describe returns a short description of value for use in messages, for example `the value "abc"`.
+func describe(value gjson.Result) string {
+
+	if value.IsObject() {
+		return "an object"
+	}
+
+	raw := value.Raw
+
+	if len(raw) > max_value_length {
+		raw = raw[:max_value_length] + "..."
+	}
+
+	return "the value " + raw
+}
+
+// parseProperty parses the mapping m of the field name. dynamic is the dynamic setting inherited from the
+// field's parent.
+func parseProperty(name string, m map[string]interface{}, dynamic string) (*property, error) {
+
+	p := &property{
+		Dynamic:    dynamic,
+		Coerce:     true,
+		Properties: make(map[string]*property),
+	}
+
+	t, ok := m["type"].(string)
+
+	if ok {
+		p.Type = t
+	}
+
+	// dynamic may be a boolean or a string
+	d, ok := m["dynamic"]
+
+	if ok {
+		p.Dynamic = fmt.Sprintf("%v", d)
+	}
+
+	format, ok := m["format"].(string)
+
+	if ok {
+		p.Formats = strings.Split(format, "||")
+	} else if p.Type == "date" || p.Type == "date_nanos" {
+		p.Formats = defaultFormats(p.Type)
+	}
+
+	ignore_malformed, ok := m["ignore_malformed"].(bool)
+
+	if ok {
+		p.IgnoreMalformed = ignore_malformed
+	}
+
+	coerce, ok := m["coerce"].(bool)
+
+	if ok {
+		p.Coerce = coerce
+	}
+
+	multi_fields, ok := m["fields"].(map[string]interface{})
+
+	if ok {
+		p.Fields = len(multi_fields)
+	}
+
+	props, ok := m["properties"].(map[string]interface{})
+
+	if !ok {
+		return p, nil
+	}
+
+	for k, v := range props {
+
+		child_m, ok := v.(map[string]interface{})
+
+		if !ok {
+			return nil, fmt.Errorf("Invalid mapping for %s", joinPath(name, k))
+		}
+
+		// Dotted names are shorthand for objects
+		names := strings.Split(k, ".")
+		parent := p
+
+		for _, n := range names[:len(names)-1] {
+
+			obj, ok := parent.Properties[n]
+
+			if !ok {
+				obj = &property{
+					Dynamic:    parent.Dynamic,
+					Coerce:     true,
+					Properties: make(map[string]*property),
+				}
+
+				parent.Properties[n] = obj
+			}
+
+			parent = obj
+		}
+
+		child, err := parseProperty(joinPath(name, k), child_m, parent.Dynamic)
+
+		if err != nil {
+			return nil, err
+		}
+
+		parent.Properties[names[len(names)-1]] = child
+	}
+
+	return p, nil
+}
+
+// countFields returns the number of fields in the mapping of p, not including p itself, in the way they
+// are counted towards the index.mapping.total_fields.limit setting.
+func countFields(p *property) int {
+
+	count := 0
+
+	for _, child := range p.Properties {
+		count += 1 + child.Fields + countFields(child)
+	}
+
+	return count
+}
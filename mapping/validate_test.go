@@ -0,0 +1,221 @@
+package mapping
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func newTestValidator(t *testing.T, enc_mappings string, limit int) *Validator {
+
+	var mappings map[string]interface{}
+
+	err := json.Unmarshal([]byte(enc_mappings), &mappings)
+
+	if err != nil {
+		t.Fatalf("Failed to decode mappings, %v", err)
+	}
+
+	v, err := NewValidator(mappings, limit)
+
+	if err != nil {
+		t.Fatalf("Failed to create validator, %v", err)
+	}
+
+	return v
+}
+
+// findings returns the kind and path of each problem with the record whose _source is source.
+func findings(v *Validator, source string) string {
+
+	problems := make([]string, 0)
+
+	for _, f := range v.ValidateLine(1, []byte(`{"_id":"1","_source":`+source+`}`)) {
+		problems = append(problems, f.Kind+":"+f.Path)
+	}
+
+	return strings.Join(problems, ",")
+}
+
+func TestValidatorValues(t *testing.T) {
+
+	mappings := `{"properties":{
+		"count":{"type":"integer"},
+		"total":{"type":"long"},
+		"strict_count":{"type":"integer","coerce":false},
+		"ratio":{"type":"half_float"},
+		"flag":{"type":"boolean"},
+		"addr":{"type":"ip"},
+		"code":{"type":"keyword"},
+		"lax":{"type":"integer","ignore_malformed":true},
+		"meta":{"properties":{"name":{"type":"keyword"}}},
+		"created":{"type":"date"},
+		"day":{"type":"date","format":"yyyy/MM/dd||epoch_second"},
+		"custom":{"type":"date","format":"GGGG"},
+		"point":{"type":"geo_point"}
+	}}`
+
+	v := newTestValidator(t, mappings, DEFAULT_FIELD_LIMIT)
+
+	tests := map[string]string{
+		`{"count":1,"total":9223372036854775807,"ratio":1.5,"flag":true,"addr":"10.0.0.1","code":"SFO"}`: ``,
+		`{"count":"5","flag":"false","code":5,"meta":{"name":"x"},"lax":"x"}`:                            ``,
+		`{"count":[1,2,null]}`:                                   ``,
+		`{"count":2147483648}`:                                   `type_conflict:count`,
+		`{"count":"five"}`:                                       `type_conflict:count`,
+		`{"count":[1,"five"]}`:                                   `type_conflict:count`,
+		`{"total":9223372036854775808}`:                          `type_conflict:total`,
+		`{"strict_count":"5"}`:                                   `type_conflict:strict_count`,
+		`{"strict_count":1.5}`:                                   `type_conflict:strict_count`,
+		`{"ratio":70000}`:                                        `type_conflict:ratio`,
+		`{"flag":"yes"}`:                                         `type_conflict:flag`,
+		`{"addr":"localhost"}`:                                   `type_conflict:addr`,
+		`{"code":{"a":1}}`:                                       `type_conflict:code`,
+		`{"meta":"x"}`:                                           `type_conflict:meta`,
+		`{"meta.name":"x","count.x":1}`:                          `type_conflict:count`,
+		`{"created":"2024-01-02T10:11:12Z"}`:                     ``,
+		`{"created":1700000000000}`:                              ``,
+		`{"created":"02/01/2024"}`:                               `malformed_date:created`,
+		`{"created":true}`:                                       `type_conflict:created`,
+		`{"day":"2024/01/02","custom":"anything"}`:               ``,
+		`{"day":1700000000}`:                                     ``,
+		`{"day":"2024-01-02"}`:                                   `malformed_date:day`,
+		`{"point":"37.6,-122.4"}`:                                ``,
+		`{"point":[-122.4,37.6]}`:                                ``,
+		`{"point":[[-122.4,37.6],"9q8yy"]}`:                      ``,
+		`{"point":{"lat":37.6,"lon":-122.4}}`:                    ``,
+		`{"point":{"type":"Point","coordinates":[-122.4,37.6]}}`: ``,
+		`{"point":"San Francisco"}`:                              `malformed_geo_point:point`,
+		`{"point":{"lat":137.6,"lon":-122.4}}`:                   `malformed_geo_point:point`,
+	}
+
+	for source, expected := range tests {
+
+		problems := findings(v, source)
+
+		if problems != expected {
+			t.Fatalf("Expected %s to have the problems '%s', got '%s'", source, expected, problems)
+		}
+	}
+}
+
+func TestValidatorDynamic(t *testing.T) {
+
+	v := newTestValidator(t, `{"properties":{"a":{"type":"keyword"}}}`, DEFAULT_FIELD_LIMIT)
+
+	if v.Fields() != 1 {
+		t.Fatalf("Expected 1 field, got %d", v.Fields())
+	}
+
+	// Fields added by dynamic mapping are checked in later records
+	if findings(v, `{"n":1,"d":"2024-01-02","s":"x","o":{"b":true}}`) != "" {
+		t.Fatalf("Expected new fields to be added")
+	}
+
+	// n, d, s (and s.keyword), o and o.b
+	if v.Fields() != 7 {
+		t.Fatalf("Expected 7 fields, got %d", v.Fields())
+	}
+
+	problems := findings(v, `{"n":"x","d":"yesterday","s":{"c":1},"o":{"b":"maybe"}}`)
+
+	if problems != "type_conflict:n,malformed_date:d,type_conflict:s,type_conflict:o.b" {
+		t.Fatalf("Unexpected problems %s", problems)
+	}
+
+	// Null values and empty arrays do not add fields
+	findings(v, `{"x":null,"y":[]}`)
+
+	if v.Fields() != 7 {
+		t.Fatalf("Expected 7 fields, got %d", v.Fields())
+	}
+}
+
+func TestValidatorStrict(t *testing.T) {
+
+	v := newTestValidator(t, `{"dynamic":"strict","properties":{"a":{"type":"keyword"},"open":{"dynamic":true,"properties":{}},"closed":{"dynamic":false,"properties":{}}}}`, DEFAULT_FIELD_LIMIT)
+
+	problems := findings(v, `{"a":"x","b":1,"open":{"c":1},"closed":{"d":1}}`)
+
+	if problems != "strict_dynamic:b" {
+		t.Fatalf("Unexpected problems %s", problems)
+	}
+
+	// Objects inherit the dynamic setting of their parents
+	v = newTestValidator(t, `{"dynamic":"strict","properties":{"meta":{"properties":{}}}}`, DEFAULT_FIELD_LIMIT)
+
+	problems = findings(v, `{"meta":{"x":1}}`)
+
+	if problems != "strict_dynamic:meta.x" {
+		t.Fatalf("Unexpected problems %s", problems)
+	}
+}
+
+func TestValidatorFieldLimit(t *testing.T) {
+
+	v := newTestValidator(t, `{"properties":{"a":{"type":"keyword"}}}`, 2)
+
+	problems := findings(v, `{"b":1}`)
+
+	if problems != "" {
+		t.Fatalf("Expected a second field to be allowed, got %s", problems)
+	}
+
+	problems = findings(v, `{"c":1,"d":2}`)
+
+	if problems != "field_limit:c,field_limit:d" {
+		t.Fatalf("Unexpected problems %s", problems)
+	}
+}
+
+func TestValidatorTypedMappings(t *testing.T) {
+
+	// Mappings from Elasticsearch 6 are keyed by type
+	v := newTestValidator(t, `{"book":{"properties":{"pages":{"type":"integer"}}}}`, DEFAULT_FIELD_LIMIT)
+
+	if findings(v, `{"pages":"many"}`) != "type_conflict:pages" {
+		t.Fatalf("Expected typed mappings to be used")
+	}
+}
+
+func TestValidatorMalformed(t *testing.T) {
+
+	v := newTestValidator(t, `{"properties":{}}`, DEFAULT_FIELD_LIMIT)
+
+	for _, line := range []string{`{"_id":`, `{"_id":"1"}`} {
+
+		f := v.ValidateLine(3, []byte(line))
+
+		if len(f) != 1 || f[0].Kind != MALFORMED_RECORD || f[0].Line != 3 {
+			t.Fatalf("Expected %s to be malformed, got %+v", line, f)
+		}
+	}
+}
+
+func TestJavaLayout(t *testing.T) {
+
+	tests := map[string]string{
+		"yyyy/MM/dd HH:mm:ss":          "2006/01/02 15:04:05",
+		"yyyy-MM-dd'T'HH:mm:ss.SSSXXX": "2006-01-02T15:04:05.000Z07:00",
+		"dd MMM yyyy":                  "02 Jan 2006",
+		"EEEE, MMMM d, yyyy h:mm a":    "Monday, January 2, 2006 3:04 PM",
+	}
+
+	for pattern, expected := range tests {
+
+		layout, ok := javaLayout(pattern)
+
+		if !ok || layout != expected {
+			t.Fatalf("Expected %s to be %s, got %s (%t)", pattern, expected, layout, ok)
+		}
+	}
+
+	for _, pattern := range []string{"GGGG", "yyyy-MM-dd'T"} {
+
+		_, ok := javaLayout(pattern)
+
+		if ok {
+			t.Fatalf("Expected %s not to have a layout", pattern)
+		}
+	}
+}
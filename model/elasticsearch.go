@@ -23,6 +23,7 @@ type ESResponseHits struct {
 type ESHit struct {
 	ID     string            `json:"_id"`
 	Index  string            `json:"_index"`
+	Type   string            `json:"_type,omitempty"`
 	Source json.RawMessage   `json:"_source"`
 	Sort   []json.RawMessage `json:"sort,omitempty"`
 }
@@ -30,8 +31,14 @@ type ESHit struct {
 type ESQuery struct {
 	Query       json.RawMessage   `json:"query"`
 	Sort        []json.RawMessage `json:"sort,omitempty"`
-	SearchAfter []json.RawMessage `json:"search_after,omitempty"`
-	// PointInTime ESPIT             `json:"pit,omitempty"`
+	SearchAfter json.RawMessage   `json:"search_after,omitempty"`
+	PointInTime *ESPIT            `json:"pit,omitempty"`
+	Slice       *ESSlice          `json:"slice,omitempty"`
+}
+
+type ESSlice struct {
+	ID  int `json:"id"`
+	Max int `json:"max"`
 }
 
 type ESPIT struct {
@@ -59,5 +66,15 @@ type ESNodeStatsResponse struct {
 			Overhead             float64 `json:"overhead"`
 			Tripped              int     `json:"tripped"`
 		} `json:"breakers"`
+		ThreadPool map[string]ESThreadPoolStats `json:"thread_pool"`
 	} `json:"nodes"`
 }
+
+type ESThreadPoolStats struct {
+	Threads   int   `json:"threads"`
+	Queue     int   `json:"queue"`
+	Active    int   `json:"active"`
+	Rejected  int64 `json:"rejected"`
+	Largest   int   `json:"largest"`
+	Completed int64 `json:"completed"`
+}
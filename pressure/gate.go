@@ -0,0 +1,146 @@
+package pressure
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Gate is opened and closed by a background goroutine which checks the cluster for pressure, so that
+// callers can pause cheaply without each querying the cluster themselves.
+type Gate struct {
+	open_ch chan bool
+	done_ch chan bool
+	since   time.Time
+	timeout time.Duration
+	mu      *sync.RWMutex
+}
+
+// Watch starts checking the cluster every interval until ctx is cancelled and returns a Gate which is closed
+// whenever the cluster is under pressure. If on_status is not nil it is called with the outcome of each check.
+func (g *Guard) Watch(ctx context.Context, on_status func(*Status)) *Gate {
+
+	open_ch := make(chan bool)
+	close(open_ch)
+
+	gate := &Gate{
+		open_ch: open_ch,
+		done_ch: make(chan bool),
+		timeout: g.opts.Timeout,
+		mu:      new(sync.RWMutex),
+	}
+
+	go func() {
+
+		defer close(gate.done_ch)
+
+		ticker := time.NewTicker(g.opts.Interval)
+		defer ticker.Stop()
+
+		for {
+
+			select {
+			case <-ctx.Done():
+				// Release anyone still waiting; they will see the cancelled context themselves
+				gate.open(false)
+				return
+			case <-ticker.C:
+				// pass
+			}
+
+			status, err := g.Check(ctx)
+
+			if err != nil && ctx.Err() != nil {
+				gate.open(false)
+				return
+			}
+
+			if err != nil {
+				// Let the caller's own requests fail (or succeed) rather than pausing indefinitely
+				log.Printf("Failed to check cluster pressure, %v", err)
+				continue
+			}
+
+			if on_status != nil {
+				on_status(status)
+			}
+
+			if status.OK() {
+				gate.open(true)
+			} else {
+				gate.close(status)
+			}
+		}
+	}()
+
+	return gate
+}
+
+// Done returns a channel which is closed once the goroutine started by Watch has returned, after which
+// on_status will not be called again.
+func (gate *Gate) Done() <-chan bool {
+	return gate.done_ch
+}
+
+// Wait returns immediately if the gate is open and otherwise blocks until it opens. It returns ErrTimeout
+// if the gate has been closed for longer than the guard's timeout, or the context's error if ctx is
+// cancelled first.
+func (gate *Gate) Wait(ctx context.Context) error {
+
+	gate.mu.RLock()
+	open_ch := gate.open_ch
+	since := gate.since
+	gate.mu.RUnlock()
+
+	var timeout_ch <-chan time.Time
+
+	if gate.timeout > 0 && !since.IsZero() {
+
+		t := time.NewTimer(time.Until(since.Add(gate.timeout)))
+		defer t.Stop()
+
+		timeout_ch = t.C
+	}
+
+	select {
+	case <-open_ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timeout_ch:
+		return ErrTimeout
+	}
+}
+
+func (gate *Gate) open(verbose bool) {
+
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+
+	if gate.since.IsZero() {
+		return
+	}
+
+	if verbose {
+		log.Printf("Cluster pressure has subsided after %v, resuming", time.Since(gate.since).Round(time.Second))
+	}
+
+	close(gate.open_ch)
+	gate.since = time.Time{}
+}
+
+func (gate *Gate) close(status *Status) {
+
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+
+	if !gate.since.IsZero() {
+		return
+	}
+
+	log.Printf("Cluster is under pressure %s, pausing", status)
+
+	gate.open_ch = make(chan bool)
+	gate.since = time.Now()
+}
@@ -0,0 +1,270 @@
+// package pressure provides methods for detecting when an Elasticsearch cluster is under pressure (tripped
+// circuit breakers, rejected thread pool tasks or poor health) and waiting for it to recover.
+package pressure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/cluster"
+)
+
+// ErrTimeout is returned by Guard.Wait when the cluster does not recover within the configured timeout.
+var ErrTimeout = errors.New("Timed out waiting for cluster pressure to subside")
+
+// Options configures a Guard.
+type Options struct {
+	// Index is the index (or alias) whose health is checked. If empty the health of the whole cluster is checked.
+	Index string
+	// BreakerRatio is the ratio of a circuit breaker's estimated size to its limit at or above which the
+	// breaker is considered tripped. If zero breakers are not checked.
+	BreakerRatio float64
+	// ThreadPools are the names of the thread pools (for example "search" or "write") to check for rejections
+	// and queued tasks.
+	ThreadPools []string
+	// MaxQueue is the number of queued tasks in any of ThreadPools, on any node, above which the cluster is
+	// considered to be under pressure. If zero queues are not checked.
+	MaxQueue int
+	// MinHealth is the lowest acceptable health status ("green", "yellow" or "red"). If empty health is not checked.
+	MinHealth string
+	// Interval is how long to wait between checks.
+	Interval time.Duration
+	// Timeout is how long Wait will wait for the cluster to recover. If zero Wait waits indefinitely.
+	Timeout time.Duration
+}
+
+// Status describes the outcome of a single check.
+type Status struct {
+	// Reasons lists why the cluster is considered to be under pressure. It is empty if it is not.
+	Reasons []string
+	// MaxQueue is the largest number of queued tasks in any of the checked thread pools on any node.
+	MaxQueue int
+	// Rejected is the number of tasks rejected by the checked thread pools since the previous check.
+	Rejected int64
+	// Health is the health status of the index or cluster.
+	Health string
+}
+
+// OK reports whether the cluster is free of pressure.
+func (s *Status) OK() bool {
+	return len(s.Reasons) == 0
+}
+
+// String returns a description of s suitable for logging.
+func (s *Status) String() string {
+
+	if s.OK() {
+		return "ok"
+	}
+
+	return fmt.Sprintf("%v", s.Reasons)
+}
+
+// Guard checks an Elasticsearch cluster for pressure.
+type Guard struct {
+	client        cluster.Client
+	opts          *Options
+	last_rejected int64
+	mu            *sync.Mutex
+}
+
+var health_rank = map[string]int{
+	"red":    0,
+	"yellow": 1,
+	"green":  2,
+}
+
+// NewGuard returns a new Guard for es_client configured by opts.
+func NewGuard(es_client cluster.Client, opts *Options) (*Guard, error) {
+
+	if opts.MinHealth != "" {
+
+		_, ok := health_rank[opts.MinHealth]
+
+		if !ok {
+			return nil, fmt.Errorf("Invalid minimum health '%s'", opts.MinHealth)
+		}
+	}
+
+	if opts.Interval <= 0 {
+		return nil, fmt.Errorf("Invalid interval")
+	}
+
+	g := &Guard{
+		client:        es_client,
+		opts:          opts,
+		last_rejected: -1,
+		mu:            new(sync.Mutex),
+	}
+
+	return g, nil
+}
+
+// Check queries the cluster once and reports whether it is under pressure. Rejections are counted relative
+// to the previous call to Check so the first call never reports any.
+func (g *Guard) Check(ctx context.Context) (*Status, error) {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	status := &Status{
+		Reasons: make([]string, 0),
+	}
+
+	if g.opts.BreakerRatio > 0 || len(g.opts.ThreadPools) > 0 {
+
+		err := g.checkNodes(ctx, status)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if g.opts.MinHealth != "" {
+
+		err := g.checkHealth(ctx, status)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return status, nil
+}
+
+// Wait blocks until Check reports that the cluster is not under pressure. It returns ErrTimeout if that
+// does not happen within the configured timeout, or the context's error if ctx is cancelled first. Errors
+// querying the cluster are treated as pressure, since an overloaded cluster may fail to answer.
+func (g *Guard) Wait(ctx context.Context) error {
+
+	if g.opts.Timeout > 0 {
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.opts.Timeout)
+		defer cancel()
+	}
+
+	for {
+
+		status, err := g.Check(ctx)
+
+		if err == nil && status.OK() {
+			return nil
+		}
+
+		if err != nil {
+			log.Printf("Failed to check cluster pressure, %v", err)
+		} else {
+			log.Printf("Cluster is under pressure %s, checking again in %v", status, g.opts.Interval)
+		}
+
+		select {
+		case <-ctx.Done():
+
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return ErrTimeout
+			}
+
+			return ctx.Err()
+
+		case <-time.After(g.opts.Interval):
+			// pass
+		}
+	}
+}
+
+func (g *Guard) checkNodes(ctx context.Context, status *Status) error {
+
+	metrics := make([]string, 0)
+
+	if g.opts.BreakerRatio > 0 {
+		metrics = append(metrics, "breaker")
+	}
+
+	if len(g.opts.ThreadPools) > 0 {
+		metrics = append(metrics, "thread_pool")
+	}
+
+	s, err := g.client.NodesStats(ctx, metrics...)
+
+	if err != nil {
+		return err
+	}
+
+	if s.Status.Failed > 0 {
+		status.Reasons = append(status.Reasons, fmt.Sprintf("stats unavailable for %d node(s)", s.Status.Failed))
+	}
+
+	rejected := int64(0)
+
+	for node_id, n := range s.Nodes {
+
+		if g.opts.BreakerRatio > 0 {
+
+			for name, b := range n.Breakers {
+
+				if b.LimitSizeInBytes <= 0 {
+					continue
+				}
+
+				ratio := float64(b.EstimatedSizeInBytes) / float64(b.LimitSizeInBytes)
+
+				if ratio >= g.opts.BreakerRatio {
+					status.Reasons = append(status.Reasons, fmt.Sprintf("%s breaker on node %s at %.0f%%", name, node_id, ratio*100))
+				}
+			}
+		}
+
+		for _, name := range g.opts.ThreadPools {
+
+			pool, ok := n.ThreadPool[name]
+
+			if !ok {
+				continue
+			}
+
+			rejected += pool.Rejected
+
+			if pool.Queue > status.MaxQueue {
+				status.MaxQueue = pool.Queue
+			}
+
+			if g.opts.MaxQueue > 0 && pool.Queue > g.opts.MaxQueue {
+				status.Reasons = append(status.Reasons, fmt.Sprintf("%s queue on node %s at %d", name, node_id, pool.Queue))
+			}
+		}
+	}
+
+	// Node counters reset when a node restarts so ignore negative deltas
+	if g.last_rejected >= 0 && rejected > g.last_rejected {
+		status.Rejected = rejected - g.last_rejected
+		status.Reasons = append(status.Reasons, fmt.Sprintf("%d rejected %v task(s)", status.Rejected, g.opts.ThreadPools))
+	}
+
+	if len(g.opts.ThreadPools) > 0 {
+		g.last_rejected = rejected
+	}
+
+	return nil
+}
+
+func (g *Guard) checkHealth(ctx context.Context, status *Status) error {
+
+	health, err := g.client.Health(ctx, g.opts.Index)
+
+	if err != nil {
+		return err
+	}
+
+	status.Health = health
+
+	if health_rank[health] < health_rank[g.opts.MinHealth] {
+		status.Reasons = append(status.Reasons, fmt.Sprintf("health is %s", health))
+	}
+
+	return nil
+}
@@ -0,0 +1,240 @@
+package pressure
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/cluster"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/model"
+)
+
+// testClient is a cluster.Client which answers node statistics and health requests from fixed responses.
+// Calling any other method panics.
+type testClient struct {
+	cluster.Client
+	stats  []string
+	health []string
+	mu     sync.Mutex
+}
+
+func (c *testClient) NodesStats(ctx context.Context, metrics ...string) (*model.ESNodeStatsResponse, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	body := c.stats[0]
+
+	if len(c.stats) > 1 {
+		c.stats = c.stats[1:]
+	}
+
+	var s *model.ESNodeStatsResponse
+
+	err := json.Unmarshal([]byte(body), &s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (c *testClient) Health(ctx context.Context, name string) (string, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	health := c.health[0]
+
+	if len(c.health) > 1 {
+		c.health = c.health[1:]
+	}
+
+	return health, nil
+}
+
+func nodeStats(breaker_bytes int, queue int, rejected int) string {
+
+	return `{"_nodes":{"total":1,"successful":1,"failed":0},"nodes":{"n1":{
+		"breakers":{"parent":{"estimated_size_in_bytes":` + strconv.Itoa(breaker_bytes) + `,"limit_size_in_bytes":100}},
+		"thread_pool":{"write":{"queue":` + strconv.Itoa(queue) + `,"rejected":` + strconv.Itoa(rejected) + `}}
+	}}}`
+}
+
+func TestNewGuard(t *testing.T) {
+
+	_, err := NewGuard(&testClient{}, &Options{MinHealth: "blue", Interval: time.Second})
+
+	if err == nil {
+		t.Fatalf("Expected an error for an invalid minimum health")
+	}
+
+	_, err = NewGuard(&testClient{}, &Options{})
+
+	if err == nil {
+		t.Fatalf("Expected an error for an invalid interval")
+	}
+}
+
+func TestCheck(t *testing.T) {
+
+	c := &testClient{
+		stats: []string{
+			nodeStats(10, 0, 5),
+			nodeStats(95, 20, 8),
+			nodeStats(10, 0, 2),
+		},
+		health: []string{"green", "red", "yellow"},
+	}
+
+	g, err := NewGuard(c, &Options{
+		BreakerRatio: 0.9,
+		ThreadPools:  []string{"write"},
+		MaxQueue:     10,
+		MinHealth:    "yellow",
+		Interval:     time.Second,
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to create guard, %v", err)
+	}
+
+	// Rejections are counted from the first check so it never reports any
+	status, err := g.Check(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to check, %v", err)
+	}
+
+	if !status.OK() || status.Health != "green" {
+		t.Fatalf("Expected no pressure, got %s", status)
+	}
+
+	status, err = g.Check(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to check, %v", err)
+	}
+
+	if len(status.Reasons) != 4 || status.Rejected != 3 || status.MaxQueue != 20 {
+		t.Fatalf("Expected breaker, queue, rejection and health pressure, got %s (%+v)", status, status)
+	}
+
+	expected := []string{"parent breaker", "write queue", "3 rejected", "health is red"}
+
+	for i, reason := range status.Reasons {
+
+		if !strings.Contains(reason, expected[i]) {
+			t.Fatalf("Expected reason %d to mention %s, got %s", i, expected[i], reason)
+		}
+	}
+
+	// A node restart resets its counters, which should not be reported as rejections
+	status, err = g.Check(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to check, %v", err)
+	}
+
+	if !status.OK() || status.Rejected != 0 {
+		t.Fatalf("Expected no pressure, got %s", status)
+	}
+}
+
+func TestWait(t *testing.T) {
+
+	c := &testClient{
+		stats:  []string{nodeStats(95, 0, 0), nodeStats(95, 0, 0), nodeStats(10, 0, 0)},
+		health: []string{"green"},
+	}
+
+	g, err := NewGuard(c, &Options{
+		BreakerRatio: 0.9,
+		Interval:     time.Millisecond,
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to create guard, %v", err)
+	}
+
+	err = g.Wait(context.Background())
+
+	if err != nil {
+		t.Fatalf("Expected the cluster to recover, %v", err)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+
+	c := &testClient{
+		stats:  []string{nodeStats(95, 0, 0)},
+		health: []string{"green"},
+	}
+
+	g, err := NewGuard(c, &Options{
+		BreakerRatio: 0.9,
+		Interval:     time.Millisecond,
+		Timeout:      20 * time.Millisecond,
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to create guard, %v", err)
+	}
+
+	err = g.Wait(context.Background())
+
+	if err != ErrTimeout {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+
+	c := &testClient{
+		stats:  []string{nodeStats(95, 0, 0), nodeStats(95, 0, 0), nodeStats(10, 0, 0)},
+		health: []string{"green"},
+	}
+
+	g, err := NewGuard(c, &Options{
+		BreakerRatio: 0.9,
+		Interval:     5 * time.Millisecond,
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to create guard, %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	statuses := make(chan *Status, 100)
+
+	gate := g.Watch(ctx, func(status *Status) {
+		statuses <- status
+	})
+
+	// Wait for the first check to close the gate
+	<-statuses
+
+	wait_ctx, wait_cancel := context.WithTimeout(context.Background(), time.Second)
+	defer wait_cancel()
+
+	err = gate.Wait(wait_ctx)
+
+	if err != nil {
+		t.Fatalf("Expected the gate to open once the cluster recovered, %v", err)
+	}
+
+	cancel()
+
+	select {
+	case <-gate.Done():
+		// pass
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the watcher to stop once its context was cancelled")
+	}
+}
@@ -0,0 +1,118 @@
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ScanFunc is called for each record read by Scan. rec is only valid for the duration of the call.
+type ScanFunc func(rec *Record) error
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Open opens the dump file at path for reading, decompressing it if path ends in ".bz2" or ".gz".
+func Open(path string) (io.ReadCloser, error) {
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s, %w", path, err)
+	}
+
+	r, err := NewReader(fh, path)
+
+	if err != nil {
+		fh.Close()
+		return nil, fmt.Errorf("Failed to open %s, %w", path, err)
+	}
+
+	if r == io.Reader(fh) {
+		return fh, nil
+	}
+
+	rc := &readCloser{
+		Reader: r,
+		Closer: fh,
+	}
+
+	return rc, nil
+}
+
+// NewReader returns an io.Reader which decompresses the records read from r if name ends in ".bz2" or
+// ".gz", or r itself otherwise.
+func NewReader(r io.Reader, name string) (io.Reader, error) {
+
+	switch {
+	case strings.HasSuffix(name, ".bz2"):
+		return bzip2.NewReader(r), nil
+	case strings.HasSuffix(name, ".gz"):
+		return gzip.NewReader(r)
+	}
+
+	return r, nil
+}
+
+// LineFunc is called for each line read by ScanLines with its (1-based) line number. line is only valid for
+// the duration of the call.
+type LineFunc func(line_number int, line []byte) error
+
+// Scan reads dump records, one per line, from r and calls fn for each of them in order. Blank lines are
+// skipped. It stops at the first record which can not be parsed or for which fn returns an error.
+func Scan(r io.Reader, fn ScanFunc) error {
+
+	return ScanLines(r, func(line_number int, line []byte) error {
+
+		rec, err := Parse(line)
+
+		if err != nil {
+			return fmt.Errorf("Failed to parse record at line %d, %w", line_number, err)
+		}
+
+		return fn(rec)
+	})
+}
+
+// ScanLines reads lines from r and calls fn for each of them in order, with surrounding whitespace removed.
+// Blank lines are skipped. It stops at the first line for which fn returns an error.
+func ScanLines(r io.Reader, fn LineFunc) error {
+
+	br := bufio.NewReaderSize(r, 1024*1024)
+	line_number := 0
+
+	for {
+
+		line, read_err := br.ReadBytes('\n')
+
+		if read_err != nil && read_err != io.EOF {
+			return fmt.Errorf("Failed to read line %d, %w", line_number+1, read_err)
+		}
+
+		if len(line) > 0 {
+
+			line_number += 1
+			line = bytes.TrimSpace(line)
+
+			if len(line) > 0 {
+
+				err := fn(line_number, line)
+
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if read_err == io.EOF {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package record
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanLines(t *testing.T) {
+
+	// The last line has no trailing newline
+	r := strings.NewReader("{\"a\":1}\n\n  \n\t{\"b\":2}  \r\n{\"c\":3}")
+
+	lines := make([]string, 0)
+
+	err := ScanLines(r, func(line_number int, line []byte) error {
+		lines = append(lines, fmt.Sprintf("%d %s", line_number, line))
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to scan lines, %v", err)
+	}
+
+	expected := []string{`1 {"a":1}`, `4 {"b":2}`, `5 {"c":3}`}
+
+	if strings.Join(lines, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Unexpected lines %v", lines)
+	}
+
+	// Errors returned by fn stop the scan
+	count := 0
+
+	err = ScanLines(strings.NewReader("a\nb\nc\n"), func(line_number int, line []byte) error {
+
+		count += 1
+
+		if line_number == 2 {
+			return io.ErrUnexpectedEOF
+		}
+
+		return nil
+	})
+
+	if err != io.ErrUnexpectedEOF || count != 2 {
+		t.Fatalf("Expected the scan to stop at the second line, got %v after %d lines", err, count)
+	}
+}
+
+func TestScan(t *testing.T) {
+
+	r := strings.NewReader(`{"_id":"1","_source":{}}` + "\n" + `{"_id":"2","_source":{"a":1}}` + "\n")
+
+	ids := make([]string, 0)
+
+	err := Scan(r, func(rec *Record) error {
+		ids = append(ids, rec.ID)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to scan records, %v", err)
+	}
+
+	if strings.Join(ids, ",") != "1,2" {
+		t.Fatalf("Unexpected records %v", ids)
+	}
+
+	r = strings.NewReader(`{"_id":"1","_source":{}}` + "\n" + `{"_id":"2"}` + "\n")
+
+	err = Scan(r, func(rec *Record) error {
+		return nil
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("Expected an error for the record at line 2, got %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+
+	dir := t.TempDir()
+	body := []byte(`{"_id":"1","_source":{}}` + "\n")
+
+	var gz_buf bytes.Buffer
+
+	gz_wr := gzip.NewWriter(&gz_buf)
+	gz_wr.Write(body)
+	gz_wr.Close()
+
+	files := map[string][]byte{
+		"dump.jsonl":    body,
+		"dump.jsonl.gz": gz_buf.Bytes(),
+	}
+
+	for name, contents := range files {
+
+		path := filepath.Join(dir, name)
+
+		err := os.WriteFile(path, contents, 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", path, err)
+		}
+
+		fh, err := Open(path)
+
+		if err != nil {
+			t.Fatalf("Failed to open %s, %v", path, err)
+		}
+
+		out, err := io.ReadAll(fh)
+		fh.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to read %s, %v", path, err)
+		}
+
+		if !bytes.Equal(out, body) {
+			t.Fatalf("Unexpected contents of %s, %s", name, out)
+		}
+	}
+
+	_, err := Open(filepath.Join(dir, "missing.jsonl"))
+
+	if err == nil {
+		t.Fatalf("Expected an error opening a missing file")
+	}
+}
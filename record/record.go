@@ -0,0 +1,116 @@
+// package record provides methods for extracting the properties of a dump record (a single line of JSON in
+// the format produced by the dump tool) without fully decoding it.
+package record
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+// DEFAULT_TYPE is the mapping type reported for every document by Elasticsearch 7. It is not a legacy type.
+const DEFAULT_TYPE string = "_doc"
+
+// Record is a dump record. Source references the bytes of the line the record was parsed from rather
+// than a copy of them.
+type Record struct {
+	// ID is empty if the record has no _id.
+	ID    string
+	Index string
+	// Type is the mapping type of records dumped from Elasticsearch 6 (or earlier) indices. It is empty
+	// if the record has no type or if its type is DEFAULT_TYPE.
+	Type   string
+	Source []byte
+}
+
+// Parse extracts the _id, _index, _type and _source properties of the dump record body. body is not
+// validated beyond what is needed to locate those properties. Records without an _id are allowed, in which
+// case ID is empty and Elasticsearch will assign one when the document is indexed.
+func Parse(body []byte) (*Record, error) {
+
+	rsp := gjson.GetManyBytes(body, "_id", "_index", "_type", "_source")
+
+	id_rsp := rsp[0]
+	index_rsp := rsp[1]
+	type_rsp := rsp[2]
+	source_rsp := rsp[3]
+
+	if !source_rsp.Exists() {
+		return nil, fmt.Errorf("Record is missing _source property")
+	}
+
+	if !source_rsp.IsObject() {
+		return nil, fmt.Errorf("Record _source property is not an object")
+	}
+
+	r := &Record{
+		ID:     id_rsp.String(),
+		Index:  index_rsp.String(),
+		Source: rawBytes(body, source_rsp),
+	}
+
+	if t := type_rsp.String(); t != DEFAULT_TYPE {
+		r.Type = t
+	}
+
+	return r, nil
+}
+
+// AddField returns a copy of the record's Source with the top-level property name set to value. It is an
+// error for Source to already have a property called name.
+func (r *Record) AddField(name string, value string) ([]byte, error) {
+
+	empty := true
+	exists := false
+
+	gjson.ParseBytes(r.Source).ForEach(func(k gjson.Result, v gjson.Result) bool {
+		empty = false
+		exists = k.String() == name
+		return !exists
+	})
+
+	if exists {
+		return nil, fmt.Errorf("Record already has a %s property", name)
+	}
+
+	enc_name, err := json.Marshal(name)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode property name, %w", err)
+	}
+
+	enc_value, err := json.Marshal(value)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode property value, %w", err)
+	}
+
+	// Source is known to be an object so the new property can be inserted after its opening brace
+	source := make([]byte, 0, len(r.Source)+len(enc_name)+len(enc_value)+2)
+	source = append(source, '{')
+	source = append(source, enc_name...)
+	source = append(source, ':')
+	source = append(source, enc_value...)
+
+	if !empty {
+		source = append(source, ',')
+	}
+
+	source = append(source, r.Source[1:]...)
+	return source, nil
+}
+
+// rawBytes returns the slice of body that r was parsed from, falling back to a copy of r.Raw if its
+// position is not known.
+func rawBytes(body []byte, r gjson.Result) []byte {
+
+	start := r.Index
+	end := start + len(r.Raw)
+
+	if start > 0 && end <= len(body) {
+		return body[start:end:end]
+	}
+
+	return []byte(r.Raw)
+}
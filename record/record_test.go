@@ -0,0 +1,285 @@
+package record
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v7/estransport"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	json "github.com/goccy/go-json"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/bulk"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/model"
+)
+
+func TestParse(t *testing.T) {
+
+	body := []byte(`{"_index":"books","_type":"book","_id":"1","_source":{"title":"Tin Drum","pages":[1, 2]},"sort":[1]}`)
+
+	r, err := Parse(body)
+
+	if err != nil {
+		t.Fatalf("Failed to parse record, %v", err)
+	}
+
+	if r.ID != "1" || r.Index != "books" || r.Type != "book" {
+		t.Fatalf("Unexpected record %+v", r)
+	}
+
+	if string(r.Source) != `{"title":"Tin Drum","pages":[1, 2]}` {
+		t.Fatalf("Unexpected source %s", r.Source)
+	}
+
+	// Source should reference body rather than a copy of it
+	body[bytes.Index(body, []byte("Tin"))] = 'P'
+
+	if !bytes.HasPrefix(r.Source, []byte(`{"title":"Pin`)) {
+		t.Fatalf("Expected source to reference the record's bytes")
+	}
+}
+
+func TestParseDefaultType(t *testing.T) {
+
+	r, err := Parse([]byte(`{"_id":"1","_type":"_doc","_source":{}}`))
+
+	if err != nil {
+		t.Fatalf("Failed to parse record, %v", err)
+	}
+
+	if r.Type != "" {
+		t.Fatalf("Expected _doc not to be reported as a legacy type, got %s", r.Type)
+	}
+}
+
+func TestParseWithoutID(t *testing.T) {
+
+	r, err := Parse([]byte(`{"_source":{"a":1}}`))
+
+	if err != nil {
+		t.Fatalf("Failed to parse record without an _id, %v", err)
+	}
+
+	if r.ID != "" {
+		t.Fatalf("Expected an empty ID, got %s", r.ID)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+
+	tests := []string{
+		`{"_id":"1"}`,
+		`{"_id":"1","_source":[1]}`,
+		`{"_id":"1","_source":"a"}`,
+	}
+
+	for _, body := range tests {
+
+		_, err := Parse([]byte(body))
+
+		if err == nil {
+			t.Fatalf("Expected an error parsing %s", body)
+		}
+	}
+}
+
+func TestAddField(t *testing.T) {
+
+	tests := map[string]string{
+		`{"_id":"1","_source":{"a":1}}`: `{"_type":"book","a":1}`,
+		`{"_id":"1","_source":{}}`:      `{"_type":"book"}`,
+	}
+
+	for body, expected := range tests {
+
+		r, err := Parse([]byte(body))
+
+		if err != nil {
+			t.Fatalf("Failed to parse record, %v", err)
+		}
+
+		source, err := r.AddField("_type", "book")
+
+		if err != nil {
+			t.Fatalf("Failed to add field, %v", err)
+		}
+
+		if string(source) != expected {
+			t.Fatalf("Expected %s, got %s", expected, source)
+		}
+	}
+
+	r, err := Parse([]byte(`{"_id":"1","_source":{"a":1,"_type":"x"}}`))
+
+	if err != nil {
+		t.Fatalf("Failed to parse record, %v", err)
+	}
+
+	_, err = r.AddField("_type", "book")
+
+	if err == nil {
+		t.Fatalf("Expected an error adding a field which already exists")
+	}
+}
+
+// benchmarkRecords returns count dump records, each with a _source of roughly 1KB.
+func benchmarkRecords(count int) [][]byte {
+
+	records := make([][]byte, count)
+
+	for i := 0; i < count; i++ {
+		records[i] = []byte(fmt.Sprintf(`{"_index":"books","_type":"_doc","_id":"%d","_score":1,"_source":{"title":"Book %d","body":"%s","tags":["a","b","c"],"nested":{"n":%d,"f":1.5}}}`, i, i, strings.Repeat("lorem ipsum ", 80), i))
+	}
+
+	return records
+}
+
+// newBenchmarkBulkIndexer returns a bulk indexer which sends its requests to a server that accepts every item.
+func newBenchmarkBulkIndexer(b *testing.B) esutil.BulkIndexer {
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if !strings.HasSuffix(req.URL.Path, "/_bulk") {
+			w.Write([]byte(`{"version":{"number":"7.10.0"}}`))
+			return
+		}
+
+		body, _ := io.ReadAll(req.Body)
+		count := bytes.Count(body, []byte("\n")) / 2
+
+		items := make([]string, count)
+
+		for i := range items {
+			items[i] = `{"index":{"status":201,"result":"created"}}`
+		}
+
+		fmt.Fprintf(w, `{"took":1,"errors":false,"items":[%s]}`, strings.Join(items, ","))
+	})
+
+	srv := httptest.NewServer(handler)
+	b.Cleanup(srv.Close)
+
+	srv_url, err := url.Parse(srv.URL)
+
+	if err != nil {
+		b.Fatalf("Failed to parse server URL, %v", err)
+	}
+
+	tr, err := estransport.New(estransport.Config{
+		URLs: []*url.URL{srv_url},
+	})
+
+	if err != nil {
+		b.Fatalf("Failed to create client, %v", err)
+	}
+
+	bi, err := bulk.NewBulkIndexer(bulk.Config{
+		Transport:  tr,
+		Index:      "books",
+		NumWorkers: 4,
+		FlushBytes: 5e+6,
+	})
+
+	if err != nil {
+		b.Fatalf("Failed to create bulk indexer, %v", err)
+	}
+
+	return bi
+}
+
+func runBenchmark(b *testing.B, extract func(body []byte) (string, []byte, error)) {
+
+	records := benchmarkRecords(1000)
+	bi := newBenchmarkBulkIndexer(b)
+
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+
+		body := records[i%len(records)]
+		b.SetBytes(int64(len(body)))
+
+		id, source, err := extract(body)
+
+		if err != nil {
+			b.Fatalf("Failed to extract document, %v", err)
+		}
+
+		err = bi.Add(ctx, esutil.BulkIndexerItem{
+			Action:     "index",
+			DocumentID: id,
+			Body:       bytes.NewReader(source),
+		})
+
+		if err != nil {
+			b.Fatalf("Failed to add document, %v", err)
+		}
+	}
+
+	err := bi.Close(ctx)
+
+	if err != nil {
+		b.Fatalf("Failed to close bulk indexer, %v", err)
+	}
+
+	if bi.Stats().NumFailed > 0 {
+		b.Fatalf("Failed to index %d documents", bi.Stats().NumFailed)
+	}
+}
+
+// BenchmarkRestoreParse indexes records using Parse to hand the raw _source bytes to the bulk indexer.
+func BenchmarkRestoreParse(b *testing.B) {
+
+	runBenchmark(b, func(body []byte) (string, []byte, error) {
+
+		r, err := Parse(body)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		return r.ID, r.Source, nil
+	})
+}
+
+// BenchmarkRestoreUnmarshal indexes records by decoding each one and encoding its _source again, for comparison.
+func BenchmarkRestoreUnmarshal(b *testing.B) {
+
+	runBenchmark(b, func(body []byte) (string, []byte, error) {
+
+		var hit *model.ESHit
+
+		err := json.Unmarshal(body, &hit)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		var source interface{}
+
+		err = json.Unmarshal(hit.Source, &source)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		enc_source, err := json.Marshal(source)
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		return hit.ID, enc_source, nil
+	})
+}
@@ -0,0 +1,327 @@
+package record
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/tidwall/gjson"
+)
+
+// Sorter sorts dump records by ID, spilling them to temporary files once more than a fixed number of bytes
+// of records have been added. Records with the same ID are kept in the order they were added.
+type Sorter struct {
+	max_bytes int
+	temp_dir  string
+	lines     []sortLine
+	bytes     int
+	runs      []string
+}
+
+type sortLine struct {
+	id   string
+	line []byte
+}
+
+// NewSorter returns a new Sorter which holds up to max_bytes bytes of records in memory and writes
+// temporary files to temp_dir (or the default directory for temporary files, if empty).
+func NewSorter(max_bytes int, temp_dir string) *Sorter {
+
+	s := &Sorter{
+		max_bytes: max_bytes,
+		temp_dir:  temp_dir,
+		lines:     make([]sortLine, 0),
+		runs:      make([]string, 0),
+	}
+
+	return s
+}
+
+// Add adds the record line, whose ID is id. line is copied.
+func (s *Sorter) Add(id string, line []byte) error {
+
+	l := sortLine{
+		id:   id,
+		line: append([]byte(nil), line...),
+	}
+
+	s.lines = append(s.lines, l)
+	s.bytes += len(l.id) + len(l.line)
+
+	if s.bytes < s.max_bytes {
+		return nil
+	}
+
+	return s.spill()
+}
+
+// spill sorts the records held in memory and writes them to a new temporary file.
+func (s *Sorter) spill() error {
+
+	s.sortLines()
+
+	fh, err := os.CreateTemp(s.temp_dir, "sort-*.jsonl")
+
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary file, %w", err)
+	}
+
+	s.runs = append(s.runs, fh.Name())
+
+	err = writeLines(fh, s.lines)
+
+	if err != nil {
+		fh.Close()
+		return fmt.Errorf("Failed to write temporary file, %w", err)
+	}
+
+	err = fh.Close()
+
+	if err != nil {
+		return fmt.Errorf("Failed to write temporary file, %w", err)
+	}
+
+	s.lines = s.lines[:0]
+	s.bytes = 0
+
+	return nil
+}
+
+func (s *Sorter) sortLines() {
+
+	sort.SliceStable(s.lines, func(i int, j int) bool {
+		return s.lines[i].id < s.lines[j].id
+	})
+}
+
+// WriteTo writes every record added so far to w, one per line, in order.
+func (s *Sorter) WriteTo(w io.Writer) (int64, error) {
+
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	err := s.Each(func(line []byte) error {
+
+		_, err := cw.Write(line)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = cw.Write([]byte("\n"))
+		return err
+	})
+
+	if err != nil {
+		return cw.n, err
+	}
+
+	return cw.n, bw.Flush()
+}
+
+// Each calls fn with every record added so far, without its trailing newline, in order. line is only
+// valid until fn returns.
+func (s *Sorter) Each(fn func(line []byte) error) error {
+
+	if len(s.runs) == 0 {
+
+		s.sortLines()
+
+		for _, l := range s.lines {
+
+			err := fn(l.line)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if len(s.lines) > 0 {
+
+		err := s.spill()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.merge(fn)
+}
+
+// merge calls fn with the records in the temporary files, in order.
+func (s *Sorter) merge(fn func(line []byte) error) error {
+
+	h := make(runHeap, 0, len(s.runs))
+
+	for i, path := range s.runs {
+
+		fh, err := os.Open(path)
+
+		if err != nil {
+			return fmt.Errorf("Failed to open temporary file, %w", err)
+		}
+
+		defer fh.Close()
+
+		r := &run{
+			index:  i,
+			reader: bufio.NewReaderSize(fh, 1024*1024),
+		}
+
+		ok, err := r.next()
+
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			h = append(h, r)
+		}
+	}
+
+	heap.Init(&h)
+
+	for len(h) > 0 {
+
+		r := h[0]
+
+		err := fn(r.line[:len(r.line)-1])
+
+		if err != nil {
+			return err
+		}
+
+		ok, err := r.next()
+
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+
+	return nil
+}
+
+// Close removes any temporary files.
+func (s *Sorter) Close() error {
+
+	for _, path := range s.runs {
+
+		err := os.Remove(path)
+
+		if err != nil {
+			return fmt.Errorf("Failed to remove temporary file, %w", err)
+		}
+	}
+
+	s.runs = s.runs[:0]
+	return nil
+}
+
+func writeLines(w io.Writer, lines []sortLine) error {
+
+	bw := bufio.NewWriter(w)
+
+	for _, l := range lines {
+
+		_, err := bw.Write(l.line)
+
+		if err != nil {
+			return err
+		}
+
+		err = bw.WriteByte('\n')
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// run is a temporary file of sorted records.
+type run struct {
+	index  int
+	reader *bufio.Reader
+	id     string
+	// line is the current record, including its trailing newline.
+	line []byte
+}
+
+// next reads the next record, returning false if there are none left.
+func (r *run) next() (bool, error) {
+
+	line, err := r.reader.ReadBytes('\n')
+
+	if err == io.EOF && len(line) == 0 {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to read temporary file, %w", err)
+	}
+
+	r.line = line
+	r.id = gjson.GetBytes(line, "_id").String()
+
+	return true, nil
+}
+
+// runHeap orders runs by the ID of their current record and then by the order they were written in, so
+// that records with the same ID stay in the order they were added.
+type runHeap []*run
+
+func (h runHeap) Len() int {
+	return len(h)
+}
+
+func (h runHeap) Less(i int, j int) bool {
+
+	if h[i].id != h[j].id {
+		return h[i].id < h[j].id
+	}
+
+	return h[i].index < h[j].index
+}
+
+func (h runHeap) Swap(i int, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *runHeap) Push(x interface{}) {
+	*h = append(*h, x.(*run))
+}
+
+func (h *runHeap) Pop() interface{} {
+
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return r
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+
+	return n, err
+}
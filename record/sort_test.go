@@ -0,0 +1,131 @@
+package record
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sortedLines(t *testing.T, s *Sorter) []string {
+
+	lines := make([]string, 0)
+
+	err := s.Each(func(line []byte) error {
+		lines = append(lines, string(line))
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to read sorted records, %v", err)
+	}
+
+	return lines
+}
+
+func TestSorter(t *testing.T) {
+
+	s := NewSorter(1<<20, t.TempDir())
+	defer s.Close()
+
+	for _, id := range []string{"b", "a", "c", "a", "10", "2"} {
+
+		err := s.Add(id, []byte(fmt.Sprintf(`{"_id":"%s","n":%d}`, id, len(s.lines))))
+
+		if err != nil {
+			t.Fatalf("Failed to add record, %v", err)
+		}
+	}
+
+	// IDs are sorted in byte order and records with the same ID stay in the order they were added
+	expected := []string{
+		`{"_id":"10","n":4}`,
+		`{"_id":"2","n":5}`,
+		`{"_id":"a","n":1}`,
+		`{"_id":"a","n":3}`,
+		`{"_id":"b","n":0}`,
+		`{"_id":"c","n":2}`,
+	}
+
+	lines := sortedLines(t, s)
+
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected order %v", lines)
+	}
+}
+
+func TestSorterSpill(t *testing.T) {
+
+	dir := t.TempDir()
+	r := rand.New(rand.NewSource(1))
+
+	// Small enough that the records are spilled to several temporary files
+	s := NewSorter(1000, dir)
+
+	expected := make([]string, 0)
+
+	for i := 0; i < 500; i++ {
+
+		line := fmt.Sprintf(`{"_id":"%03d","n":%d}`, r.Intn(100), i)
+		expected = append(expected, line)
+
+		err := s.Add(line[8:11], []byte(line))
+
+		if err != nil {
+			t.Fatalf("Failed to add record, %v", err)
+		}
+	}
+
+	if len(s.runs) < 2 {
+		t.Fatalf("Expected records to be spilled to temporary files, got %d", len(s.runs))
+	}
+
+	sort.SliceStable(expected, func(i int, j int) bool {
+		return expected[i][8:11] < expected[j][8:11]
+	})
+
+	var buf bytes.Buffer
+
+	n, err := s.WriteTo(&buf)
+
+	if err != nil {
+		t.Fatalf("Failed to write sorted records, %v", err)
+	}
+
+	if n != int64(buf.Len()) {
+		t.Fatalf("Expected %d bytes to be reported as written, got %d", buf.Len(), n)
+	}
+
+	if buf.String() != strings.Join(expected, "\n")+"\n" {
+		t.Fatalf("Unexpected order:\n%s", buf.String())
+	}
+
+	err = s.Close()
+
+	if err != nil {
+		t.Fatalf("Failed to close sorter, %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		t.Fatalf("Failed to read %s, %v", dir, err)
+	}
+
+	if len(entries) != 0 {
+		t.Fatalf("Expected temporary files to be removed, found %d", len(entries))
+	}
+}
+
+func TestSorterEmpty(t *testing.T) {
+
+	s := NewSorter(1000, t.TempDir())
+	defer s.Close()
+
+	if len(sortedLines(t, s)) != 0 {
+		t.Fatalf("Expected no records")
+	}
+}
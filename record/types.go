@@ -0,0 +1,64 @@
+package record
+
+import (
+	"sync"
+)
+
+// TYPED_ID_SEPARATOR separates the type and _id of the IDs returned by TypedID.
+const TYPED_ID_SEPARATOR string = "#"
+
+// TypedID returns the record's _id prefixed with its legacy type, as "{type}#{id}", so that documents of
+// different types with the same _id remain distinct in a typeless index. Records without a type or an _id
+// keep their _id as is.
+func (r *Record) TypedID() string {
+
+	if r.Type == "" || r.ID == "" {
+		return r.ID
+	}
+
+	return r.Type + TYPED_ID_SEPARATOR + r.ID
+}
+
+// TypeCollisions detects records of different legacy types with the same _id, which replace each other when
+// they are restored into the same typeless index. It remembers the _id of every typed record it is given so
+// its memory use grows with the number of records.
+type TypeCollisions struct {
+	seen map[string]string
+	mu   *sync.Mutex
+}
+
+// NewTypeCollisions returns a new TypeCollisions.
+func NewTypeCollisions() *TypeCollisions {
+
+	c := &TypeCollisions{
+		seen: make(map[string]string),
+		mu:   new(sync.Mutex),
+	}
+
+	return c
+}
+
+// Add remembers the type of r and returns the type of an earlier record with the same _id and a different
+// type, or an empty string if there was none. Records without a type or an _id are ignored.
+func (c *TypeCollisions) Add(r *Record) string {
+
+	if r.Type == "" || r.ID == "" {
+		return ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	other, ok := c.seen[r.ID]
+
+	if !ok {
+		c.seen[r.ID] = r.Type
+		return ""
+	}
+
+	if other == r.Type {
+		return ""
+	}
+
+	return other
+}
@@ -0,0 +1,56 @@
+package record
+
+import (
+	"testing"
+)
+
+func TestTypedID(t *testing.T) {
+
+	tests := []struct {
+		record   *Record
+		expected string
+	}{
+		{&Record{ID: "1", Type: "book"}, "book#1"},
+		{&Record{ID: "1"}, "1"},
+		{&Record{Type: "book"}, ""},
+	}
+
+	for _, test := range tests {
+
+		id := test.record.TypedID()
+
+		if id != test.expected {
+			t.Fatalf("Expected %+v to have the ID '%s', got '%s'", test.record, test.expected, id)
+		}
+	}
+}
+
+func TestTypeCollisions(t *testing.T) {
+
+	c := NewTypeCollisions()
+
+	records := []struct {
+		record   *Record
+		expected string
+	}{
+		{&Record{ID: "1", Type: "book"}, ""},
+		{&Record{ID: "2", Type: "book"}, ""},
+		// The same _id and type is a duplicate record rather than a collision
+		{&Record{ID: "1", Type: "book"}, ""},
+		{&Record{ID: "1", Type: "author"}, "book"},
+		{&Record{ID: "2", Type: "author"}, "book"},
+		{&Record{ID: "3", Type: "author"}, ""},
+		// Records without a type or an _id are ignored
+		{&Record{ID: "3"}, ""},
+		{&Record{Type: "book"}, ""},
+	}
+
+	for _, r := range records {
+
+		other := c.Add(r.record)
+
+		if other != r.expected {
+			t.Fatalf("Expected %+v to collide with '%s', got '%s'", r.record, r.expected, other)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package record
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/bzip2"
+)
+
+// writeCloser closes a compressing writer and then the file it writes to.
+type writeCloser struct {
+	io.WriteCloser
+	fh *os.File
+}
+
+func (w *writeCloser) Close() error {
+
+	err := w.WriteCloser.Close()
+
+	if err != nil {
+		w.fh.Close()
+		return err
+	}
+
+	return w.fh.Close()
+}
+
+// Create creates (or truncates) the dump file at path for writing, compressing it if path ends in ".bz2" or
+// ".gz". The returned io.WriteCloser must be closed to finish writing the file.
+func Create(path string) (io.WriteCloser, error) {
+
+	fh, err := os.Create(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create %s, %w", path, err)
+	}
+
+	if !strings.HasSuffix(path, ".bz2") && !strings.HasSuffix(path, ".gz") {
+		return fh, nil
+	}
+
+	return &writeCloser{WriteCloser: NewWriter(fh, path), fh: fh}, nil
+}
+
+// NewWriter returns an io.WriteCloser which compresses the records written to it before writing them to w
+// if name ends in ".bz2" or ".gz". Closing the returned writer finishes the compressed stream but does not
+// close w.
+func NewWriter(w io.Writer, name string) io.WriteCloser {
+
+	switch {
+	case strings.HasSuffix(name, ".bz2"):
+		return bzip2.NewWriter(w)
+	case strings.HasSuffix(name, ".gz"):
+		return gzip.NewWriter(w)
+	}
+
+	return nopCloser{w}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
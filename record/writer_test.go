@@ -0,0 +1,55 @@
+package record
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreate(t *testing.T) {
+
+	dir := t.TempDir()
+	body := []byte(strings.Repeat(`{"_id":"1","_source":{"title":"Tin Drum"}}`+"\n", 100))
+
+	for _, name := range []string{"dump.jsonl", "dump.jsonl.gz", "dump.jsonl.bz2"} {
+
+		path := filepath.Join(dir, name)
+
+		wc, err := Create(path)
+
+		if err != nil {
+			t.Fatalf("Failed to create %s, %v", path, err)
+		}
+
+		_, err = wc.Write(body)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", path, err)
+		}
+
+		err = wc.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to close %s, %v", path, err)
+		}
+
+		fh, err := Open(path)
+
+		if err != nil {
+			t.Fatalf("Failed to open %s, %v", path, err)
+		}
+
+		out, err := io.ReadAll(fh)
+		fh.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to read %s, %v", path, err)
+		}
+
+		if !bytes.Equal(out, body) {
+			t.Fatalf("Unexpected contents of %s", name)
+		}
+	}
+}
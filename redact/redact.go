@@ -0,0 +1,268 @@
+// package redact provides methods for masking, dropping or pseudonymizing the values of fields in dump
+// records.
+package redact
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/canonical"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/fields"
+)
+
+// The actions a rule can take.
+const (
+	// DROP removes the value, and its key, from the record.
+	DROP string = "drop"
+	// MASK replaces the characters of a string with MASK_CHARACTER, or any value with a replacement.
+	MASK string = "mask"
+	// HASH replaces the value with the hex-encoded HMAC-SHA256 of the value.
+	HASH string = "hash"
+)
+
+// The character that masked characters are replaced with.
+const MASK_CHARACTER rune = '*'
+
+// Rule describes how to redact the values at a path.
+type Rule struct {
+	// Path is the gjson path of the values to redact, relative to the _source of each record.
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	// Replacement, if set, is the JSON value which MASK replaces values with. Otherwise strings have their
+	// characters masked and other values are replaced with null.
+	Replacement json.RawMessage `json:"replacement,omitempty"`
+	// KeepFirst and KeepLast are the number of characters at the start and end of strings which MASK leaves
+	// as they are. Strings too short to keep them are masked entirely.
+	KeepFirst int `json:"keep_first,omitempty"`
+	KeepLast  int `json:"keep_last,omitempty"`
+	// Length, if greater than zero, is the number of hex characters of the hash HASH keeps.
+	Length int `json:"length,omitempty"`
+}
+
+// String returns a short description of r, for example "hash email".
+func (r *Rule) String() string {
+	return r.Action + " " + r.Path
+}
+
+// Count is the number of values a rule has redacted.
+type Count struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	// Values is the number of values the rule redacted.
+	Values int `json:"values"`
+	// Records is the number of records with at least one value the rule redacted.
+	Records int `json:"records"`
+}
+
+// Redactor applies a list of rules to dump records. It is not safe for concurrent use.
+type Redactor struct {
+	rules  []*Rule
+	secret []byte
+	counts []*Count
+}
+
+// ReadRules reads a JSON-encoded list of rules from the file at path.
+func ReadRules(path string) ([]*Rule, error) {
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read rules, %w", err)
+	}
+
+	var rules []*Rule
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&rules)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode rules %s, %w", path, err)
+	}
+
+	return rules, nil
+}
+
+// NewRedactor returns a new Redactor which applies rules, in order, using secret as the key for HASH rules.
+// secret is required if there are any HASH rules. The same secret always produces the same hash for the
+// same value, so values hashed in different dumps with the same secret can still be matched.
+func NewRedactor(rules []*Rule, secret []byte) (*Redactor, error) {
+
+	counts := make([]*Count, len(rules))
+
+	for i, r := range rules {
+
+		if r.Path == "" {
+			return nil, fmt.Errorf("Rule %d is missing a path", i+1)
+		}
+
+		switch r.Action {
+		case DROP:
+			// pass
+		case MASK:
+
+			if r.KeepFirst < 0 || r.KeepLast < 0 {
+				return nil, fmt.Errorf("Rule %d (%s) can not keep a negative number of characters", i+1, r)
+			}
+
+			if len(r.Replacement) > 0 && !json.Valid(r.Replacement) {
+				return nil, fmt.Errorf("Rule %d (%s) has an invalid replacement", i+1, r)
+			}
+
+		case HASH:
+
+			if len(secret) == 0 {
+				return nil, fmt.Errorf("Rule %d (%s) requires a secret", i+1, r)
+			}
+
+			if r.Length < 0 || r.Length > sha256.Size*2 {
+				return nil, fmt.Errorf("Rule %d (%s) has an invalid length, it must be between 0 and %d", i+1, r, sha256.Size*2)
+			}
+
+		default:
+			return nil, fmt.Errorf("Rule %d has an invalid action '%s'", i+1, r.Action)
+		}
+
+		counts[i] = &Count{
+			Path:   r.Path,
+			Action: r.Action,
+		}
+	}
+
+	rd := &Redactor{
+		rules:  rules,
+		secret: secret,
+		counts: counts,
+	}
+
+	return rd, nil
+}
+
+// Redact returns a copy of the dump record body with every rule applied to its _source.
+func (rd *Redactor) Redact(body []byte) ([]byte, error) {
+
+	for i, r := range rd.rules {
+
+		matches, err := fields.Find(body, "_source."+r.Path)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to apply rule %d (%s), %w", i+1, r, err)
+		}
+
+		for _, m := range matches {
+
+			body, err = rd.apply(r, body, m)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to apply rule %d (%s), %w", i+1, r, err)
+			}
+		}
+
+		if len(matches) > 0 {
+			rd.counts[i].Values += len(matches)
+			rd.counts[i].Records += 1
+		}
+	}
+
+	return body, nil
+}
+
+// Counts returns the number of values each rule has redacted so far, in the order of the rules.
+func (rd *Redactor) Counts() []*Count {
+	return rd.counts
+}
+
+// apply returns a copy of body with r applied to the value m.
+func (rd *Redactor) apply(r *Rule, body []byte, m *fields.Match) ([]byte, error) {
+
+	switch r.Action {
+	case DROP:
+		return fields.Delete(body, m)
+	case MASK:
+		return fields.Replace(body, m, rd.mask(r, m.Raw)), nil
+	default:
+
+		value, err := rd.hash(r, m.Raw)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return fields.Replace(body, m, value), nil
+	}
+}
+
+// mask returns the masked JSON value of the raw JSON value raw.
+func (rd *Redactor) mask(r *Rule, raw string) []byte {
+
+	if len(r.Replacement) > 0 {
+		return r.Replacement
+	}
+
+	v := gjson.Parse(raw)
+
+	if v.Type != gjson.String {
+		return []byte("null")
+	}
+
+	str := v.String()
+	count := utf8.RuneCountInString(str)
+
+	var b strings.Builder
+	i := 0
+
+	for _, c := range str {
+
+		if count <= r.KeepFirst+r.KeepLast || (i >= r.KeepFirst && i < count-r.KeepLast) {
+			c = MASK_CHARACTER
+		}
+
+		b.WriteRune(c)
+		i += 1
+	}
+
+	enc, _ := json.Marshal(b.String())
+	return enc
+}
+
+// hash returns the hashed JSON value of the raw JSON value raw. Strings are hashed as they are and other
+// values are hashed as canonical JSON, so the string "1" and the number 1.0 have the same hash.
+func (rd *Redactor) hash(r *Rule, raw string) ([]byte, error) {
+
+	var value []byte
+	v := gjson.Parse(raw)
+
+	if v.Type == gjson.String {
+		value = []byte(v.String())
+	} else {
+
+		c, err := canonical.Normalize([]byte(raw))
+
+		if err != nil {
+			return nil, err
+		}
+
+		value = c
+	}
+
+	mac := hmac.New(sha256.New, rd.secret)
+	mac.Write(value)
+
+	digest := hex.EncodeToString(mac.Sum(nil))
+
+	if r.Length > 0 {
+		digest = digest[:r.Length]
+	}
+
+	return []byte(`"` + digest + `"`), nil
+}
@@ -0,0 +1,169 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// hmacHex returns the hex-encoded HMAC-SHA256 of value with secret.
+func hmacHex(secret string, value string) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestRedact(t *testing.T) {
+
+	rules := []*Rule{
+		{Action: DROP, Path: "ssn"},
+		{Action: MASK, Path: "phone", KeepLast: 4},
+		{Action: MASK, Path: "name", KeepFirst: 1},
+		{Action: MASK, Path: "age"},
+		{Action: MASK, Path: "address", Replacement: json.RawMessage(`"redacted"`)},
+		{Action: HASH, Path: "email"},
+		{Action: HASH, Path: "donors.#.id", Length: 8},
+		{Action: DROP, Path: "missing"},
+	}
+
+	rd, err := NewRedactor(rules, []byte("s3cret"))
+
+	if err != nil {
+		t.Fatalf("Failed to create redactor, %v", err)
+	}
+
+	body := []byte(`{"_id":"1","_source":{"ssn":"123-45-6789","phone":"415-555-0100","name":"Ådä","age":42,"address":{"city":"SF"},"email":"ada@example.com","donors":[{"id":1},{"id":"1"},{"id":1.0}]}}`)
+
+	out, err := rd.Redact(body)
+
+	if err != nil {
+		t.Fatalf("Failed to redact record, %v", err)
+	}
+
+	// The number 1, the string "1" and the number 1.0 have the same hash
+	id_hash := hmacHex("s3cret", "1")[:8]
+
+	expected := `{"_id":"1","_source":{"phone":"********0100","name":"Å**","age":null,"address":"redacted","email":"` + hmacHex("s3cret", "ada@example.com") + `","donors":[{"id":"` + id_hash + `"},{"id":"` + id_hash + `"},{"id":"` + id_hash + `"}]}}`
+
+	if string(out) != expected {
+		t.Fatalf("Expected %s, got %s", expected, out)
+	}
+
+	counts := rd.Counts()
+
+	expected_values := []int{1, 1, 1, 1, 1, 1, 3, 0}
+
+	for i, c := range counts {
+
+		if c.Values != expected_values[i] {
+			t.Fatalf("Expected rule %d (%s %s) to redact %d values, got %d", i+1, c.Action, c.Path, expected_values[i], c.Values)
+		}
+	}
+
+	_, err = rd.Redact([]byte(`{"_id":"2","_source":{"donors":[{"id":2}]}}`))
+
+	if err != nil {
+		t.Fatalf("Failed to redact record, %v", err)
+	}
+
+	if counts[6].Values != 4 || counts[6].Records != 2 {
+		t.Fatalf("Expected 4 values in 2 records, got %d in %d", counts[6].Values, counts[6].Records)
+	}
+}
+
+func TestMaskShort(t *testing.T) {
+
+	rd, err := NewRedactor([]*Rule{{Action: MASK, Path: "pin", KeepFirst: 2, KeepLast: 2}}, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to create redactor, %v", err)
+	}
+
+	// Strings too short to keep any characters are masked entirely
+	tests := map[string]string{
+		`"1234"`:   `"****"`,
+		`"12345"`:  `"12*45"`,
+		`""`:       `""`,
+		`"a\"bcd"`: `"a\"*cd"`,
+	}
+
+	for value, expected := range tests {
+
+		out, err := rd.Redact([]byte(`{"_source":{"pin":` + value + `}}`))
+
+		if err != nil {
+			t.Fatalf("Failed to redact %s, %v", value, err)
+		}
+
+		if string(out) != `{"_source":{"pin":`+expected+`}}` {
+			t.Fatalf("Expected %s to be masked as %s, got %s", value, expected, out)
+		}
+	}
+}
+
+func TestNewRedactorInvalid(t *testing.T) {
+
+	tests := map[string]*Rule{
+		"missing path":         {Action: DROP},
+		"invalid action":       {Action: "shred", Path: "a"},
+		"negative keep":        {Action: MASK, Path: "a", KeepFirst: -1},
+		"invalid replacement":  {Action: MASK, Path: "a", Replacement: json.RawMessage(`{`)},
+		"hash length too long": {Action: HASH, Path: "a", Length: 65},
+	}
+
+	for name, r := range tests {
+
+		_, err := NewRedactor([]*Rule{r}, []byte("s3cret"))
+
+		if err == nil {
+			t.Fatalf("Expected an error for a rule with a %s", name)
+		}
+	}
+
+	_, err := NewRedactor([]*Rule{{Action: HASH, Path: "a"}}, nil)
+
+	if err == nil {
+		t.Fatalf("Expected an error for a hash rule without a secret")
+	}
+}
+
+func TestReadRules(t *testing.T) {
+
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "rules.json")
+
+	err := os.WriteFile(path, []byte(`[{"path":"email","action":"hash","length":12},{"path":"phone","action":"mask","keep_last":4}]`), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write rules, %v", err)
+	}
+
+	rules, err := ReadRules(path)
+
+	if err != nil {
+		t.Fatalf("Failed to read rules, %v", err)
+	}
+
+	if len(rules) != 2 || rules[0].String() != "hash email" || rules[0].Length != 12 || rules[1].KeepLast != 4 {
+		t.Fatalf("Unexpected rules %+v, %+v", rules[0], rules[1])
+	}
+
+	err = os.WriteFile(path, []byte(`[{"path":"email","action":"hash","lenght":12}]`), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write rules, %v", err)
+	}
+
+	_, err = ReadRules(path)
+
+	if err == nil {
+		t.Fatalf("Expected an error reading a rule with an unknown property")
+	}
+}
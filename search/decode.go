@@ -0,0 +1,189 @@
+// package search provides methods for reading Elasticsearch search responses.
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/tidwall/gjson"
+)
+
+// Page describes a search response page once its hits have been consumed.
+type Page struct {
+	ScrollID      string
+	PointInTimeID string
+	Total         int
+	Hits          int
+	Bytes         int
+	// LastSort is the sort value of the last hit in the page, for use with search_after.
+	LastSort json.RawMessage
+}
+
+// HitFunc is called for each hit in a search response page with the (compact) encoded hit. hit is only
+// valid for the duration of the call.
+type HitFunc func(hit []byte) error
+
+// DecodeHits reads a search response from r without buffering the entire response in memory, calling
+// fn for each hit as it is read.
+func DecodeHits(r io.Reader, fn HitFunc) (*Page, error) {
+
+	dec := json.NewDecoder(r)
+	page := &Page{}
+
+	err := expectDelim(dec, '{')
+
+	if err != nil {
+		return nil, err
+	}
+
+	for dec.More() {
+
+		k, err := dec.Token()
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch k {
+		case "_scroll_id":
+
+			err = dec.Decode(&page.ScrollID)
+
+		case "pit_id":
+
+			err = dec.Decode(&page.PointInTimeID)
+
+		case "error":
+
+			var raw json.RawMessage
+			err = dec.Decode(&raw)
+
+			if err == nil {
+				err = fmt.Errorf("%s", raw)
+			}
+
+		case "hits":
+
+			err = decodeHits(dec, page, fn)
+
+		default:
+
+			var raw json.RawMessage
+			err = dec.Decode(&raw)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+func decodeHits(dec *json.Decoder, page *Page, fn HitFunc) error {
+
+	err := expectDelim(dec, '{')
+
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	for dec.More() {
+
+		k, err := dec.Token()
+
+		if err != nil {
+			return err
+		}
+
+		switch k {
+		case "total":
+
+			var total struct {
+				Value int `json:"value"`
+			}
+
+			err = dec.Decode(&total)
+			page.Total = total.Value
+
+		case "hits":
+
+			err = expectDelim(dec, '[')
+
+			if err != nil {
+				return err
+			}
+
+			for dec.More() {
+
+				var raw json.RawMessage
+
+				err = dec.Decode(&raw)
+
+				if err != nil {
+					return err
+				}
+
+				hit := []byte(raw)
+
+				if bytes.IndexByte(hit, '\n') > -1 {
+
+					buf.Reset()
+
+					err = json.Compact(&buf, hit)
+
+					if err != nil {
+						return err
+					}
+
+					hit = buf.Bytes()
+				}
+
+				page.Hits += 1
+				page.Bytes += len(hit)
+
+				if sort := gjson.GetBytes(hit, "sort"); sort.Exists() {
+					page.LastSort = json.RawMessage(sort.Raw)
+				}
+
+				err = fn(hit)
+
+				if err != nil {
+					return err
+				}
+			}
+
+			err = expectDelim(dec, ']')
+
+		default:
+
+			var raw json.RawMessage
+			err = dec.Decode(&raw)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+
+	t, err := dec.Token()
+
+	if err != nil {
+		return err
+	}
+
+	if t != d {
+		return fmt.Errorf("Unexpected token %v, expected %v", t, d)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package search
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeHits(t *testing.T) {
+
+	body := `{
+  "_scroll_id": "abc",
+  "took": 3,
+  "hits": {
+    "total": {"value": 2, "relation": "eq"},
+    "max_score": null,
+    "hits": [
+      {
+        "_id": "1",
+        "_source": {"title": "a <b>"},
+        "sort": [1, "x"]
+      },
+      {"_id":"2","_source":{"title":"c"},"sort":[2,"y"]}
+    ]
+  }
+}`
+
+	hits := make([]string, 0)
+
+	page, err := DecodeHits(strings.NewReader(body), func(hit []byte) error {
+		hits = append(hits, string(hit))
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to decode hits, %v", err)
+	}
+
+	if page.ScrollID != "abc" || page.Total != 2 || page.Hits != 2 {
+		t.Fatalf("Unexpected page %+v", page)
+	}
+
+	// Hits spanning several lines are compacted so that each can be written as a single line
+	expected := []string{
+		`{"_id":"1","_source":{"title":"a <b>"},"sort":[1,"x"]}`,
+		`{"_id":"2","_source":{"title":"c"},"sort":[2,"y"]}`,
+	}
+
+	for i, hit := range hits {
+
+		if hit != expected[i] {
+			t.Fatalf("Expected %s, got %s", expected[i], hit)
+		}
+	}
+
+	if page.Bytes != len(expected[0])+len(expected[1]) {
+		t.Fatalf("Unexpected page size %d", page.Bytes)
+	}
+
+	if string(page.LastSort) != `[2,"y"]` {
+		t.Fatalf("Unexpected last sort value %s", page.LastSort)
+	}
+}
+
+func TestDecodeHitsPointInTime(t *testing.T) {
+
+	body := `{"pit_id":"xyz","hits":{"hits":[]}}`
+
+	page, err := DecodeHits(strings.NewReader(body), func(hit []byte) error {
+		t.Fatalf("Unexpected hit %s", hit)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to decode hits, %v", err)
+	}
+
+	if page.PointInTimeID != "xyz" || page.Hits != 0 || page.LastSort != nil {
+		t.Fatalf("Unexpected page %+v", page)
+	}
+}
+
+func TestDecodeHitsError(t *testing.T) {
+
+	body := `{"error":{"type":"search_phase_execution_exception"},"status":500}`
+
+	_, err := DecodeHits(strings.NewReader(body), func(hit []byte) error {
+		return nil
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "search_phase_execution_exception") {
+		t.Fatalf("Expected the response's error, got %v", err)
+	}
+}
+
+func TestDecodeHitsInvalid(t *testing.T) {
+
+	tests := []string{
+		`[]`,
+		`{"hits":{"hits":[{"_id":"1"}`,
+		`{"hits":[]}`,
+	}
+
+	for _, body := range tests {
+
+		_, err := DecodeHits(strings.NewReader(body), func(hit []byte) error {
+			return nil
+		})
+
+		if err == nil {
+			t.Fatalf("Expected an error decoding %s", body)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	retry "github.com/avast/retry-go"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/cluster"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/pressure"
+)
+
+// ScanOptions configures Scan.
+type ScanOptions struct {
+	// Request describes the search to page through. Its Size, Scroll, PointInTime, KeepAlive and SearchAfter
+	// properties are managed by Scan.
+	Request cluster.SearchRequest
+	// PointInTime pages through a point in time using search_after rather than through a scroll, which allows
+	// the number of hits requested to change from page to page.
+	PointInTime bool
+	// KeepAlive is how long to keep the scroll or point in time alive between pages.
+	KeepAlive time.Duration
+	// Sizer picks the number of hits to request for each page. If PointInTime is false only its initial
+	// size is used. If nil, 100 hits are requested for every page.
+	Sizer *Sizer
+	// Guard, if set, is used to wait for the cluster to recover after a failed request.
+	Guard *pressure.Guard
+	// Total, if greater than zero, is the number of hits after which Scan stops without requesting another page.
+	Total int
+	// OnPage, if set, is called after each page of hits has been read.
+	OnPage func(*Page)
+}
+
+// Scan pages through every hit of the search described by opts, calling fn for each hit as it is read. Failed
+// requests are retried, unless some of the hits in the response had already been passed to fn.
+func Scan(ctx context.Context, es_client cluster.Client, opts *ScanOptions, fn HitFunc) error {
+
+	req := opts.Request
+	keep_alive := opts.KeepAlive
+	sizer := opts.Sizer
+
+	if sizer == nil {
+		sizer = NewSizer(100, 1, 100, 0)
+	}
+
+	if keep_alive <= 0 {
+		keep_alive = 10 * time.Minute
+	}
+
+	if opts.PointInTime {
+
+		pit_id, err := es_client.OpenPointInTime(ctx, req.Index, keep_alive)
+
+		if err != nil {
+			return err
+		}
+
+		req.PointInTime = pit_id
+		req.KeepAlive = keep_alive
+
+		defer func() {
+			_ = es_client.ClosePointInTime(context.Background(), req.PointInTime)
+		}()
+
+	} else {
+		req.Scroll = keep_alive
+	}
+
+	scroll_id := ""
+
+	defer func() {
+
+		if scroll_id != "" {
+			_ = es_client.ClearScroll(context.Background(), scroll_id)
+		}
+	}()
+
+	count := 0
+
+	for {
+
+		var page *Page
+		var guard_err error
+
+		err := retry.Do(
+			func() error {
+
+				if guard_err != nil {
+					return retry.Unrecoverable(guard_err)
+				}
+
+				var rsp *esapi.Response
+				var err error
+
+				if opts.PointInTime || scroll_id == "" {
+					req.Size = sizer.Size()
+					rsp, err = es_client.Search(ctx, &req)
+				} else {
+					rsp, err = es_client.Scroll(ctx, scroll_id, keep_alive, req.FilterPath)
+				}
+
+				if err != nil {
+					return err
+				}
+
+				defer rsp.Body.Close()
+
+				if rsp.IsError() {
+					return errors.New(rsp.String())
+				}
+
+				// Hits are handed to fn as they are decoded so once any have been accepted the page
+				// can not be retried without duplicating or skipping records.
+				sent := 0
+
+				p, err := DecodeHits(rsp.Body, func(hit []byte) error {
+
+					err := fn(hit)
+
+					if err != nil {
+						return err
+					}
+
+					sent += 1
+					return nil
+				})
+
+				if err != nil {
+
+					if sent > 0 || ctx.Err() != nil {
+						return retry.Unrecoverable(fmt.Errorf("Failed after reading %d hits, %w", sent, err))
+					}
+
+					return err
+				}
+
+				page = p
+				return nil
+			},
+			retry.OnRetry(func(n uint, err error) {
+
+				log.Printf("Request failed, %v", err)
+				sizer.Shrink()
+
+				// Wait for circuit breakers to untrip and the cluster to recover
+				if opts.Guard != nil {
+					guard_err = opts.Guard.Wait(ctx)
+				}
+			}),
+			retry.MaxDelay(1*time.Minute),
+			retry.MaxJitter(10*time.Second),
+			retry.LastErrorOnly(true),
+		)
+
+		if err != nil {
+			return err
+		}
+
+		if page.Hits == 0 {
+			return nil
+		}
+
+		count += page.Hits
+		scroll_id = page.ScrollID
+
+		if opts.PointInTime {
+
+			if page.PointInTimeID != "" {
+				req.PointInTime = page.PointInTimeID
+			}
+
+			req.SearchAfter = page.LastSort
+			sizer.Observe(page)
+		}
+
+		if opts.OnPage != nil {
+			opts.OnPage(page)
+		}
+
+		if opts.Total > 0 && count >= opts.Total {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package search
+
+import (
+	"sync"
+)
+
+// Sizer picks the number of hits to request for each page of search results so that responses stay close
+// to a target size in bytes.
+type Sizer struct {
+	size   int
+	min    int
+	max    int
+	target int
+	mu     *sync.Mutex
+}
+
+// NewSizer returns a new Sizer starting at size hits per page and adjusting between min and max hits so
+// that pages are roughly target bytes. If target is less than or equal to zero the size never changes,
+// even if it is outside of min and max.
+func NewSizer(size int, min int, max int, target int) *Sizer {
+
+	if min < 1 {
+		min = 1
+	}
+
+	if max < min {
+		max = min
+	}
+
+	s := &Sizer{
+		size:   size,
+		min:    min,
+		max:    max,
+		target: target,
+		mu:     new(sync.Mutex),
+	}
+
+	// min and max only bound the size when it is being adjusted
+	if target > 0 {
+		s.size = clamp(size, min, max)
+	}
+
+	return s
+}
+
+// Size returns the number of hits to request for the next page.
+func (s *Sizer) Size() int {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.size
+}
+
+// Observe adjusts the page size based on the average size of the hits in page. The page size at most
+// doubles or halves at a time so that a single unusual page does not swing it too far.
+func (s *Sizer) Observe(page *Page) {
+
+	if s.target <= 0 || page.Hits == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	avg := page.Bytes / page.Hits
+
+	if avg < 1 {
+		avg = 1
+	}
+
+	ideal := clamp(s.target/avg, s.size/2, s.size*2)
+	s.size = clamp(ideal, s.min, s.max)
+}
+
+// Shrink halves the page size, for example after a circuit breaker has tripped.
+func (s *Sizer) Shrink() {
+
+	if s.target <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.size = clamp(s.size/2, s.min, s.max)
+}
+
+func clamp(v int, min int, max int) int {
+
+	if v < min {
+		return min
+	}
+
+	if v > max {
+		return max
+	}
+
+	return v
+}
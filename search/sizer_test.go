@@ -0,0 +1,95 @@
+package search
+
+import (
+	"testing"
+)
+
+func TestSizerFixed(t *testing.T) {
+
+	// Without a target the size is used as is, even outside of the default bounds
+	s := NewSizer(50000, 10, 10000, 0)
+
+	if s.Size() != 50000 {
+		t.Fatalf("Expected a size of 50000, got %d", s.Size())
+	}
+
+	s.Observe(&Page{Hits: 10, Bytes: 10 * 1024 * 1024})
+	s.Shrink()
+
+	if s.Size() != 50000 {
+		t.Fatalf("Expected the size not to change, got %d", s.Size())
+	}
+
+	s = NewSizer(5, 10, 10000, 0)
+
+	if s.Size() != 5 {
+		t.Fatalf("Expected a size of 5, got %d", s.Size())
+	}
+}
+
+func TestSizerClamp(t *testing.T) {
+
+	s := NewSizer(50000, 10, 10000, 1024)
+
+	if s.Size() != 10000 {
+		t.Fatalf("Expected the size to be clamped to 10000, got %d", s.Size())
+	}
+
+	s = NewSizer(1, 10, 10000, 1024)
+
+	if s.Size() != 10 {
+		t.Fatalf("Expected the size to be clamped to 10, got %d", s.Size())
+	}
+}
+
+func TestSizerObserve(t *testing.T) {
+
+	s := NewSizer(100, 10, 10000, 100000)
+
+	// 100 bytes per hit would ideally be 1000 hits but the size at most doubles at a time
+	s.Observe(&Page{Hits: 100, Bytes: 10000})
+
+	if s.Size() != 200 {
+		t.Fatalf("Expected the size to double to 200, got %d", s.Size())
+	}
+
+	s.Observe(&Page{Hits: 200, Bytes: 20000})
+	s.Observe(&Page{Hits: 400, Bytes: 40000})
+
+	if s.Size() != 800 {
+		t.Fatalf("Expected the size to reach 800, got %d", s.Size())
+	}
+
+	s.Observe(&Page{Hits: 800, Bytes: 80000})
+
+	if s.Size() != 1000 {
+		t.Fatalf("Expected the size to settle at 1000, got %d", s.Size())
+	}
+
+	// 10KB per hit would ideally be 10 hits but the size at most halves at a time
+	s.Observe(&Page{Hits: 1000, Bytes: 10000000})
+
+	if s.Size() != 500 {
+		t.Fatalf("Expected the size to halve to 500, got %d", s.Size())
+	}
+
+	// Empty pages are ignored
+	s.Observe(&Page{})
+
+	if s.Size() != 500 {
+		t.Fatalf("Expected an empty page to be ignored, got %d", s.Size())
+	}
+}
+
+func TestSizerShrink(t *testing.T) {
+
+	s := NewSizer(40, 10, 10000, 1024)
+
+	s.Shrink()
+	s.Shrink()
+	s.Shrink()
+
+	if s.Size() != 10 {
+		t.Fatalf("Expected the size to stop at 10, got %d", s.Size())
+	}
+}
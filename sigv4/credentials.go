@@ -0,0 +1,370 @@
+package sigv4
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long before they expire temporary credentials are refreshed.
+const EXPIRY_WINDOW time.Duration = 5 * time.Minute
+
+// Credentials are the AWS credentials used to sign requests.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expires is when temporary credentials expire. It is the zero time for long-term credentials.
+	Expires time.Time
+}
+
+// Retrieve returns creds, so that a fixed set of credentials can be used as a Provider.
+func (creds *Credentials) Retrieve(ctx context.Context) (*Credentials, error) {
+	return creds, nil
+}
+
+// expiring reports whether creds expire within EXPIRY_WINDOW of now.
+func (creds *Credentials) expiring(now time.Time) bool {
+	return !creds.Expires.IsZero() && now.Add(EXPIRY_WINDOW).After(creds.Expires)
+}
+
+// Provider returns the credentials to sign requests with.
+type Provider interface {
+	Retrieve(context.Context) (*Credentials, error)
+}
+
+// CachedProvider is a Provider which reuses the credentials returned by another Provider until they are
+// about to expire.
+type CachedProvider struct {
+	provider Provider
+	creds    *Credentials
+	mu       *sync.Mutex
+}
+
+// NewCachedProvider returns a new CachedProvider for provider.
+func NewCachedProvider(provider Provider) *CachedProvider {
+
+	p := &CachedProvider{
+		provider: provider,
+		mu:       new(sync.Mutex),
+	}
+
+	return p
+}
+
+// Retrieve returns the cached credentials, retrieving new ones first if there are none or they expire
+// within EXPIRY_WINDOW.
+func (p *CachedProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.creds != nil && !p.creds.expiring(time.Now()) {
+		return p.creds, nil
+	}
+
+	creds, err := p.provider.Retrieve(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("Credentials are missing an access key ID or secret access key")
+	}
+
+	p.creds = creds
+	return creds, nil
+}
+
+// LoadCredentials returns a CachedProvider for the first of these sources of credentials that is configured:
+//
+//   - profile, if it is not empty, in the shared credentials and config files
+//   - the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables
+//   - a web identity token, from the AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN environment variables (EKS)
+//   - the AWS_PROFILE environment variable's profile, or "default", in the shared credentials and config files
+//   - the container credentials endpoint (ECS), from the AWS_CONTAINER_CREDENTIALS_* environment variables
+//   - the EC2 instance metadata service, unless AWS_EC2_METADATA_DISABLED is true
+//
+// Profiles can define static keys, a credential_process or a web identity token. Profiles which assume a role
+// using another profile's credentials or use AWS IAM Identity Center (SSO) are not supported; use a
+// credential_process such as "aws configure export-credentials --profile {name}" for those instead.
+// Temporary credentials are refreshed before they expire.
+func LoadCredentials(profile string) (Provider, error) {
+
+	if profile != "" {
+		return loadProfile(profile, true)
+	}
+
+	creds := &Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+		return NewCachedProvider(creds), nil
+	}
+
+	token_file := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	role_arn := os.Getenv("AWS_ROLE_ARN")
+
+	if token_file != "" && role_arn != "" {
+
+		p := &WebIdentityProvider{
+			TokenFile:   token_file,
+			RoleARN:     role_arn,
+			SessionName: os.Getenv("AWS_ROLE_SESSION_NAME"),
+			Region:      LoadRegion(""),
+		}
+
+		return NewCachedProvider(p), nil
+	}
+
+	p, err := loadProfile(profileName(""), false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p != nil {
+		return p, nil
+	}
+
+	if os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "" || os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "" {
+		return NewCachedProvider(&ContainerProvider{}), nil
+	}
+
+	if strings.ToLower(os.Getenv("AWS_EC2_METADATA_DISABLED")) == "true" {
+		return nil, fmt.Errorf("No AWS credentials found")
+	}
+
+	return NewCachedProvider(&InstanceMetadataProvider{}), nil
+}
+
+// loadProfile returns a CachedProvider for the credentials defined by profile in the shared credentials or
+// config file. If the profile is not defined in either file an error is returned if required is true and
+// otherwise nil.
+func loadProfile(profile string, required bool) (Provider, error) {
+
+	creds_path, err := sharedFilePath("AWS_SHARED_CREDENTIALS_FILE", "credentials")
+
+	if err != nil {
+		return nil, err
+	}
+
+	config_path, err := sharedFilePath("AWS_CONFIG_FILE", "config")
+
+	if err != nil {
+		return nil, err
+	}
+
+	section, creds_found, err := readINISection(creds_path, profile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config, config_found, err := readINISection(config_path, configSectionName(profile))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !creds_found && !config_found {
+
+		if required {
+			return nil, fmt.Errorf("Profile %s not found in %s or %s", profile, creds_path, config_path)
+		}
+
+		return nil, nil
+	}
+
+	// Properties in the credentials file take precedence over the same properties in the config file
+	for k, v := range section {
+		config[k] = v
+	}
+
+	switch {
+	case config["aws_access_key_id"] != "" || config["aws_secret_access_key"] != "":
+
+		creds := &Credentials{
+			AccessKeyID:     config["aws_access_key_id"],
+			SecretAccessKey: config["aws_secret_access_key"],
+			SessionToken:    config["aws_session_token"],
+		}
+
+		if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+			return nil, fmt.Errorf("Profile %s is missing aws_access_key_id or aws_secret_access_key", profile)
+		}
+
+		return NewCachedProvider(creds), nil
+
+	case config["credential_process"] != "":
+
+		p := &ProcessProvider{
+			Command: config["credential_process"],
+		}
+
+		return NewCachedProvider(p), nil
+
+	case config["web_identity_token_file"] != "":
+
+		if config["role_arn"] == "" {
+			return nil, fmt.Errorf("Profile %s has a web_identity_token_file but no role_arn", profile)
+		}
+
+		region := config["region"]
+
+		if region == "" {
+			region = LoadRegion(profile)
+		}
+
+		p := &WebIdentityProvider{
+			TokenFile:   config["web_identity_token_file"],
+			RoleARN:     config["role_arn"],
+			SessionName: config["role_session_name"],
+			Region:      region,
+		}
+
+		return NewCachedProvider(p), nil
+
+	case config["role_arn"] != "", config["sso_session"] != "", config["sso_start_url"] != "":
+		return nil, fmt.Errorf("Profile %s assumes a role or uses SSO, which is not supported; configure a credential_process instead", profile)
+
+	default:
+		return nil, fmt.Errorf("Profile %s does not define any credentials", profile)
+	}
+}
+
+// LoadRegion returns the AWS_REGION or AWS_DEFAULT_REGION environment variable or, failing that, the region
+// for profile in the shared config file (AWS_CONFIG_FILE or ~/.aws/config). It returns an empty string if no
+// region is configured.
+func LoadRegion(profile string) string {
+
+	for _, k := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+
+	path, err := sharedFilePath("AWS_CONFIG_FILE", "config")
+
+	if err != nil {
+		return ""
+	}
+
+	section, _, err := readINISection(path, configSectionName(profileName(profile)))
+
+	if err != nil {
+		return ""
+	}
+
+	return section["region"]
+}
+
+func profileName(profile string) string {
+
+	if profile != "" {
+		return profile
+	}
+
+	if v := os.Getenv("AWS_PROFILE"); v != "" {
+		return v
+	}
+
+	return "default"
+}
+
+// configSectionName returns the name of the section for profile in the shared config file, where profiles
+// other than the default are named "profile {name}".
+func configSectionName(profile string) string {
+
+	if profile == "default" {
+		return profile
+	}
+
+	return "profile " + profile
+}
+
+// sharedFilePath returns the value of the environment variable env_var or, if it is empty, the path of name
+// in the ~/.aws directory.
+func sharedFilePath(env_var string, name string) (string, error) {
+
+	if path := os.Getenv(env_var); path != "" {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to determine home directory, %w", err)
+	}
+
+	return filepath.Join(home, ".aws", name), nil
+}
+
+// readINISection returns the key/value pairs in section name of the INI file at path, and whether the
+// section was found. A missing file is treated as an empty one.
+func readINISection(path string, name string) (map[string]string, bool, error) {
+
+	section := make(map[string]string)
+
+	fh, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return section, false, nil
+	}
+
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to open %s, %w", path, err)
+	}
+
+	defer fh.Close()
+
+	found := false
+	current := ""
+
+	scanner := bufio.NewScanner(fh)
+
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			found = found || current == name
+			continue
+		}
+
+		if current != name {
+			continue
+		}
+
+		k, v, ok := strings.Cut(line, "=")
+
+		if !ok {
+			continue
+		}
+
+		section[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+
+	err = scanner.Err()
+
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to read %s, %w", path, err)
+	}
+
+	return section, found, nil
+}
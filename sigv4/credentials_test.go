@@ -0,0 +1,413 @@
+package sigv4
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var aws_env_vars = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_PROFILE",
+	"AWS_REGION",
+	"AWS_DEFAULT_REGION",
+	"AWS_WEB_IDENTITY_TOKEN_FILE",
+	"AWS_ROLE_ARN",
+	"AWS_ROLE_SESSION_NAME",
+	"AWS_ENDPOINT_URL_STS",
+	"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+	"AWS_CONTAINER_CREDENTIALS_FULL_URI",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN",
+	"AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+	"AWS_EC2_METADATA_SERVICE_ENDPOINT",
+	"AWS_EC2_METADATA_DISABLED",
+}
+
+// setupEnv clears the AWS environment variables and points the shared credentials and config files at
+// files containing credentials and config.
+func setupEnv(t *testing.T, credentials string, config string) {
+
+	for _, k := range aws_env_vars {
+		t.Setenv(k, "")
+	}
+
+	dir := t.TempDir()
+
+	creds_path := filepath.Join(dir, "credentials")
+	config_path := filepath.Join(dir, "config")
+
+	for path, body := range map[string]string{creds_path: credentials, config_path: config} {
+
+		err := os.WriteFile(path, []byte(body), 0600)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", path, err)
+		}
+	}
+
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", creds_path)
+	t.Setenv("AWS_CONFIG_FILE", config_path)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+}
+
+func retrieve(t *testing.T, profile string) *Credentials {
+
+	p, err := LoadCredentials(profile)
+
+	if err != nil {
+		t.Fatalf("Failed to load credentials, %v", err)
+	}
+
+	creds, err := p.Retrieve(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to retrieve credentials, %v", err)
+	}
+
+	return creds
+}
+
+const test_credentials_file string = `
+[default]
+aws_access_key_id = DEFAULTKEY
+aws_secret_access_key = defaultsecret
+
+[collection]
+aws_access_key_id = COLLECTIONKEY
+aws_secret_access_key = collectionsecret
+aws_session_token = collectiontoken
+`
+
+func TestLoadCredentialsPrecedence(t *testing.T) {
+
+	setupEnv(t, test_credentials_file, "")
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENVKEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "envsecret")
+
+	// An explicit profile takes precedence over the environment
+	creds := retrieve(t, "collection")
+
+	if creds.AccessKeyID != "COLLECTIONKEY" || creds.SessionToken != "collectiontoken" {
+		t.Fatalf("Expected the collection profile's credentials, got %s", creds.AccessKeyID)
+	}
+
+	creds = retrieve(t, "")
+
+	if creds.AccessKeyID != "ENVKEY" {
+		t.Fatalf("Expected the environment's credentials, got %s", creds.AccessKeyID)
+	}
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	creds = retrieve(t, "")
+
+	if creds.AccessKeyID != "DEFAULTKEY" {
+		t.Fatalf("Expected the default profile's credentials, got %s", creds.AccessKeyID)
+	}
+
+	t.Setenv("AWS_PROFILE", "collection")
+
+	creds = retrieve(t, "")
+
+	if creds.AccessKeyID != "COLLECTIONKEY" {
+		t.Fatalf("Expected the AWS_PROFILE profile's credentials, got %s", creds.AccessKeyID)
+	}
+}
+
+func TestLoadCredentialsErrors(t *testing.T) {
+
+	config := `
+[profile sso]
+sso_session = corp
+sso_account_id = 123456789012
+
+[profile chained]
+role_arn = arn:aws:iam::123456789012:role/restore
+source_profile = default
+`
+
+	setupEnv(t, "", config)
+
+	for _, profile := range []string{"missing", "sso", "chained"} {
+
+		_, err := LoadCredentials(profile)
+
+		if err == nil {
+			t.Fatalf("Expected an error loading the %s profile", profile)
+		}
+	}
+
+	// No credentials at all, with the instance metadata service disabled
+	_, err := LoadCredentials("")
+
+	if err == nil {
+		t.Fatalf("Expected an error when no credentials are configured")
+	}
+}
+
+func TestLoadRegion(t *testing.T) {
+
+	config := `
+[default]
+region = us-east-1
+
+[profile collection]
+region = us-west-2
+`
+
+	setupEnv(t, "", config)
+
+	if LoadRegion("") != "us-east-1" || LoadRegion("collection") != "us-west-2" {
+		t.Fatalf("Unexpected regions %s and %s", LoadRegion(""), LoadRegion("collection"))
+	}
+
+	t.Setenv("AWS_REGION", "eu-west-1")
+
+	if LoadRegion("collection") != "eu-west-1" {
+		t.Fatalf("Expected AWS_REGION to take precedence, got %s", LoadRegion("collection"))
+	}
+}
+
+func TestProcessProvider(t *testing.T) {
+
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	output := fmt.Sprintf(`{"Version":1,"AccessKeyId":"PROCESSKEY","SecretAccessKey":"processsecret","SessionToken":"processtoken","Expiration":"%s"}`, expires)
+
+	script := filepath.Join(t.TempDir(), "creds.sh")
+
+	err := os.WriteFile(script, []byte("#!/bin/sh\necho '"+output+"'\n"), 0700)
+
+	if err != nil {
+		t.Fatalf("Failed to write script, %v", err)
+	}
+
+	setupEnv(t, "", "[profile process]\ncredential_process = "+script+"\n")
+
+	creds := retrieve(t, "process")
+
+	if creds.AccessKeyID != "PROCESSKEY" || creds.SessionToken != "processtoken" || creds.Expires.IsZero() {
+		t.Fatalf("Unexpected credentials %+v", creds)
+	}
+}
+
+func TestWebIdentityProvider(t *testing.T) {
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		req.ParseForm()
+
+		if req.Form.Get("Action") != "AssumeRoleWithWebIdentity" || req.Form.Get("WebIdentityToken") != "oidc-token" || req.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/dump" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>WEBKEY</AccessKeyId>
+      <SecretAccessKey>websecret</SecretAccessKey>
+      <SessionToken>webtoken</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	})
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	token_file := filepath.Join(t.TempDir(), "token")
+
+	err := os.WriteFile(token_file, []byte("oidc-token\n"), 0600)
+
+	if err != nil {
+		t.Fatalf("Failed to write token, %v", err)
+	}
+
+	setupEnv(t, "", "")
+
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", token_file)
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/dump")
+	t.Setenv("AWS_ENDPOINT_URL_STS", srv.URL)
+
+	creds := retrieve(t, "")
+
+	if creds.AccessKeyID != "WEBKEY" || creds.SessionToken != "webtoken" || creds.Expires.IsZero() {
+		t.Fatalf("Unexpected credentials %+v", creds)
+	}
+}
+
+func metadataCredentials(key string) string {
+	return fmt.Sprintf(`{"Code":"Success","AccessKeyId":"%s","SecretAccessKey":"secret","Token":"token","Expiration":"%s"}`, key, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+}
+
+func TestContainerProvider(t *testing.T) {
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		if req.URL.Path != "/creds" || req.Header.Get("Authorization") != "auth-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(metadataCredentials("CONTAINERKEY")))
+	})
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	setupEnv(t, "", "")
+
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", srv.URL+"/creds")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "auth-token")
+
+	creds := retrieve(t, "")
+
+	if creds.AccessKeyID != "CONTAINERKEY" || creds.SessionToken != "token" {
+		t.Fatalf("Unexpected credentials %+v", creds)
+	}
+}
+
+func TestInstanceMetadataProvider(t *testing.T) {
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		if req.URL.Path == "/latest/api/token" {
+
+			if req.Method != http.MethodPut {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			w.Write([]byte("imds-token"))
+			return
+		}
+
+		if req.Header.Get("X-aws-ec2-metadata-token") != "imds-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch req.URL.Path {
+		case "/latest/meta-data/iam/security-credentials/":
+			w.Write([]byte("restore-role"))
+		case "/latest/meta-data/iam/security-credentials/restore-role":
+			w.Write([]byte(metadataCredentials("INSTANCEKEY")))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	setupEnv(t, "", "")
+
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "")
+	t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", srv.URL)
+
+	creds := retrieve(t, "")
+
+	if creds.AccessKeyID != "INSTANCEKEY" {
+		t.Fatalf("Unexpected credentials %+v", creds)
+	}
+}
+
+type countingProvider struct {
+	count   int
+	expires time.Duration
+}
+
+func (p *countingProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+
+	p.count += 1
+
+	creds := &Credentials{
+		AccessKeyID:     fmt.Sprintf("KEY%d", p.count),
+		SecretAccessKey: "secret",
+		Expires:         time.Now().Add(p.expires),
+	}
+
+	return creds, nil
+}
+
+func TestCachedProvider(t *testing.T) {
+
+	p := &countingProvider{expires: time.Hour}
+	cached := NewCachedProvider(p)
+
+	for i := 0; i < 3; i++ {
+
+		creds, err := cached.Retrieve(context.Background())
+
+		if err != nil {
+			t.Fatalf("Failed to retrieve credentials, %v", err)
+		}
+
+		if creds.AccessKeyID != "KEY1" {
+			t.Fatalf("Expected cached credentials, got %s", creds.AccessKeyID)
+		}
+	}
+
+	// Credentials that expire within the expiry window are refreshed every time
+	p = &countingProvider{expires: time.Minute}
+	cached = NewCachedProvider(p)
+
+	cached.Retrieve(context.Background())
+
+	creds, err := cached.Retrieve(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to retrieve credentials, %v", err)
+	}
+
+	if creds.AccessKeyID != "KEY2" {
+		t.Fatalf("Expected refreshed credentials, got %s", creds.AccessKeyID)
+	}
+}
+
+func TestTransportRefreshesCredentials(t *testing.T) {
+
+	keys := make([]string, 0)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		keys = append(keys, strings.SplitN(strings.TrimPrefix(auth, ALGORITHM+" Credential="), "/", 2)[0])
+	})
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	tr := &Transport{
+		Credentials: NewCachedProvider(&countingProvider{expires: time.Minute}),
+		Region:      test_region,
+		Service:     test_service,
+	}
+
+	client := &http.Client{Transport: tr}
+
+	for i := 0; i < 2; i++ {
+
+		rsp, err := client.Post(srv.URL, "application/json", strings.NewReader(`{}`))
+
+		if err != nil {
+			t.Fatalf("Failed to send request, %v", err)
+		}
+
+		rsp.Body.Close()
+	}
+
+	if len(keys) != 2 || keys[0] != "KEY1" || keys[1] != "KEY2" {
+		t.Fatalf("Expected each request to be signed with refreshed credentials, got %v", keys)
+	}
+}
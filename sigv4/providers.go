@@ -0,0 +1,341 @@
+package sigv4
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// How long to wait for the container and instance metadata endpoints, which are only reachable from inside
+// AWS, before giving up.
+const METADATA_TIMEOUT time.Duration = 5 * time.Second
+
+// The default address of the ECS container credentials endpoint, for AWS_CONTAINER_CREDENTIALS_RELATIVE_URI.
+const CONTAINER_ENDPOINT string = "http://169.254.170.2"
+
+// The default address of the EC2 instance metadata service.
+const INSTANCE_METADATA_ENDPOINT string = "http://169.254.169.254"
+
+// ProcessProvider retrieves credentials by running an external command, as configured by the
+// credential_process property of a profile. The command must write a JSON object with Version,
+// AccessKeyId, SecretAccessKey and, optionally, SessionToken and Expiration properties to STDOUT.
+type ProcessProvider struct {
+	Command string
+}
+
+// Retrieve runs p.Command and returns the credentials it writes.
+func (p *ProcessProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+
+	var cmd *exec.Cmd
+
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd.exe", "/C", p.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", p.Command)
+	}
+
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to run credential_process, %w", err)
+	}
+
+	var body struct {
+		Version         int    `json:"Version"`
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		SessionToken    string `json:"SessionToken"`
+		Expiration      string `json:"Expiration"`
+	}
+
+	err = json.Unmarshal(out, &body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode credential_process output, %w", err)
+	}
+
+	if body.Version != 1 {
+		return nil, fmt.Errorf("Unsupported credential_process output version %d", body.Version)
+	}
+
+	return newCredentials(body.AccessKeyID, body.SecretAccessKey, body.SessionToken, body.Expiration)
+}
+
+// WebIdentityProvider retrieves temporary credentials by exchanging the OIDC token in TokenFile for the
+// role RoleARN using the AWS STS AssumeRoleWithWebIdentity action, as used by EKS service accounts. The
+// token file is read again each time, since it is rotated. The STS endpoint can be overridden with the
+// AWS_ENDPOINT_URL_STS environment variable.
+type WebIdentityProvider struct {
+	TokenFile   string
+	RoleARN     string
+	SessionName string
+	// Region is the region of the STS endpoint to use. If empty the global endpoint is used.
+	Region string
+}
+
+// Retrieve exchanges the web identity token for credentials.
+func (p *WebIdentityProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+
+	token, err := os.ReadFile(p.TokenFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read web identity token, %w", err)
+	}
+
+	session_name := p.SessionName
+
+	if session_name == "" {
+		session_name = fmt.Sprintf("go-jsonl-elasticsearch-%d", time.Now().UnixNano())
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_STS")
+
+	switch {
+	case endpoint != "":
+		// pass
+	case p.Region != "":
+		endpoint = fmt.Sprintf("https://sts.%s.amazonaws.com/", p.Region)
+	default:
+		endpoint = "https://sts.amazonaws.com/"
+	}
+
+	form := url.Values{
+		"Action":           []string{"AssumeRoleWithWebIdentity"},
+		"Version":          []string{"2011-06-15"},
+		"RoleArn":          []string{p.RoleARN},
+		"RoleSessionName":  []string{session_name},
+		"WebIdentityToken": []string{strings.TrimSpace(string(token))},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create AssumeRoleWithWebIdentity request, %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	body, err := doRequest(http.DefaultClient, req)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to assume role %s with web identity, %w", p.RoleARN, err)
+	}
+
+	var rsp struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+			Expiration      string `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+
+	err = xml.Unmarshal(body, &rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode AssumeRoleWithWebIdentity response, %w", err)
+	}
+
+	c := rsp.Credentials
+	return newCredentials(c.AccessKeyID, c.SecretAccessKey, c.SessionToken, c.Expiration)
+}
+
+// ContainerProvider retrieves credentials from the ECS container credentials endpoint, configured by the
+// AWS_CONTAINER_CREDENTIALS_RELATIVE_URI or AWS_CONTAINER_CREDENTIALS_FULL_URI environment variables and
+// authorized by AWS_CONTAINER_AUTHORIZATION_TOKEN or AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE, if set.
+type ContainerProvider struct{}
+
+// Retrieve requests credentials from the container credentials endpoint.
+func (p *ContainerProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+
+	if relative_uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative_uri != "" {
+		endpoint = CONTAINER_ENDPOINT + relative_uri
+	}
+
+	if endpoint == "" {
+		return nil, fmt.Errorf("No container credentials endpoint configured")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create container credentials request, %w", err)
+	}
+
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+
+	if path := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); path != "" {
+
+		b, err := os.ReadFile(path)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read container authorization token, %w", err)
+		}
+
+		token = strings.TrimSpace(string(b))
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	body, err := doRequest(&http.Client{Timeout: METADATA_TIMEOUT}, req)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve container credentials, %w", err)
+	}
+
+	return decodeMetadataCredentials(body)
+}
+
+// InstanceMetadataProvider retrieves the credentials of the EC2 instance's IAM role from the instance
+// metadata service, using IMDSv2. The endpoint can be overridden with the AWS_EC2_METADATA_SERVICE_ENDPOINT
+// environment variable.
+type InstanceMetadataProvider struct{}
+
+// Retrieve requests credentials from the instance metadata service.
+func (p *InstanceMetadataProvider) Retrieve(ctx context.Context) (*Credentials, error) {
+
+	endpoint := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+
+	if endpoint == "" {
+		endpoint = INSTANCE_METADATA_ENDPOINT
+	}
+
+	endpoint = strings.TrimRight(endpoint, "/")
+
+	client := &http.Client{Timeout: METADATA_TIMEOUT}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create instance metadata token request, %w", err)
+	}
+
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+
+	token, err := doRequest(client, req)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve instance metadata token, %w", err)
+	}
+
+	get := func(path string) ([]byte, error) {
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+path, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		return doRequest(client, req)
+	}
+
+	roles, err := get("/latest/meta-data/iam/security-credentials/")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve instance role, %w", err)
+	}
+
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+
+	if role == "" {
+		return nil, fmt.Errorf("The instance does not have an IAM role")
+	}
+
+	body, err := get("/latest/meta-data/iam/security-credentials/" + role)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve instance role credentials, %w", err)
+	}
+
+	return decodeMetadataCredentials(body)
+}
+
+// decodeMetadataCredentials decodes the credentials returned by the container and instance metadata endpoints.
+func decodeMetadataCredentials(body []byte) (*Credentials, error) {
+
+	var rsp struct {
+		Code            string `json:"Code"`
+		Message         string `json:"Message"`
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+		Expiration      string `json:"Expiration"`
+	}
+
+	err := json.Unmarshal(body, &rsp)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode credentials, %w", err)
+	}
+
+	if rsp.Code != "" && rsp.Code != "Success" {
+		return nil, fmt.Errorf("Failed to retrieve credentials, %s: %s", rsp.Code, rsp.Message)
+	}
+
+	return newCredentials(rsp.AccessKeyID, rsp.SecretAccessKey, rsp.Token, rsp.Expiration)
+}
+
+// newCredentials returns new Credentials, parsing expiration as an RFC 3339 date if it is not empty.
+func newCredentials(access_key_id string, secret_access_key string, session_token string, expiration string) (*Credentials, error) {
+
+	creds := &Credentials{
+		AccessKeyID:     access_key_id,
+		SecretAccessKey: secret_access_key,
+		SessionToken:    session_token,
+	}
+
+	if expiration != "" {
+
+		t, err := time.Parse(time.RFC3339, expiration)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse credentials expiration, %w", err)
+		}
+
+		creds.Expires = t
+	}
+
+	return creds, nil
+}
+
+// doRequest sends req using client and returns the response body, or an error if the response status is
+// not 200.
+func doRequest(client *http.Client, req *http.Request) ([]byte, error) {
+
+	rsp, err := client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	body, err := io.ReadAll(rsp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s, %s", rsp.Status, bytes.TrimSpace(body))
+	}
+
+	return body, nil
+}
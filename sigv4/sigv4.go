@@ -0,0 +1,219 @@
+// package sigv4 provides methods for signing HTTP requests using AWS Signature Version 4, as required by
+// Amazon OpenSearch Service (and Amazon Elasticsearch Service) domains.
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const ALGORITHM string = "AWS4-HMAC-SHA256"
+
+const TIME_FORMAT string = "20060102T150405Z"
+
+const DATE_FORMAT string = "20060102"
+
+// EMPTY_PAYLOAD_HASH is the SHA-256 hash of an empty request body.
+const EMPTY_PAYLOAD_HASH string = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// PayloadHash returns the hex-encoded SHA-256 hash of body.
+func PayloadHash(body []byte) string {
+	h := sha256.Sum256(body)
+	return hex.EncodeToString(h[:])
+}
+
+// Sign adds the X-Amz-Date, X-Amz-Security-Token (if creds has a session token) and Authorization headers to
+// req. payload_hash is the hex-encoded SHA-256 hash of the request body. The Host header and every X-Amz-*
+// header present on req are signed.
+func Sign(req *http.Request, payload_hash string, creds *Credentials, region string, service string, t time.Time) {
+
+	t = t.UTC()
+	amz_date := t.Format(TIME_FORMAT)
+
+	req.Header.Set("X-Amz-Date", amz_date)
+
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	canonical_request, signed_headers := canonicalRequest(req, payload_hash)
+	scope := credentialScope(t, region, service)
+	string_to_sign := stringToSign(canonical_request, amz_date, scope)
+
+	sig := signature(creds.SecretAccessKey, t, region, service, string_to_sign)
+
+	auth := fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", ALGORITHM, creds.AccessKeyID, scope, signed_headers, sig)
+	req.Header.Set("Authorization", auth)
+}
+
+// canonicalRequest returns the canonical form of req and the list of headers it signs.
+func canonicalRequest(req *http.Request, payload_hash string) (string, string) {
+
+	canonical_headers, signed_headers := canonicalHeaders(req)
+
+	canonical_request := strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL),
+		canonicalQuery(req.URL),
+		canonical_headers,
+		signed_headers,
+		payload_hash,
+	}, "\n")
+
+	return canonical_request, signed_headers
+}
+
+func credentialScope(t time.Time, region string, service string) string {
+
+	return strings.Join([]string{
+		t.Format(DATE_FORMAT),
+		region,
+		service,
+		"aws4_request",
+	}, "/")
+}
+
+func stringToSign(canonical_request string, amz_date string, scope string) string {
+
+	return strings.Join([]string{
+		ALGORITHM,
+		amz_date,
+		scope,
+		PayloadHash([]byte(canonical_request)),
+	}, "\n")
+}
+
+// signature returns the hex-encoded signature of string_to_sign using a key derived from secret for the
+// date of t, region and service.
+func signature(secret string, t time.Time, region string, service string, string_to_sign string) string {
+
+	key := hmacSHA256([]byte("AWS4"+secret), t.Format(DATE_FORMAT))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+
+	return hex.EncodeToString(hmacSHA256(key, string_to_sign))
+}
+
+func canonicalHeaders(req *http.Request) (string, string) {
+
+	host := req.Host
+
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{
+		"host": host,
+	}
+
+	for k, v := range req.Header {
+
+		k = strings.ToLower(k)
+
+		if !strings.HasPrefix(k, "x-amz-") {
+			continue
+		}
+
+		values := make([]string, len(v))
+
+		for i, str_v := range v {
+			values[i] = strings.Join(strings.Fields(str_v), " ")
+		}
+
+		headers[k] = strings.Join(values, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+
+	for k := range headers {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	var buf strings.Builder
+
+	for _, k := range names {
+		buf.WriteString(k)
+		buf.WriteString(":")
+		buf.WriteString(headers[k])
+		buf.WriteString("\n")
+	}
+
+	return buf.String(), strings.Join(names, ";")
+}
+
+// canonicalPath returns the URI-encoded path of u. Services other than S3 expect the already-escaped path to
+// be encoded a second time.
+func canonicalPath(u *url.URL) string {
+
+	path := u.EscapedPath()
+
+	if path == "" {
+		return "/"
+	}
+
+	return escape(path, false)
+}
+
+func canonicalQuery(u *url.URL) string {
+
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+
+	for k := range query {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+
+	for _, k := range keys {
+
+		values := query[k]
+		sort.Strings(values)
+
+		for _, v := range values {
+			pairs = append(pairs, escape(k, true)+"="+escape(v, true))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// escape URI-encodes every byte of s except the unreserved characters (and, unless encode_slash is true, "/").
+func escape(s string, encode_slash bool) string {
+
+	var buf strings.Builder
+
+	for i := 0; i < len(s); i++ {
+
+		c := s[i]
+
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encode_slash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+
+	return buf.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,186 @@
+package sigv4
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The credentials, date, region and service used by the AWS Signature Version 4 test suite.
+var test_creds = &Credentials{
+	AccessKeyID:     "AKIDEXAMPLE",
+	SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+}
+
+const test_region string = "us-east-1"
+
+const test_service string = "service"
+
+var test_time = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+// testVector is a case from the AWS Signature Version 4 test suite. Only the cases which sign just the Host
+// and X-Amz-Date headers, with paths that need no escaping, are included since Sign only signs those headers
+// and, like the AWS SDKs for services other than S3, escapes paths a second time.
+type testVector struct {
+	name              string
+	method            string
+	url               string
+	canonical_request string
+	string_to_sign    string
+	signature         string
+}
+
+var test_vectors = []testVector{
+	{
+		name:   "get-vanilla",
+		method: "GET",
+		url:    "https://example.amazonaws.com/",
+		canonical_request: `GET
+/
+
+host:example.amazonaws.com
+x-amz-date:20150830T123600Z
+
+host;x-amz-date
+e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855`,
+		string_to_sign: `AWS4-HMAC-SHA256
+20150830T123600Z
+20150830/us-east-1/service/aws4_request
+bb579772317eb040ac9ed261061d46c1f17a8133879d6129b6e1c25292927e63`,
+		signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+	},
+	{
+		name:   "get-vanilla-query-order-key-case",
+		method: "GET",
+		url:    "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+		canonical_request: `GET
+/
+Param1=value1&Param2=value2
+host:example.amazonaws.com
+x-amz-date:20150830T123600Z
+
+host;x-amz-date
+e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855`,
+		string_to_sign: `AWS4-HMAC-SHA256
+20150830T123600Z
+20150830/us-east-1/service/aws4_request
+816cd5b414d056048ba4f7c5386d6e0533120fb1fcfa93762cf0fc39e2cf19e0`,
+		signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+	},
+	{
+		name:   "post-vanilla",
+		method: "POST",
+		url:    "https://example.amazonaws.com/",
+		canonical_request: `POST
+/
+
+host:example.amazonaws.com
+x-amz-date:20150830T123600Z
+
+host;x-amz-date
+e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855`,
+		string_to_sign: `AWS4-HMAC-SHA256
+20150830T123600Z
+20150830/us-east-1/service/aws4_request
+553f88c9e4d10fc9e109e2aeb65f030801b70c2f6468faca261d401ae622fc87`,
+		signature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+	},
+}
+
+func TestSignVectors(t *testing.T) {
+
+	for _, v := range test_vectors {
+
+		req, err := http.NewRequest(v.method, v.url, nil)
+
+		if err != nil {
+			t.Fatalf("Failed to create request for %s, %v", v.name, err)
+		}
+
+		Sign(req, EMPTY_PAYLOAD_HASH, test_creds, test_region, test_service, test_time)
+
+		canonical_request, _ := canonicalRequest(req, EMPTY_PAYLOAD_HASH)
+
+		if canonical_request != v.canonical_request {
+			t.Fatalf("Unexpected canonical request for %s:\n%s", v.name, canonical_request)
+		}
+
+		string_to_sign := stringToSign(canonical_request, req.Header.Get("X-Amz-Date"), credentialScope(test_time, test_region, test_service))
+
+		if string_to_sign != v.string_to_sign {
+			t.Fatalf("Unexpected string to sign for %s:\n%s", v.name, string_to_sign)
+		}
+
+		expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=" + v.signature
+
+		if req.Header.Get("Authorization") != expected {
+			t.Fatalf("Unexpected Authorization header for %s: %s", v.name, req.Header.Get("Authorization"))
+		}
+	}
+}
+
+func TestSignSessionToken(t *testing.T) {
+
+	creds := &Credentials{
+		AccessKeyID:     test_creds.AccessKeyID,
+		SecretAccessKey: test_creds.SecretAccessKey,
+		SessionToken:    "token",
+	}
+
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+
+	if err != nil {
+		t.Fatalf("Failed to create request, %v", err)
+	}
+
+	Sign(req, EMPTY_PAYLOAD_HASH, creds, test_region, test_service, test_time)
+
+	if req.Header.Get("X-Amz-Security-Token") != "token" {
+		t.Fatalf("Expected the session token to be sent")
+	}
+
+	if !strings.Contains(req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,") {
+		t.Fatalf("Expected the session token to be signed, got %s", req.Header.Get("Authorization"))
+	}
+}
+
+func TestCanonicalPath(t *testing.T) {
+
+	tests := map[string]string{
+		"https://example.amazonaws.com":                      "/",
+		"https://example.amazonaws.com/books/_search":        "/books/_search",
+		"https://example.amazonaws.com/example%20space/":     "/example%2520space/",
+		"https://example.amazonaws.com/logs-%3Cdate%3E/_doc": "/logs-%253Cdate%253E/_doc",
+	}
+
+	for raw_url, expected := range tests {
+
+		req, err := http.NewRequest("GET", raw_url, nil)
+
+		if err != nil {
+			t.Fatalf("Failed to create request, %v", err)
+		}
+
+		path := canonicalPath(req.URL)
+
+		if path != expected {
+			t.Fatalf("Expected %s for %s, got %s", expected, raw_url, path)
+		}
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/?b=2&a=x%20y&a=1&filter_path=hits.hits._id,_scroll_id", nil)
+
+	if err != nil {
+		t.Fatalf("Failed to create request, %v", err)
+	}
+
+	expected := "a=1&a=x%20y&b=2&filter_path=hits.hits._id%2C_scroll_id"
+
+	if canonicalQuery(req.URL) != expected {
+		t.Fatalf("Expected %s, got %s", expected, canonicalQuery(req.URL))
+	}
+}
@@ -0,0 +1,71 @@
+package sigv4
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Transport is an http.RoundTripper which signs each request with AWS Signature Version 4 before passing
+// it to Base.
+type Transport struct {
+	Base http.RoundTripper
+	// Credentials provides the credentials to sign each request with. It should cache them, like a
+	// CachedProvider, since it is called for every request.
+	Credentials Provider
+	Region      string
+	// Service is the signing name of the service, "es" for Amazon OpenSearch Service domains or "aoss" for
+	// OpenSearch Serverless collections.
+	Service string
+}
+
+// RoundTrip signs a copy of req and sends it using t.Base. The request body is read in full so that it can
+// be hashed.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	creds, err := t.Credentials.Retrieve(req.Context())
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve AWS credentials, %w", err)
+	}
+
+	signed := req.Clone(req.Context())
+
+	body := []byte{}
+
+	if req.Body != nil && req.Body != http.NoBody {
+
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read request body for signing, %w", err)
+		}
+
+		body = b
+	}
+
+	signed.Body = io.NopCloser(bytes.NewReader(body))
+	signed.ContentLength = int64(len(body))
+
+	// Signing requires the full body so it can not be sent with chunked encoding
+	signed.TransferEncoding = nil
+	signed.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	payload_hash := PayloadHash(body)
+	signed.Header.Set("X-Amz-Content-Sha256", payload_hash)
+
+	Sign(signed, payload_hash, creds, t.Region, t.Service, time.Now())
+
+	base := t.Base
+
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return base.RoundTrip(signed)
+}
@@ -0,0 +1,238 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/bulk"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/pressure"
+)
+
+// AdaptiveOptions configures an AdaptiveBulkIndexer.
+type AdaptiveOptions struct {
+	// Config is used to create each underlying bulk indexer. Its NumWorkers and FlushBytes properties
+	// are the upper bounds for adjustment.
+	Config bulk.Config
+	// MinWorkers is the lower bound for the number of bulk indexer workers.
+	MinWorkers int
+	// MinFlushBytes is the lower bound for the bulk indexer flush threshold.
+	MinFlushBytes int
+}
+
+// AdaptiveBulkIndexer is an esutil.BulkIndexer that shrinks its concurrency and flush threshold when the
+// cluster pushes back (bulk rejections or any other pressure reported by a pressure.Guard) and grows them
+// again when it recovers. Because a bulk indexer can not be reconfigured in place each adjustment
+// flushes and closes the current bulk indexer and replaces it with a new one.
+type AdaptiveBulkIndexer struct {
+	opts         *AdaptiveOptions
+	current      esutil.BulkIndexer
+	workers      int
+	flush_bytes  int
+	closed_stats esutil.BulkIndexerStats
+	rejections   int64
+	calm         int
+	closed       bool
+	mu           *sync.RWMutex
+}
+
+// NewAdaptiveBulkIndexer returns a new AdaptiveBulkIndexer which starts at the maximum concurrency and
+// flush threshold defined by opts.Config. Pass each pressure.Status reported for the cluster to Observe.
+func NewAdaptiveBulkIndexer(opts *AdaptiveOptions) (*AdaptiveBulkIndexer, error) {
+
+	if opts.Config.NumWorkers < 1 {
+		return nil, fmt.Errorf("Invalid number of workers")
+	}
+
+	if opts.Config.FlushBytes < 1 {
+		return nil, fmt.Errorf("Invalid flush threshold")
+	}
+
+	if opts.MinWorkers < 1 {
+		opts.MinWorkers = 1
+	}
+
+	if opts.MinFlushBytes < 1 || opts.MinFlushBytes > opts.Config.FlushBytes {
+		opts.MinFlushBytes = opts.Config.FlushBytes
+	}
+
+	a := &AdaptiveBulkIndexer{
+		opts:        opts,
+		workers:     opts.Config.NumWorkers,
+		flush_bytes: opts.Config.FlushBytes,
+		mu:          new(sync.RWMutex),
+	}
+
+	bi, err := a.newBulkIndexer(a.workers, a.flush_bytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a.current = bi
+	return a, nil
+}
+
+// Add adds item to the current bulk indexer, counting any rejections reported for it.
+func (a *AdaptiveBulkIndexer) Add(ctx context.Context, item esutil.BulkIndexerItem) error {
+
+	on_failure := item.OnFailure
+
+	item.OnFailure = func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+
+		if res.Status == 429 {
+			atomic.AddInt64(&a.rejections, 1)
+		}
+
+		if on_failure != nil {
+			on_failure(ctx, item, res, err)
+		}
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.current.Add(ctx, item)
+}
+
+// Close closes the current bulk indexer. Subsequent calls to Observe have no effect.
+func (a *AdaptiveBulkIndexer) Close(ctx context.Context) error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.closed = true
+	return a.current.Close(ctx)
+}
+
+// Stats returns the combined statistics of every bulk indexer used so far.
+func (a *AdaptiveBulkIndexer) Stats() esutil.BulkIndexerStats {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return addStats(a.closed_stats, a.current.Stats())
+}
+
+// Observe adjusts the concurrency and flush threshold in response to status and to any bulk rejections
+// seen since the previous call. It should be called periodically (for example from pressure.Guard.Watch)
+// and not concurrently with itself.
+func (a *AdaptiveBulkIndexer) Observe(ctx context.Context, status *pressure.Status) {
+
+	rejections := atomic.SwapInt64(&a.rejections, 0)
+
+	workers := a.workers
+	flush_bytes := a.flush_bytes
+
+	if rejections > 0 || !status.OK() {
+
+		a.calm = 0
+
+		workers = maxInt(workers/2, a.opts.MinWorkers)
+		flush_bytes = maxInt(flush_bytes/2, a.opts.MinFlushBytes)
+
+		if workers != a.workers || flush_bytes != a.flush_bytes {
+			log.Printf("Cluster is pushing back (%d bulk rejections, %s), reducing to %d workers and %d flush bytes", rejections, status, workers, flush_bytes)
+		}
+
+	} else {
+
+		a.calm += 1
+
+		// Wait for two quiet intervals in a row before growing again
+		if a.calm < 2 {
+			return
+		}
+
+		workers = minInt(workers+1, a.opts.Config.NumWorkers)
+		flush_bytes = minInt(flush_bytes+flush_bytes/4, a.opts.Config.FlushBytes)
+
+		if workers != a.workers || flush_bytes != a.flush_bytes {
+			log.Printf("Cluster has recovered, increasing to %d workers and %d flush bytes", workers, flush_bytes)
+		}
+	}
+
+	if workers == a.workers && flush_bytes == a.flush_bytes {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Check before creating a new bulk indexer so that none is started (and left running) after Close
+	if a.closed {
+		return
+	}
+
+	bi, err := a.newBulkIndexer(workers, flush_bytes)
+
+	if err != nil {
+		log.Printf("Failed to resize bulk indexer, %v", err)
+		return
+	}
+
+	// Use a context which can not be cancelled so that the items already added are always flushed and
+	// their callbacks run, even when ctx is cancelled because the caller is shutting down
+	err = a.current.Close(context.Background())
+
+	if err != nil {
+		log.Printf("Failed to close bulk indexer, %v", err)
+	}
+
+	a.closed_stats = addStats(a.closed_stats, a.current.Stats())
+
+	a.current = bi
+	a.workers = workers
+	a.flush_bytes = flush_bytes
+}
+
+func (a *AdaptiveBulkIndexer) newBulkIndexer(workers int, flush_bytes int) (esutil.BulkIndexer, error) {
+
+	cfg := a.opts.Config
+	cfg.NumWorkers = workers
+	cfg.FlushBytes = flush_bytes
+
+	bi, err := bulk.NewBulkIndexer(cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create bulk indexer, %w", err)
+	}
+
+	return bi, nil
+}
+
+func addStats(a esutil.BulkIndexerStats, b esutil.BulkIndexerStats) esutil.BulkIndexerStats {
+
+	return esutil.BulkIndexerStats{
+		NumAdded:    a.NumAdded + b.NumAdded,
+		NumFlushed:  a.NumFlushed + b.NumFlushed,
+		NumFailed:   a.NumFailed + b.NumFailed,
+		NumIndexed:  a.NumIndexed + b.NumIndexed,
+		NumCreated:  a.NumCreated + b.NumCreated,
+		NumUpdated:  a.NumUpdated + b.NumUpdated,
+		NumDeleted:  a.NumDeleted + b.NumDeleted,
+		NumRequests: a.NumRequests + b.NumRequests,
+	}
+}
+
+func minInt(a int, b int) int {
+
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func maxInt(a int, b int) int {
+
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,243 @@
+package throttle
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v7/estransport"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/bulk"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/pressure"
+)
+
+// newBulkServer returns a server which answers bulk requests, indexing every item unless reject is true in
+// which case every item is rejected with a 429 status.
+func newBulkServer(t *testing.T, reject *int32) *httptest.Server {
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if !strings.HasSuffix(req.URL.Path, "/_bulk") {
+			w.Write([]byte(`{"version":{"number":"7.10.0"}}`))
+			return
+		}
+
+		body, _ := io.ReadAll(req.Body)
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+
+		items := make([]string, 0)
+		is_action := true
+
+		for scanner.Scan() {
+
+			if is_action {
+
+				item := `{"index":{"_index":"test","status":201,"result":"created"}}`
+
+				if atomic.LoadInt32(reject) == 1 {
+					item = `{"index":{"_index":"test","status":429,"error":{"type":"es_rejected_execution_exception"}}}`
+				}
+
+				items = append(items, item)
+			}
+
+			is_action = !is_action
+		}
+
+		fmt.Fprintf(w, `{"took":1,"errors":false,"items":[%s]}`, strings.Join(items, ","))
+	})
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func newTestAdaptiveBulkIndexer(t *testing.T, srv *httptest.Server) *AdaptiveBulkIndexer {
+
+	srv_url, err := url.Parse(srv.URL)
+
+	if err != nil {
+		t.Fatalf("Failed to parse server URL, %v", err)
+	}
+
+	tr, err := estransport.New(estransport.Config{
+		URLs: []*url.URL{srv_url},
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to create client, %v", err)
+	}
+
+	opts := &AdaptiveOptions{
+		Config: bulk.Config{
+			Transport:  tr,
+			Index:      "test",
+			NumWorkers: 8,
+			FlushBytes: 1024 * 1024,
+		},
+		MinWorkers:    2,
+		MinFlushBytes: 1024,
+	}
+
+	a, err := NewAdaptiveBulkIndexer(opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create adaptive bulk indexer, %v", err)
+	}
+
+	return a
+}
+
+func addItems(t *testing.T, a *AdaptiveBulkIndexer, count int, wg *sync.WaitGroup, failed *int64) {
+
+	for i := 0; i < count; i++ {
+
+		wg.Add(1)
+
+		item := esutil.BulkIndexerItem{
+			Action: "index",
+			Body:   strings.NewReader(`{"n":1}`),
+			OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+				wg.Done()
+			},
+			OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+				atomic.AddInt64(failed, 1)
+				wg.Done()
+			},
+		}
+
+		err := a.Add(context.Background(), item)
+
+		if err != nil {
+			t.Fatalf("Failed to add item, %v", err)
+		}
+	}
+}
+
+func TestAdaptiveBulkIndexerShrinksAndGrows(t *testing.T) {
+
+	reject := int32(0)
+	a := newTestAdaptiveBulkIndexer(t, newBulkServer(t, &reject))
+
+	wg := new(sync.WaitGroup)
+	failed := int64(0)
+
+	// Items added before an adjustment must be flushed even if the watch context has been cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	addItems(t, a, 10, wg, &failed)
+
+	a.Observe(ctx, &pressure.Status{Reasons: []string{"write queue is full"}})
+
+	if a.workers != 4 || a.flush_bytes != 512*1024 {
+		t.Fatalf("Expected 4 workers and 524288 flush bytes, got %d and %d", a.workers, a.flush_bytes)
+	}
+
+	a.Observe(ctx, &pressure.Status{Reasons: []string{"write queue is full"}})
+	a.Observe(ctx, &pressure.Status{Reasons: []string{"write queue is full"}})
+
+	if a.workers != 2 {
+		t.Fatalf("Expected workers to stop at the minimum of 2, got %d", a.workers)
+	}
+
+	// Growing again requires two quiet intervals in a row
+	a.Observe(ctx, &pressure.Status{})
+
+	if a.workers != 2 {
+		t.Fatalf("Expected workers to stay at 2 after one quiet interval, got %d", a.workers)
+	}
+
+	a.Observe(ctx, &pressure.Status{})
+
+	if a.workers != 3 {
+		t.Fatalf("Expected workers to grow to 3, got %d", a.workers)
+	}
+
+	addItems(t, a, 5, wg, &failed)
+
+	err := a.Close(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to close, %v", err)
+	}
+
+	wg.Wait()
+
+	if failed != 0 {
+		t.Fatalf("Expected no failures, got %d", failed)
+	}
+
+	stats := a.Stats()
+
+	if stats.NumAdded != 15 || stats.NumIndexed != 15 {
+		t.Fatalf("Expected 15 items added and indexed across every bulk indexer, got %+v", stats)
+	}
+}
+
+func TestAdaptiveBulkIndexerRejections(t *testing.T) {
+
+	reject := int32(1)
+	a := newTestAdaptiveBulkIndexer(t, newBulkServer(t, &reject))
+
+	wg := new(sync.WaitGroup)
+	failed := int64(0)
+
+	addItems(t, a, 3, wg, &failed)
+
+	// Flush the rejected items by forcing an adjustment, which closes the current bulk indexer
+	a.Observe(context.Background(), &pressure.Status{Reasons: []string{"flush"}})
+	wg.Wait()
+
+	if failed != 3 {
+		t.Fatalf("Expected 3 rejected items, got %d", failed)
+	}
+
+	workers := a.workers
+
+	// The cluster reports no pressure but the rejections alone should shrink the bulk indexer
+	a.Observe(context.Background(), &pressure.Status{})
+
+	if a.workers != workers/2 {
+		t.Fatalf("Expected rejections to halve the workers from %d, got %d", workers, a.workers)
+	}
+
+	err := a.Close(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to close, %v", err)
+	}
+}
+
+func TestAdaptiveBulkIndexerObserveAfterClose(t *testing.T) {
+
+	reject := int32(0)
+	a := newTestAdaptiveBulkIndexer(t, newBulkServer(t, &reject))
+
+	err := a.Close(context.Background())
+
+	if err != nil {
+		t.Fatalf("Failed to close, %v", err)
+	}
+
+	current := a.current
+
+	a.Observe(context.Background(), &pressure.Status{Reasons: []string{"write queue is full"}})
+
+	if a.current != current || a.workers != 8 {
+		t.Fatalf("Expected Observe to have no effect after Close")
+	}
+}
@@ -0,0 +1,76 @@
+// package throttle provides methods for limiting the rate at which data is sent to an Elasticsearch cluster.
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket which refills at a fixed rate per second. A request for more tokens than
+// are available is granted immediately but leaves the bucket in debt, so later callers wait until it
+// has been repaid. This allows single requests larger than the bucket (for example a multi-MB document)
+// while still holding the long-term average to the configured rate.
+type Limiter struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	mu     *sync.Mutex
+}
+
+// NewLimiter returns a new Limiter permitting rate tokens per second, with a burst of one second's worth
+// of tokens. If rate is less than or equal to zero the returned Limiter never waits.
+func NewLimiter(rate float64) *Limiter {
+
+	l := &Limiter{
+		rate:   rate,
+		tokens: rate,
+		last:   time.Now(),
+		mu:     new(sync.Mutex),
+	}
+
+	return l
+}
+
+// WaitN blocks until n tokens have been taken from the bucket or ctx is cancelled.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+
+	if l.rate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+
+	now := time.Now()
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	l.last = now
+
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+
+	// Wait for any existing debt to be repaid before taking tokens for this request
+	var wait time.Duration
+
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+
+	l.tokens -= float64(n)
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,72 @@
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiterUnlimited(t *testing.T) {
+
+	l := NewLimiter(0)
+
+	for i := 0; i < 100; i++ {
+
+		err := l.WaitN(context.Background(), 1000000)
+
+		if err != nil {
+			t.Fatalf("Expected an unlimited limiter never to wait, %v", err)
+		}
+	}
+}
+
+func TestLimiterDebt(t *testing.T) {
+
+	l := NewLimiter(100)
+
+	// A request larger than the bucket is granted immediately
+	start := time.Now()
+
+	err := l.WaitN(context.Background(), 120)
+
+	if err != nil {
+		t.Fatalf("Failed to wait, %v", err)
+	}
+
+	if time.Since(start) > 50*time.Millisecond {
+		t.Fatalf("Expected the first request to be granted immediately")
+	}
+
+	// The next caller waits for the debt of about 20 tokens (200ms) to be repaid
+	err = l.WaitN(context.Background(), 1)
+
+	if err != nil {
+		t.Fatalf("Failed to wait, %v", err)
+	}
+
+	elapsed := time.Since(start)
+
+	if elapsed < 150*time.Millisecond {
+		t.Fatalf("Expected the second request to wait for the debt to be repaid, waited %v", elapsed)
+	}
+}
+
+func TestLimiterCancelled(t *testing.T) {
+
+	l := NewLimiter(1)
+
+	err := l.WaitN(context.Background(), 100)
+
+	if err != nil {
+		t.Fatalf("Failed to wait, %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err = l.WaitN(ctx, 1)
+
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected the deadline to be exceeded, got %v", err)
+	}
+}
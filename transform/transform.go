@@ -0,0 +1,270 @@
+// package transform provides methods for changing the properties of documents, for example as they are
+// copied from one index to another.
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The actions a rule can take.
+const (
+	// SET sets the property to a value, creating any objects along its path which do not exist.
+	SET string = "set"
+	// REMOVE removes the property.
+	REMOVE string = "remove"
+	// RENAME moves the property to another path, replacing any value already there.
+	RENAME string = "rename"
+	// COPY copies the property to another path, replacing any value already there.
+	COPY string = "copy"
+)
+
+// Rule describes a change to make to each document.
+type Rule struct {
+	Action string `json:"action"`
+	// Path is the dotted path of the property to change, relative to the document's _source, for example
+	// "address.city".
+	Path string `json:"path"`
+	// To is the dotted path which RENAME moves, and COPY copies, the property to.
+	To string `json:"to,omitempty"`
+	// Value is the JSON value which SET sets the property to.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// String returns a short description of r, for example "rename title".
+func (r *Rule) String() string {
+	return r.Action + " " + r.Path
+}
+
+// Transformer applies a list of rules to documents. It is safe for concurrent use.
+type Transformer struct {
+	rules []*Rule
+}
+
+// ReadRules reads a JSON-encoded list of rules from the file at path.
+func ReadRules(path string) ([]*Rule, error) {
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read rules, %w", err)
+	}
+
+	var rules []*Rule
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&rules)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode rules %s, %w", path, err)
+	}
+
+	return rules, nil
+}
+
+// NewTransformer returns a new Transformer which applies rules, in order.
+func NewTransformer(rules []*Rule) (*Transformer, error) {
+
+	for i, r := range rules {
+
+		if r.Path == "" || strings.Contains(r.Path, "..") || strings.HasPrefix(r.Path, ".") || strings.HasSuffix(r.Path, ".") {
+			return nil, fmt.Errorf("Rule %d has an invalid path '%s'", i+1, r.Path)
+		}
+
+		switch r.Action {
+		case SET:
+
+			if len(r.Value) == 0 || !json.Valid(r.Value) {
+				return nil, fmt.Errorf("Rule %d (%s) is missing a valid value", i+1, r)
+			}
+
+		case REMOVE:
+			// pass
+		case RENAME, COPY:
+
+			if r.To == "" || strings.Contains(r.To, "..") || strings.HasPrefix(r.To, ".") || strings.HasSuffix(r.To, ".") {
+				return nil, fmt.Errorf("Rule %d (%s) has an invalid destination '%s'", i+1, r, r.To)
+			}
+
+			if r.To == r.Path || strings.HasPrefix(r.To, r.Path+".") {
+				return nil, fmt.Errorf("Rule %d (%s) can not move a property inside itself", i+1, r)
+			}
+
+		default:
+			return nil, fmt.Errorf("Rule %d has an invalid action '%s'", i+1, r.Action)
+		}
+	}
+
+	t := &Transformer{
+		rules: rules,
+	}
+
+	return t, nil
+}
+
+// Transform returns a copy of the JSON object source with every rule applied. Rules for properties which do
+// not exist are skipped. It is an error for a path to run through a value which is not an object.
+func (t *Transformer) Transform(source []byte) ([]byte, error) {
+
+	doc, err := decode(source)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode document, %w", err)
+	}
+
+	obj, ok := doc.(map[string]interface{})
+
+	if !ok {
+		return nil, fmt.Errorf("Document is not an object")
+	}
+
+	for i, r := range t.rules {
+
+		err := apply(obj, r)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to apply rule %d (%s), %w", i+1, r, err)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	err = enc.Encode(obj)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode document, %w", err)
+	}
+
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+func apply(obj map[string]interface{}, r *Rule) error {
+
+	if r.Action == SET {
+
+		value, err := decode(r.Value)
+
+		if err != nil {
+			return err
+		}
+
+		return set(obj, r.Path, value)
+	}
+
+	parent, key, err := lookup(obj, r.Path, false)
+
+	if err != nil {
+		return err
+	}
+
+	if parent == nil {
+		return nil
+	}
+
+	value, exists := parent[key]
+
+	if !exists {
+		return nil
+	}
+
+	switch r.Action {
+	case REMOVE:
+		delete(parent, key)
+	case RENAME:
+
+		delete(parent, key)
+		return set(obj, r.To, value)
+
+	case COPY:
+
+		// Decode the value again so that the copy does not share objects or arrays with the original
+		enc_value, err := json.Marshal(value)
+
+		if err != nil {
+			return err
+		}
+
+		value, err = decode(enc_value)
+
+		if err != nil {
+			return err
+		}
+
+		return set(obj, r.To, value)
+	}
+
+	return nil
+}
+
+// set sets the property at path in obj to value, creating any missing objects along the way.
+func set(obj map[string]interface{}, path string, value interface{}) error {
+
+	parent, key, err := lookup(obj, path, true)
+
+	if err != nil {
+		return err
+	}
+
+	parent[key] = value
+	return nil
+}
+
+// lookup returns the object containing the property at path and the property's key. If create is true
+// missing objects along the path are created, and otherwise a nil object is returned if any are missing.
+func lookup(obj map[string]interface{}, path string, create bool) (map[string]interface{}, string, error) {
+
+	keys := strings.Split(path, ".")
+
+	for i, k := range keys[:len(keys)-1] {
+
+		v, exists := obj[k]
+
+		if !exists {
+
+			if !create {
+				return nil, "", nil
+			}
+
+			child := make(map[string]interface{})
+			obj[k] = child
+			obj = child
+
+			continue
+		}
+
+		child, ok := v.(map[string]interface{})
+
+		if !ok {
+			return nil, "", fmt.Errorf("%s is not an object", strings.Join(keys[:i+1], "."))
+		}
+
+		obj = child
+	}
+
+	return obj, keys[len(keys)-1], nil
+}
+
+// decode decodes the JSON value body, keeping numbers as they are written.
+func decode(body []byte) (interface{}, error) {
+
+	var v interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	err := dec.Decode(&v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
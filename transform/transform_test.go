@@ -0,0 +1,121 @@
+package transform
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+
+	rules := []*Rule{
+		{Action: RENAME, Path: "title", To: "name"},
+		{Action: COPY, Path: "address", To: "location.address"},
+		{Action: REMOVE, Path: "address.notes"},
+		{Action: SET, Path: "meta.migrated", Value: json.RawMessage(`true`)},
+		// Rules for properties which do not exist are skipped
+		{Action: REMOVE, Path: "missing.property"},
+		{Action: RENAME, Path: "missing", To: "other"},
+	}
+
+	tr, err := NewTransformer(rules)
+
+	if err != nil {
+		t.Fatalf("Failed to create transformer, %v", err)
+	}
+
+	source := []byte(`{"title":"SFO <Museum>","id":12345678901234567890,"address":{"city":"San Francisco","notes":"x"}}`)
+
+	out, err := tr.Transform(source)
+
+	if err != nil {
+		t.Fatalf("Failed to transform document, %v", err)
+	}
+
+	// Numbers too large for a float64 are kept as they are, and the copied address is not changed by the
+	// removal from the original
+	expected := `{"address":{"city":"San Francisco"},"id":12345678901234567890,"location":{"address":{"city":"San Francisco","notes":"x"}},"meta":{"migrated":true},"name":"SFO <Museum>"}`
+
+	if string(out) != expected {
+		t.Fatalf("Unexpected document %s", out)
+	}
+}
+
+func TestTransformErrors(t *testing.T) {
+
+	tr, err := NewTransformer([]*Rule{
+		{Action: SET, Path: "title.text", Value: json.RawMessage(`"x"`)},
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to create transformer, %v", err)
+	}
+
+	_, err = tr.Transform([]byte(`{"title":"Tin Drum"}`))
+
+	if err == nil {
+		t.Fatalf("Expected an error setting a property of a string")
+	}
+
+	_, err = tr.Transform([]byte(`[]`))
+
+	if err == nil {
+		t.Fatalf("Expected an error transforming an array")
+	}
+}
+
+func TestNewTransformerInvalid(t *testing.T) {
+
+	tests := []*Rule{
+		{Action: "upper", Path: "title"},
+		{Action: REMOVE, Path: ""},
+		{Action: REMOVE, Path: "a..b"},
+		{Action: SET, Path: "title"},
+		{Action: SET, Path: "title", Value: json.RawMessage(`{`)},
+		{Action: RENAME, Path: "title"},
+		{Action: COPY, Path: "title", To: "title.text"},
+	}
+
+	for _, r := range tests {
+
+		_, err := NewTransformer([]*Rule{r})
+
+		if err == nil {
+			t.Fatalf("Expected rule %+v to be invalid", r)
+		}
+	}
+}
+
+func TestReadRules(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "rules.json")
+
+	err := os.WriteFile(path, []byte(`[{"action":"set","path":"a","value":{"b":1}}]`), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write rules, %v", err)
+	}
+
+	rules, err := ReadRules(path)
+
+	if err != nil {
+		t.Fatalf("Failed to read rules, %v", err)
+	}
+
+	if len(rules) != 1 || rules[0].Action != SET || string(rules[0].Value) != `{"b":1}` {
+		t.Fatalf("Unexpected rules %+v", rules)
+	}
+
+	err = os.WriteFile(path, []byte(`[{"action":"set","path":"a","valeu":1}]`), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write rules, %v", err)
+	}
+
+	_, err = ReadRules(path)
+
+	if err == nil {
+		t.Fatalf("Expected an error reading rules with an unknown property")
+	}
+}
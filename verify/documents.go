@@ -0,0 +1,48 @@
+package verify
+
+import (
+	"sync"
+)
+
+// Documents counts the distinct documents a set of records will create in an index. Records which share an
+// _id replace each other and so count as a single document, while records without an _id are each assigned
+// one when they are indexed.
+type Documents struct {
+	ids       map[string]bool
+	anonymous int
+	mu        *sync.Mutex
+}
+
+// NewDocuments returns a new, empty, Documents.
+func NewDocuments() *Documents {
+
+	d := &Documents{
+		ids: make(map[string]bool),
+		mu:  new(sync.Mutex),
+	}
+
+	return d
+}
+
+// Add records a document with the given _id, which may be empty.
+func (d *Documents) Add(id string) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if id == "" {
+		d.anonymous += 1
+		return
+	}
+
+	d.ids[id] = true
+}
+
+// Count returns the number of distinct documents added.
+func (d *Documents) Count() int {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.ids) + d.anonymous
+}
@@ -0,0 +1,24 @@
+package verify
+
+import (
+	"testing"
+)
+
+func TestDocuments(t *testing.T) {
+
+	d := NewDocuments()
+
+	// A record repeating an _id, for example from an incremental dump appended to a full dump, replaces the
+	// earlier document
+	d.Add("a")
+	d.Add("b")
+	d.Add("a")
+
+	// Records without an _id are assigned distinct ones
+	d.Add("")
+	d.Add("")
+
+	if d.Count() != 4 {
+		t.Fatalf("Expected 4 documents, got %d", d.Count())
+	}
+}
@@ -0,0 +1,75 @@
+package verify
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Reservoir keeps a uniformly random sample of the records it is given.
+type Reservoir struct {
+	size    int
+	seen    int
+	ids     []string
+	records map[string][]byte
+	rand    *rand.Rand
+	mu      *sync.Mutex
+}
+
+// NewReservoir returns a new Reservoir that will retain at most size records.
+func NewReservoir(size int) *Reservoir {
+
+	r := &Reservoir{
+		size:    size,
+		ids:     make([]string, 0, size),
+		records: make(map[string][]byte, size),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		mu:      new(sync.Mutex),
+	}
+
+	return r
+}
+
+// Add offers the record source, identified by id, to the sample. If id has already been sampled its
+// source is replaced, mirroring what happens when a duplicate ID is indexed.
+func (r *Reservoir) Add(id string, source []byte) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.records[id]; ok {
+		r.records[id] = source
+		return
+	}
+
+	r.seen += 1
+
+	if len(r.ids) < r.size {
+		r.ids = append(r.ids, id)
+		r.records[id] = source
+		return
+	}
+
+	i := r.rand.Intn(r.seen)
+
+	if i < r.size {
+		delete(r.records, r.ids[i])
+		r.ids[i] = id
+		r.records[id] = source
+	}
+}
+
+// Records returns the sampled records keyed by ID.
+func (r *Reservoir) Records() map[string][]byte {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := make(map[string][]byte, len(r.records))
+
+	for id, source := range r.records {
+		records[id] = source
+	}
+
+	return records
+}
@@ -0,0 +1,80 @@
+package verify
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReservoirKeepsEverythingUnderSize(t *testing.T) {
+
+	r := NewReservoir(10)
+
+	for i := 0; i < 5; i++ {
+		r.Add(fmt.Sprintf("id%d", i), []byte(fmt.Sprintf(`{"n":%d}`, i)))
+	}
+
+	records := r.Records()
+
+	if len(records) != 5 {
+		t.Fatalf("Expected 5 records, got %d", len(records))
+	}
+
+	if string(records["id3"]) != `{"n":3}` {
+		t.Fatalf("Unexpected record %s", records["id3"])
+	}
+}
+
+func TestReservoirIsBounded(t *testing.T) {
+
+	r := NewReservoir(10)
+
+	for i := 0; i < 1000; i++ {
+		r.Add(fmt.Sprintf("id%d", i), []byte(`{}`))
+	}
+
+	if len(r.Records()) != 10 {
+		t.Fatalf("Expected 10 records, got %d", len(r.Records()))
+	}
+}
+
+func TestReservoirReplacesDuplicates(t *testing.T) {
+
+	r := NewReservoir(10)
+
+	r.Add("id", []byte(`{"n":1}`))
+	r.Add("id", []byte(`{"n":2}`))
+
+	records := r.Records()
+
+	if len(records) != 1 || string(records["id"]) != `{"n":2}` {
+		t.Fatalf("Expected the later record to replace the earlier one, got %v", records)
+	}
+}
+
+func TestReservoirIsUniform(t *testing.T) {
+
+	// Every record should be about as likely to be sampled as any other
+	counts := make(map[string]int)
+
+	for run := 0; run < 2000; run++ {
+
+		r := NewReservoir(1)
+
+		for i := 0; i < 4; i++ {
+			r.Add(fmt.Sprintf("id%d", i), []byte(`{}`))
+		}
+
+		for id := range r.Records() {
+			counts[id] += 1
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+
+		n := counts[fmt.Sprintf("id%d", i)]
+
+		if n < 350 || n > 650 {
+			t.Fatalf("Expected id%d to be sampled about 500 times, got %d (%v)", i, n, counts)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// package verify provides methods for checking that the documents in an Elasticsearch index match the
+// records they were restored from.
+package verify
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+
+	"github.com/sfomuseum/go-jsonl-elasticsearch/canonical"
+	"github.com/sfomuseum/go-jsonl-elasticsearch/index"
+)
+
+// The maximum number of documents to request in a single _mget call.
+const mget_batch_size int = 500
+
+// Mismatch describes a sampled document whose indexed _source differs from its input record.
+type Mismatch struct {
+	ID       string `json:"id"`
+	Reason   string `json:"reason"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// Report describes the outcome of verifying an index against its input records.
+type Report struct {
+	Index      string      `json:"index"`
+	Expected   int         `json:"expected_count"`
+	Count      int         `json:"count"`
+	Sampled    int         `json:"sampled"`
+	Missing    []string    `json:"missing"`
+	Mismatched []*Mismatch `json:"mismatched"`
+	OK         bool        `json:"ok"`
+}
+
+// Verify compares the document count of the index (or alias) name with expected, the number of distinct
+// documents restored to it, and compares the indexed _source of each of the documents in samples with its
+// input record. The index should be refreshed before calling Verify.
+func Verify(ctx context.Context, es_client *esapi.API, name string, expected int, samples *Reservoir) (*Report, error) {
+
+	count, err := index.Count(ctx, es_client, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Index:      name,
+		Expected:   expected,
+		Count:      count,
+		Missing:    make([]string, 0),
+		Mismatched: make([]*Mismatch, 0),
+	}
+
+	records := samples.Records()
+	report.Sampled = len(records)
+
+	ids := make([]string, 0, len(records))
+
+	for id := range records {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	for i := 0; i < len(ids); i += mget_batch_size {
+
+		j := i + mget_batch_size
+
+		if j > len(ids) {
+			j = len(ids)
+		}
+
+		docs, err := index.Documents(ctx, es_client, name, ids[i:j])
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range ids[i:j] {
+
+			source, ok := docs[id]
+
+			if !ok {
+				report.Missing = append(report.Missing, id)
+				continue
+			}
+
+			expected_source, err := canonical.Marshal(records[id])
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to canonicalize input record %s, %w", id, err)
+			}
+
+			actual_source, err := canonical.Marshal(source)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to canonicalize indexed document %s, %w", id, err)
+			}
+
+			if string(expected_source) != string(actual_source) {
+
+				m := &Mismatch{
+					ID:       id,
+					Reason:   "_source differs",
+					Expected: string(expected_source),
+					Actual:   string(actual_source),
+				}
+
+				report.Mismatched = append(report.Mismatched, m)
+			}
+		}
+	}
+
+	report.OK = report.Count == report.Expected && len(report.Missing) == 0 && len(report.Mismatched) == 0
+	return report, nil
+}
@@ -0,0 +1,106 @@
+package verify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (tr *handlerTransport) Perform(req *http.Request) (*http.Response, error) {
+
+	rec := httptest.NewRecorder()
+	tr.handler.ServeHTTP(rec, req)
+
+	return rec.Result(), nil
+}
+
+func newTestAPI(count int, docs string) *esapi.API {
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/_count"):
+			w.Write([]byte(`{"count":` + strconv.Itoa(count) + `}`))
+		case strings.HasSuffix(req.URL.Path, "/_mget"):
+			w.Write([]byte(docs))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+
+	return esapi.New(&handlerTransport{handler: handler})
+}
+
+func TestVerify(t *testing.T) {
+
+	samples := NewReservoir(10)
+	samples.Add("a", []byte(`{"x": 1, "y": 2}`))
+	samples.Add("b", []byte(`{"x": 2}`))
+	samples.Add("c", []byte(`{"x": 3}`))
+
+	docs := `{"docs":[
+		{"_id":"a","found":true,"_source":{"y":2,"x":1}},
+		{"_id":"b","found":true,"_source":{"x":20}},
+		{"_id":"c","found":false}
+	]}`
+
+	report, err := Verify(context.Background(), newTestAPI(3, docs), "books", 3, samples)
+
+	if err != nil {
+		t.Fatalf("Failed to verify, %v", err)
+	}
+
+	if report.OK {
+		t.Fatalf("Expected verification to fail")
+	}
+
+	if report.Sampled != 3 || report.Count != 3 || report.Expected != 3 {
+		t.Fatalf("Unexpected counts %+v", report)
+	}
+
+	if len(report.Missing) != 1 || report.Missing[0] != "c" {
+		t.Fatalf("Expected c to be missing, got %v", report.Missing)
+	}
+
+	// Documents with their keys in a different order still match
+	if len(report.Mismatched) != 1 || report.Mismatched[0].ID != "b" {
+		t.Fatalf("Expected only b to be mismatched, got %v", report.Mismatched)
+	}
+}
+
+func TestVerifyCount(t *testing.T) {
+
+	samples := NewReservoir(10)
+	samples.Add("a", []byte(`{"x": 1}`))
+
+	docs := `{"docs":[{"_id":"a","found":true,"_source":{"x":1}}]}`
+
+	report, err := Verify(context.Background(), newTestAPI(1, docs), "books", 1, samples)
+
+	if err != nil {
+		t.Fatalf("Failed to verify, %v", err)
+	}
+
+	if !report.OK {
+		t.Fatalf("Expected verification to succeed, %+v", report)
+	}
+
+	report, err = Verify(context.Background(), newTestAPI(2, docs), "books", 1, samples)
+
+	if err != nil {
+		t.Fatalf("Failed to verify, %v", err)
+	}
+
+	if report.OK {
+		t.Fatalf("Expected verification to fail when the count differs")
+	}
+}
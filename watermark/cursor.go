@@ -0,0 +1,64 @@
+package watermark
+
+import (
+	"fmt"
+
+	json "github.com/goccy/go-json"
+	"github.com/tidwall/gjson"
+)
+
+// Cursor is the position of a search which follows changes to a field: the value of the field in the last
+// document read and the _ids of the documents read with that value. Unlike the sort value of a hit, which
+// includes its position in a point in time, it remains valid from one point in time to the next.
+type Cursor struct {
+	// Value is the value of the field in the last document read, as it appears in a sort value, or the value
+	// to start at if no documents have been read.
+	Value json.RawMessage
+	ids   map[string]bool
+	read  int
+}
+
+// NewCursor returns a new Cursor which starts at the documents whose field is value. value can be empty to
+// start at the lowest value.
+func NewCursor(value json.RawMessage) *Cursor {
+
+	c := &Cursor{
+		Value: value,
+		ids:   make(map[string]bool),
+	}
+
+	return c
+}
+
+// Add advances c past hit, a hit of a search sorted by the field in ascending order, and reports whether it
+// had not already been read.
+func (c *Cursor) Add(hit []byte) (bool, error) {
+
+	rsp := gjson.GetManyBytes(hit, "_id", "sort.0")
+
+	id := rsp[0].String()
+	value := rsp[1]
+
+	if id == "" || !value.Exists() {
+		return false, fmt.Errorf("Hit is missing its _id or sort value")
+	}
+
+	if string(c.Value) != value.Raw {
+		c.Value = json.RawMessage(value.Raw)
+		c.ids = make(map[string]bool)
+	}
+
+	if c.ids[id] {
+		return false, nil
+	}
+
+	c.ids[id] = true
+	c.read += 1
+
+	return true, nil
+}
+
+// Read returns the number of documents c has been advanced past.
+func (c *Cursor) Read() int {
+	return c.read
+}
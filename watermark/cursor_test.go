@@ -0,0 +1,94 @@
+package watermark
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	json "github.com/goccy/go-json"
+)
+
+// poll returns the _ids of hits, given as pairs of _id and value in the order of a search sorted by the field, which
+// are added to c.
+func poll(t *testing.T, c *Cursor, hits ...[2]interface{}) []string {
+
+	t.Helper()
+
+	added := make([]string, 0)
+
+	// Each poll searches a new point in time, so the same documents have different tiebreakers
+	for i, h := range hits {
+
+		hit := fmt.Sprintf(`{"_id":"%s","_source":{},"sort":[%v,%d]}`, h[0], h[1], 1000-i)
+
+		ok, err := c.Add([]byte(hit))
+
+		if err != nil {
+			t.Fatalf("Failed to add %s, %v", hit, err)
+		}
+
+		if ok {
+			added = append(added, h[0].(string))
+		}
+	}
+
+	return added
+}
+
+func TestFollowTie(t *testing.T) {
+
+	c := NewCursor(nil)
+
+	added := poll(t, c, [2]interface{}{"a", 5}, [2]interface{}{"b", 7}, [2]interface{}{"c", 7})
+
+	if !reflect.DeepEqual(added, []string{"a", "b", "c"}) {
+		t.Fatalf("Expected a, b and c to be added, got %v", added)
+	}
+
+	if string(c.Value) != "7" || c.Read() != 3 {
+		t.Fatalf("Expected the cursor to be at 7 after 3 documents, got %s after %d", c.Value, c.Read())
+	}
+
+	// The next poll searches from 7 again: d was indexed with the same value as the last document, and sorts
+	// between the documents already read
+	added = poll(t, c, [2]interface{}{"b", 7}, [2]interface{}{"d", 7}, [2]interface{}{"c", 7})
+
+	if !reflect.DeepEqual(added, []string{"d"}) {
+		t.Fatalf("Expected only d to be added, got %v", added)
+	}
+
+	// A document read at an earlier value is read again once it changes
+	added = poll(t, c, [2]interface{}{"c", 7}, [2]interface{}{"d", 7}, [2]interface{}{"b", 7}, [2]interface{}{"a", 9})
+
+	if !reflect.DeepEqual(added, []string{"a"}) || string(c.Value) != "9" {
+		t.Fatalf("Expected a to be added at 9, got %v at %s", added, c.Value)
+	}
+
+	added = poll(t, c, [2]interface{}{"a", 9})
+
+	if len(added) != 0 || c.Read() != 5 {
+		t.Fatalf("Expected nothing to be added, got %v", added)
+	}
+}
+
+func TestCursorStart(t *testing.T) {
+
+	// A cursor which starts at the high-water mark of a dump reads the documents with that value again, since
+	// the dump does not record which of them it wrote
+	c := NewCursor(json.RawMessage(`"2025-10-19T00:00:00.500Z"`))
+
+	added := poll(t, c, [2]interface{}{"a", 1760832000500})
+
+	if len(added) != 1 || string(c.Value) != "1760832000500" {
+		t.Fatalf("Expected a to be added at 1760832000500, got %v at %s", added, c.Value)
+	}
+
+	for _, hit := range []string{`{"sort":[1]}`, `{"_id":"a"}`} {
+
+		_, err := c.Add([]byte(hit))
+
+		if err == nil {
+			t.Fatalf("Expected an error adding %s", hit)
+		}
+	}
+}
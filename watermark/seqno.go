@@ -0,0 +1,296 @@
+package watermark
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	json "github.com/goccy/go-json"
+)
+
+// SEQ_NO is the sequence number of each document, which increases whenever a document changes but is only
+// ordered within each shard, so it has a high-water mark for every shard rather than one for the index.
+const SEQ_NO string = "_seq_no"
+
+// Checkpoint is the high-water mark of SEQ_NO in one shard.
+type Checkpoint struct {
+	Index string `json:"index"`
+	Shard int    `json:"shard"`
+	// SeqNo is the largest sequence number in the shard, or -1 if it has no documents.
+	SeqNo int64 `json:"seq_no"`
+}
+
+// preference returns the search preference which limits a search to the shard of c.
+func (c *Checkpoint) preference() string {
+	return "_shards:" + strconv.Itoa(c.Shard)
+}
+
+type shardKey struct {
+	index string
+	shard int
+}
+
+// decodeCheckpoints returns the list of checkpoints raw by their index and shard. raw may be empty.
+func decodeCheckpoints(raw json.RawMessage) (map[shardKey]*Checkpoint, error) {
+
+	checkpoints := make(map[shardKey]*Checkpoint)
+
+	if len(raw) == 0 {
+		return checkpoints, nil
+	}
+
+	var list []*Checkpoint
+
+	err := json.Unmarshal(raw, &list)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %s checkpoints, %w", SEQ_NO, err)
+	}
+
+	for _, c := range list {
+		checkpoints[shardKey{c.Index, c.Shard}] = c
+	}
+
+	return checkpoints, nil
+}
+
+// checkpoints returns the list of the largest sequence numbers of the documents matching query in each shard
+// of index, or nil if none of them are greater than those in since. Shards which have not changed keep their
+// checkpoint in since.
+func (w *Watermark) checkpoints(ctx context.Context, es_client *esapi.API, index string, query json.RawMessage, since json.RawMessage) (json.RawMessage, error) {
+
+	previous, err := decodeCheckpoints(since)
+
+	if err != nil {
+		return nil, err
+	}
+
+	shards, err := primaryShards(ctx, es_client, index)
+
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+
+	for _, c := range shards {
+
+		last := int64(-1)
+		var since_value json.RawMessage
+
+		p, ok := previous[shardKey{c.Index, c.Shard}]
+
+		if ok {
+			last = p.SeqNo
+			since_value = json.RawMessage(strconv.FormatInt(p.SeqNo, 10))
+		}
+
+		// c.SeqNo is the shard's global checkpoint
+		if c.SeqNo <= last {
+			c.SeqNo = last
+			continue
+		}
+
+		seq_no, err := w.maxSeqNo(ctx, es_client, c, query, since_value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if seq_no > last {
+			changed = true
+		} else {
+			seq_no = last
+		}
+
+		c.SeqNo = seq_no
+	}
+
+	if !changed {
+		return nil, nil
+	}
+
+	enc_shards, err := json.Marshal(shards)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode %s checkpoints, %w", SEQ_NO, err)
+	}
+
+	return enc_shards, nil
+}
+
+// primaryShards returns a checkpoint for every primary shard of index whose SeqNo is the shard's global
+// checkpoint, the sequence number up to which every operation has been applied to every copy of the shard
+// and so can not be lost if the primary fails.
+func primaryShards(ctx context.Context, es_client *esapi.API, index string) ([]*Checkpoint, error) {
+
+	rsp, err := es_client.Indices.Stats(
+		es_client.Indices.Stats.WithContext(ctx),
+		es_client.Indices.Stats.WithIndex(index),
+		es_client.Indices.Stats.WithMetric("docs"),
+		es_client.Indices.Stats.WithLevel("shards"),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.IsError() {
+		return nil, fmt.Errorf("Failed to retrieve the shards of %s, %s", index, rsp.String())
+	}
+
+	var body struct {
+		Indices map[string]struct {
+			Shards map[string][]struct {
+				Routing struct {
+					Primary bool `json:"primary"`
+				} `json:"routing"`
+				SeqNo struct {
+					GlobalCheckpoint int64 `json:"global_checkpoint"`
+				} `json:"seq_no"`
+			} `json:"shards"`
+		} `json:"indices"`
+	}
+
+	err = json.NewDecoder(rsp.Body).Decode(&body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode the shards of %s, %w", index, err)
+	}
+
+	shards := make([]*Checkpoint, 0)
+
+	for name, idx := range body.Indices {
+
+		for id, copies := range idx.Shards {
+
+			shard, err := strconv.Atoi(id)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid shard %s of %s, %w", id, name, err)
+			}
+
+			for _, c := range copies {
+
+				if !c.Routing.Primary {
+					continue
+				}
+
+				shards = append(shards, &Checkpoint{
+					Index: name,
+					Shard: shard,
+					SeqNo: c.SeqNo.GlobalCheckpoint,
+				})
+			}
+		}
+	}
+
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("Failed to retrieve the shards of %s, no primary shards were found", index)
+	}
+
+	sort.Slice(shards, func(i int, j int) bool {
+
+		if shards[i].Index != shards[j].Index {
+			return shards[i].Index < shards[j].Index
+		}
+
+		return shards[i].Shard < shards[j].Shard
+	})
+
+	return shards, nil
+}
+
+// maxSeqNo returns the largest sequence number of the documents matching query in the shard of c whose sequence
+// number is greater than since and less than or equal to c.SeqNo, or -1 if there are none.
+func (w *Watermark) maxSeqNo(ctx context.Context, es_client *esapi.API, c *Checkpoint, query json.RawMessage, since json.RawMessage) (int64, error) {
+
+	window_query, err := w.Query(query, since, json.RawMessage(strconv.FormatInt(c.SeqNo, 10)), false)
+
+	if err != nil {
+		return 0, err
+	}
+
+	sort := map[string]string{
+		SEQ_NO: "desc",
+	}
+
+	value, err := maxValue(ctx, es_client, c.Index, c.preference(), window_query, sort)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to find the high-water mark of %s in shard %d of %s, %w", SEQ_NO, c.Shard, c.Index, err)
+	}
+
+	if value == nil {
+		return -1, nil
+	}
+
+	seq_no, err := strconv.ParseInt(string(value), 10, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s %s in shard %d of %s, %w", SEQ_NO, value, c.Shard, c.Index, err)
+	}
+
+	return seq_no, nil
+}
+
+// shardSearches returns a search for each shard in until with documents whose sequence number is greater than
+// its checkpoint in since.
+func (w *Watermark) shardSearches(query json.RawMessage, since json.RawMessage, until json.RawMessage) ([]*Search, error) {
+
+	previous, err := decodeCheckpoints(since)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var current []*Checkpoint
+
+	if len(until) > 0 {
+
+		err = json.Unmarshal(until, &current)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode %s checkpoints, %w", SEQ_NO, err)
+		}
+	}
+
+	searches := make([]*Search, 0)
+
+	for _, c := range current {
+
+		var since_value json.RawMessage
+
+		p, ok := previous[shardKey{c.Index, c.Shard}]
+
+		if ok {
+
+			if c.SeqNo <= p.SeqNo {
+				continue
+			}
+
+			since_value = json.RawMessage(strconv.FormatInt(p.SeqNo, 10))
+
+		} else if c.SeqNo < 0 {
+			continue
+		}
+
+		shard_query, err := w.Query(query, since_value, json.RawMessage(strconv.FormatInt(c.SeqNo, 10)), false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		searches = append(searches, &Search{
+			Index:      c.Index,
+			Preference: c.preference(),
+			Query:      shard_query,
+		})
+	}
+
+	return searches, nil
+}
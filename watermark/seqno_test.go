@@ -0,0 +1,197 @@
+package watermark
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	json "github.com/goccy/go-json"
+	"github.com/tidwall/gjson"
+)
+
+// shardServer answers shard statistics and _seq_no searches for the sequence numbers of the documents in
+// each shard.
+type shardServer struct {
+	// seq_nos are the sequence numbers of the documents in each shard.
+	seq_nos map[shardKey][]int64
+	// checkpoints are the global checkpoints of each shard.
+	checkpoints map[shardKey]int64
+	searches    []string
+	mu          sync.Mutex
+}
+
+func (s *shardServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	if len(parts) >= 2 && parts[1] == "_stats" {
+
+		indices := map[string]map[string][]interface{}{}
+
+		for k, gcp := range s.checkpoints {
+
+			if indices[k.index] == nil {
+				indices[k.index] = map[string][]interface{}{}
+			}
+
+			// A replica which is behind the primary is ignored
+			indices[k.index][fmt.Sprint(k.shard)] = []interface{}{
+				map[string]interface{}{"routing": map[string]bool{"primary": false}, "seq_no": map[string]int64{"global_checkpoint": gcp - 1}},
+				map[string]interface{}{"routing": map[string]bool{"primary": true}, "seq_no": map[string]int64{"global_checkpoint": gcp}},
+			}
+		}
+
+		body := map[string]interface{}{"indices": map[string]interface{}{}}
+
+		for name, shards := range indices {
+			body["indices"].(map[string]interface{})[name] = map[string]interface{}{"shards": shards}
+		}
+
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+
+	enc_body, _ := io.ReadAll(req.Body)
+
+	var shard int
+	fmt.Sscanf(req.URL.Query().Get("preference"), "_shards:%d", &shard)
+
+	k := shardKey{parts[0], shard}
+	s.searches = append(s.searches, fmt.Sprintf("%s/%d", k.index, k.shard))
+
+	bounds := gjson.GetBytes(enc_body, `query.bool.filter.0.range._seq_no`)
+	gt := bounds.Get("gt")
+	lte := bounds.Get("lte").Int()
+
+	max := int64(-1)
+
+	for _, seq_no := range s.seq_nos[k] {
+
+		if (gt.Exists() && seq_no <= gt.Int()) || seq_no > lte || seq_no <= max {
+			continue
+		}
+
+		max = seq_no
+	}
+
+	if max < 0 {
+		fmt.Fprint(w, `{}`)
+		return
+	}
+
+	fmt.Fprintf(w, `{"hits":{"hits":[{"sort":[%d]}]}}`, max)
+}
+
+func TestCheckpoints(t *testing.T) {
+
+	srv := &shardServer{
+		seq_nos: map[shardKey][]int64{
+			{"books-1", 0}: {0, 1, 2},
+			{"books-1", 1}: {0, 1, 5, 9},
+			{"books-2", 0}: {},
+		},
+		checkpoints: map[shardKey]int64{
+			{"books-1", 0}: 2,
+			// The document with sequence number 9 is not yet on every copy of the shard
+			{"books-1", 1}: 7,
+			{"books-2", 0}: -1,
+		},
+	}
+
+	api := newTestAPI(srv.ServeHTTP)
+	w := &Watermark{Field: SEQ_NO, Type: SEQ_NO}
+	ctx := context.Background()
+
+	high_water, err := w.HighWater(ctx, api, "books", nil, nil, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to find checkpoints, %v", err)
+	}
+
+	assertJSON(t, `[{"index":"books-1","shard":0,"seq_no":2},{"index":"books-1","shard":1,"seq_no":5},{"index":"books-2","shard":0,"seq_no":-1}]`, high_water)
+
+	// Nothing has changed
+	srv.searches = nil
+
+	unchanged, err := w.HighWater(ctx, api, "books", nil, high_water, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to find checkpoints, %v", err)
+	}
+
+	if unchanged != nil {
+		t.Fatalf("Expected no checkpoints when no shard has changed, got %s", unchanged)
+	}
+
+	// Only shards whose global checkpoint has moved past their checkpoint are searched
+	if len(srv.searches) != 1 || srv.searches[0] != "books-1/1" {
+		t.Fatalf("Expected a search of books-1/1, got %v", srv.searches)
+	}
+
+	srv.checkpoints[shardKey{"books-1", 1}] = 9
+	srv.seq_nos[shardKey{"books-2", 0}] = []int64{0}
+	srv.checkpoints[shardKey{"books-2", 0}] = 0
+
+	changed, err := w.HighWater(ctx, api, "books", nil, high_water, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to find checkpoints, %v", err)
+	}
+
+	assertJSON(t, `[{"index":"books-1","shard":0,"seq_no":2},{"index":"books-1","shard":1,"seq_no":9},{"index":"books-2","shard":0,"seq_no":0}]`, changed)
+}
+
+func TestShardSearches(t *testing.T) {
+
+	w := &Watermark{Field: SEQ_NO, Type: SEQ_NO}
+
+	since := json.RawMessage(`[{"index":"books-1","shard":0,"seq_no":2},{"index":"books-1","shard":1,"seq_no":5}]`)
+	until := json.RawMessage(`[{"index":"books-1","shard":0,"seq_no":2},{"index":"books-1","shard":1,"seq_no":9},{"index":"books-2","shard":0,"seq_no":-1},{"index":"books-2","shard":1,"seq_no":3}]`)
+	query := json.RawMessage(`{"term":{"status":"public"}}`)
+
+	searches, err := w.Searches("books", query, since, until, true)
+
+	if err != nil {
+		t.Fatalf("Failed to build searches, %v", err)
+	}
+
+	if len(searches) != 2 {
+		t.Fatalf("Expected 2 searches, got %d", len(searches))
+	}
+
+	if searches[0].Index != "books-1" || searches[0].Preference != "_shards:1" {
+		t.Fatalf("Expected a search of shard 1 of books-1, got %+v", searches[0])
+	}
+
+	assertJSON(t, `{"bool":{"filter":[{"range":{"_seq_no":{"gt":5,"lte":9}}},{"term":{"status":"public"}}]}}`, searches[0].Query)
+
+	// A shard without a checkpoint is searched from the start
+	if searches[1].Index != "books-2" || searches[1].Preference != "_shards:1" {
+		t.Fatalf("Expected a search of shard 1 of books-2, got %+v", searches[1])
+	}
+
+	assertJSON(t, `{"bool":{"filter":[{"range":{"_seq_no":{"lte":3}}},{"term":{"status":"public"}}]}}`, searches[1].Query)
+
+	searches, err = w.Searches("books", nil, since, nil, false)
+
+	if err != nil {
+		t.Fatalf("Failed to build searches, %v", err)
+	}
+
+	if len(searches) != 0 {
+		t.Fatalf("Expected no searches without checkpoints, got %d", len(searches))
+	}
+
+	_, err = w.Searches("books", nil, json.RawMessage(`"2026-10-19"`), until, false)
+
+	if err == nil {
+		t.Fatalf("Expected an error for invalid checkpoints")
+	}
+}
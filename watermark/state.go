@@ -0,0 +1,99 @@
+package watermark
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// State records the high-water mark of the last successful run, along with the options that determine which
+// documents it covered.
+type State struct {
+	Index string          `json:"index"`
+	Field string          `json:"field"`
+	Query json.RawMessage `json:"query,omitempty"`
+	// HighWater is the largest value of Field in the documents covered so far or, for SEQ_NO, the list of
+	// checkpoints of every shard.
+	HighWater json.RawMessage `json:"high_water_mark"`
+	Updated   time.Time       `json:"updated"`
+}
+
+// ReadState reads the state at path. If there is no file at path nil is returned.
+func ReadState(path string) (*State, error) {
+
+	body, err := os.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s, %w", path, err)
+	}
+
+	var s *State
+
+	err = json.Unmarshal(body, &s)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %s, %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Matches returns an error if s was recorded for a different index, field or query. Queries are compared
+// without whitespace since Write indents them.
+func (s *State) Matches(index string, field string, query json.RawMessage) error {
+
+	if s.Index != index || s.Field != field || !bytes.Equal(compact(s.Query), compact(query)) {
+		return fmt.Errorf("The state was recorded for a different index, field or query")
+	}
+
+	return nil
+}
+
+// compact returns raw without insignificant whitespace, or as is if it is not valid JSON.
+func compact(raw json.RawMessage) []byte {
+
+	var buf bytes.Buffer
+
+	err := json.Compact(&buf, raw)
+
+	if err != nil {
+		return raw
+	}
+
+	return buf.Bytes()
+}
+
+// Write writes s to path, replacing any existing file.
+func (s *State) Write(path string) error {
+
+	body, err := json.MarshalIndent(s, "", "  ")
+
+	if err != nil {
+		return fmt.Errorf("Failed to encode state, %w", err)
+	}
+
+	// Write to a temporary file first so that an interrupted write never leaves a truncated state file
+	tmp_path := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+
+	err = os.WriteFile(tmp_path, append(body, '\n'), 0644)
+
+	if err != nil {
+		return fmt.Errorf("Failed to write %s, %w", tmp_path, err)
+	}
+
+	err = os.Rename(tmp_path, path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to replace %s, %w", path, err)
+	}
+
+	return nil
+}
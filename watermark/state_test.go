@@ -0,0 +1,97 @@
+package watermark
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+func TestState(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "books.state")
+
+	s, err := ReadState(path)
+
+	if err != nil {
+		t.Fatalf("Failed to read missing state, %v", err)
+	}
+
+	if s != nil {
+		t.Fatalf("Expected no state, got %+v", s)
+	}
+
+	query := json.RawMessage(`{"term":{"status":"public"}}`)
+
+	s = &State{
+		Index:     "books",
+		Field:     SEQ_NO,
+		Query:     query,
+		HighWater: json.RawMessage(`[{"index":"books","shard":0,"seq_no":7}]`),
+		Updated:   time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC),
+	}
+
+	err = s.Write(path)
+
+	if err != nil {
+		t.Fatalf("Failed to write state, %v", err)
+	}
+
+	_, err = os.Stat(filepath.Join(filepath.Dir(path), ".books.state.tmp"))
+
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected the temporary file to be renamed")
+	}
+
+	read, err := ReadState(path)
+
+	if err != nil {
+		t.Fatalf("Failed to read state, %v", err)
+	}
+
+	assertJSON(t, string(s.HighWater), read.HighWater)
+
+	if !read.Updated.Equal(s.Updated) {
+		t.Fatalf("Expected updated %v, got %v", s.Updated, read.Updated)
+	}
+
+	err = read.Matches("books", SEQ_NO, query)
+
+	if err != nil {
+		t.Fatalf("Expected state to match, %v", err)
+	}
+
+	for _, other := range []string{"authors", "lastmodified"} {
+
+		err = read.Matches(other, other, query)
+
+		if err == nil {
+			t.Fatalf("Expected state not to match %s", other)
+		}
+	}
+
+	err = read.Matches("books", SEQ_NO, nil)
+
+	if err == nil {
+		t.Fatalf("Expected state not to match a different query")
+	}
+}
+
+func TestReadStateInvalid(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "books.state")
+
+	err := os.WriteFile(path, []byte(`{"index":`), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write state, %v", err)
+	}
+
+	_, err = ReadState(path)
+
+	if err == nil {
+		t.Fatalf("Expected an error reading an invalid state")
+	}
+}
@@ -0,0 +1,446 @@
+// package watermark provides methods for limiting a search to the documents which changed within a window of
+// values of a field, such as a last modified date, and for recording the high-water mark of that field between
+// runs.
+package watermark
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/elastic/go-elasticsearch/v7/esutil"
+	json "github.com/goccy/go-json"
+)
+
+// The format of the values of date fields, used to read and write high-water marks. epoch_millis is accepted
+// so that -since can also be a number of milliseconds.
+const DATE_FORMAT string = "strict_date_optional_time||epoch_millis"
+
+// The types of fields which can be used as a watermark.
+var numeric_types = []string{
+	"long",
+	"integer",
+	"short",
+	"byte",
+	"double",
+	"float",
+	"half_float",
+	"scaled_float",
+	"unsigned_long",
+}
+
+var date_types = []string{
+	"date",
+	"date_nanos",
+}
+
+// Watermark is a date or numeric field whose value increases whenever a document changes.
+type Watermark struct {
+	// Field is the name of the field.
+	Field string
+	// Type is the type of the field in the index's mappings.
+	Type string
+}
+
+// Search is one of the searches which together cover the documents in a window.
+type Search struct {
+	// Index is the index (or alias) to search.
+	Index string
+	// Preference, if set, limits the search to one shard, for example "_shards:0".
+	Preference string
+	// Query is the query to run. If empty all documents are matched.
+	Query json.RawMessage
+	// Sort, if set, orders the documents by the field.
+	Sort []json.RawMessage
+	// Cursor, if set, is advanced past each document read by a sorted search and used to skip those which had
+	// already been read.
+	Cursor *Cursor
+}
+
+// New returns a new Watermark for the field field in the index (or alias) index. It is an error for the field
+// not to be a date or numeric field, or to have different types in the indices an alias points to. SEQ_NO is
+// also accepted, in which case each shard has its own high-water mark.
+func New(ctx context.Context, es_client *esapi.API, index string, field string) (*Watermark, error) {
+
+	if field == SEQ_NO {
+
+		w := &Watermark{
+			Field: SEQ_NO,
+			Type:  SEQ_NO,
+		}
+
+		return w, nil
+	}
+
+	rsp, err := es_client.Indices.GetFieldMapping(
+		[]string{field},
+		es_client.Indices.GetFieldMapping.WithContext(ctx),
+		es_client.Indices.GetFieldMapping.WithIndex(index),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.IsError() {
+		return nil, fmt.Errorf("Failed to retrieve mapping for %s, %s", field, rsp.String())
+	}
+
+	var body map[string]struct {
+		Mappings map[string]struct {
+			Mapping map[string]struct {
+				Type string `json:"type"`
+			} `json:"mapping"`
+		} `json:"mappings"`
+	}
+
+	err = json.NewDecoder(rsp.Body).Decode(&body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode mapping for %s, %w", field, err)
+	}
+
+	// The mapping is keyed by the last part of the field's name
+	leaf := field[strings.LastIndex(field, ".")+1:]
+	field_type := ""
+
+	for name, idx := range body {
+
+		m, ok := idx.Mappings[field]
+
+		if !ok {
+			return nil, fmt.Errorf("%s does not have a field named %s", name, field)
+		}
+
+		t := m.Mapping[leaf].Type
+
+		if field_type != "" && t != field_type {
+			return nil, fmt.Errorf("%s is a %s field in some indices and a %s field in others", field, field_type, t)
+		}
+
+		field_type = t
+	}
+
+	if field_type == "" {
+		return nil, fmt.Errorf("%s does not have a field named %s", index, field)
+	}
+
+	if !contains(date_types, field_type) && !contains(numeric_types, field_type) {
+		return nil, fmt.Errorf("%s is a %s field, a watermark must be a date or numeric field", field, field_type)
+	}
+
+	w := &Watermark{
+		Field: field,
+		Type:  field_type,
+	}
+
+	return w, nil
+}
+
+// IsDate reports whether w is a date field.
+func (w *Watermark) IsDate() bool {
+	return contains(date_types, w.Type)
+}
+
+// IsSeqNo reports whether w is the sequence number of each document, SEQ_NO.
+func (w *Watermark) IsSeqNo() bool {
+	return w.Type == SEQ_NO
+}
+
+// Searches returns the searches which together cover the documents in index matching query whose field is
+// greater than since and less than or equal to until, as Query does. For SEQ_NO since and until are lists of
+// checkpoints, as returned by HighWater, and there is one search for each shard with documents in the window.
+// Otherwise there is a single search of index.
+func (w *Watermark) Searches(index string, query json.RawMessage, since json.RawMessage, until json.RawMessage, missing bool) ([]*Search, error) {
+
+	if w.IsSeqNo() {
+		return w.shardSearches(query, since, until)
+	}
+
+	window_query, err := w.Query(query, since, until, missing)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Search{
+		Index: index,
+		Query: window_query,
+	}
+
+	return []*Search{s}, nil
+}
+
+// After returns a search of index for the documents matching query whose field is greater than or equal to the
+// value of c and less than or equal to until, sorted by the field. The documents with the value of c which it
+// has already read are skipped as the search is read, so that a document which shares its value with the last
+// one read is neither skipped nor read twice. If c has no value the search starts at the lowest value.
+func (w *Watermark) After(index string, query json.RawMessage, c *Cursor, until json.RawMessage) (*Search, error) {
+
+	if w.IsSeqNo() {
+		return nil, fmt.Errorf("%s is only ordered within each shard, so it can not be used to sort documents", SEQ_NO)
+	}
+
+	bounds := map[string]interface{}{}
+
+	if len(c.Value) > 0 {
+		bounds["gte"] = c.Value
+	}
+
+	if len(until) > 0 {
+		bounds["lte"] = until
+	}
+
+	after_query, err := w.rangeQuery(query, bounds, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort := map[string]string{
+		"order": "asc",
+	}
+
+	// Dates are sorted by their milliseconds, which are accepted by DATE_FORMAT, even for date_nanos fields
+	if w.IsDate() {
+		sort["numeric_type"] = "date"
+	}
+
+	enc_sort, err := json.Marshal(map[string]interface{}{w.Field: sort})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode sort, %w", err)
+	}
+
+	s := &Search{
+		Index:  index,
+		Query:  after_query,
+		Sort:   []json.RawMessage{enc_sort},
+		Cursor: c,
+	}
+
+	return s, nil
+}
+
+// Value returns value, the value of the field in the sort value of a document read by a search returned by
+// After, in the form returned by HighWater.
+func (w *Watermark) Value(value json.RawMessage) (json.RawMessage, error) {
+
+	if !w.IsDate() {
+		return value, nil
+	}
+
+	var millis int64
+
+	err := json.Unmarshal(value, &millis)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid sort value %s, %w", value, err)
+	}
+
+	return json.Marshal(time.UnixMilli(millis).UTC().Format("2006-01-02T15:04:05.000Z"))
+}
+
+// Query returns query limited to the documents whose field is greater than since and less than or equal to
+// until. Either can be empty, in which case the window is unbounded on that side, and query can be empty to
+// match every document. If missing is true documents without the field are included as well, otherwise they
+// are excluded even if the window is unbounded.
+func (w *Watermark) Query(query json.RawMessage, since json.RawMessage, until json.RawMessage, missing bool) (json.RawMessage, error) {
+
+	bounds := map[string]interface{}{}
+
+	if len(since) > 0 {
+		bounds["gt"] = since
+	}
+
+	if len(until) > 0 {
+		bounds["lte"] = until
+	}
+
+	return w.rangeQuery(query, bounds, missing)
+}
+
+// rangeQuery returns query limited to the documents whose field is within bounds, the parameters of a range
+// query, as Query does.
+func (w *Watermark) rangeQuery(query json.RawMessage, bounds map[string]interface{}, missing bool) (json.RawMessage, error) {
+
+	if len(bounds) == 0 {
+
+		if missing {
+			return query, nil
+		}
+
+		return w.filter(query, map[string]interface{}{
+			"exists": map[string]string{"field": w.Field},
+		})
+	}
+
+	if w.IsDate() {
+		bounds["format"] = DATE_FORMAT
+	}
+
+	var clause interface{} = map[string]interface{}{
+		"range": map[string]interface{}{
+			w.Field: bounds,
+		},
+	}
+
+	if missing {
+
+		clause = map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []interface{}{
+					clause,
+					map[string]interface{}{
+						"bool": map[string]interface{}{
+							"must_not": map[string]interface{}{
+								"exists": map[string]string{"field": w.Field},
+							},
+						},
+					},
+				},
+				"minimum_should_match": 1,
+			},
+		}
+	}
+
+	return w.filter(query, clause)
+}
+
+// filter returns query limited to the documents matching clause.
+func (w *Watermark) filter(query json.RawMessage, clause interface{}) (json.RawMessage, error) {
+
+	filters := []interface{}{clause}
+
+	if len(query) > 0 {
+		filters = append(filters, query)
+	}
+
+	enc_query, err := json.Marshal(map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": filters,
+		},
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode query, %w", err)
+	}
+
+	return enc_query, nil
+}
+
+// HighWater returns the largest value of the field in the documents in index matching query whose field is
+// greater than since and less than or equal to until, or nil if there are none. Dates are returned as ISO 8601
+// strings with millisecond precision. The value is read before a search is made so that documents which change
+// during the search are left for the next window rather than skipped. For SEQ_NO since and the value returned
+// are lists of checkpoints, one for each shard, and until is ignored.
+func (w *Watermark) HighWater(ctx context.Context, es_client *esapi.API, index string, query json.RawMessage, since json.RawMessage, until json.RawMessage) (json.RawMessage, error) {
+
+	if w.IsSeqNo() {
+		return w.checkpoints(ctx, es_client, index, query, since)
+	}
+
+	window_query, err := w.Query(query, since, until, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort := map[string]string{
+		"order": "desc",
+	}
+
+	if w.IsDate() {
+		sort["numeric_type"] = "date"
+	}
+
+	value, err := maxValue(ctx, es_client, index, "", window_query, map[string]interface{}{w.Field: sort})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find the high-water mark of %s, %w", w.Field, err)
+	}
+
+	if value == nil {
+		return nil, nil
+	}
+
+	return w.Value(value)
+}
+
+// maxValue returns the sort value of the first of the documents in index matching query sorted by sort, which
+// sorts them in descending order of a single field, or nil if there are none. The value is read from the sort
+// value of the document rather than from a max aggregation, which is a double and so can not represent every
+// long exactly. preference can be used to limit the search to one shard.
+func maxValue(ctx context.Context, es_client *esapi.API, index string, preference string, query json.RawMessage, sort interface{}) (json.RawMessage, error) {
+
+	if len(query) == 0 {
+		query = json.RawMessage(`{"match_all":{}}`)
+	}
+
+	body := map[string]interface{}{
+		"size":    1,
+		"query":   query,
+		"sort":    []interface{}{sort},
+		"_source": false,
+	}
+
+	opts := []func(*esapi.SearchRequest){
+		es_client.Search.WithContext(ctx),
+		es_client.Search.WithIndex(index),
+		es_client.Search.WithBody(esutil.NewJSONReader(body)),
+		es_client.Search.WithTrackTotalHits(false),
+		es_client.Search.WithFilterPath("hits.hits.sort"),
+	}
+
+	if preference != "" {
+		opts = append(opts, es_client.Search.WithPreference(preference))
+	}
+
+	rsp, err := es_client.Search(opts...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.IsError() {
+		return nil, fmt.Errorf("%s", rsp.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Sort []json.RawMessage `json:"sort"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	err = json.NewDecoder(rsp.Body).Decode(&result)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode response, %w", err)
+	}
+
+	if len(result.Hits.Hits) == 0 || len(result.Hits.Hits[0].Sort) == 0 {
+		return nil, nil
+	}
+
+	return result.Hits.Hits[0].Sort[0], nil
+}
+
+func contains(items []string, str string) bool {
+
+	for _, item := range items {
+
+		if item == str {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,309 @@
+package watermark
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	json "github.com/goccy/go-json"
+	"github.com/tidwall/gjson"
+)
+
+// handlerTransport is an esapi.Transport which answers requests with an http.Handler.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (tr *handlerTransport) Perform(req *http.Request) (*http.Response, error) {
+
+	rec := httptest.NewRecorder()
+	tr.handler.ServeHTTP(rec, req)
+
+	return rec.Result(), nil
+}
+
+func newTestAPI(handler http.HandlerFunc) *esapi.API {
+	return esapi.New(&handlerTransport{handler})
+}
+
+// assertJSON fails t if the JSON documents expected and got are not equal.
+func assertJSON(t *testing.T, expected string, got []byte) {
+
+	t.Helper()
+
+	var expected_value interface{}
+	var got_value interface{}
+
+	err := json.Unmarshal([]byte(expected), &expected_value)
+
+	if err != nil {
+		t.Fatalf("Invalid expected JSON %s, %v", expected, err)
+	}
+
+	err = json.Unmarshal(got, &got_value)
+
+	if err != nil {
+		t.Fatalf("Invalid JSON %s, %v", got, err)
+	}
+
+	if !reflect.DeepEqual(expected_value, got_value) {
+		t.Fatalf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestQuery(t *testing.T) {
+
+	date := &Watermark{Field: "lastmodified", Type: "date"}
+	number := &Watermark{Field: "version", Type: "long"}
+
+	query := json.RawMessage(`{"term":{"status":"public"}}`)
+	since := json.RawMessage(`"2026-10-18T00:00:00.000Z"`)
+	until := json.RawMessage(`"now-60s"`)
+
+	out, err := date.Query(query, nil, nil, true)
+
+	if err != nil {
+		t.Fatalf("Failed to build query, %v", err)
+	}
+
+	if string(out) != string(query) {
+		t.Fatalf("Expected an unbounded window to leave the query as is, got %s", out)
+	}
+
+	// Documents without the field are excluded unless missing is true
+	out, err = date.Query(query, nil, nil, false)
+
+	if err != nil {
+		t.Fatalf("Failed to build query, %v", err)
+	}
+
+	assertJSON(t, `{"bool":{"filter":[{"exists":{"field":"lastmodified"}},{"term":{"status":"public"}}]}}`, out)
+
+	out, err = date.Query(query, since, until, false)
+
+	if err != nil {
+		t.Fatalf("Failed to build query, %v", err)
+	}
+
+	assertJSON(t, `{"bool":{"filter":[{"range":{"lastmodified":{"gt":"2026-10-18T00:00:00.000Z","lte":"now-60s","format":"`+DATE_FORMAT+`"}}},{"term":{"status":"public"}}]}}`, out)
+
+	out, err = number.Query(nil, nil, json.RawMessage(`42`), true)
+
+	if err != nil {
+		t.Fatalf("Failed to build query, %v", err)
+	}
+
+	assertJSON(t, `{"bool":{"filter":[{"bool":{"should":[{"range":{"version":{"lte":42}}},{"bool":{"must_not":{"exists":{"field":"version"}}}}],"minimum_should_match":1}}]}}`, out)
+}
+
+func TestNew(t *testing.T) {
+
+	mappings := map[string]string{
+		"lastmodified": `{"books":{"mappings":{"lastmodified":{"full_name":"lastmodified","mapping":{"lastmodified":{"type":"date"}}}}}}`,
+		"meta.version": `{"books":{"mappings":{"meta.version":{"full_name":"meta.version","mapping":{"version":{"type":"long"}}}}}}`,
+		"title":        `{"books":{"mappings":{"title":{"full_name":"title","mapping":{"title":{"type":"keyword"}}}}}}`,
+		"missing":      `{"books":{"mappings":{}}}`,
+		"mixed":        `{"books-1":{"mappings":{"mixed":{"mapping":{"mixed":{"type":"long"}}}}},"books-2":{"mappings":{"mixed":{"mapping":{"mixed":{"type":"date"}}}}}}`,
+	}
+
+	requests := 0
+
+	api := newTestAPI(func(w http.ResponseWriter, req *http.Request) {
+
+		requests += 1
+
+		field := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+		fmt.Fprint(w, mappings[field])
+	})
+
+	ctx := context.Background()
+
+	w, err := New(ctx, api, "books", "lastmodified")
+
+	if err != nil {
+		t.Fatalf("Failed to create watermark, %v", err)
+	}
+
+	if !w.IsDate() || w.IsSeqNo() {
+		t.Fatalf("Expected lastmodified to be a date field, got %s", w.Type)
+	}
+
+	w, err = New(ctx, api, "books", "meta.version")
+
+	if err != nil {
+		t.Fatalf("Failed to create watermark, %v", err)
+	}
+
+	if w.IsDate() || w.Type != "long" {
+		t.Fatalf("Expected meta.version to be a long field, got %s", w.Type)
+	}
+
+	for _, field := range []string{"title", "missing", "mixed"} {
+
+		_, err := New(ctx, api, "books", field)
+
+		if err == nil {
+			t.Fatalf("Expected an error using %s as a watermark", field)
+		}
+	}
+
+	count := requests
+
+	w, err = New(ctx, api, "books", SEQ_NO)
+
+	if err != nil {
+		t.Fatalf("Failed to create watermark, %v", err)
+	}
+
+	if !w.IsSeqNo() || w.IsDate() {
+		t.Fatalf("Expected %s to be a sequence number watermark, got %s", SEQ_NO, w.Type)
+	}
+
+	if requests != count {
+		t.Fatalf("Expected %s not to require a mapping", SEQ_NO)
+	}
+}
+
+func TestHighWater(t *testing.T) {
+
+	tests := []struct {
+		Watermark *Watermark
+		Response  string
+		Expected  string
+		Sort      string
+	}{
+		{&Watermark{Field: "lastmodified", Type: "date"}, `{"hits":{"hits":[{"sort":[1760832000500]}]}}`, `"2025-10-19T00:00:00.500Z"`, `{"lastmodified":{"numeric_type":"date","order":"desc"}}`},
+		{&Watermark{Field: "version", Type: "long"}, `{"hits":{"hits":[{"sort":[42]}]}}`, `42`, `{"version":{"order":"desc"}}`},
+		// Longs above 2^53, which a max aggregation would round to a double, are read exactly
+		{&Watermark{Field: "version", Type: "long"}, `{"hits":{"hits":[{"sort":[9007199254740993]}]}}`, `9007199254740993`, `{"version":{"order":"desc"}}`},
+		{&Watermark{Field: "version", Type: "unsigned_long"}, `{"hits":{"hits":[{"sort":[18446744073709551615]}]}}`, `18446744073709551615`, `{"version":{"order":"desc"}}`},
+		{&Watermark{Field: "version", Type: "long"}, `{}`, ``, `{"version":{"order":"desc"}}`},
+	}
+
+	for _, test := range tests {
+
+		var body string
+
+		api := newTestAPI(func(w http.ResponseWriter, req *http.Request) {
+
+			enc_body, _ := io.ReadAll(req.Body)
+			body = string(enc_body)
+
+			fmt.Fprint(w, test.Response)
+		})
+
+		high_water, err := test.Watermark.HighWater(context.Background(), api, "books", nil, json.RawMessage(`1`), nil)
+
+		if err != nil {
+			t.Fatalf("Failed to find high-water mark, %v", err)
+		}
+
+		if string(high_water) != test.Expected {
+			t.Fatalf("Expected high-water mark %s, got %s", test.Expected, high_water)
+		}
+
+		if !strings.Contains(body, `"gt":1`) || !strings.Contains(body, `"size":1`) {
+			t.Fatalf("Unexpected request %s", body)
+		}
+
+		assertJSON(t, `[`+test.Sort+`]`, []byte(gjson.Get(body, "sort").Raw))
+	}
+}
+
+func TestSearches(t *testing.T) {
+
+	w := &Watermark{Field: "version", Type: "long"}
+
+	searches, err := w.Searches("books", nil, json.RawMessage(`1`), json.RawMessage(`5`), false)
+
+	if err != nil {
+		t.Fatalf("Failed to build searches, %v", err)
+	}
+
+	if len(searches) != 1 || searches[0].Index != "books" || searches[0].Preference != "" {
+		t.Fatalf("Expected a single search of books, got %+v", searches)
+	}
+
+	assertJSON(t, `{"bool":{"filter":[{"range":{"version":{"gt":1,"lte":5}}}]}}`, searches[0].Query)
+}
+
+func TestAfter(t *testing.T) {
+
+	date := &Watermark{Field: "lastmodified", Type: "date_nanos"}
+	number := &Watermark{Field: "version", Type: "long"}
+
+	query := json.RawMessage(`{"term":{"status":"public"}}`)
+	until := json.RawMessage(`"now-60s"`)
+
+	c := NewCursor(json.RawMessage(`"2026-10-18T00:00:00.000Z"`))
+
+	s, err := date.After("books", query, c, until)
+
+	if err != nil {
+		t.Fatalf("Failed to build search, %v", err)
+	}
+
+	if s.Index != "books" || s.Cursor != c || len(s.Sort) != 1 {
+		t.Fatalf("Unexpected search %+v", s)
+	}
+
+	assertJSON(t, `{"lastmodified":{"order":"asc","numeric_type":"date"}}`, s.Sort[0])
+
+	// Documents with the value of the cursor are searched again, and skipped by the cursor if they were read
+	assertJSON(t, `{"bool":{"filter":[{"range":{"lastmodified":{"gte":"2026-10-18T00:00:00.000Z","lte":"now-60s","format":"`+DATE_FORMAT+`"}}},{"term":{"status":"public"}}]}}`, s.Query)
+
+	s, err = number.After("books", nil, NewCursor(nil), nil)
+
+	if err != nil {
+		t.Fatalf("Failed to build search, %v", err)
+	}
+
+	assertJSON(t, `{"version":{"order":"asc"}}`, s.Sort[0])
+	assertJSON(t, `{"bool":{"filter":[{"exists":{"field":"version"}}]}}`, s.Query)
+
+	_, err = (&Watermark{Field: SEQ_NO, Type: SEQ_NO}).After("books", nil, NewCursor(nil), nil)
+
+	if err == nil {
+		t.Fatalf("Expected an error sorting by %s", SEQ_NO)
+	}
+}
+
+func TestValue(t *testing.T) {
+
+	date := &Watermark{Field: "lastmodified", Type: "date"}
+	number := &Watermark{Field: "version", Type: "double"}
+
+	tests := []struct {
+		Watermark *Watermark
+		Value     string
+		Expected  string
+	}{
+		{date, `1760832000500`, `"2025-10-19T00:00:00.500Z"`},
+		{number, `1.5`, `1.5`},
+	}
+
+	for _, test := range tests {
+
+		value, err := test.Watermark.Value(json.RawMessage(test.Value))
+
+		if err != nil {
+			t.Fatalf("Failed to read sort value %s, %v", test.Value, err)
+		}
+
+		if string(value) != test.Expected {
+			t.Fatalf("Expected %s, got %s", test.Expected, value)
+		}
+	}
+
+	_, err := date.Value(json.RawMessage(`"2025-10-19"`))
+
+	if err == nil {
+		t.Fatalf("Expected an error for a date sort value which is not a number")
+	}
+}